@@ -22,6 +22,7 @@ func NewAssignStat(dst []Var, src []ExpNode) AssignStat {
 			fName := dst[i].FunctionName()
 			if fName != "" {
 				f.Name = fName
+				f.NameWhat = varNameWhat(dst[i])
 				src[i] = f
 			}
 		}
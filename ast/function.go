@@ -9,8 +9,9 @@ import (
 type Function struct {
 	Location
 	ParList
-	Body BlockStat
-	Name string
+	Body     BlockStat
+	Name     string
+	NameWhat string // How the function is named (as "namewhat" in debug.getinfo)
 }
 
 var _ ExpNode = Function{}
@@ -52,6 +53,16 @@ func (f Function) HWrite(w HWriter) {
 	w.Dedent()
 }
 
+// Returns the NameWhat of a function assigned to v.  For names, it is
+// "global" until the compiler finds out if the name is a local variable or an
+// upvalue.
+func varNameWhat(v Var) string {
+	if _, ok := v.(Name); ok {
+		return "global"
+	}
+	return "field"
+}
+
 // A ParList represents a function parameter list (it is not a node).
 type ParList struct {
 	Params  []Name
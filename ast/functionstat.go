@@ -14,9 +14,11 @@ func NewFunctionStat(fName Var, method Name, fx Function) AssignStat {
 		)
 		fx.Location = loc
 		fx.Name = method.FunctionName()
+		fx.NameWhat = "method"
 		fName = NewIndexExp(fName, method.AstString())
 	} else {
 		fx.Name = fName.FunctionName()
+		fx.NameWhat = varNameWhat(fName)
 	}
 	return NewAssignStat([]Var{fName}, []ExpNode{fx})
 }
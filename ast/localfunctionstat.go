@@ -14,6 +14,7 @@ var _ Stat = LocalFunctionStat{}
 // and function definition.
 func NewLocalFunctionStat(name Name, fx Function) LocalFunctionStat {
 	fx.Name = name.Val
+	fx.NameWhat = "local"
 	return LocalFunctionStat{
 		Location: MergeLocations(name, fx), // TODO: use "local" for location start
 		Function: fx,
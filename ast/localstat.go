@@ -25,6 +25,7 @@ func NewLocalStat(nameAttribs []NameAttrib, values []ExpNode) LocalStat {
 		f, ok := v.(Function)
 		if ok && f.Name == "" {
 			f.Name = nameAttribs[i].Name.Val
+			f.NameWhat = "local"
 			values[i] = f
 		}
 	}
@@ -66,6 +66,7 @@ func NewTableField(key ExpNode, value ExpNode) TableField {
 		name, ok := key.(String)
 		if ok {
 			f.Name = string(name.Val)
+			f.NameWhat = "field"
 			value = f
 		}
 	}
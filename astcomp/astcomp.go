@@ -52,6 +52,14 @@ const (
 	loopVarRegName  = ir.Name("<var>")
 )
 
+// Names of internal local variables as seen by debug functions (same as in the
+// reference implementation).  The local variable holding the varargs has a
+// special name so the runtime can find them.
+const (
+	loopStateDebugName = "(for state)"
+	ellipsisDebugName  = "..."
+)
+
 // Error that results from a valid AST which does not form a valid program.
 type Error struct {
 	Where   ast.Locator
@@ -25,9 +25,6 @@ func (c *expCompiler) ProcessBFunctionCallExp(f ast.BFunctionCall) {
 
 // ProcessBinOpExp compiles a BinOpExp.
 func (c *expCompiler) ProcessBinOpExp(b ast.BinOp) {
-	if b.OpType == 1 { // HACK
-		b.OpType += 0x100;
-	}
 	if b.OpType == ops.OpAnd {
 		c.compileLogicalOp(b, true)
 		return
@@ -37,9 +34,11 @@ func (c *expCompiler) ProcessBinOpExp(b ast.BinOp) {
 		return
 	}
 	lsrc := c.compileExpNoDestHint(b.Left)
+	isInt := c.isIntExp(b.Left)
 	for _, r := range b.Right {
 		c.TakeRegister(lsrc)
 		rsrc := c.compileExpNoDestHint(r.Operand)
+		isInt = isInt && isIntOp(r.Op) && c.isIntExp(r.Operand)
 		switch r.Op {
 		case ops.OpNeq:
 			// x ~= y ==> ~(x = y)
@@ -76,6 +75,7 @@ func (c *expCompiler) ProcessBinOpExp(b ast.BinOp) {
 				Dst:  c.dst,
 				Lsrc: lsrc,
 				Rsrc: rsrc,
+				Int:  isInt,
 			})
 		}
 		c.ReleaseRegister(lsrc)
@@ -83,6 +83,38 @@ func (c *expCompiler) ProcessBinOpExp(b ast.BinOp) {
 	}
 }
 
+// isIntExp returns true if e is known to evaluate to an integer.  This is the
+// case of integer literals, local variables marked with MarkIntReg and of
+// additions, subtractions and multiplications of such expressions.  It must be
+// called after e is compiled, so that looking up names has no side effects.
+func (c *compiler) isIntExp(e ast.ExpNode) bool {
+	switch x := e.(type) {
+	case ast.Int:
+		return true
+	case ast.Name:
+		reg, ok := c.GetRegister(ir.Name(x.Val))
+		return ok && c.IsIntReg(reg)
+	case *ast.UnOp:
+		return x.Op == ops.OpNeg && c.isIntExp(x.Operand)
+	case *ast.BinOp:
+		if !c.isIntExp(x.Left) {
+			return false
+		}
+		for _, r := range x.Right {
+			if !isIntOp(r.Op) || !c.isIntExp(r.Operand) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Returns true if op applied to integers always gives an integer.
+func isIntOp(op ops.Op) bool {
+	return op == ops.OpAdd || op == ops.OpSub || op == ops.OpMul
+}
+
 // This implements short-circuiting in logical expressions.
 func (c *expCompiler) compileLogicalOp(b ast.BinOp, not bool) {
 	doneLbl := c.GetNewLabel()
@@ -360,9 +392,22 @@ func (c *compiler) compileExpList(exps []ast.ExpNode, dstRegs []ir.Register) {
 }
 
 func (c *compiler) compileFunctionBody(f ast.Function) {
+	info := ir.FunctionInfo{
+		NameWhat: f.NameWhat,
+		NParams:  len(f.Params),
+		IsVararg: f.HasDots,
+	}
+	if start := f.StartPos(); start != nil {
+		info.LineDefined = start.Line
+	}
+	if end := f.EndPos(); end != nil {
+		info.LastLineDefined = end.Line
+	}
+	c.SetFunctionInfo(info)
+
 	recvRegs := make([]ir.Register, len(f.Params))
 	callerReg := c.GetFreeRegister()
-	c.DeclareLocal(callerRegName, callerReg)
+	c.DeclareLocalWithDebugName(callerRegName, "", callerReg)
 	for i, p := range f.Params {
 		reg := c.GetFreeRegister()
 		c.DeclareLocal(ir.Name(p.Val), reg)
@@ -372,7 +417,7 @@ func (c *compiler) compileFunctionBody(f ast.Function) {
 		c.emitInstr(f, ir.Receive{Dst: recvRegs})
 	} else {
 		reg := c.GetFreeRegister()
-		c.DeclareLocal(ellipsisRegName, reg)
+		c.DeclareLocalWithDebugName(ellipsisRegName, ellipsisDebugName, reg)
 		c.emitInstr(f, ir.ReceiveEtc{Dst: recvRegs, Etc: reg})
 	}
 
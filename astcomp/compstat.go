@@ -18,12 +18,40 @@ func (c *compiler) ProcessAssignStat(s ast.AssignStat) {
 
 	// Evaluate the right hand side
 	resultRegs := make([]ir.Register, len(s.Dest))
-	c.compileExpList(s.Src, resultRegs)
+	c.compileExpList(c.nameAssignedFunctions(s), resultRegs)
 
 	// Compile the lvalues and assignments
 	c.compileAssignments(s.Dest, resultRegs)
 }
 
+// Returns the source expressions of s, where functions assigned to a name get
+// the NameWhat of the variable the name resolves to ("global", "local" or
+// "upvalue"), which the parser cannot know.
+func (c *compiler) nameAssignedFunctions(s ast.AssignStat) []ast.ExpNode {
+	src := append([]ast.ExpNode(nil), s.Src...)
+	for i, e := range s.Src {
+		if i >= len(s.Dest) {
+			break
+		}
+		f, ok := e.(ast.Function)
+		n, isName := s.Dest[i].(ast.Name)
+		if !ok || !isName {
+			continue
+		}
+		reg, ok := c.GetRegister(ir.Name(n.Val))
+		switch {
+		case !ok:
+			f.NameWhat = "global"
+		case c.IsUpvalueReg(reg):
+			f.NameWhat = "upvalue"
+		default:
+			f.NameWhat = "local"
+		}
+		src[i] = f
+	}
+	return src
+}
+
 // ProcessBlockStat compiles a BlockStat.
 func (c *compiler) ProcessBlockStat(s ast.BlockStat) {
 	c.PushContext()
@@ -52,9 +80,15 @@ func (c *compiler) ProcessForInStat(s ast.ForInStat) {
 
 	c.PushContext()
 	c.PushCloseAction(closeReg) // Now closeReg is no longer needed
-	c.DeclareLocal(loopFRegName, fReg)
-	c.DeclareLocal(loopSRegName, sReg)
-	c.DeclareLocal(loopVarRegName, varReg)
+
+	// As in ProcessLocalStat, release the registers so they are only held by
+	// the local variables and freed at the end of the loop.
+	c.ReleaseRegister(fReg)
+	c.ReleaseRegister(sReg)
+	c.ReleaseRegister(varReg)
+	c.DeclareLocalWithDebugName(loopFRegName, loopStateDebugName, fReg)
+	c.DeclareLocalWithDebugName(loopSRegName, loopStateDebugName, sReg)
+	c.DeclareLocalWithDebugName(loopVarRegName, loopStateDebugName, varReg)
 
 	loopLbl := c.GetNewLabel()
 	must(c.EmitLabelNoLine(loopLbl))
@@ -117,11 +151,16 @@ func (c *compiler) ProcessForStat(s ast.ForStat) {
 	ir.EmitMoveNoLine(c.CodeBuilder, stepReg, r)
 	c.TakeRegister(stepReg)
 
+	// If the start and step values are integers, the loop can use integer
+	// arithmetic only.
+	isInt := c.isIntExp(s.Start) && c.isIntExp(s.Step)
+
 	// Prepare the for loop
 	c.emitInstr(s, ir.PrepForLoop{
 		Start: startReg,
 		Stop:  stopReg,
 		Step:  stepReg,
+		Int:   isInt,
 	})
 
 	c.PushContext()
@@ -143,7 +182,12 @@ func (c *compiler) ProcessForStat(s ast.ForStat) {
 	// iter <- start
 	ir.EmitMoveNoLine(c.CodeBuilder, iterReg, startReg)
 	c.DeclareLocal(ir.Name(s.Var.Val), iterReg)
+	if isInt {
+		// The loop variable is an integer too, unless the body changes it.
+		c.MarkIntReg(iterReg)
+	}
 	c.compileBlock(s.Body)
+	c.CheckIntReg(iterReg)
 	c.PopContext()
 
 	//Advance the for loop
@@ -151,6 +195,7 @@ func (c *compiler) ProcessForStat(s ast.ForStat) {
 		Start: startReg,
 		Stop:  stopReg,
 		Step:  stepReg,
+		Int:   isInt,
 	})
 	// If startReg is not nil, it means the loop continues
 	c.EmitNoLine(ir.JumpIf{
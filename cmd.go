@@ -12,6 +12,9 @@ import (
 	"strings"
 
 	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/coverage"
+	"github.com/arnodel/golua/dap"
+	"github.com/arnodel/golua/debugger"
 	"github.com/arnodel/golua/lib"
 	"github.com/arnodel/golua/lib/base"
 	"github.com/arnodel/golua/lib/debuglib"
@@ -22,25 +25,48 @@ import (
 type luaCmd struct {
 	disFlag        bool
 	astFlag        bool
+	outputFile     string
+	stripFlag      bool
+	optLevel       int
 	unbufferedFlag bool
+	debugFlag      bool
+	dapFlag        bool
+	dapAddr        string
+	profileFile    string
+	profileFormat  string
+	profilePeriod  uint64
+	coverageFile   string
 	cpuLimit       uint64
 	memLimit       uint64
 	flags          string
 	exec           execFlags
 
 	complianceFlags rt.ComplianceFlags
+
+	stdin   *bufio.Reader
+	console *debugger.Console // Only set in debug mode
 }
 
 func (c *luaCmd) setFlags() {
 	flag.BoolVar(&c.disFlag, "dis", false, "Disassemble source instead of running it")
 	flag.BoolVar(&c.astFlag, "ast", false, "Print AST instead of running code")
+	flag.StringVar(&c.outputFile, "o", "", "Compile source to a binary chunk in `file` instead of running it")
+	flag.BoolVar(&c.stripFlag, "s", false, "Strip debug information from the binary chunk written with -o")
+	flag.IntVar(&c.optLevel, "O", 0, "optimization `level` of the compiled code (0, 1 or 2)")
 	flag.BoolVar(&c.unbufferedFlag, "u", false, "Force unbuffered output")
+	flag.BoolVar(&c.debugFlag, "debug", false, "Run code in the interactive debugger")
+	flag.BoolVar(&c.dapFlag, "dap", false, "Serve the Debug Adapter Protocol on stdio")
+	flag.StringVar(&c.dapAddr, "dapaddr", "", "Serve the Debug Adapter Protocol on this TCP address instead of stdio")
+	flag.StringVar(&c.coverageFile, "coverage", "", "write the line and branch coverage of the Lua code to `file` in LCOV format")
 	flag.Var(&c.exec, "e", "statement to execute")
 
 	if rt.QuotasAvailable {
 		flag.Uint64Var(&c.cpuLimit, "cpulimit", 0, "CPU limit")
 		flag.Uint64Var(&c.memLimit, "memlimit", 0, "memory limit")
 		flag.StringVar(&c.flags, "flags", "", "compliance flags turned on")
+		flag.StringVar(&c.profileFile, "luaprofile", "", "write a profile of the Lua code to `file`")
+		flag.StringVar(&c.profileFormat, "luaprofileformat", "pprof", "format of the Lua profile: pprof, collapsed (cpu) or collapsed-mem")
+		flag.Uint64Var(&c.profilePeriod, "luaprofileperiod", 1, "CPU required between Lua profile samples")
 	}
 }
 
@@ -54,8 +80,12 @@ func (c *luaCmd) run() (retcode int) {
 		repl      bool
 	)
 
+	if c.dapFlag || c.dapAddr != "" {
+		return c.serveDAP()
+	}
+
 	buffered := !isaTTY(os.Stdin) || flag.NArg() > 0
-	if c.unbufferedFlag {
+	if c.unbufferedFlag || c.debugFlag {
 		buffered = false
 	}
 	iolib.BufferedStdFiles = buffered
@@ -72,14 +102,40 @@ func (c *luaCmd) run() (retcode int) {
 
 	// Get a Lua runtime
 	r := rt.New(nil)
+	r.SetOptimizationLevel(c.optLevel)
 	c.pushContext(r)
 
+	if c.coverageFile != "" {
+		collector := rt.NewCoverageCollector()
+		r.SetCoverageCollector(collector)
+		defer c.writeCoverage(collector)
+	}
+
 	cleanup := lib.LoadAll(r)
 	defer cleanup()
 
+	if c.profileFile != "" {
+		if err := c.checkProfileFormat(); err != nil {
+			return fatal("%s", err)
+		}
+		if err := r.StartProfiling(rt.ProfileOptions{CpuPeriod: c.profilePeriod}); err != nil {
+			return fatal("Error starting profiler: %s", err)
+		}
+		defer c.writeProfile(r)
+	}
+
 	// Run finalizers before we exit
 	defer runtime.GC()
 
+	c.stdin = bufio.NewReader(os.Stdin)
+	if c.debugFlag {
+		// Debugger commands are read from stdin, sharing the reader with the
+		// repl.
+		c.console = debugger.NewConsole(c.stdin, os.Stdout)
+		c.console.Attach(r.MainThread())
+		c.console.SetAction(debugger.StepIn)
+	}
+
 	if len(c.exec) == 0 && flag.NArg() == 0 {
 		chunkName = "<stdin>"
 		readStdin = true
@@ -96,7 +152,7 @@ func (c *luaCmd) run() (retcode int) {
 
 	var argVals []rt.Value
 	if len(args) > 0 {
-		argTable := rt.NewTable()
+		argTable := r.NewTable()
 		argVals = make([]rt.Value, len(args))
 		for i, arg := range args {
 			argVal := rt.StringValue(arg)
@@ -114,6 +170,9 @@ func (c *luaCmd) run() (retcode int) {
 		clos := r.LoadLuaUnit(unit, rt.TableValue(r.GlobalEnv()))
 		cerr := rt.Call(r.MainThread(), rt.FunctionValue(clos), argVals, rt.NewTerminationWith(nil, 0, false))
 		if cerr != nil {
+			if c.debuggerHasQuit() {
+				return 0
+			}
 			return fatal("!!! %s", cerr.Error())
 		}
 	}
@@ -122,7 +181,7 @@ func (c *luaCmd) run() (retcode int) {
 		if repl {
 			return c.repl(r)
 		}
-		chunk, err = ioutil.ReadAll(os.Stdin)
+		chunk, err = ioutil.ReadAll(c.stdin)
 		if err != nil {
 			return fatal("Error reading <stdin>: %s", err)
 		}
@@ -147,24 +206,124 @@ func (c *luaCmd) run() (retcode int) {
 		return 0
 	}
 
-	defer func() {
-		if rec := recover(); rec != nil {
-			quotaExceeded, ok := rec.(rt.ContextTerminationError)
-			if !ok {
-				panic(r)
-			}
-			fmt.Fprintf(os.Stderr, "%s\n", quotaExceeded)
-			retcode = 2
+	if c.outputFile != "" {
+		if err := c.compile(r, chunkName, chunk); err != nil {
+			return fatal("%s", err)
 		}
-	}()
+		return 0
+	}
 
-	clos, err := r.LoadFromSourceOrCode(chunkName, chunk, "bt", rt.TableValue(r.GlobalEnv()), true)
+	var clos *rt.Closure
+	err = r.SafeRun(func() (err error) {
+		clos, err = r.LoadFromSourceOrCode(chunkName, chunk, "bt", rt.TableValue(r.GlobalEnv()), true)
+		return err
+	})
 	if err != nil {
-		return fatal("Error loading %s: %s", chunkName, err)
+		return c.fatalError(fmt.Sprintf("Error loading %s", chunkName), err)
 	}
-	cerr := rt.Call(r.MainThread(), rt.FunctionValue(clos), argVals, rt.NewTerminationWith(nil, 0, false))
-	if cerr != nil {
-		return fatal("!!! %s", cerr.Error())
+	cerr := r.SafeCall(rt.FunctionValue(clos), argVals, rt.NewTerminationWith(nil, 0, false))
+	if cerr != nil && !c.debuggerHasQuit() {
+		return c.fatalError("!!!", cerr)
+	}
+	return 0
+}
+
+// Report an error and return the exit code: 2 if the runtime context was
+// terminated (e.g. because a limit was reached), 1 otherwise.
+func (c *luaCmd) fatalError(prefix string, err error) int {
+	if termErr, ok := err.(*rt.TerminationError); ok {
+		fmt.Fprintf(os.Stderr, "%s\n", termErr)
+		return 2
+	}
+	return fatal("%s %s", prefix, err)
+}
+
+// Compile a chunk and write it to the output file as a binary chunk, which can
+// be loaded like source code.
+func (c *luaCmd) compile(r *rt.Runtime, chunkName string, chunk []byte) error {
+	// Blank the first line if it is a comment (e.g. "#!/usr/bin/env golua"),
+	// keeping the line numbers unchanged.
+	if len(chunk) > 0 && chunk[0] == '#' {
+		if i := bytes.IndexByte(chunk, '\n'); i >= 0 {
+			chunk = chunk[i:]
+		} else {
+			chunk = nil
+		}
+	}
+	unit, _, err := r.CompileLuaChunk(chunkName, chunk)
+	if err != nil {
+		return fmt.Errorf("Error parsing %s: %s", chunkName, err)
+	}
+	code := r.RefactorCodeConsts(r.LoadLuaUnit(unit, rt.NilValue).Code)
+	var buf bytes.Buffer
+	if _, err := rt.MarshalConst(&buf, rt.CodeValue(code), 0, c.stripFlag); err != nil {
+		return fmt.Errorf("Error compiling %s: %s", chunkName, err)
+	}
+	if err := ioutil.WriteFile(c.outputFile, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("Error writing %s: %s", c.outputFile, err)
+	}
+	return nil
+}
+
+func (c *luaCmd) debuggerHasQuit() bool {
+	return c.console != nil && c.console.HasQuit()
+}
+
+func (c *luaCmd) checkProfileFormat() error {
+	switch c.profileFormat {
+	case "pprof", "collapsed", "collapsed-mem":
+		return nil
+	default:
+		return fmt.Errorf("Unknown profile format: %s", c.profileFormat)
+	}
+}
+
+func (c *luaCmd) writeProfile(r *rt.Runtime) {
+	prof := r.StopProfiling()
+	f, err := os.Create(c.profileFile)
+	if err != nil {
+		fatal("Error writing profile: %s", err)
+		return
+	}
+	defer f.Close()
+	switch c.profileFormat {
+	case "collapsed":
+		err = prof.WriteCollapsed(f, rt.ProfileCpu)
+	case "collapsed-mem":
+		err = prof.WriteCollapsed(f, rt.ProfileMem)
+	default:
+		err = prof.WritePprof(f)
+	}
+	if err != nil {
+		fatal("Error writing profile: %s", err)
+	}
+}
+
+func (c *luaCmd) writeCoverage(collector *rt.CoverageCollector) {
+	report := coverage.NewReport(collector.Units())
+	f, err := os.Create(c.coverageFile)
+	if err != nil {
+		fatal("Error writing coverage: %s", err)
+		return
+	}
+	defer f.Close()
+	if err := report.WriteLCOV(f); err != nil {
+		fatal("Error writing coverage: %s", err)
+	}
+	report.WriteSummary(os.Stderr)
+}
+
+// Serve debugging sessions.  The programs to run are specified by the client.
+func (c *luaCmd) serveDAP() int {
+	if c.dapAddr != "" {
+		fmt.Fprintf(os.Stderr, "Serving DAP on %s\n", c.dapAddr)
+		if err := dap.ListenAndServe(c.dapAddr); err != nil {
+			return fatal("DAP server error: %s", err)
+		}
+		return 0
+	}
+	if err := dap.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+		return fatal("DAP server error: %s", err)
 	}
 	return 0
 }
@@ -180,8 +339,13 @@ func isaTTY(f *os.File) bool {
 }
 
 func (c *luaCmd) repl(r *rt.Runtime) int {
-	reader := bufio.NewReader(os.Stdin)
+	reader := c.stdin
 	w := new(bytes.Buffer)
+	if c.console != nil {
+		// Only pause on breakpoints or when requested with :step.
+		c.console.SetAction(debugger.Continue)
+		fmt.Println("Debugger commands start with ':' (e.g. :help)")
+	}
 	for {
 		if len(w.Bytes()) == 0 {
 			fmt.Print("> ")
@@ -195,16 +359,25 @@ func (c *luaCmd) repl(r *rt.Runtime) int {
 			fmt.Print(string(line))
 			return 0
 		}
+		if c.console != nil && len(w.Bytes()) == 0 && bytes.HasPrefix(line, []byte(":")) {
+			if action, ok := c.console.Command(string(line[1:])); ok && action == debugger.Quit {
+				return 0
+			}
+			continue
+		}
 		_, err = w.Write(line)
 		if err != nil {
 			return fatal("error: %s", err)
 		}
 		more, err := c.runChunk(r, w.Bytes())
+		if c.debuggerHasQuit() {
+			return 0
+		}
 		if !more {
 			w = new(bytes.Buffer)
 			if err != nil {
 				fmt.Printf("!!! %s\n", err)
-				if _, ok := err.(rt.ContextTerminationError); ok {
+				if _, ok := err.(*rt.TerminationError); ok {
 					fmt.Print("Reset limits and continue? [yN] ")
 					line, err := reader.ReadString('\n')
 					if err == io.EOF || strings.TrimSpace(line) != "y" {
@@ -219,33 +392,25 @@ func (c *luaCmd) repl(r *rt.Runtime) int {
 }
 
 func (c *luaCmd) runChunk(r *rt.Runtime, source []byte) (more bool, err error) {
-	defer func() {
-		if rec := recover(); rec != nil {
-			quotaExceeded, ok := rec.(rt.ContextTerminationError)
-			if !ok {
-				panic(r)
-			}
-			err = quotaExceeded
-			more = false
-		}
-	}()
-	clos, err := r.CompileAndLoadLuaChunkOrExp("<stdin>", source, rt.TableValue(r.GlobalEnv()))
+	var clos *rt.Closure
+	err = r.SafeRun(func() (err error) {
+		clos, err = r.CompileAndLoadLuaChunkOrExp("<stdin>", source, rt.TableValue(r.GlobalEnv()))
+		return err
+	})
 	if err != nil {
 		return rt.ErrorIsUnexpectedEOF(err), err
 	}
 	t := r.MainThread()
 	term := rt.NewTerminationWith(nil, 0, true)
-	cerr := rt.Call(t, rt.FunctionValue(clos), nil, term)
-	if cerr == nil {
+	return false, r.SafeRun(func() error {
+		if err := rt.Call(t, rt.FunctionValue(clos), nil, term); err != nil {
+			return err
+		}
 		if len(term.Etc()) > 0 {
-			cerr = base.Print(t, term.Etc())
-			if cerr != nil {
-				return false, cerr
-			}
+			return base.Print(t, term.Etc())
 		}
-		return false, nil
-	}
-	return false, cerr
+		return nil
+	})
 }
 
 func (c *luaCmd) pushContext(r *rt.Runtime) {
@@ -0,0 +1,55 @@
+// Command golua-bindgen generates Go code which makes Go packages importable
+// from Lua with golib.import without building plugins at runtime, so that they
+// can be compiled into a program (e.g. the golua command).  It is meant to be
+// used with go generate, e.g.
+//
+//	//go:generate go run github.com/arnodel/golua/cmd/golua-bindgen -o bindings.go strings strconv
+//
+// The generated file registers the exported functions and types of each
+// package when the package containing it is initialised.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/arnodel/golua/lib/golib/goimports"
+)
+
+func main() {
+	flag.Usage = usage
+	var (
+		output  string
+		pkgName string
+	)
+	flag.StringVar(&output, "o", "", "write the generated code to `file` instead of stdout")
+	flag.StringVar(&pkgName, "package", os.Getenv("GOPACKAGE"), "package `name` of the generated code (defaults to $GOPACKAGE, set by go generate)")
+	flag.Parse()
+	if flag.NArg() == 0 || pkgName == "" {
+		usage()
+		os.Exit(2)
+	}
+	var buf bytes.Buffer
+	if err := goimports.WriteBindings(&buf, pkgName, flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "golua-bindgen: %s\n", err)
+		os.Exit(1)
+	}
+	var err error
+	if output == "" {
+		_, err = os.Stdout.Write(buf.Bytes())
+	} else {
+		err = ioutil.WriteFile(output, buf.Bytes(), 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "golua-bindgen: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: golua-bindgen [-o file] [-package name] importpath...\n")
+	flag.PrintDefaults()
+}
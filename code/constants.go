@@ -14,12 +14,35 @@ type Constant interface {
 // Code is a constant representing a chunk of code.  It doesn't contain any
 // actual opcodes, but refers to a range in the code unit it belongs to.
 type Code struct {
-	Name                   string   // Name of the function (if it has one)
-	StartOffset, EndOffset uint     // Where to find the opcode in the code Unit this belongs to
-	UpvalueCount           int16    // Number of upvalues
-	CellCount              int16    // Number of cell registers needed to run the code
-	RegCount               int16    // Number of registers needed to run the coee
-	UpNames                []string // Names of the upvalues
+	Name                   string     // Name of the function (if it has one)
+	StartOffset, EndOffset uint       // Where to find the opcode in the code Unit this belongs to
+	UpvalueCount           int16      // Number of upvalues
+	CellCount              int16      // Number of cell registers needed to run the code
+	RegCount               int16      // Number of registers needed to run the coee
+	UpNames                []string   // Names of the upvalues
+	LocalVars              []LocalVar // Optional: local variables (for debugging)
+
+	// Information about the function definition (for debugging)
+	NameWhat                     string // How the function is named
+	LineDefined, LastLineDefined int32  // Lines of the definition (0 for the main chunk)
+	NParams                      int16  // Number of fixed parameters
+	IsVararg                     bool   // True if the function takes varargs
+}
+
+// A LocalVar describes where a local variable of a function is stored.  The
+// local variable named "..." is special, it holds the varargs of the function
+// (as an array value).
+type LocalVar struct {
+	Name    string
+	Reg     Reg  // Register containing the value of the variable
+	StartPC uint // The variable is active from this opcode...
+	EndPC   uint // ...until just before this one (both relative to StartOffset)
+}
+
+// IsActive returns true if the variable is active when executing the opcode at
+// the given pc (relative to the start of the function).
+func (v LocalVar) IsActive(pc uint) bool {
+	return v.StartPC <= pc && pc < v.EndPC
 }
 
 var _ Constant = Code{}
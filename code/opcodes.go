@@ -9,11 +9,11 @@ import (
 // Opcode is the type of opcodes
 type Opcode uint32
 
-// There are 7 types of opcodes (Typ0 - Type7).  The type of opcode is defined
-// by the most significant 4 bits of the opcode.
+// There are 8 types of opcodes (Typ0 - Type8).  The type of opcode is defined
+// by the most significant 4 bits of the opcode.  An opcode may be preceded by a
+// wide prefix (TypeW) when its fields do not fit in the opcode.
 
-// Prefixes for the different types of opcodes.  Note: there is an unused prefix
-// (0001).
+// Prefixes for the different types of opcodes.
 const (
 	Type1Pfx Opcode = 1 << 31 // 1......
 	Type2Pfx Opcode = 7 << 28 // 0111...
@@ -23,6 +23,8 @@ const (
 	Type6Pfx Opcode = 3 << 28 // 0011...
 	Type7Pfx Opcode = 2 << 28 // 0010...
 	Type0Pfx Opcode = 0 << 28 // 0000...
+	Type8Pfx Opcode = 1 << 28 // 0001... (and not 00010000)
+	TypeWPfx Opcode = 1 << 28 // 00010000
 
 	type4aFlag Opcode = 1 << 24
 )
@@ -48,6 +50,43 @@ func (c Opcode) HasType0() bool {
 	return c&(0xf<<28) == 0
 }
 
+// IsWidePrefix returns true if the opcode is a wide prefix (TypeW).
+func (c Opcode) IsWidePrefix() bool {
+	return c&(0xff<<24) == TypeWPfx
+}
+
+// ==================================================================
+// TypeW:  00010000 AAAAAAAA BBBBBBBB CCCCCCCC
+//
+// Wide prefix.  It is placed just before an opcode whose fields do not all fit
+// in the opcode and contains their high bits.
+// - AAAAAAAA, BBBBBBBB and CCCCCCCC are the high 8 bits of the indexes of the
+//   registers rA, rB and rC (and of the Index8 field M)
+// - BBBBBBBBCCCCCCCC are the high 16 bits of the KIndex or Offset field (in
+//   Type3 and Type5 opcodes)
+//
+// Jump offsets are relative to the opcode, not to its prefix.
+
+// Instr is an opcode together with its wide prefix, which is 0 if the opcode
+// does not need one.
+type Instr struct {
+	Prefix Opcode
+	Opcode Opcode
+}
+
+// Builds an instruction from an opcode and the high bits of its fields.
+func mkInstr(opcode, wide Opcode) Instr {
+	if wide == 0 {
+		return Instr{Opcode: opcode}
+	}
+	return Instr{Prefix: TypeWPfx | wide, Opcode: opcode}
+}
+
+// IsWide returns true if the instruction needs a wide prefix.
+func (i Instr) IsWide() bool {
+	return i.Prefix != 0
+}
+
 // ==================================================================
 // Type1:  1XXXXabc AAAAAAAA BBBBBBBB CCCCCCCC
 //
@@ -92,8 +131,11 @@ func (c Opcode) GetX() BinOp {
 
 // This functions builds the opcode for
 //    rA <- op(rB, rC)
-func mkType1(op BinOp, rA, rB, rC Reg) Opcode {
-	return Type1Pfx | rA.toA() | rB.toB() | rC.toC() | op.encodeX()
+func mkType1(op BinOp, rA, rB, rC Reg) Instr {
+	return mkInstr(
+		Type1Pfx|rA.toA()|rB.toB()|rC.toC()|op.encodeX(),
+		rA.wideA()|rB.wideB()|rC.wideC(),
+	)
 }
 
 // ==================================================================
@@ -108,8 +150,11 @@ func mkType1(op BinOp, rA, rB, rC Reg) Opcode {
 // This builds the opcode for
 //     rA <- rB[rC]  if f is Off
 //     rB[rC] <- rA  if f is On
-func mkType2(f Flag, rA, rB, rC Reg) Opcode {
-	return Type2Pfx | rA.toA() | rB.toB() | rC.toC() | f.encodeF()
+func mkType2(f Flag, rA, rB, rC Reg) Instr {
+	return mkInstr(
+		Type2Pfx|rA.toA()|rB.toB()|rC.toC()|f.encodeF(),
+		rA.wideA()|rB.wideB()|rC.wideC(),
+	)
 }
 
 // ==================================================================
@@ -141,6 +186,7 @@ func (op UnOpK16) LoadsK() bool {
 
 type encoderToN interface {
 	encodeN() Opcode
+	wideN() Opcode
 }
 
 // Lit16 is a 16 bit literal used in several opcode types, used to represent
@@ -152,6 +198,10 @@ func (l Lit16) encodeN() Opcode {
 	return Opcode(l)
 }
 
+func (l Lit16) wideN() Opcode {
+	return 0
+}
+
 // ToInt16 converts l to an int16
 func (l Lit16) ToInt16() int16 {
 	return int16(l)
@@ -179,30 +229,53 @@ func Lit16FromInt16(n int16) Lit16 {
 	return Lit16(n)
 }
 
-// KIndex is an index into the constants table
-type KIndex uint16
+// KIndex is an index into the constants table.  Indexes which do not fit in 16
+// bits need a wide prefix.
+type KIndex uint32
 
 func (i KIndex) encodeN() Opcode {
-	return Opcode(i)
+	return Opcode(uint16(i))
+}
+
+func (i KIndex) wideN() Opcode {
+	return Opcode(i >> 16)
 }
 
 // KIndexFromInt returns a KIndex encoding the given index i, panicking if out
 // of range.
 func KIndexFromInt(i int) KIndex {
-	if i < 0 || i > math.MaxUint16 {
+	if i < 0 || uint64(i) > math.MaxUint32 {
 		panic("constant index out of range")
 	}
 	return KIndex(i)
 }
 
-// SetKIndex returns a copy of the opcode with a new KIndex.
+// SetKIndex returns a copy of the opcode with a new KIndex.  Only the low 16
+// bits of the index are kept (see Instr.SetKIndex).
 func (c Opcode) SetKIndex(i KIndex) Opcode {
 	return c&0xffff0000 | i.encodeN()
 }
 
+// SetKIndex returns a copy of the instruction with a new KIndex, and false if
+// the index does not fit because the instruction has no wide prefix.
+func (i Instr) SetKIndex(k KIndex) (Instr, bool) {
+	i.Opcode = i.Opcode.SetKIndex(k)
+	if i.Prefix == 0 {
+		return i, k.wideN() == 0
+	}
+	i.Prefix = i.Prefix&0xffff0000 | k.wideN()
+	return i, true
+}
+
 // GetKIndex decodes the KIndex from the opcode.
 func (c Opcode) GetKIndex() KIndex {
-	return KIndex(c)
+	return KIndex(uint16(c))
+}
+
+// GetWideKIndex decodes the KIndex from the opcode and its wide prefix w (0 if
+// it has none).
+func (c Opcode) GetWideKIndex(w Opcode) KIndex {
+	return c.GetKIndex() | KIndex(uint16(w))<<16
 }
 
 // GetN decodes the Lit16 from the opcode.
@@ -216,8 +289,11 @@ func (c Opcode) GetY() UnOpK16 {
 }
 
 // Build a Type3 opcode from its constituents.
-func mkType3(f Flag, op UnOpK16, rA Reg, k encoderToN) Opcode {
-	return Type3Pfx | f.encodeF() | op.encodeY() | rA.toA() | k.encodeN()
+func mkType3(f Flag, op UnOpK16, rA Reg, k encoderToN) Instr {
+	return mkInstr(
+		Type3Pfx|f.encodeF()|op.encodeY()|rA.toA()|k.encodeN(),
+		rA.wideA()|k.wideN(),
+	)
 }
 
 // ==================================================================
@@ -252,8 +328,11 @@ func (c Opcode) GetUnOp() UnOp {
 	return UnOp(c & 0xff)
 }
 
-func mkType4a(f Flag, op UnOp, rA, rB Reg) Opcode {
-	return Type4Pfx | type4aFlag | f.encodeF() | op.encodeZ() | rA.toA() | rB.toB()
+func mkType4a(f Flag, op UnOp, rA, rB Reg) Instr {
+	return mkInstr(
+		Type4Pfx|type4aFlag|f.encodeF()|op.encodeZ()|rA.toA()|rB.toB(),
+		rA.wideA()|rB.wideB(),
+	)
 }
 
 // ==================================================================
@@ -325,8 +404,11 @@ func (c Opcode) GetUnOpK() UnOpK {
 }
 
 // Build a Type4b opcode from its constituents
-func mkType4b(f Flag, op UnOpK, rA Reg, k Lit8) Opcode {
-	return Type4Pfx | f.encodeF() | rA.toA() | k.encodeL() | op.encodeZ()
+func mkType4b(f Flag, op UnOpK, rA Reg, k Lit8) Instr {
+	return mkInstr(
+		Type4Pfx|f.encodeF()|rA.toA()|k.encodeL()|op.encodeZ(),
+		rA.wideA(),
+	)
 }
 
 // ==================================================================
@@ -350,13 +432,20 @@ func (op JumpOp) encodeJ() Opcode {
 	return Opcode(op) << 24
 }
 
-// An Offset is a relative position in the code for jumping to.
-type Offset int16
+// An Offset is a relative position in the code for jumping to.  Offsets which
+// do not fit in 16 bits need a wide prefix.
+type Offset int32
 
 func (d Offset) encodeD() Opcode {
 	return Opcode(uint16(d))
 }
 
+// The low 16 bits are a signed offset, so the high bits in the prefix are what
+// must be added to it.
+func (d Offset) wideD() Opcode {
+	return Opcode(uint16((d - Offset(int16(d))) >> 16))
+}
+
 // ClStackOffset is an offset from the bottom of the close stack
 type ClStackOffset uint16
 
@@ -364,8 +453,13 @@ func (d ClStackOffset) encodeD() Opcode {
 	return Opcode(d)
 }
 
+func (d ClStackOffset) wideD() Opcode {
+	return 0
+}
+
 type encoderToD interface {
 	encodeD() Opcode
+	wideD() Opcode
 }
 
 // GetJ decodes the JumpOp from this opcode.
@@ -375,20 +469,48 @@ func (c Opcode) GetJ() JumpOp {
 
 // GetOffset decodes the Offset from the opcode.
 func (c Opcode) GetOffset() Offset {
-	return Offset(uint16(c))
+	return Offset(int16(c))
+}
+
+// GetWideOffset decodes the Offset from the opcode and its wide prefix w (0 if
+// it has none).
+func (c Opcode) GetWideOffset(w Opcode) Offset {
+	return c.GetOffset() + Offset(int16(w))<<16
 }
 
 func (c Opcode) GetClStackOffset() ClStackOffset {
 	return ClStackOffset(uint16(c))
 }
 
-// SetOffset returns a copy of the opcode with the given offset.
+// SetOffset returns a copy of the opcode with the given offset.  Only the low
+// 16 bits of the offset are kept (see Instr.SetOffset).
 func (c Opcode) SetOffset(n Offset) Opcode {
 	return c&0xffff0000 | n.encodeD()
 }
 
-func mkType5(f Flag, op JumpOp, rA Reg, k encoderToD) Opcode {
-	return Type5Pfx | f.encodeF() | op.encodeJ() | rA.toA() | k.encodeD()
+// SetOffset returns a copy of the instruction with the given offset, and false
+// if the offset does not fit because the instruction has no wide prefix.
+func (i Instr) SetOffset(n Offset) (Instr, bool) {
+	i.Opcode = i.Opcode.SetOffset(n)
+	if i.Prefix == 0 {
+		return i, n.wideD() == 0
+	}
+	i.Prefix = i.Prefix&0xffff0000 | n.wideD()
+	return i, true
+}
+
+// Widen returns a copy of the instruction with a wide prefix, adding an empty
+// one if needed.
+func (i Instr) Widen() Instr {
+	i.Prefix |= TypeWPfx
+	return i
+}
+
+func mkType5(f Flag, op JumpOp, rA Reg, k encoderToD) Instr {
+	return mkInstr(
+		Type5Pfx|f.encodeF()|op.encodeJ()|rA.toA()|k.encodeD(),
+		rA.wideA()|k.wideD(),
+	)
 }
 
 // ==================================================================
@@ -418,8 +540,17 @@ func (c Opcode) GetM() Index8 {
 	return Index8(c)
 }
 
-func mkType6(f Flag, rA, rB Reg, i Index8) Opcode {
-	return Type6Pfx | f.encodeF() | rA.toA() | rB.toB() | i.encodeM()
+// GetWideM decodes the M field from the opcode and its wide prefix w (0 if it
+// has none).  It is a 16 bit index.
+func (c Opcode) GetWideM(w Opcode) int {
+	return int(c.GetM()) | int(uint8(w))<<8
+}
+
+func mkType6(f Flag, rA, rB Reg, i uint16) Instr {
+	return mkInstr(
+		Type6Pfx|f.encodeF()|rA.toA()|rB.toB()|Index8(i).encodeM(),
+		rA.wideA()|rB.wideB()|Opcode(i>>8),
+	)
 }
 
 // ==================================================================
@@ -427,8 +558,63 @@ func mkType6(f Flag, rA, rB Reg, i Index8) Opcode {
 //
 // For loop opcodes
 
-func mkType7(f Flag, rA, rB, rC Reg) Opcode {
-	return Type7Pfx | f.encodeF() | rA.toA() | rB.toB() | rC.toC()
+func mkType7(f Flag, rA, rB, rC Reg) Instr {
+	return mkInstr(
+		Type7Pfx|f.encodeF()|rA.toA()|rB.toB()|rC.toC(),
+		rA.wideA()|rB.wideB()|rC.wideC(),
+	)
+}
+
+// ==================================================================
+// Type8:  0001XXXX AAAAAAAA BBBBBBBB CCCCCCCC
+//
+// Opcodes specialised for integer values.
+// - XXXX encodes the operator op (it is never 0, so the opcode is not a wide
+//   prefix)
+// - AAAAAAAA, BBBBBBBB, CCCCCCCC encode the registers rA, rB, rC, which are
+//   value registers (there are no bits left for cells)
+
+// IntOp is the type of operators available in Type8 opcodes.
+type IntOp uint8
+
+// Available integer operators
+const (
+	OpPrepForInt IntOp = iota + 1 // prepare a for loop with integer start and step
+	OpAdvForInt                   // advance a for loop prepared with OpPrepForInt
+	OpAddInt                      // addition of integers
+	OpSubInt                      // subtraction of integers
+	OpMulInt                      // multiplication of integers
+)
+
+// encodeI encodes an IntOp into an opcode.
+func (op IntOp) encodeI() Opcode {
+	return Opcode(op) << 24
+}
+
+// GetIntOp decodes the IntOp from the opcode.
+func (c Opcode) GetIntOp() IntOp {
+	return IntOp(c >> 24 & 0xf)
+}
+
+// GetWideValueRegs returns the registers rA, rB, rC encoded in a Type8 opcode
+// and its wide prefix w (0 if it has none).
+func (c Opcode) GetWideValueRegs(w Opcode) (rA, rB, rC Reg) {
+	rA = ValueReg(uint16(c>>16&0xff) | uint16(w>>16&0xff)<<8)
+	rB = ValueReg(uint16(c>>8&0xff) | uint16(w>>8&0xff)<<8)
+	rC = ValueReg(uint16(c&0xff) | uint16(w&0xff)<<8)
+	return
+}
+
+// Build a Type8 opcode from its constituents.  It returns false if one of the
+// registers is a cell.
+func mkType8(op IntOp, rA, rB, rC Reg) (Instr, bool) {
+	if rA.IsCell() || rB.IsCell() || rC.IsCell() {
+		return Instr{}, false
+	}
+	return mkInstr(
+		Type8Pfx|op.encodeI()|rA.toA()|rB.toB()|rC.toC(),
+		rA.wideA()|rB.wideB()|rC.wideC(),
+	), true
 }
 
 // ==================================================================
@@ -436,8 +622,8 @@ func mkType7(f Flag, rA, rB, rC Reg) Opcode {
 //
 // Receiving args
 
-func mkType0(f Flag, rA Reg) Opcode {
-	return f.encodeF() | rA.toA()
+func mkType0(f Flag, rA Reg) Instr {
+	return mkInstr(f.encodeF()|rA.toA(), rA.wideA())
 }
 
 // ==================================================================
@@ -469,7 +655,7 @@ func (c Opcode) GetF() bool {
 // GetA returns the register rA encoded in the opcode.
 func (c Opcode) GetA() Reg {
 	return Reg{
-		idx: uint8(c >> 16 & 0xff),
+		idx: uint16(c >> 16 & 0xff),
 		tp:  RegType(c >> 26 & 1),
 	}
 }
@@ -477,7 +663,7 @@ func (c Opcode) GetA() Reg {
 // GetB returns the register rB encoded in the opcode.
 func (c Opcode) GetB() Reg {
 	return Reg{
-		idx: uint8(c >> 8 & 0xff),
+		idx: uint16(c >> 8 & 0xff),
 		tp:  RegType(c >> 25 & 1),
 	}
 }
@@ -485,11 +671,35 @@ func (c Opcode) GetB() Reg {
 // GetC returns the register rC encoded in the opcode.
 func (c Opcode) GetC() Reg {
 	return Reg{
-		idx: uint8(c & 0xff),
+		idx: uint16(c & 0xff),
 		tp:  RegType(c >> 24 & 1),
 	}
 }
 
+// GetWideA returns the register rA encoded in the opcode and its wide prefix w
+// (0 if it has none).
+func (c Opcode) GetWideA(w Opcode) Reg {
+	r := c.GetA()
+	r.idx |= uint16(w>>16&0xff) << 8
+	return r
+}
+
+// GetWideB returns the register rB encoded in the opcode and its wide prefix w
+// (0 if it has none).
+func (c Opcode) GetWideB(w Opcode) Reg {
+	r := c.GetB()
+	r.idx |= uint16(w>>8&0xff) << 8
+	return r
+}
+
+// GetWideC returns the register rC encoded in the opcode and its wide prefix w
+// (0 if it has none).
+func (c Opcode) GetWideC(w Opcode) Reg {
+	r := c.GetC()
+	r.idx |= uint16(w&0xff) << 8
+	return r
+}
+
 func (c Opcode) getYorJ() uint8 {
 	return uint8((c >> 24) & 3)
 }
@@ -502,11 +712,17 @@ type OpcodeDisassembler interface {
 
 // Disassemble gets a human readable representation of an opcode.
 func (c Opcode) Disassemble(d OpcodeDisassembler, i int) string {
+	return c.DisassembleWide(d, i, 0)
+}
+
+// DisassembleWide gets a human readable representation of an opcode with the
+// wide prefix w (0 if it has none).
+func (c Opcode) DisassembleWide(d OpcodeDisassembler, i int, w Opcode) string {
 	if c.HasType1() {
 		// Type1
-		rA := c.GetA()
-		rB := c.GetB()
-		rC := c.GetC()
+		rA := c.GetWideA(w)
+		rB := c.GetWideB(w)
+		rC := c.GetWideC(w)
 		tpl := "???"
 		switch c.GetX() {
 		case OpAdd:
@@ -546,19 +762,19 @@ func (c Opcode) Disassemble(d OpcodeDisassembler, i int) string {
 	}
 	switch c.TypePfx() {
 	case Type2Pfx:
-		rA := c.GetA()
+		rA := c.GetWideA(w)
 		f := c.GetF()
-		rB := c.GetB()
-		rC := c.GetC()
+		rB := c.GetWideB(w)
+		rC := c.GetWideC(w)
 		if !f {
 			return fmt.Sprintf("%s <- %s[%s]", rA, rB, rC)
 		}
 		return fmt.Sprintf("%s[%s] <- %s", rB, rC, rA)
 	case Type4Pfx:
-		rA := c.GetA()
+		rA := c.GetWideA(w)
 		f := c.GetF()
 		if c.HasType4a() {
-			rB := c.GetB()
+			rB := c.GetWideB(w)
 			// Type4a
 			tpl := "??? %s"
 			switch c.GetUnOp() {
@@ -613,14 +829,15 @@ func (c Opcode) Disassemble(d OpcodeDisassembler, i int) string {
 		}
 		return fmt.Sprintf("%s <- "+k, rA)
 	case Type0Pfx:
-		rA := c.GetA()
+		rA := c.GetWideA(w)
 		if c.GetF() {
 			return "recv ..." + rA.String()
 		}
 		return "recv " + rA.String()
 	case Type3Pfx:
-		rA := c.GetA()
+		rA := c.GetWideA(w)
 		n := c.GetN()
+		k := c.GetWideKIndex(w)
 		f := c.GetF()
 		// Type3
 		tpl := "???"
@@ -630,24 +847,24 @@ func (c Opcode) Disassemble(d OpcodeDisassembler, i int) string {
 		case OpStr2:
 			tpl = fmt.Sprintf("%q", n.ToStr2())
 		case OpK:
-			tpl = fmt.Sprintf("K%d (%s)", n, d.ShortKString(n.ToKIndex()))
+			tpl = fmt.Sprintf("K%d (%s)", k, d.ShortKString(k))
 		case OpClosureK:
-			tpl = fmt.Sprintf("clos(K%d) (%s)", n, d.ShortKString(n.ToKIndex()))
+			tpl = fmt.Sprintf("clos(K%d) (%s)", k, d.ShortKString(k))
 		}
 		if f {
 			return fmt.Sprintf("push %s, "+tpl, rA)
 		}
 		return fmt.Sprintf("%s <- "+tpl, rA)
 	case Type5Pfx:
-		rA := c.GetA()
+		rA := c.GetWideA(w)
 		f := c.GetF()
 		switch c.GetJ() {
 		case OpJump:
-			j := int(c.GetOffset())
+			j := int(c.GetWideOffset(w))
 			dest := i + j
 			return fmt.Sprintf("jump %+d (%s)", j, d.GetLabel(dest))
 		case OpJumpIf:
-			j := int(c.GetOffset())
+			j := int(c.GetWideOffset(w))
 			dest := i + j
 			not := ""
 			if !f {
@@ -670,21 +887,40 @@ func (c Opcode) Disassemble(d OpcodeDisassembler, i int) string {
 			return "???"
 		}
 	case Type6Pfx:
-		rA := c.GetA()
-		rB := c.GetB()
+		rA := c.GetWideA(w)
+		rB := c.GetWideB(w)
 		f := c.GetF()
-		m := c.GetM()
+		m := c.GetWideM(w)
 		if f {
 			return fmt.Sprintf("fill %s, %d, %s", rA, m, rB)
 		}
 		return fmt.Sprintf("%s <- etclookup(%s, %d)", rA, rB, m)
 	case Type7Pfx:
-		rStart, rStop, rStep := c.GetA(), c.GetB(), c.GetC()
+		rStart, rStop, rStep := c.GetWideA(w), c.GetWideB(w), c.GetWideC(w)
 		action := "prep"
 		if c.GetF() {
 			action = "adv"
 		}
 		return fmt.Sprintf("%sfor %s, %s, %s", action, rStart, rStop, rStep)
+	case Type8Pfx:
+		if c.IsWidePrefix() {
+			return "wide"
+		}
+		rA, rB, rC := c.GetWideValueRegs(w)
+		switch c.GetIntOp() {
+		case OpPrepForInt:
+			return fmt.Sprintf("prepfor.i %s, %s, %s", rA, rB, rC)
+		case OpAdvForInt:
+			return fmt.Sprintf("advfor.i %s, %s, %s", rA, rB, rC)
+		case OpAddInt:
+			return fmt.Sprintf("%s <- %s +i %s", rA, rB, rC)
+		case OpSubInt:
+			return fmt.Sprintf("%s <- %s -i %s", rA, rB, rC)
+		case OpMulInt:
+			return fmt.Sprintf("%s <- %s *i %s", rA, rB, rC)
+		default:
+			return "???"
+		}
 	default:
 		return "???"
 	}
@@ -14,11 +14,11 @@ const (
 // Reg is a register
 type Reg struct {
 	tp  RegType
-	idx uint8
+	idx uint16
 }
 
 // ValueReg returns a value register.
-func ValueReg(idx uint8) Reg {
+func ValueReg(idx uint16) Reg {
 	return Reg{
 		idx: idx,
 		tp:  ValueRegType,
@@ -26,7 +26,7 @@ func ValueReg(idx uint8) Reg {
 }
 
 // CellReg returns a cell register.
-func CellReg(idx uint8) Reg {
+func CellReg(idx uint16) Reg {
 	return Reg{
 		idx: idx,
 		tp:  CellRegType,
@@ -34,7 +34,7 @@ func CellReg(idx uint8) Reg {
 }
 
 // Idx returns the index of the register
-func (r Reg) Idx() uint8 {
+func (r Reg) Idx() uint16 {
 	return r.idx
 }
 
@@ -48,16 +48,31 @@ func (r Reg) IsCell() bool {
 	return r.tp == CellRegType
 }
 
+// The opcode only contains the low 8 bits of the register index, the high 8
+// bits go in the wide prefix (see wideA, wideB, wideC).
+
 func (r Reg) toA() Opcode {
-	return Opcode(r.Idx())<<16 | Opcode(r.RegType())<<26
+	return Opcode(uint8(r.idx))<<16 | Opcode(r.RegType())<<26
 }
 
 func (r Reg) toB() Opcode {
-	return Opcode(r.Idx())<<8 | Opcode(r.RegType())<<25
+	return Opcode(uint8(r.idx))<<8 | Opcode(r.RegType())<<25
 }
 
 func (r Reg) toC() Opcode {
-	return Opcode(r.Idx()) | Opcode(r.RegType())<<24
+	return Opcode(uint8(r.idx)) | Opcode(r.RegType())<<24
+}
+
+func (r Reg) wideA() Opcode {
+	return Opcode(r.idx>>8) << 16
+}
+
+func (r Reg) wideB() Opcode {
+	return Opcode(r.idx>>8) << 8
+}
+
+func (r Reg) wideC() Opcode {
+	return Opcode(r.idx >> 8)
 }
 
 func (r Reg) String() string {
@@ -45,8 +45,13 @@ func (d *unitDisassembler) disassemble(w io.Writer) {
 	}
 	disCode := make([]string, len(d.unit.Code))
 	maxSpanLen := 10
+	var wide Opcode
 	for i, opcode := range d.unit.Code {
-		disCode[i] = opcode.Disassemble(d, i)
+		disCode[i] = opcode.DisassembleWide(d, i, wide)
+		wide = 0
+		if opcode.IsWidePrefix() {
+			wide = opcode
+		}
 		if l := len(d.spans[i]); l > maxSpanLen {
 			maxSpanLen = l
 		}
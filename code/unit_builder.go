@@ -6,12 +6,20 @@ type Label uint
 // A Builder helps build a code Unit (in particular it calculates the offsets
 // for jump instructions).
 type Builder struct {
-	source    string          // identifies the source of the code
-	lines     []int32         // lines in the source code corresponding to the opcodes
-	code      []Opcode        // opcodes emitted
-	jumpTo    map[Label]int   // destination locations for the labels
-	jumpFrom  map[Label][]int // lists of locations for opcode that jump to a given label
-	constants []Constant      // constants required for the code
+	source       string               // identifies the source of the code
+	lines        []int32              // lines in the source code corresponding to the opcodes
+	code         []Opcode             // opcodes emitted
+	jumpTo       map[Label]int        // destination locations for the labels
+	jumpFrom     map[Label][]jumpSite // lists of locations for opcode that jump to a given label
+	constants    []Constant           // constants required for the code
+	wideJumps    bool                 // true if all jumps are emitted with a wide prefix
+	jumpOverflow bool                 // true if a jump could not be encoded
+}
+
+// A jumpSite is the location of a jump opcode whose offset is not known yet.
+type jumpSite struct {
+	addr int  // location of the opcode
+	wide bool // true if the opcode has a wide prefix
 }
 
 // NewBuilder returns an empty Builder for the given source.
@@ -19,28 +27,55 @@ func NewBuilder(source string) *Builder {
 	return &Builder{
 		source:   source,
 		jumpTo:   make(map[Label]int),
-		jumpFrom: make(map[Label][]int),
+		jumpFrom: make(map[Label][]jumpSite),
+	}
+}
+
+// Emit adds an instruction (associating it with a source code line).  If it
+// has a wide prefix, the prefix is emitted first.
+func (c *Builder) Emit(instr Instr, line int) {
+	if instr.IsWide() {
+		c.emitOpcode(instr.Prefix, line)
 	}
+	c.emitOpcode(instr.Opcode, line)
 }
 
-// Emit adds an opcode (associating it with a source code line).
-func (c *Builder) Emit(opcode Opcode, line int) {
+func (c *Builder) emitOpcode(opcode Opcode, line int) {
 	c.code = append(c.code, opcode)
 	c.lines = append(c.lines, int32(line))
 }
 
-// EmitJump adds a jump opcode, jumping to the given label.  The offset part of
-// the opcode must be left as 0, it will be filled by the builder when the
-// location of the label is known.
-func (c *Builder) EmitJump(opcode Opcode, lbl Label, line int) {
+// EmitJump adds a jump instruction, jumping to the given label.  The offset part
+// of the instruction must be left as 0, it will be filled by the builder when
+// the location of the label is known.
+//
+// Backward jumps get a wide prefix if needed.  Forward jumps only get one if
+// wide jumps are set (see SetWideJumps), otherwise JumpOverflow reports when
+// their offset does not fit.
+func (c *Builder) EmitJump(instr Instr, lbl Label, line int) {
+	if c.wideJumps {
+		instr = instr.Widen()
+	}
 	jumpToAddr, ok := c.jumpTo[lbl]
-	addr := len(c.code)
 	if ok {
-		opcode = opcode.SetOffset(Offset(jumpToAddr - addr))
-	} else {
-		c.jumpFrom[lbl] = append(c.jumpFrom[lbl], addr)
+		if !instr.IsWide() {
+			var fits bool
+			instr, fits = instr.SetOffset(Offset(jumpToAddr - len(c.code)))
+			if !fits {
+				instr = instr.Widen()
+			}
+		}
+		if instr.IsWide() {
+			// The offset is relative to the opcode, which comes after the
+			// prefix.
+			instr, _ = instr.SetOffset(Offset(jumpToAddr - len(c.code) - 1))
+		}
+	}
+	c.Emit(instr, line)
+	if !ok {
+		addr := len(c.code) - 1
+		c.jumpFrom[lbl] = append(c.jumpFrom[lbl], jumpSite{addr: addr, wide: instr.IsWide()})
 	}
-	c.Emit(opcode, line)
 }
 
 // EmitLabel adds a label for the current location.  It panics if called twice
@@ -51,12 +86,46 @@ func (c *Builder) EmitLabel(lbl Label) {
 		panic("Label already emitted for a different location")
 	}
 	c.jumpTo[lbl] = addr
-	for _, jumpFromAddr := range c.jumpFrom[lbl] {
-		c.code[jumpFromAddr] = c.code[jumpFromAddr].SetOffset(Offset(addr - jumpFromAddr))
+	for _, site := range c.jumpFrom[lbl] {
+		instr := Instr{Opcode: c.code[site.addr]}
+		if site.wide {
+			instr.Prefix = c.code[site.addr-1]
+		}
+		instr, fits := instr.SetOffset(Offset(addr - site.addr))
+		if !fits {
+			c.jumpOverflow = true
+		}
+		c.code[site.addr] = instr.Opcode
+		if site.wide {
+			c.code[site.addr-1] = instr.Prefix
+		}
 	}
 	delete(c.jumpFrom, lbl)
 }
 
+// SetWideJumps sets whether all jumps should be emitted with a wide prefix, so
+// that they can reach any location.
+func (c *Builder) SetWideJumps(wide bool) {
+	c.wideJumps = wide
+}
+
+// JumpOverflow returns true if a jump emitted since the last call to Offset or
+// Rewind could not be encoded because its destination is too far.  The code
+// must then be emitted again with wide jumps (see SetWideJumps).
+func (c *Builder) JumpOverflow() bool {
+	return c.jumpOverflow
+}
+
+// Rewind removes all the opcodes emitted after the given location, which must
+// be a value returned by Offset.
+func (c *Builder) Rewind(offset uint) {
+	c.code = c.code[:offset]
+	c.lines = c.lines[:offset]
+	c.jumpTo = make(map[Label]int)
+	c.jumpFrom = make(map[Label][]jumpSite)
+	c.jumpOverflow = false
+}
+
 // Offset returns the current location.  It must be called when all emitted jump
 // labels have been resolved, otherwise it panice.
 func (c *Builder) Offset() uint {
@@ -64,6 +133,13 @@ func (c *Builder) Offset() uint {
 		panic("Illegal offset")
 	}
 	c.jumpTo = make(map[Label]int)
+	c.jumpOverflow = false
+	return uint(len(c.code))
+}
+
+// Len returns the number of opcodes emitted so far.  Contrary to Offset, it
+// can be called at any time.
+func (c *Builder) Len() uint {
 	return uint(len(c.code))
 }
 
@@ -0,0 +1,224 @@
+// Package coverage turns the coverage counters collected by a
+// runtime.CoverageCollector into line and branch coverage reports.
+//
+// A line is covered if at least one of its instructions has been executed.
+// Each conditional jump in the code gives two branches: the jump being taken
+// and the jump not being taken.  A branch is covered if it has been followed
+// at least once.
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/arnodel/golua/code"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// A Report gives the coverage of a number of source files.
+type Report struct {
+	Files []*FileCoverage // Sorted by source
+}
+
+// FileCoverage is the coverage of a source file.
+type FileCoverage struct {
+	Source   string
+	Lines    []LineCoverage   // Lines which contain code, in increasing order
+	Branches []BranchCoverage // In increasing line order
+}
+
+// LineCoverage gives the number of times a line was executed.
+type LineCoverage struct {
+	Line int32
+	Hits uint64
+}
+
+// BranchCoverage gives the number of times a branch was followed.
+type BranchCoverage struct {
+	Line      int32
+	Block     int    // Index of the conditional jump in the line
+	Branch    int    // 0 when the jump is taken, 1 when it is not
+	Evaluated bool   // True if the condition was ever evaluated
+	Taken     uint64 // Number of times the branch was followed
+}
+
+// NewReport makes a report from the coverage of code units.  Units with the
+// same source are merged, e.g. when a file is loaded more than once.
+func NewReport(units []*rt.UnitCoverage) *Report {
+	files := map[string]*fileCounts{}
+	for _, u := range units {
+		f := files[u.Source]
+		if f == nil {
+			f = &fileCounts{lines: map[int32]uint64{}, branches: map[branchKey]*BranchCoverage{}}
+			files[u.Source] = f
+		}
+		f.add(u)
+	}
+	report := &Report{}
+	for source, f := range files {
+		report.Files = append(report.Files, f.fileCoverage(source))
+	}
+	sort.Slice(report.Files, func(i, j int) bool {
+		return report.Files[i].Source < report.Files[j].Source
+	})
+	return report
+}
+
+// LineCount returns the number of lines with code and the number of those
+// which are covered.
+func (f *FileCoverage) LineCount() (total, covered int) {
+	for _, l := range f.Lines {
+		if l.Hits > 0 {
+			covered++
+		}
+	}
+	return len(f.Lines), covered
+}
+
+// BranchCount returns the number of branches and the number of those which
+// are covered.
+func (f *FileCoverage) BranchCount() (total, covered int) {
+	for _, b := range f.Branches {
+		if b.Taken > 0 {
+			covered++
+		}
+	}
+	return len(f.Branches), covered
+}
+
+// WriteLCOV writes the report in the LCOV tracefile format, which is
+// understood by genhtml and most coverage services.
+func (r *Report) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range r.Files {
+		fmt.Fprintf(bw, "TN:\nSF:%s\n", f.Source)
+		for _, b := range f.Branches {
+			taken := "-"
+			if b.Evaluated {
+				taken = fmt.Sprint(b.Taken)
+			}
+			fmt.Fprintf(bw, "BRDA:%d,%d,%d,%s\n", b.Line, b.Block, b.Branch, taken)
+		}
+		branches, coveredBranches := f.BranchCount()
+		fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", branches, coveredBranches)
+		for _, l := range f.Lines {
+			fmt.Fprintf(bw, "DA:%d,%d\n", l.Line, l.Hits)
+		}
+		lines, coveredLines := f.LineCount()
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", lines, coveredLines)
+	}
+	return bw.Flush()
+}
+
+// WriteSummary writes a plain text summary of the report, with the line and
+// branch coverage of each file followed by the totals.
+func (r *Report) WriteSummary(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var lines, coveredLines, branches, coveredBranches int
+	for _, f := range r.Files {
+		l, cl := f.LineCount()
+		b, cb := f.BranchCount()
+		writeSummaryLine(bw, f.Source, l, cl, b, cb)
+		lines += l
+		coveredLines += cl
+		branches += b
+		coveredBranches += cb
+	}
+	writeSummaryLine(bw, "total", lines, coveredLines, branches, coveredBranches)
+	return bw.Flush()
+}
+
+func writeSummaryLine(w io.Writer, name string, lines, coveredLines, branches, coveredBranches int) {
+	fmt.Fprintf(w, "%s: lines %s, branches %s\n",
+		name, ratio(coveredLines, lines), ratio(coveredBranches, branches))
+}
+
+func ratio(n, total int) string {
+	if total == 0 {
+		return "0/0"
+	}
+	return fmt.Sprintf("%d/%d (%.1f%%)", n, total, 100*float64(n)/float64(total))
+}
+
+type branchKey struct {
+	line   int32
+	block  int
+	branch int
+}
+
+// The counts for a source file, accumulated over a number of units.
+type fileCounts struct {
+	lines    map[int32]uint64
+	branches map[branchKey]*BranchCoverage
+}
+
+func (f *fileCounts) add(u *rt.UnitCoverage) {
+	if u.Unit.Lines == nil {
+		return
+	}
+	// Hits for the lines of this unit.  As a line may contain many
+	// instructions, a line is considered executed as many times as its most
+	// executed instruction.
+	unitLines := map[int32]uint64{}
+	blocks := map[int32]int{}
+	for pc, opcode := range u.Unit.Code {
+		line := u.Unit.Lines[pc]
+		if line <= 0 {
+			continue
+		}
+		hits, jumps := u.Counts(pc)
+		if cur, ok := unitLines[line]; !ok || hits > cur {
+			unitLines[line] = hits
+		}
+		if !isConditionalJump(opcode) {
+			continue
+		}
+		block := blocks[line]
+		blocks[line]++
+		f.addBranch(branchKey{line, block, 0}, hits > 0, jumps)
+		f.addBranch(branchKey{line, block, 1}, hits > 0, hits-jumps)
+	}
+	for line, hits := range unitLines {
+		f.lines[line] += hits
+	}
+}
+
+func (f *fileCounts) addBranch(k branchKey, evaluated bool, taken uint64) {
+	b := f.branches[k]
+	if b == nil {
+		b = &BranchCoverage{Line: k.line, Block: k.block, Branch: k.branch}
+		f.branches[k] = b
+	}
+	b.Evaluated = b.Evaluated || evaluated
+	b.Taken += taken
+}
+
+func (f *fileCounts) fileCoverage(source string) *FileCoverage {
+	fc := &FileCoverage{Source: source}
+	for line, hits := range f.lines {
+		fc.Lines = append(fc.Lines, LineCoverage{Line: line, Hits: hits})
+	}
+	sort.Slice(fc.Lines, func(i, j int) bool {
+		return fc.Lines[i].Line < fc.Lines[j].Line
+	})
+	for _, b := range f.branches {
+		fc.Branches = append(fc.Branches, *b)
+	}
+	sort.Slice(fc.Branches, func(i, j int) bool {
+		bi, bj := fc.Branches[i], fc.Branches[j]
+		if bi.Line != bj.Line {
+			return bi.Line < bj.Line
+		}
+		if bi.Block != bj.Block {
+			return bi.Block < bj.Block
+		}
+		return bi.Branch < bj.Branch
+	})
+	return fc
+}
+
+func isConditionalJump(opcode code.Opcode) bool {
+	return !opcode.HasType1() && opcode.TypePfx() == code.Type5Pfx && opcode.GetJ() == code.OpJumpIf
+}
@@ -0,0 +1,128 @@
+package coverage
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+const testSource = `local function sign(x)
+    if x > 0 then
+        return 1
+    else
+        return -1
+    end
+end
+for i = 1, 3 do
+    sign(i)
+end
+`
+
+func runWithCoverage(t *testing.T, times int) *Report {
+	r := rt.New(nil)
+	c := rt.NewCoverageCollector()
+	r.SetCoverageCollector(c)
+	for i := 0; i < times; i++ {
+		clos, err := r.CompileAndLoadLuaChunk("test.lua", []byte(testSource), rt.TableValue(r.GlobalEnv()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rt.Call(r.MainThread(), rt.FunctionValue(clos), nil, rt.NewTerminationWith(nil, 0, false)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewReport(c.Units())
+}
+
+func TestReport(t *testing.T) {
+	report := runWithCoverage(t, 1)
+	if len(report.Files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(report.Files))
+	}
+	f := report.Files[0]
+	hits := map[int32]uint64{}
+	for _, l := range f.Lines {
+		hits[l.Line] = l.Hits
+	}
+	if hits[3] != 3 || hits[5] != 0 || hits[9] != 3 {
+		t.Errorf("unexpected line hits %v", hits)
+	}
+	if total, covered := f.LineCount(); covered != total-1 {
+		t.Errorf("expected one uncovered line, got %d/%d", covered, total)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteLCOV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lcov := buf.String()
+	for _, s := range []string{"SF:test.lua\n", "DA:3,3\n", "DA:5,0\n", "BRDA:2,0,0,0\n", "BRDA:2,0,1,3\n", "end_of_record\n"} {
+		if !strings.Contains(lcov, s) {
+			t.Errorf("LCOV output does not contain %q:\n%s", s, lcov)
+		}
+	}
+
+	buf.Reset()
+	if err := report.WriteSummary(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "test.lua: lines ") || !strings.Contains(buf.String(), "branches 1/2 (50.0%)") {
+		t.Errorf("unexpected summary:\n%s", buf.String())
+	}
+}
+
+func TestReportMergesUnits(t *testing.T) {
+	report := runWithCoverage(t, 2)
+	if len(report.Files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(report.Files))
+	}
+	for _, l := range report.Files[0].Lines {
+		if l.Line == 3 && l.Hits != 6 {
+			t.Errorf("expected 6 hits on line 3, got %d", l.Hits)
+		}
+	}
+}
+
+// Runtimes created from a snapshot share the counters of the code they copy,
+// and can run concurrently (run with -race).
+func TestConcurrentRuntimes(t *testing.T) {
+	r := rt.New(nil)
+	c := rt.NewCoverageCollector()
+	r.SetCoverageCollector(c)
+	clos, err := r.CompileAndLoadLuaChunk("test.lua", []byte("sign = "+strings.Replace(testSource, "local function sign", "function", 1)), rt.TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Call(r.MainThread(), rt.FunctionValue(clos), nil, rt.NewTerminationWith(nil, 0, false)); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := r.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 4
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		r := snapshot.NewRuntime(nil)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sign := r.GlobalEnv().Get(rt.StringValue("sign"))
+			for j := 0; j < 100; j++ {
+				rt.Call1(r.MainThread(), sign, rt.IntValue(1))
+			}
+		}()
+		NewReport(c.Units())
+	}
+	wg.Wait()
+	hits := map[int32]uint64{}
+	for _, l := range NewReport(c.Units()).Files[0].Lines {
+		hits[l.Line] = l.Hits
+	}
+	if hits[3] != 3+n*100 {
+		t.Errorf("expected %d hits on line 3, got %d", 3+n*100, hits[3])
+	}
+}
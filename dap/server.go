@@ -0,0 +1,599 @@
+// Package dap implements a Debug Adapter Protocol server, so that Lua code
+// running in golua can be debugged from editors which support the protocol.
+//
+// A Server handles one debugging session.  The client launches a Lua program
+// (only the "launch" request is supported, not "attach"), which then runs
+// under the control of a debugger.Debugger.  Breakpoints, stepping, stack
+// frames, scopes (locals, upvalues and globals), variables and evaluation of
+// expressions are supported.  There is only one thread, the main Lua thread of
+// the program.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync"
+
+	"github.com/arnodel/golua/debugger"
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// The only thread reported to the client.
+const mainThreadID = 1
+
+var (
+	errNotPaused   = errors.New("the program is not paused")
+	errNotLaunched = errors.New("no program launched")
+)
+
+// Server serves a debugging session.
+type Server struct {
+	in *bufio.Reader
+
+	outMux sync.Mutex // Protects the fields below
+	out    io.Writer
+	seq    int
+
+	dbg    *debugger.Debugger
+	launch *launchArguments
+	done   chan struct{} // Closed when the program has finished
+
+	mux         sync.Mutex // Protects the fields below
+	pause       *debugger.Pause
+	handles     []interface{} // Values of variable references, reset on resume
+	terminating bool          // True when the client wants to stop the program
+	entry       bool          // True until the program pauses for the first time
+
+	// Communication with the paused Lua thread.
+	calls  chan func()
+	resume chan debugger.Action
+}
+
+var _ debugger.Frontend = (*Server)(nil)
+
+// NewServer returns a server reading requests from in and writing responses
+// and events to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	s := &Server{
+		in:     bufio.NewReader(in),
+		out:    out,
+		calls:  make(chan func()),
+		resume: make(chan debugger.Action),
+	}
+	s.dbg = debugger.New(s)
+	return s
+}
+
+// ListenAndServe listens on the TCP network address addr and serves debugging
+// sessions on incoming connections, one at a time.
+func ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		err = NewServer(conn, conn).Serve()
+		conn.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Serve handles requests until the client disconnects.  If the program is
+// still running at that point, it is stopped.
+func (s *Server) Serve() error {
+	defer s.stopProgram()
+	for {
+		msg, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(msg, &req); err != nil {
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+		body, err := s.handle(&req)
+		if err := s.respond(&req, body, err); err != nil {
+			return err
+		}
+		switch req.Command {
+		case "initialize":
+			s.sendEvent("initialized", nil)
+		case "disconnect":
+			return nil
+		}
+	}
+}
+
+// Paused implements debugger.Frontend.Paused.  It tells the client the program
+// stopped, then runs the functions sent by the request handler until it is told
+// to resume.
+func (s *Server) Paused(p *debugger.Pause) debugger.Action {
+	s.mux.Lock()
+	if s.terminating {
+		s.mux.Unlock()
+		return debugger.Quit
+	}
+	s.pause = p
+	reason := p.Reason.String()
+	if s.entry && p.Reason == debugger.PausedOnStep {
+		reason = "entry"
+	}
+	s.entry = false
+	s.mux.Unlock()
+
+	s.sendEvent("stopped", stoppedBody{
+		Reason:            reason,
+		ThreadID:          mainThreadID,
+		AllThreadsStopped: true,
+	})
+	for {
+		select {
+		case f := <-s.calls:
+			f()
+		case action := <-s.resume:
+			s.mux.Lock()
+			s.pause = nil
+			s.handles = nil
+			s.mux.Unlock()
+			return action
+		}
+	}
+}
+
+// Handle a request, returning the body of the response.
+func (s *Server) handle(req *request) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+		}, nil
+	case "launch":
+		var args launchArguments
+		if err := s.parseArgs(req, &args); err != nil {
+			return nil, err
+		}
+		return nil, s.setLaunchArgs(&args)
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err := s.parseArgs(req, &args); err != nil {
+			return nil, err
+		}
+		return s.setBreakpoints(&args), nil
+	case "setExceptionBreakpoints":
+		return nil, nil
+	case "configurationDone":
+		return nil, s.startProgram()
+	case "threads":
+		return threadsBody{Threads: []thread{{ID: mainThreadID, Name: "main"}}}, nil
+	case "stackTrace":
+		var args stackTraceArguments
+		if err := s.parseArgs(req, &args); err != nil {
+			return nil, err
+		}
+		return s.stackTrace(&args)
+	case "scopes":
+		var args scopesArguments
+		if err := s.parseArgs(req, &args); err != nil {
+			return nil, err
+		}
+		return s.scopes(&args)
+	case "variables":
+		var args variablesArguments
+		if err := s.parseArgs(req, &args); err != nil {
+			return nil, err
+		}
+		return s.variables(&args)
+	case "evaluate":
+		var args evaluateArguments
+		if err := s.parseArgs(req, &args); err != nil {
+			return nil, err
+		}
+		return s.evaluate(&args)
+	case "continue":
+		return continueBody{AllThreadsContinued: true}, s.resumeWith(debugger.Continue)
+	case "next":
+		return nil, s.resumeWith(debugger.StepOver)
+	case "stepIn":
+		return nil, s.resumeWith(debugger.StepIn)
+	case "stepOut":
+		return nil, s.resumeWith(debugger.StepOut)
+	case "pause":
+		s.dbg.Pause()
+		return nil, nil
+	case "terminate", "disconnect":
+		s.stopProgram()
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported request %q", req.Command)
+	}
+}
+
+func (s *Server) parseArgs(req *request, args interface{}) error {
+	if len(req.Arguments) == 0 {
+		return nil
+	}
+	return json.Unmarshal(req.Arguments, args)
+}
+
+func (s *Server) setLaunchArgs(args *launchArguments) error {
+	if args.Program == "" {
+		return errors.New("missing program")
+	}
+	// Breakpoints are set with absolute paths by clients.
+	program, err := filepath.Abs(args.Program)
+	if err != nil {
+		return err
+	}
+	args.Program = program
+	s.launch = args
+	return nil
+}
+
+func (s *Server) setBreakpoints(args *setBreakpointsArguments) breakpointsBody {
+	file := args.Source.Path
+	s.dbg.ClearBreakpoints(file)
+	bps := make([]breakpoint, len(args.Breakpoints))
+	for i, b := range args.Breakpoints {
+		s.dbg.SetBreakpoint(debugger.Breakpoint{File: file, Line: int32(b.Line)})
+		bps[i] = breakpoint{Verified: true, Line: b.Line}
+	}
+	return breakpointsBody{Breakpoints: bps}
+}
+
+// Start running the program in a new goroutine.
+func (s *Server) startProgram() error {
+	if s.launch == nil {
+		return errNotLaunched
+	}
+	if s.done != nil {
+		return errors.New("program already started")
+	}
+	s.done = make(chan struct{})
+	s.entry = s.launch.StopOnEntry
+	if s.launch.StopOnEntry {
+		s.dbg.SetAction(debugger.StepIn)
+	}
+	go func() {
+		defer close(s.done)
+		exitCode := 0
+		if err := s.runProgram(s.launch); err != nil && !s.dbg.HasQuit() {
+			s.sendEvent("output", outputBody{Category: "stderr", Output: err.Error() + "\n"})
+			exitCode = 1
+		}
+		s.sendEvent("exited", exitedBody{ExitCode: exitCode})
+		s.sendEvent("terminated", nil)
+	}()
+	return nil
+}
+
+func (s *Server) runProgram(args *launchArguments) error {
+	chunk, err := ioutil.ReadFile(args.Program)
+	if err != nil {
+		return err
+	}
+	// The program's standard streams are not those of the process, which may
+	// be used for the protocol.  Output is sent to the client instead.
+	stdout := &outputWriter{server: s, category: "stdout"}
+	r := rt.New(stdout, rt.WithStdio(rt.Stdio{
+		Stdin:  eofReader{},
+		Stdout: stdout,
+		Stderr: &outputWriter{server: s, category: "stderr"},
+	}))
+	cleanup := lib.LoadAll(r)
+	defer cleanup()
+
+	argTable := r.NewTable()
+	argVals := make([]rt.Value, len(args.Args))
+	for i, arg := range args.Args {
+		argVals[i] = rt.StringValue(arg)
+		r.SetTable(argTable, rt.IntValue(int64(i+1)), argVals[i])
+	}
+	r.SetTable(r.GlobalEnv(), rt.StringValue("arg"), rt.TableValue(argTable))
+
+	if !args.NoDebug {
+		s.dbg.Attach(r.MainThread())
+	}
+	clos, err := r.LoadFromSourceOrCode(args.Program, chunk, "bt", rt.TableValue(r.GlobalEnv()), true)
+	if err != nil {
+		return err
+	}
+	return rt.Call(r.MainThread(), rt.FunctionValue(clos), argVals, rt.NewTerminationWith(nil, 0, false))
+}
+
+// Stop the program if it is running.  It will stop at the next line executed.
+func (s *Server) stopProgram() {
+	s.mux.Lock()
+	s.terminating = true
+	paused := s.pause != nil
+	s.mux.Unlock()
+	if paused {
+		s.resume <- debugger.Quit
+	} else {
+		s.dbg.Pause()
+	}
+}
+
+// Resume the paused program.  Only the request handler sends to s.resume, so
+// the program cannot resume between the check and the send.
+func (s *Server) resumeWith(action debugger.Action) error {
+	s.mux.Lock()
+	paused := s.pause != nil
+	s.mux.Unlock()
+	if !paused {
+		return errNotPaused
+	}
+	s.resume <- action
+	return nil
+}
+
+// Run f on the goroutine of the paused program, and wait until it returns.
+func (s *Server) inPause(f func(p *debugger.Pause) error) error {
+	s.mux.Lock()
+	p := s.pause
+	s.mux.Unlock()
+	if p == nil {
+		return errNotPaused
+	}
+	var err error
+	done := make(chan struct{})
+	s.calls <- func() {
+		defer close(done)
+		err = f(p)
+	}
+	<-done
+	return err
+}
+
+func (s *Server) stackTrace(args *stackTraceArguments) (body stackTraceBody, err error) {
+	err = s.inPause(func(p *debugger.Pause) error {
+		frames := p.Frames
+		body.TotalFrames = len(frames)
+		start := args.StartFrame
+		if start > len(frames) {
+			start = len(frames)
+		}
+		end := len(frames)
+		if args.Levels > 0 && start+args.Levels < end {
+			end = start + args.Levels
+		}
+		body.StackFrames = []stackFrame{}
+		for i := start; i < end; i++ {
+			info := frames[i].Info
+			name := info.Name
+			if name == "" {
+				name = "?"
+			}
+			sf := stackFrame{
+				ID:     i + 1, // Frame ids must be positive
+				Name:   name,
+				Line:   int(info.CurrentLine),
+				Column: 1,
+			}
+			if frames[i].IsLua() {
+				sf.Source = &source{Name: filepath.Base(info.Source), Path: info.Source}
+			} else {
+				sf.Source = &source{Name: info.Source, PresentationHint: "deemphasize"}
+			}
+			body.StackFrames = append(body.StackFrames, sf)
+		}
+		return nil
+	})
+	return
+}
+
+// The kinds of scope in a frame.
+const (
+	localsScope = iota
+	upvaluesScope
+	globalsScope
+)
+
+// A scopeRef is the value of the variable reference of a scope.
+type scopeRef struct {
+	frame *debugger.Frame
+	kind  int
+}
+
+func (s *Server) scopes(args *scopesArguments) (body scopesBody, err error) {
+	err = s.inPause(func(p *debugger.Pause) error {
+		frame, err := getFrame(p, args.FrameID)
+		if err != nil {
+			return err
+		}
+		body.Scopes = []scope{
+			{Name: "Locals", VariablesReference: s.newHandle(&scopeRef{frame, localsScope})},
+			{Name: "Upvalues", VariablesReference: s.newHandle(&scopeRef{frame, upvaluesScope})},
+			{Name: "Globals", VariablesReference: s.newHandle(&scopeRef{frame, globalsScope}), Expensive: true},
+		}
+		return nil
+	})
+	return
+}
+
+func (s *Server) variables(args *variablesArguments) (body variablesBody, err error) {
+	err = s.inPause(func(p *debugger.Pause) error {
+		h, err := s.getHandle(args.VariablesReference)
+		if err != nil {
+			return err
+		}
+		body.Variables = []variable{}
+		switch x := h.(type) {
+		case *scopeRef:
+			var vars []debugger.Variable
+			switch x.kind {
+			case localsScope:
+				vars = x.frame.Locals()
+			case upvaluesScope:
+				vars = x.frame.Upvalues()
+			case globalsScope:
+				if t, ok := x.frame.Globals(p.Thread).TryTable(); ok {
+					vars = tableFields(t)
+				}
+			}
+			for _, v := range vars {
+				body.Variables = append(body.Variables, s.newVariable(v.Name, v.Value))
+			}
+		case *rt.Table:
+			for _, v := range tableFields(x) {
+				body.Variables = append(body.Variables, s.newVariable(v.Name, v.Value))
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (s *Server) evaluate(args *evaluateArguments) (body evaluateBody, err error) {
+	err = s.inPause(func(p *debugger.Pause) error {
+		frame, err := getFrame(p, args.FrameID)
+		if err != nil {
+			return err
+		}
+		vals, err := frame.Eval(p.Thread, args.Expression)
+		if err != nil {
+			return err
+		}
+		if len(vals) == 0 {
+			return nil
+		}
+		v := s.newVariable("", vals[0])
+		body.Result = v.Value
+		body.Type = v.Type
+		body.VariablesReference = v.VariablesReference
+		for _, val := range vals[1:] {
+			body.Result += ", " + debugger.FormatValue(val)
+		}
+		return nil
+	})
+	return
+}
+
+// Return the frame with the given id.  The top frame is used if the id is 0.
+func getFrame(p *debugger.Pause, id int) (*debugger.Frame, error) {
+	if id == 0 {
+		id = 1
+	}
+	if id < 1 || id > len(p.Frames) {
+		return nil, fmt.Errorf("invalid frame id %d", id)
+	}
+	return p.Frames[id-1], nil
+}
+
+// Make a DAP variable, allocating a reference if the value is a table so its
+// fields can be inspected.
+func (s *Server) newVariable(name string, val rt.Value) variable {
+	v := variable{
+		Name:  name,
+		Value: debugger.FormatValue(val),
+		Type:  val.TypeName(),
+	}
+	if t, ok := val.TryTable(); ok {
+		v.VariablesReference = s.newHandle(t)
+	}
+	return v
+}
+
+// Return the fields of a table as variables.  String keys are used as names,
+// other keys are shown in brackets.
+func tableFields(t *rt.Table) []debugger.Variable {
+	var vars []debugger.Variable
+	k, v, _ := t.Next(rt.NilValue)
+	for !k.IsNil() {
+		name, ok := k.TryString()
+		if !ok {
+			name = "[" + debugger.FormatValue(k) + "]"
+		}
+		vars = append(vars, debugger.Variable{Name: name, Value: v})
+		k, v, _ = t.Next(k)
+	}
+	return vars
+}
+
+func (s *Server) newHandle(h interface{}) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.handles = append(s.handles, h)
+	return len(s.handles)
+}
+
+func (s *Server) getHandle(ref int) (interface{}, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if ref < 1 || ref > len(s.handles) {
+		return nil, fmt.Errorf("invalid variables reference %d", ref)
+	}
+	return s.handles[ref-1], nil
+}
+
+func (s *Server) respond(req *request, body interface{}, err error) error {
+	resp := &response{
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Success:    err == nil,
+		Command:    req.Command,
+		Body:       body,
+	}
+	if err != nil {
+		resp.Message = err.Error()
+		resp.Body = nil
+	}
+	s.outMux.Lock()
+	defer s.outMux.Unlock()
+	s.seq++
+	resp.Seq = s.seq
+	return writeMessage(s.out, resp)
+}
+
+// Events can be sent from the goroutine running the program.  Errors are
+// ignored as they will also happen when responding to the next request.
+func (s *Server) sendEvent(name string, body interface{}) {
+	s.outMux.Lock()
+	defer s.outMux.Unlock()
+	s.seq++
+	_ = writeMessage(s.out, &event{
+		Seq:   s.seq,
+		Type:  "event",
+		Event: name,
+		Body:  body,
+	})
+}
+
+// An outputWriter sends what is written to it to the client in output events.
+type outputWriter struct {
+	server   *Server
+	category string
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.server.sendEvent("output", outputBody{Category: w.category, Output: string(p)})
+	return len(p), nil
+}
+
+// The standard input of programs, which is always empty.
+type eofReader struct{}
+
+func (eofReader) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
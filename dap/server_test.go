@@ -0,0 +1,257 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const testProgram = `local function add(a, b)
+    local s = a + b
+    return s
+end
+local t = {x = 1}
+print(add(t.x, 2))
+io.write("do") io.stderr:write("ne\n")
+`
+
+// A scripted DAP client talking to a server.
+type testClient struct {
+	t   *testing.T
+	in  *bufio.Reader
+	out io.Writer
+	seq int
+
+	events []*testMessage // Events received while waiting for a response
+}
+
+// A message received from the server.
+type testMessage struct {
+	Type       string          `json:"type"`
+	Command    string          `json:"command"`
+	Event      string          `json:"event"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+func newTestClient(t *testing.T) *testClient {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	go func() {
+		if err := NewServer(serverIn, serverOut).Serve(); err != nil {
+			t.Error(err)
+		}
+		serverOut.Close()
+	}()
+	return &testClient{t: t, in: bufio.NewReader(clientIn), out: clientOut}
+}
+
+func (c *testClient) send(command string, args interface{}) int {
+	c.seq++
+	req := map[string]interface{}{"seq": c.seq, "type": "request", "command": command}
+	if args != nil {
+		req["arguments"] = args
+	}
+	if err := writeMessage(c.out, req); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.seq
+}
+
+func (c *testClient) receive() *testMessage {
+	b, err := readMessage(c.in)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var msg testMessage
+	if err := json.Unmarshal(b, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return &msg
+}
+
+// Send a request and decode the body of the response into body.  Events
+// received in the meantime are kept for waitFor.
+func (c *testClient) request(command string, args interface{}, body interface{}) {
+	seq := c.send(command, args)
+	for {
+		msg := c.receive()
+		if msg.Type == "event" {
+			c.events = append(c.events, msg)
+		}
+		if msg.Type == "response" && msg.RequestSeq == seq {
+			if !msg.Success {
+				c.t.Fatalf("%s failed: %s", command, msg.Message)
+			}
+			if body != nil {
+				if err := json.Unmarshal(msg.Body, body); err != nil {
+					c.t.Fatal(err)
+				}
+			}
+			return
+		}
+	}
+}
+
+// Wait for an event, returning the output events received in the meantime.
+func (c *testClient) waitFor(event string, body interface{}) (output string) {
+	for {
+		var msg *testMessage
+		if len(c.events) > 0 {
+			msg, c.events = c.events[0], c.events[1:]
+		} else {
+			msg = c.receive()
+		}
+		if msg.Type != "event" {
+			continue
+		}
+		if msg.Event == "output" {
+			var out outputBody
+			json.Unmarshal(msg.Body, &out)
+			output += out.Output
+		}
+		if msg.Event == event {
+			if body != nil {
+				json.Unmarshal(msg.Body, body)
+			}
+			return
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	program := filepath.Join(t.TempDir(), "test.lua")
+	if err := ioutil.WriteFile(program, []byte(testProgram), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t)
+
+	var caps capabilities
+	c.request("initialize", map[string]string{"adapterID": "golua"}, &caps)
+	if !caps.SupportsConfigurationDoneRequest {
+		t.Error("configurationDone should be supported")
+	}
+	c.waitFor("initialized", nil)
+	c.request("launch", launchArguments{Program: program, StopOnEntry: true}, nil)
+	var bps breakpointsBody
+	c.request("setBreakpoints", setBreakpointsArguments{
+		Source:      source{Path: program},
+		Breakpoints: []sourceBreakpoint{{Line: 3}},
+	}, &bps)
+	if len(bps.Breakpoints) != 1 || !bps.Breakpoints[0].Verified {
+		t.Errorf("unexpected breakpoints %+v", bps)
+	}
+	c.request("configurationDone", nil, nil)
+
+	var stopped stoppedBody
+	c.waitFor("stopped", &stopped)
+	if stopped.Reason != "entry" {
+		t.Errorf("expected to stop on entry, got %q", stopped.Reason)
+	}
+	c.request("continue", nil, nil)
+	c.waitFor("stopped", &stopped)
+	if stopped.Reason != "breakpoint" {
+		t.Errorf("expected to stop on breakpoint, got %q", stopped.Reason)
+	}
+
+	var trace stackTraceBody
+	c.request("stackTrace", stackTraceArguments{ThreadID: mainThreadID}, &trace)
+	if len(trace.StackFrames) != 2 {
+		t.Fatalf("expected 2 frames, got %+v", trace.StackFrames)
+	}
+	if f := trace.StackFrames[0]; f.Name != "add" || f.Line != 3 || f.Source.Path != program {
+		t.Errorf("unexpected top frame %+v", f)
+	}
+	if f := trace.StackFrames[1]; f.Name != "<main chunk>" || f.Line != 6 {
+		t.Errorf("unexpected bottom frame %+v", f)
+	}
+
+	var scopes scopesBody
+	c.request("scopes", scopesArguments{FrameID: trace.StackFrames[0].ID}, &scopes)
+	if len(scopes.Scopes) != 3 || scopes.Scopes[0].Name != "Locals" {
+		t.Fatalf("unexpected scopes %+v", scopes)
+	}
+	var vars variablesBody
+	c.request("variables", variablesArguments{VariablesReference: scopes.Scopes[0].VariablesReference}, &vars)
+	checkVars(t, vars.Variables, "a=1 b=2 s=3")
+
+	// Expand a table in the caller's frame
+	var eval evaluateBody
+	c.request("evaluate", evaluateArguments{Expression: "t", FrameID: trace.StackFrames[1].ID}, &eval)
+	if eval.Type != "table" || eval.VariablesReference == 0 {
+		t.Fatalf("unexpected evaluation %+v", eval)
+	}
+	c.request("variables", variablesArguments{VariablesReference: eval.VariablesReference}, &vars)
+	checkVars(t, vars.Variables, "x=1")
+
+	// Change a local variable
+	c.request("evaluate", evaluateArguments{Expression: "s = 10", FrameID: trace.StackFrames[0].ID}, nil)
+	c.request("evaluate", evaluateArguments{Expression: "s, a + b", FrameID: trace.StackFrames[0].ID}, &eval)
+	if eval.Result != "10, 3" {
+		t.Errorf("unexpected evaluation %+v", eval)
+	}
+
+	c.request("next", nil, nil)
+	output := c.waitFor("stopped", &stopped)
+	if output != "10\n" || stopped.Reason != "step" {
+		t.Errorf("unexpected output %q or reason %q", output, stopped.Reason)
+	}
+	c.request("continue", nil, nil)
+	var exited exitedBody
+	output = c.waitFor("exited", &exited)
+	if output != "done\n" || exited.ExitCode != 0 {
+		t.Errorf("unexpected output %q or exit code %d", output, exited.ExitCode)
+	}
+	c.waitFor("terminated", nil)
+	c.request("disconnect", nil, nil)
+}
+
+func TestErrors(t *testing.T) {
+	program := filepath.Join(t.TempDir(), "error.lua")
+	if err := ioutil.WriteFile(program, []byte(`error("oops")`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t)
+	c.request("initialize", nil, nil)
+	c.waitFor("initialized", nil)
+	c.request("launch", launchArguments{Program: program}, nil)
+
+	// Requests which need a paused program fail.
+	seq := c.send("stackTrace", stackTraceArguments{ThreadID: mainThreadID})
+	for {
+		msg := c.receive()
+		if msg.Type == "response" && msg.RequestSeq == seq {
+			if msg.Success || msg.Message != errNotPaused.Error() {
+				t.Errorf("unexpected response %+v", msg)
+			}
+			break
+		}
+	}
+
+	c.request("configurationDone", nil, nil)
+	var exited exitedBody
+	output := c.waitFor("exited", &exited)
+	if exited.ExitCode != 1 || output == "" {
+		t.Errorf("unexpected output %q or exit code %d", output, exited.ExitCode)
+	}
+	c.request("disconnect", nil, nil)
+}
+
+func checkVars(t *testing.T, vars []variable, want string) {
+	t.Helper()
+	got := ""
+	for i, v := range vars {
+		if i > 0 {
+			got += " "
+		}
+		got += v.Name + "=" + v.Value
+	}
+	if got != want {
+		t.Errorf("got variables %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,315 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/arnodel/golua/luastrings"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// Console is a Frontend which reads debugger commands from a terminal.  Type
+// "help" at the prompt for a list of commands.
+type Console struct {
+	*Debugger
+
+	in      *bufio.Reader
+	out     io.Writer
+	pause   *Pause
+	frame   int    // Index of the selected frame in pause.Frames
+	lastCmd string // Repeated when an empty line is entered
+	sources sourceCache
+}
+
+var _ Frontend = (*Console)(nil)
+
+// NewConsole returns a Console reading commands from in and writing to out,
+// with a new Debugger using it as a Frontend.
+func NewConsole(in *bufio.Reader, out io.Writer) *Console {
+	c := &Console{in: in, out: out, sources: sourceCache{}}
+	c.Debugger = New(c)
+	return c
+}
+
+// Prompt is printed by the console when it is waiting for a command.
+const Prompt = "(golua-dbg) "
+
+// Paused implements Frontend.Paused by printing the current location and
+// reading commands until one of them resumes execution.
+func (c *Console) Paused(p *Pause) Action {
+	c.pause = p
+	c.frame = 0
+	defer func() { c.pause = nil }()
+	if p.Reason != PausedOnStep {
+		c.printf("Paused on %s\n", p.Reason)
+	}
+	c.printLocation(p.Frames[0])
+	for {
+		fmt.Fprint(c.out, Prompt)
+		line, err := c.in.ReadString('\n')
+		if err != nil && line == "" {
+			// No more input
+			c.printf("\n")
+			return Quit
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = c.lastCmd
+		} else {
+			c.lastCmd = line
+		}
+		if action, ok := c.Command(line); ok {
+			return action
+		}
+	}
+}
+
+// Command executes a debugger command.  If the command resumes execution, it
+// returns the corresponding action and true.  Commands which require a paused
+// thread print an error if there is none.
+func (c *Console) Command(line string) (Action, bool) {
+	cmd, arg := splitCommand(line)
+	switch cmd {
+	case "":
+		return 0, false
+	case "help", "h":
+		c.printf("%s", consoleHelp)
+	case "break", "b":
+		if b, ok := c.parseBreakpoint(arg); ok {
+			c.SetBreakpoint(b)
+			c.printf("Breakpoint set at %s:%d\n", b.File, b.Line)
+		}
+	case "delete", "d":
+		if arg == "" {
+			c.ClearBreakpoints("")
+			c.printf("All breakpoints deleted\n")
+		} else if b, ok := c.parseBreakpoint(arg); ok {
+			if c.ClearBreakpoint(b) {
+				c.printf("Breakpoint deleted\n")
+			} else {
+				c.printf("No breakpoint at %s:%d\n", b.File, b.Line)
+			}
+		}
+	case "breakpoints":
+		for _, b := range c.Breakpoints() {
+			c.printf("%s:%d\n", b.File, b.Line)
+		}
+	case "quit", "q":
+		return Quit, true
+	case "step", "s":
+		if c.pause == nil {
+			c.SetAction(StepIn)
+			c.printf("Will pause at the next line executed\n")
+			return 0, false
+		}
+		return StepIn, true
+	default:
+		if c.pause == nil {
+			if _, ok := pausedCommands[cmd]; ok {
+				c.printf("No paused thread\n")
+			} else {
+				c.printf("Unknown command %q (type help for a list)\n", cmd)
+			}
+			return 0, false
+		}
+		return c.pausedCommand(cmd, arg)
+	}
+	return 0, false
+}
+
+var pausedCommands = map[string]struct{}{
+	"continue": {}, "c": {}, "next": {}, "n": {},
+	"finish": {}, "f": {}, "backtrace": {}, "bt": {}, "frame": {}, "up": {},
+	"down": {}, "locals": {}, "upvalues": {}, "print": {}, "p": {}, "list": {},
+	"l": {},
+}
+
+func (c *Console) pausedCommand(cmd, arg string) (Action, bool) {
+	frames := c.pause.Frames
+	switch cmd {
+	case "continue", "c":
+		return Continue, true
+	case "next", "n":
+		return StepOver, true
+	case "finish", "f":
+		return StepOut, true
+	case "backtrace", "bt":
+		for i, f := range frames {
+			marker := " "
+			if i == c.frame {
+				marker = "*"
+			}
+			c.printf("%s#%d %s\n", marker, i, frameDescription(f))
+		}
+	case "frame":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 || n >= len(frames) {
+			c.printf("Invalid frame number\n")
+			break
+		}
+		c.selectFrame(n)
+	case "up":
+		if c.frame+1 >= len(frames) {
+			c.printf("Already at the outermost frame\n")
+			break
+		}
+		c.selectFrame(c.frame + 1)
+	case "down":
+		if c.frame == 0 {
+			c.printf("Already at the innermost frame\n")
+			break
+		}
+		c.selectFrame(c.frame - 1)
+	case "list", "l":
+		c.printSource(frames[c.frame].Info, 5)
+	case "locals":
+		c.printVariables(frames[c.frame].Locals())
+	case "upvalues":
+		c.printVariables(frames[c.frame].Upvalues())
+	case "print", "p":
+		if arg == "" {
+			c.printf("Usage: print <expression>\n")
+			break
+		}
+		vals, err := frames[c.frame].Eval(c.pause.Thread, arg)
+		if err != nil {
+			c.printf("Error: %s\n", err)
+			break
+		}
+		strs := make([]string, len(vals))
+		for i, v := range vals {
+			strs[i] = FormatValue(v)
+		}
+		c.printf("%s\n", strings.Join(strs, "\t"))
+	}
+	return 0, false
+}
+
+func (c *Console) selectFrame(n int) {
+	c.frame = n
+	c.printf("#%d ", n)
+	c.printLocation(c.pause.Frames[n])
+}
+
+func (c *Console) printLocation(f *Frame) {
+	c.printf("%s\n", frameDescription(f))
+	c.printSource(f.Info, 0)
+}
+
+// Print the lines around the current line of a frame, context lines before and
+// after it.
+func (c *Console) printSource(info *rt.DebugInfo, context int) {
+	if info.CurrentLine <= 0 {
+		return
+	}
+	lines := c.sources.get(info.Source)
+	cur := int(info.CurrentLine)
+	for l := cur - context; l <= cur+context; l++ {
+		if l < 1 || l > len(lines) {
+			continue
+		}
+		marker := " "
+		if l == cur {
+			marker = ">"
+		}
+		c.printf("%s%4d\t%s\n", marker, l, lines[l-1])
+	}
+}
+
+func (c *Console) printVariables(vars []Variable) {
+	for _, v := range vars {
+		c.printf("%s = %s\n", v.Name, FormatValue(v.Value))
+	}
+}
+
+// Parse a breakpoint location "[file:]line".  If the file is omitted, the file
+// of the current frame is used.
+func (c *Console) parseBreakpoint(arg string) (Breakpoint, bool) {
+	file, lineStr := "", arg
+	if i := strings.LastIndexByte(arg, ':'); i >= 0 {
+		file, lineStr = arg[:i], arg[i+1:]
+	}
+	line, err := strconv.Atoi(lineStr)
+	if err != nil || line <= 0 {
+		c.printf("Invalid location %q (expected [file:]line)\n", arg)
+		return Breakpoint{}, false
+	}
+	if file == "" {
+		if c.pause == nil {
+			c.printf("No current file, specify one with file:line\n")
+			return Breakpoint{}, false
+		}
+		file = c.pause.Frames[c.frame].Info.Source
+	}
+	return Breakpoint{File: file, Line: int32(line)}, true
+}
+
+func (c *Console) printf(format string, args ...interface{}) {
+	fmt.Fprintf(c.out, format, args...)
+}
+
+func splitCommand(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		return line[:i], strings.TrimSpace(line[i+1:])
+	}
+	return line, ""
+}
+
+func frameDescription(f *Frame) string {
+	info := f.Info
+	name := info.Name
+	if name == "" {
+		name = "?"
+	}
+	if info.CurrentLine > 0 {
+		return fmt.Sprintf("%s in %s:%d", name, info.Source, info.CurrentLine)
+	}
+	return fmt.Sprintf("%s in %s", name, info.Source)
+}
+
+// FormatValue returns a representation of v suitable for displaying in the
+// debugger.  Strings are quoted and no metamethods are called.
+func FormatValue(v rt.Value) string {
+	if s, ok := v.TryString(); ok {
+		return luastrings.Quote(s, '"')
+	}
+	s, _ := v.ToString()
+	return s
+}
+
+// sourceCache keeps the lines of source files displayed by the console.
+type sourceCache map[string][]string
+
+func (s sourceCache) get(file string) []string {
+	lines, ok := s[file]
+	if !ok {
+		if b, err := os.ReadFile(file); err == nil {
+			lines = strings.Split(string(b), "\n")
+		}
+		s[file] = lines
+	}
+	return lines
+}
+
+const consoleHelp = `Commands (an empty line repeats the last command):
+  continue, c          resume execution until the next breakpoint
+  step, s              execute until the next line
+  next, n              execute until the next line in the current function
+  finish, f            execute until the current function returns
+  break, b [file:]line set a breakpoint
+  delete, d [file:]line delete a breakpoint (all breakpoints if no argument)
+  breakpoints          list breakpoints
+  backtrace, bt        print the call stack
+  frame N              select frame N in the call stack
+  up, down             select the calling / called frame
+  list, l              print the source around the current line
+  locals               print the local variables in the selected frame
+  upvalues             print the upvalues in the selected frame
+  print, p expr        evaluate an expression in the selected frame
+  quit, q              stop execution
+`
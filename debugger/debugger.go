@@ -0,0 +1,256 @@
+// Package debugger implements a debugger for Lua code running in a golua
+// runtime.  It relies on the line debug hook to pause execution, so it only
+// pauses in Lua functions.
+//
+// The Debugger type keeps track of breakpoints and stepping.  When execution
+// pauses, it asks a Frontend what to do next.  The Console type is a Frontend
+// that reads commands from a terminal.
+package debugger
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// A Frontend decides what to do when the debugger pauses execution.  The
+// Paused method is called on the thread that was paused, which stays paused
+// until it returns.
+type Frontend interface {
+	Paused(p *Pause) Action
+}
+
+// Action is what the debugger should do after a pause.
+type Action uint8
+
+const (
+	Continue Action = iota // Run until the next breakpoint
+	StepIn                 // Pause at the next line
+	StepOver               // Pause at the next line in the same function or its callers
+	StepOut                // Pause at the next line in a caller
+	Quit                   // Stop execution with an error
+)
+
+// PauseReason says why execution paused.
+type PauseReason uint8
+
+const (
+	PausedOnStep       PauseReason = iota // A step was requested
+	PausedOnBreakpoint                    // A breakpoint was hit
+	PausedOnRequest                       // Debugger.Pause was called
+)
+
+func (r PauseReason) String() string {
+	switch r {
+	case PausedOnStep:
+		return "step"
+	case PausedOnBreakpoint:
+		return "breakpoint"
+	case PausedOnRequest:
+		return "pause"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrQuit is the error that stops execution when the Frontend returns Quit.
+var ErrQuit = errors.New("debugger: quit")
+
+// A Breakpoint is a location in a source file.  The file matches a source if
+// it is equal to it or to a trailing part of its path.
+type Breakpoint struct {
+	File string
+	Line int32
+}
+
+func (b Breakpoint) matches(source string, line int32) bool {
+	if b.Line != line {
+		return false
+	}
+	return b.File == source ||
+		strings.HasSuffix(source, "/"+b.File) ||
+		filepath.Clean(b.File) == filepath.Clean(source)
+}
+
+// Debugger pauses the execution of Lua code on breakpoints or after steps.
+// Its methods can be called concurrently.
+type Debugger struct {
+	frontend Frontend
+
+	mux         sync.Mutex
+	breakpoints []Breakpoint
+	action      Action
+	depth       int  // Depth of the call stack when the last step started
+	pauseReq    bool // True if a pause was requested
+	quit        bool // True if the Frontend returned Quit
+	hook        rt.Value
+}
+
+// New returns a new debugger that asks frontend what to do when it pauses.
+// Initially it will only pause on breakpoints.
+func New(frontend Frontend) *Debugger {
+	d := &Debugger{frontend: frontend}
+	d.hook = rt.FunctionValue(rt.NewGoFunction(d.lineHook, "debugger", 2, false))
+	return d
+}
+
+// Attach makes the debugger control the thread t, by setting its debug hooks.
+// Coroutines created by t inherit the hooks so they are also controlled by the
+// debugger.  The debugger will stop working if the hooks are changed (e.g. by
+// debug.sethook).
+func (d *Debugger) Attach(t *rt.Thread) {
+	t.SetupHooks(rt.DebugHooks{
+		DebugHookFlags: rt.HookFlagLine,
+		Hook:           d.hook,
+	})
+}
+
+// Detach removes the debug hooks from t.
+func (d *Debugger) Detach(t *rt.Thread) {
+	t.SetupHooks(rt.DebugHooks{})
+}
+
+// SetBreakpoint adds a breakpoint (if it was not already set).
+func (d *Debugger) SetBreakpoint(b Breakpoint) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	for _, bb := range d.breakpoints {
+		if bb == b {
+			return
+		}
+	}
+	d.breakpoints = append(d.breakpoints, b)
+}
+
+// ClearBreakpoint removes a breakpoint, returning true if it was set.
+func (d *Debugger) ClearBreakpoint(b Breakpoint) bool {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	for i, bb := range d.breakpoints {
+		if bb == b {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ClearBreakpoints removes all the breakpoints in file, or all breakpoints if
+// file is empty.
+func (d *Debugger) ClearBreakpoints(file string) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	bps := d.breakpoints[:0]
+	for _, b := range d.breakpoints {
+		if file != "" && b.File != file {
+			bps = append(bps, b)
+		}
+	}
+	d.breakpoints = bps
+}
+
+// Breakpoints returns the current breakpoints.
+func (d *Debugger) Breakpoints() []Breakpoint {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return append([]Breakpoint(nil), d.breakpoints...)
+}
+
+// Pause requests that execution pauses at the next line.
+func (d *Debugger) Pause() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.pauseReq = true
+}
+
+// The hook function set up by Attach.
+func (d *Debugger) lineHook(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if event, _ := c.Arg(0).TryString(); event != "line" {
+		return c.Next(), nil
+	}
+	line, _ := c.Arg(1).TryInt()
+	if d.HasQuit() {
+		// Keep failing in case the error is caught by pcall.
+		return nil, ErrQuit
+	}
+
+	// The hook is called with a termination whose parent is the continuation
+	// being executed (see runtime.AsLuaCont).
+	cont := c.Next()
+	lc, ok := rt.AsLuaCont(cont)
+	if !ok {
+		return c.Next(), nil
+	}
+	depth := stackDepth(lc)
+	reason, ok := d.shouldPause(lc.DebugInfo().Source, int32(line), depth)
+	if !ok {
+		return c.Next(), nil
+	}
+	p := &Pause{
+		Thread: t,
+		Reason: reason,
+		Frames: getFrames(lc),
+	}
+	action := d.frontend.Paused(p)
+	d.mux.Lock()
+	if action == Quit {
+		d.quit = true
+		d.mux.Unlock()
+		return nil, ErrQuit
+	}
+	d.action = action
+	d.depth = depth
+	d.mux.Unlock()
+	return c.Next(), nil
+}
+
+func (d *Debugger) shouldPause(source string, line int32, depth int) (PauseReason, bool) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.pauseReq {
+		d.pauseReq = false
+		return PausedOnRequest, true
+	}
+	for _, b := range d.breakpoints {
+		if b.matches(source, line) {
+			return PausedOnBreakpoint, true
+		}
+	}
+	switch d.action {
+	case StepIn:
+		return PausedOnStep, true
+	case StepOver:
+		return PausedOnStep, depth <= d.depth
+	case StepOut:
+		return PausedOnStep, depth < d.depth
+	}
+	return 0, false
+}
+
+// HasQuit returns true if the Frontend has returned Quit.  After that, all
+// threads the debugger is attached to fail with ErrQuit as soon as they execute
+// a line.
+func (d *Debugger) HasQuit() bool {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.quit
+}
+
+// SetAction sets what the debugger does until it next pauses.  E.g. StepIn
+// makes it pause at the next line executed (useful at the start of a program),
+// Continue makes it only pause on breakpoints.
+func (d *Debugger) SetAction(action Action) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.action = action
+}
+
+func stackDepth(c rt.Cont) (depth int) {
+	for ; c != nil; c = c.Parent() {
+		depth++
+	}
+	return
+}
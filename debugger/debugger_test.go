@@ -0,0 +1,201 @@
+package debugger
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/golua/lib"
+	"github.com/arnodel/golua/lib/coroutine"
+	"github.com/arnodel/golua/lib/packagelib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+const testSource = `local function add(a, b)
+    local s = a + b
+    return s
+end
+local x = 10
+local y = add(x, 5)
+result = y
+`
+
+// A frontend that records the lines it pauses at and plays a list of actions.
+type scriptedFrontend struct {
+	actions []Action
+	pauses  []string
+	onPause func(p *Pause)
+}
+
+func (f *scriptedFrontend) Paused(p *Pause) Action {
+	info := p.Frames[0].Info
+	f.pauses = append(f.pauses, fmt.Sprintf("%s:%d", info.Name, info.CurrentLine))
+	if f.onPause != nil {
+		f.onPause(p)
+	}
+	if len(f.actions) == 0 {
+		return Continue
+	}
+	action := f.actions[0]
+	f.actions = f.actions[1:]
+	return action
+}
+
+func runTestSource(t *testing.T, d *Debugger) (*rt.Runtime, error) {
+	r := rt.New(nil)
+	d.Attach(r.MainThread())
+	clos, err := r.CompileAndLoadLuaChunk("test.lua", []byte(testSource), rt.TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, rt.Call(r.MainThread(), rt.FunctionValue(clos), nil, rt.NewTerminationWith(nil, 0, false))
+}
+
+func TestStepping(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions []Action
+		pauses  string
+	}{
+		{
+			name:    "step in",
+			actions: []Action{StepIn, StepIn, StepIn, StepIn, StepIn, StepIn, Continue},
+			pauses:  "<main chunk>:1 <main chunk>:5 <main chunk>:6 add:2 add:3 <main chunk>:7",
+		},
+		{
+			name:    "step over",
+			actions: []Action{StepOver, StepOver, StepOver, StepOver},
+			pauses:  "<main chunk>:1 <main chunk>:5 <main chunk>:6 <main chunk>:7",
+		},
+		{
+			name:    "step out",
+			actions: []Action{StepIn, StepIn, StepIn, StepOut},
+			pauses:  "<main chunk>:1 <main chunk>:5 <main chunk>:6 add:2 <main chunk>:7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &scriptedFrontend{actions: tt.actions}
+			d := New(f)
+			d.SetAction(StepIn)
+			if _, err := runTestSource(t, d); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(f.pauses, " "); got != tt.pauses {
+				t.Errorf("got pauses %q, want %q", got, tt.pauses)
+			}
+		})
+	}
+}
+
+func TestBreakpointAndEval(t *testing.T) {
+	var locals, upvalues, evals []string
+	f := &scriptedFrontend{
+		onPause: func(p *Pause) {
+			if p.Reason != PausedOnBreakpoint {
+				t.Errorf("unexpected reason %s", p.Reason)
+			}
+			for _, v := range p.Frames[0].Locals() {
+				locals = append(locals, v.Name+"="+FormatValue(v.Value))
+			}
+			for _, v := range p.Frames[1].Upvalues() {
+				upvalues = append(upvalues, v.Name)
+			}
+			vals, err := p.Frames[1].Eval(p.Thread, "x, y")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range vals {
+				evals = append(evals, FormatValue(v))
+			}
+			// Assign to a local in the paused function
+			if _, err := p.Frames[0].Eval(p.Thread, "s = s * 2"); err != nil {
+				t.Fatal(err)
+			}
+		},
+	}
+	d := New(f)
+	d.SetBreakpoint(Breakpoint{File: "test.lua", Line: 3})
+	r, err := runTestSource(t, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(what string, got []string, want string) {
+		if s := strings.Join(got, " "); s != want {
+			t.Errorf("%s: got %q, want %q", what, s, want)
+		}
+	}
+	check("pauses", f.pauses, "add:3")
+	check("locals", locals, "a=10 b=5 s=15")
+	check("upvalues", upvalues, "_ENV")
+	check("evals", evals, "10 nil")
+	if res := r.GlobalEnv().Get(rt.StringValue("result")); res != rt.IntValue(30) {
+		t.Errorf("got result %v, want 30", res)
+	}
+}
+
+func TestBreakpointInCoroutine(t *testing.T) {
+	const src = `local co = coroutine.wrap(function(a)
+    local b = coroutine.yield(a + 1)
+    return a + b
+end)
+local x = co(1)
+result = co(x)
+`
+	f := &scriptedFrontend{actions: []Action{StepIn, Continue}}
+	d := New(f)
+	d.SetBreakpoint(Breakpoint{File: "co.lua", Line: 2})
+	r := rt.New(nil)
+	lib.LoadLibs(r, packagelib.LibLoader, coroutine.LibLoader)
+	d.Attach(r.MainThread())
+	clos, err := r.CompileAndLoadLuaChunk("co.lua", []byte(src), rt.TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Call(r.MainThread(), rt.FunctionValue(clos), nil, rt.NewTerminationWith(nil, 0, false)); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(f.pauses, " "); got != "<lua function>:2 <main chunk>:6" {
+		t.Errorf("got pauses %q", got)
+	}
+	if res := r.GlobalEnv().Get(rt.StringValue("result")); res != rt.IntValue(3) {
+		t.Errorf("got result %v, want 3", res)
+	}
+}
+
+func TestQuit(t *testing.T) {
+	f := &scriptedFrontend{actions: []Action{Quit}}
+	d := New(f)
+	d.SetAction(StepIn)
+	_, err := runTestSource(t, d)
+	if err == nil || !d.HasQuit() {
+		t.Errorf("expected to quit, got err=%v", err)
+	}
+}
+
+func TestConsole(t *testing.T) {
+	in := bufio.NewReader(strings.NewReader("b 3\nc\nlocals\np a + b\nup\nbt\nq\n"))
+	var out bytes.Buffer
+	c := NewConsole(in, &out)
+	c.SetAction(StepIn)
+	if _, err := runTestSource(t, c.Debugger); err == nil || !c.HasQuit() {
+		t.Fatalf("expected to quit, got %v", err)
+	}
+	want := `<main chunk> in test.lua:1
+(golua-dbg) Breakpoint set at test.lua:3
+(golua-dbg) Paused on breakpoint
+add in test.lua:3
+(golua-dbg) a = 10
+b = 5
+s = 15
+(golua-dbg) 15
+(golua-dbg) #1 <main chunk> in test.lua:6
+(golua-dbg)  #0 add in test.lua:3
+*#1 <main chunk> in test.lua:6
+(golua-dbg) `
+	if got := out.String(); got != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", got, want)
+	}
+}
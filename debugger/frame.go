@@ -0,0 +1,191 @@
+package debugger
+
+import (
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// A Pause describes a thread paused by the debugger.
+type Pause struct {
+	Thread *rt.Thread
+	Reason PauseReason
+	Frames []*Frame // The call stack, starting with the paused function
+}
+
+// A Frame is an entry in the call stack of a paused thread.
+type Frame struct {
+	Cont rt.Cont
+	Info *rt.DebugInfo
+}
+
+// A Variable is a named value in a frame.
+type Variable struct {
+	Name  string
+	Value rt.Value
+}
+
+func getFrames(c rt.Cont) []*Frame {
+	var frames []*Frame
+	for ; c != nil; c = c.Parent() {
+		if info := c.DebugInfo(); info != nil {
+			frames = append(frames, &Frame{Cont: c, Info: info})
+		}
+	}
+	return frames
+}
+
+// IsLua returns true if the frame is running a Lua function (other frames have
+// no variables).
+func (f *Frame) IsLua() bool {
+	_, ok := rt.AsLuaCont(f.Cont)
+	return ok
+}
+
+// Locals returns the local variables active in the frame.
+func (f *Frame) Locals() []Variable {
+	lc, ok := rt.AsLuaCont(f.Cont)
+	if !ok {
+		return nil
+	}
+	var vars []Variable
+	for i := 1; ; i++ {
+		name, val, ok := lc.GetLocal(i)
+		if !ok {
+			return vars
+		}
+		vars = append(vars, Variable{Name: name, Value: val})
+	}
+}
+
+// Upvalues returns the upvalues of the function running in the frame.
+func (f *Frame) Upvalues() []Variable {
+	lc, ok := rt.AsLuaCont(f.Cont)
+	if !ok {
+		return nil
+	}
+	vars := make([]Variable, lc.UpvalueCount)
+	for i := range vars {
+		vars[i] = Variable{Name: lc.UpNames[i], Value: lc.GetUpvalue(i)}
+	}
+	return vars
+}
+
+// Eval evaluates the Lua expressions or chunk src in the scope of the frame,
+// i.e. local variables and upvalues of the frame are accessible (and can be
+// assigned to), as well as global variables.  It returns the values of the
+// expression or the values returned by the chunk.
+func (f *Frame) Eval(t *rt.Thread, src string) ([]rt.Value, error) {
+	env := t.NewTable()
+	meta := t.NewTable()
+	t.SetEnv(meta, "__index", rt.FunctionValue(rt.NewGoFunction(f.getVar, "__index", 2, false)))
+	t.SetEnv(meta, "__newindex", rt.FunctionValue(rt.NewGoFunction(f.setVar, "__newindex", 3, false)))
+	env.SetMetatable(meta)
+	// Try a list of expressions first, then a chunk.
+	clos, err := t.CompileAndLoadLuaChunk("debugger", []byte("return "+src), rt.TableValue(env))
+	if err != nil {
+		clos, err = t.CompileAndLoadLuaChunk("debugger", []byte(src), rt.TableValue(env))
+		if err != nil {
+			return nil, err
+		}
+	}
+	term := rt.NewTerminationWith(nil, 0, true)
+	if err := rt.Call(t, rt.FunctionValue(clos), nil, term); err != nil {
+		return nil, err
+	}
+	return term.Etc(), nil
+}
+
+// Implements __index for the environment of Eval.
+func (f *Frame) getVar(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.CheckNArgs(2); err != nil {
+		return nil, err
+	}
+	name, isName := c.Arg(1).TryString()
+	if isName {
+		if ptr := f.lookup(name); ptr != nil {
+			return c.PushingNext1(t.Runtime, ptr.get()), nil
+		}
+	}
+	val, err := rt.Index(t, f.Globals(t), c.Arg(1))
+	if err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, val), nil
+}
+
+// Implements __newindex for the environment of Eval.
+func (f *Frame) setVar(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.CheckNArgs(3); err != nil {
+		return nil, err
+	}
+	name, isName := c.Arg(1).TryString()
+	if isName {
+		if ptr := f.lookup(name); ptr != nil {
+			ptr.set(c.Arg(2))
+			return c.Next(), nil
+		}
+	}
+	if err := rt.SetIndex(t, f.Globals(t), c.Arg(1), c.Arg(2)); err != nil {
+		return nil, err
+	}
+	return c.Next(), nil
+}
+
+// A varRef refers to a local variable or an upvalue in a frame.
+type varRef struct {
+	lc    *rt.LuaCont
+	local int // index of the local variable, or 0 for an upvalue
+	upval int
+}
+
+func (r *varRef) get() rt.Value {
+	if r.local > 0 {
+		_, val, _ := r.lc.GetLocal(r.local)
+		return val
+	}
+	return r.lc.GetUpvalue(r.upval)
+}
+
+func (r *varRef) set(val rt.Value) {
+	if r.local > 0 {
+		r.lc.SetLocal(r.local, val)
+	} else {
+		r.lc.SetUpvalue(r.upval, val)
+	}
+}
+
+// Find the local variable or upvalue with the given name visible in the frame.
+func (f *Frame) lookup(name string) *varRef {
+	lc, ok := rt.AsLuaCont(f.Cont)
+	if !ok {
+		return nil
+	}
+	// The last local variable declared with that name hides the others.
+	var ref *varRef
+	for i := 1; ; i++ {
+		n, _, ok := lc.GetLocal(i)
+		if !ok {
+			break
+		}
+		if n == name {
+			ref = &varRef{lc: lc, local: i}
+		}
+	}
+	if ref != nil {
+		return ref
+	}
+	for i := 0; i < int(lc.UpvalueCount); i++ {
+		if lc.UpNames[i] == name {
+			return &varRef{lc: lc, upval: i}
+		}
+	}
+	return nil
+}
+
+// Globals returns the value of _ENV in the frame, or the global environment if
+// it is not available.
+func (f *Frame) Globals(t *rt.Thread) rt.Value {
+	if ref := f.lookup("_ENV"); ref != nil {
+		return ref.get()
+	}
+	return rt.TableValue(t.GlobalEnv())
+}
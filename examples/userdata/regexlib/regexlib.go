@@ -32,17 +32,17 @@ var LibLoader = packagelib.Loader{
 // up a package (which is a lua table and returns it).
 func load(r *rt.Runtime) (rt.Value, func()) {
 	// First build a table of methods.
-	regexMethods := rt.NewTable()
+	regexMethods := r.NewTable()
 	r.SetEnvGoFunc(regexMethods, "find", regexFind, 2, false)
 
 	// Build the metatable
-	regexMeta := rt.NewTable()
+	regexMeta := r.NewTable()
 	r.SetEnv(regexMeta, "__index", rt.TableValue(regexMethods))
 	r.SetEnvGoFunc(regexMeta, "__tostring", regexToString, 1, false)
 	r.SetRegistry(regexMetaKey, rt.TableValue(regexMeta))
 
 	// Make a new table
-	pkg := rt.NewTable()
+	pkg := r.NewTable()
 
 	// Add the "new" function to it
 	r.SetEnvGoFunc(pkg, "new", newRegex, 1, false)
@@ -12,6 +12,8 @@ type RegData struct {
 	IsCell     bool
 	IsConstant bool
 	refCount   int
+	isInt      bool // See MarkIntReg
+	intFrom    int  // Where the register started to be known to hold an integer
 }
 
 const regHasUpvalue uint = 1
@@ -28,6 +30,7 @@ type CodeBuilder struct {
 	lines        []int
 	labels       []bool
 	constantPool *ConstantPool
+	info         FunctionInfo
 }
 
 func NewCodeBuilder(chunkName string, constantPool *ConstantPool) *CodeBuilder {
@@ -224,9 +227,21 @@ func (c *CodeBuilder) EmitJump(lblName Name, line int) bool {
 	return false
 }
 
+// DeclareLocal declares a local variable with the given name, stored in reg,
+// in the current lexical scope.
 func (c *CodeBuilder) DeclareLocal(name Name, reg Register) {
+	c.DeclareLocalWithDebugName(name, string(name), reg)
+}
+
+// DeclareLocalWithDebugName is like DeclareLocal, but debug functions see the
+// local variable as debugName.  If debugName is empty, they do not see it at
+// all.  This is useful for local variables that the compiler introduces.
+func (c *CodeBuilder) DeclareLocalWithDebugName(name Name, debugName string, reg Register) {
 	c.TakeRegister(reg)
 	c.context.addToTop(name, reg)
+	if debugName != "" {
+		c.EmitNoLine(DeclareLocal{Name: debugName, Reg: reg})
+	}
 }
 
 func (c *CodeBuilder) MarkConstantReg(reg Register) {
@@ -237,6 +252,65 @@ func (c *CodeBuilder) IsConstantReg(reg Register) bool {
 	return c.registers[reg].IsConstant
 }
 
+// IsUpvalueReg returns true if reg holds an upvalue of the function being
+// built.
+func (c *CodeBuilder) IsUpvalueReg(reg Register) bool {
+	for _, r := range c.upvalueDests {
+		if r == reg {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkIntReg records that reg holds an integer, provided the instructions
+// emitted from now on do not change it.  This must be checked by calling
+// CheckIntReg once reg is no longer in scope.
+func (c *CodeBuilder) MarkIntReg(reg Register) {
+	c.registers[reg].isInt = true
+	c.registers[reg].intFrom = len(c.code)
+}
+
+// IsIntReg returns true if reg is known to hold an integer (see MarkIntReg).
+func (c *CodeBuilder) IsIntReg(reg Register) bool {
+	return c.registers[reg].isInt
+}
+
+// CheckIntReg checks that the value of reg was not changed since MarkIntReg was
+// called.  If it might have been (this includes the case when reg was captured
+// by a closure), the instructions emitted since then no longer assume that
+// their operands are integers.
+func (c *CodeBuilder) CheckIntReg(reg Register) {
+	data := &c.registers[reg]
+	if !data.isInt {
+		return
+	}
+	data.isInt = false
+	changed := data.IsCell
+	for _, instr := range c.code[data.intFrom:] {
+		for _, r := range writtenRegisters(instr) {
+			changed = changed || r == reg
+		}
+	}
+	if !changed {
+		return
+	}
+	for i, instr := range c.code[data.intFrom:] {
+		switch x := instr.(type) {
+		case Combine:
+			x.Int = false
+			instr = x
+		case PrepForLoop:
+			x.Int = false
+			instr = x
+		case AdvForLoop:
+			x.Int = false
+			instr = x
+		}
+		c.code[data.intFrom+i] = instr
+	}
+}
+
 func (c *CodeBuilder) EmitNoLine(instr Instruction) {
 	c.Emit(instr, 0)
 }
@@ -246,6 +320,11 @@ func (c *CodeBuilder) Emit(instr Instruction, line int) {
 	c.lines = append(c.lines, line)
 }
 
+// SetFunctionInfo sets the debug information about the function being built.
+func (c *CodeBuilder) SetFunctionInfo(info FunctionInfo) {
+	c.info = info
+}
+
 func (c *CodeBuilder) Close() (uint, []Register) {
 	return c.getConstantIndex(c.getCode()), c.upvalues
 }
@@ -263,6 +342,7 @@ func (c *CodeBuilder) getCode() *Code {
 		UpvalueDests: c.upvalueDests,
 		UpNames:      c.upnames,
 		Name:         c.chunkName,
+		FunctionInfo: c.info,
 	}
 }
 
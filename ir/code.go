@@ -66,6 +66,17 @@ type Code struct {
 	Registers    []RegData
 	UpNames      []string
 	Name         string
+	FunctionInfo
+}
+
+// FunctionInfo contains information about a function definition which is only
+// used for debugging.
+type FunctionInfo struct {
+	NameWhat        string // How the function is named (see ast.Function)
+	LineDefined     int    // Line where the definition starts (0 for a main chunk)
+	LastLineDefined int    // Line where the definition ends (0 for a main chunk)
+	NParams         int    // Number of fixed parameters
+	IsVararg        bool   // True if the function takes varargs
 }
 
 // ProcessConstant uses the given ConstantProcessor to process the receiver.
@@ -0,0 +1,306 @@
+package ir
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/arnodel/golua/ops"
+)
+
+// The functions in this file evaluate operations on constants at compile time.
+// They follow the semantics of the runtime package, and only succeed when the
+// result does not depend on metamethods or on the runtime state, and when the
+// operation would not raise an error.
+
+// foldUnOp computes op(x) if possible.
+func foldUnOp(op ops.Op, x Constant) (Constant, bool) {
+	switch op {
+	case ops.OpId:
+		return x, true
+	case ops.OpNot:
+		return Bool(!isTrue(x)), true
+	case ops.OpNeg:
+		switch xx := x.(type) {
+		case Int:
+			return -xx, true
+		case Float:
+			return -xx, true
+		}
+	case ops.OpLen:
+		if s, ok := x.(String); ok {
+			return Int(len(s)), true
+		}
+	case ops.OpBitNot:
+		if n, ok := toInt(x); ok {
+			return Int(^n), true
+		}
+	}
+	return nil, false
+}
+
+// foldBinOp computes op(x, y) if possible.
+func foldBinOp(op ops.Op, x, y Constant) (Constant, bool) {
+	switch op {
+	case ops.OpAdd, ops.OpSub, ops.OpMul, ops.OpDiv, ops.OpFloorDiv, ops.OpMod, ops.OpPow:
+		return foldArith(op, x, y)
+	case ops.OpBitAnd, ops.OpBitOr, ops.OpBitXor, ops.OpShiftL, ops.OpShiftR:
+		nx, okx := toInt(x)
+		ny, oky := toInt(y)
+		if !okx || !oky {
+			return nil, false
+		}
+		return Int(foldBitwise(op, nx, ny)), true
+	case ops.OpConcat:
+		sx, okx := concatString(x)
+		sy, oky := concatString(y)
+		if !okx || !oky {
+			return nil, false
+		}
+		return String(sx + sy), true
+	case ops.OpEq:
+		return Bool(constEqual(x, y)), true
+	case ops.OpLt, ops.OpLeq:
+		return foldComparison(op, x, y)
+	}
+	return nil, false
+}
+
+func foldArith(op ops.Op, x, y Constant) (Constant, bool) {
+	nx, okx := x.(Int)
+	ny, oky := y.(Int)
+	if okx && oky {
+		switch op {
+		case ops.OpAdd:
+			return nx + ny, true
+		case ops.OpSub:
+			return nx - ny, true
+		case ops.OpMul:
+			return nx * ny, true
+		case ops.OpFloorDiv:
+			if ny == 0 {
+				return nil, false
+			}
+			return Int(floordivInt(int64(nx), int64(ny))), true
+		case ops.OpMod:
+			if ny == 0 {
+				return nil, false
+			}
+			return Int(modInt(int64(nx), int64(ny))), true
+		}
+	}
+	fx, okx := toFloat(x)
+	fy, oky := toFloat(y)
+	if !okx || !oky {
+		return nil, false
+	}
+	switch op {
+	case ops.OpAdd:
+		return Float(fx + fy), true
+	case ops.OpSub:
+		return Float(fx - fy), true
+	case ops.OpMul:
+		return Float(fx * fy), true
+	case ops.OpDiv:
+		return Float(fx / fy), true
+	case ops.OpFloorDiv:
+		return Float(math.Floor(fx / fy)), true
+	case ops.OpMod:
+		return Float(modFloat(fx, fy)), true
+	case ops.OpPow:
+		return Float(math.Pow(fx, fy)), true
+	}
+	return nil, false
+}
+
+func foldBitwise(op ops.Op, x, y int64) int64 {
+	// Shifts are logical, and shifting in the other direction when y < 0.
+	switch op {
+	case ops.OpBitAnd:
+		return x & y
+	case ops.OpBitOr:
+		return x | y
+	case ops.OpBitXor:
+		return x ^ y
+	case ops.OpShiftL:
+		if y < 0 {
+			return int64(uint64(x) >> uint64(-y))
+		}
+		return int64(uint64(x) << uint64(y))
+	default: // ops.OpShiftR
+		if y < 0 {
+			return int64(uint64(x) << uint64(-y))
+		}
+		return int64(uint64(x) >> uint64(y))
+	}
+}
+
+func foldComparison(op ops.Op, x, y Constant) (Constant, bool) {
+	if sx, ok := x.(String); ok {
+		sy, ok := y.(String)
+		if !ok {
+			return nil, false
+		}
+		if op == ops.OpLt {
+			return Bool(sx < sy), true
+		}
+		return Bool(sx <= sy), true
+	}
+	c, ok := compareNumbers(x, y)
+	if !ok {
+		return nil, false
+	}
+	if op == ops.OpLt {
+		return Bool(c < 0), true
+	}
+	return Bool(c <= 0), true
+}
+
+// compareNumbers returns -1, 0 or 1 if x < y, x == y or x > y, and 2 if x or y
+// is NaN.  Integers are compared with floats exactly.
+func compareNumbers(x, y Constant) (int, bool) {
+	switch xx := x.(type) {
+	case Int:
+		switch yy := y.(type) {
+		case Int:
+			return compareInts(int64(xx), int64(yy)), true
+		case Float:
+			return compareIntAndFloat(int64(xx), float64(yy)), true
+		}
+	case Float:
+		switch yy := y.(type) {
+		case Int:
+			c := compareIntAndFloat(int64(yy), float64(xx))
+			if c != 2 {
+				c = -c
+			}
+			return c, true
+		case Float:
+			return compareFloats(float64(xx), float64(yy)), true
+		}
+	}
+	return 0, false
+}
+
+func compareInts(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareFloats(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	case x == y:
+		return 0
+	default:
+		return 2
+	}
+}
+
+func compareIntAndFloat(n int64, f float64) int {
+	if nf := int64(f); float64(nf) == f {
+		return compareInts(n, nf)
+	}
+	return compareFloats(float64(n), f)
+}
+
+// constEqual returns true if x == y in Lua.
+func constEqual(x, y Constant) bool {
+	switch xx := x.(type) {
+	case Int:
+		if yy, ok := y.(Float); ok {
+			return equalIntAndFloat(int64(xx), float64(yy))
+		}
+	case Float:
+		switch yy := y.(type) {
+		case Int:
+			return equalIntAndFloat(int64(yy), float64(xx))
+		case Float:
+			return xx == yy
+		}
+	}
+	return x == y
+}
+
+func equalIntAndFloat(n int64, f float64) bool {
+	nf := int64(f)
+	return float64(nf) == f && nf == n
+}
+
+// isTrue returns the truth value of x in Lua.
+func isTrue(x Constant) bool {
+	switch xx := x.(type) {
+	case NilType:
+		return false
+	case Bool:
+		return bool(xx)
+	default:
+		return true
+	}
+}
+
+func toInt(x Constant) (int64, bool) {
+	switch xx := x.(type) {
+	case Int:
+		return int64(xx), true
+	case Float:
+		n := int64(xx)
+		return n, float64(n) == float64(xx)
+	}
+	return 0, false
+}
+
+func toFloat(x Constant) (float64, bool) {
+	switch xx := x.(type) {
+	case Int:
+		return float64(xx), true
+	case Float:
+		return float64(xx), true
+	}
+	return 0, false
+}
+
+// Floats are not folded in concatenations, as their conversion to strings is
+// left to the runtime.
+func concatString(x Constant) (string, bool) {
+	switch xx := x.(type) {
+	case String:
+		return string(xx), true
+	case Int:
+		return strconv.FormatInt(int64(xx), 10), true
+	}
+	return "", false
+}
+
+func floordivInt(x, y int64) int64 {
+	r := x % y
+	q := x / y
+	if r != 0 && (r < 0) != (y < 0) {
+		q--
+	}
+	return q
+}
+
+func modInt(x, y int64) int64 {
+	r := x % y
+	if r != 0 && (r < 0) != (y < 0) {
+		r += y
+	}
+	return r
+}
+
+func modFloat(x, y float64) float64 {
+	r := math.Mod(x, y)
+	if r != 0 && (r < 0) != (y < 0) {
+		r += y
+	}
+	return r
+}
@@ -52,6 +52,9 @@ type InstrProcessor interface {
 
 	// A label (for jumping to)
 	ProcessDeclareLabelInstr(DeclareLabel)
+
+	// Debug information about local variables
+	ProcessDeclareLocalInstr(DeclareLocal)
 }
 
 // A Register is an IR register.  The number of IR registers is not bounded
@@ -76,6 +79,7 @@ type Combine struct {
 	Dst  Register // Destination register
 	Lsrc Register // Left operand register
 	Rsrc Register // Right operand register
+	Int  bool     // True if Lsrc and Rsrc are known to contain integers
 }
 
 // DestReg returns the destination register of this instruction.
@@ -95,7 +99,7 @@ func (c Combine) ProcessInstr(p InstrProcessor) {
 }
 
 func (c Combine) String() string {
-	return fmt.Sprintf("%s := %s(%s, %s)", c.Dst, c.Op, c.Lsrc, c.Rsrc)
+	return fmt.Sprintf("%s := %s%s(%s, %s)", c.Dst, c.Op, intSuffix(c.Int), c.Lsrc, c.Rsrc)
 }
 
 // Transform applies a unary operator Op to Src and stores the result in Dst.
@@ -502,13 +506,31 @@ func (l DeclareLabel) ProcessInstr(p InstrProcessor) {
 	p.ProcessDeclareLabelInstr(l)
 }
 
+// DeclareLocal is not a real IR instruction.  It records that from this point
+// the register holds the value of a local variable with the given name, until
+// the register is released.  This information is only used by debug functions.
+type DeclareLocal struct {
+	Name string
+	Reg  Register
+}
+
+func (l DeclareLocal) String() string {
+	return fmt.Sprintf("local %s = %s", l.Name, l.Reg)
+}
+
+// ProcessInstr makes the InstrProcessor process this instruction.
+func (l DeclareLocal) ProcessInstr(p InstrProcessor) {
+	p.ProcessDeclareLocalInstr(l)
+}
+
 // PrepForLoop prepares a for loop
 type PrepForLoop struct {
 	Start, Stop, Step Register
+	Int               bool // True if Start and Step are known to contain integers
 }
 
 func (i PrepForLoop) String() string {
-	return fmt.Sprintf("prepfor %s, %s, %s", i.Start, i.Stop, i.Step)
+	return fmt.Sprintf("prepfor%s %s, %s, %s", intSuffix(i.Int), i.Start, i.Stop, i.Step)
 }
 
 func (i PrepForLoop) ProcessInstr(p InstrProcessor) {
@@ -519,12 +541,20 @@ func (i PrepForLoop) ProcessInstr(p InstrProcessor) {
 
 type AdvForLoop struct {
 	Start, Stop, Step Register
+	Int               bool // True if the loop was prepared with Int set
 }
 
 func (i AdvForLoop) String() string {
-	return fmt.Sprintf("advfor %s, %s, %s", i.Start, i.Stop, i.Step)
+	return fmt.Sprintf("advfor%s %s, %s, %s", intSuffix(i.Int), i.Start, i.Stop, i.Step)
 }
 
 func (i AdvForLoop) ProcessInstr(p InstrProcessor) {
 	p.ProcessAdvForLoopInstr(i)
 }
+
+func intSuffix(isInt bool) string {
+	if isInt {
+		return ".i"
+	}
+	return ""
+}
@@ -0,0 +1,524 @@
+package ir
+
+import (
+	"math"
+
+	"github.com/arnodel/golua/ops"
+)
+
+// Optimization levels for Optimize.
+const (
+	// OptNone only applies DefaultFold.
+	OptNone = 0
+
+	// OptBasic folds operations on constants and removes dead code (code which
+	// cannot be reached and registers which are set but never used).
+	OptBasic = 1
+
+	// OptFull also propagates copies of registers and reuses registers which
+	// already contain a constant instead of loading it again.
+	OptFull = 2
+)
+
+// Maximum number of times the passes are applied to a function.  Each pass can
+// enable further optimizations by the other passes, so they are applied until
+// there is nothing left to do, or this number is reached.
+const maxOptRounds = 8
+
+// Optimize optimizes the code items in the given constant slice at the given
+// level (see OptBasic and OptFull).  It returns a new constant slice, which may
+// contain extra constants computed by the optimizations.  Constant indices are
+// preserved.
+func Optimize(consts []Constant, level int) []Constant {
+	if level <= OptNone {
+		return FoldConstants(consts, DefaultFold)
+	}
+	o := &optimizer{
+		consts: append([]Constant(nil), consts...),
+		kmap:   map[interface{}]uint{},
+		level:  level,
+	}
+	for i, k := range consts {
+		if isScalar(k) {
+			o.kmap[constKey(k)] = uint(i)
+		}
+	}
+	for i, k := range consts {
+		if c, ok := k.(*Code); ok {
+			oc := o.optimizeCode(*c)
+			o.consts[i] = &oc
+		}
+	}
+	return o.consts
+}
+
+type optimizer struct {
+	consts []Constant
+	kmap   map[interface{}]uint // Index of scalar constants
+	level  int
+}
+
+func (o *optimizer) optimizeCode(c Code) Code {
+	c.Instructions = append([]Instruction(nil), c.Instructions...)
+	c.Lines = append([]int(nil), c.Lines...)
+	for i := 0; i < maxOptRounds; i++ {
+		changed := o.foldConstants(&c)
+		changed = removeUnreachableCode(&c) || changed
+		if o.level >= OptFull {
+			changed = propagateCopies(&c) || changed
+		}
+		changed = removeDeadStores(&c) || changed
+		if !changed {
+			break
+		}
+	}
+	return c
+}
+
+// Returns the index of the constant k, adding it to the constants if needed.
+func (o *optimizer) constIndex(k Constant) uint {
+	key := constKey(k)
+	if i, ok := o.kmap[key]; ok {
+		return i
+	}
+	i := uint(len(o.consts))
+	o.consts = append(o.consts, k)
+	o.kmap[key] = i
+	return i
+}
+
+// Floats are keyed by their bits so that e.g. 0.0 and -0.0 are different
+// constants.
+type floatKey uint64
+
+func constKey(k Constant) interface{} {
+	if f, ok := k.(Float); ok {
+		return floatKey(math.Float64bits(float64(f)))
+	}
+	return k
+}
+
+func isScalar(k Constant) bool {
+	switch k.(type) {
+	case Int, Float, Bool, String, NilType:
+		return true
+	default:
+		return false
+	}
+}
+
+// foldConstants replaces operations on registers containing known constants
+// with the result of the operation, and conditional jumps on known constants
+// with unconditional jumps (or nothing).  It also removes loading a constant
+// into a register which already contains it.
+func (o *optimizer) foldConstants(c *Code) bool {
+	var (
+		changed bool
+		known   = map[Register]Constant{} // Constant values of registers
+		loaded  = map[Register]uint{}     // Constants still in their register
+		takes   = newTakeCounter(c)
+	)
+	for i, instr := range c.Instructions {
+		if instr == nil {
+			continue
+		}
+		if newInstr, ok := o.foldInstr(instr, known, loaded); ok {
+			changed = true
+			c.Instructions[i] = newInstr
+			if newInstr == nil {
+				continue
+			}
+			instr = newInstr
+		}
+		if _, ok := instr.(DeclareLabel); ok {
+			// Jumps may come here with other values in registers.
+			known = map[Register]Constant{}
+			loaded = map[Register]uint{}
+			continue
+		}
+		takes.update(instr)
+		for r := range loaded {
+			if !takes.isTaken(r) {
+				delete(loaded, r)
+			}
+		}
+		for _, r := range writtenRegisters(instr) {
+			delete(known, r)
+			delete(loaded, r)
+		}
+		if l, ok := instr.(LoadConst); ok && !c.Registers[l.Dst].IsCell {
+			if k := o.consts[l.Kidx]; isScalar(k) {
+				known[l.Dst] = k
+				loaded[l.Dst] = l.Kidx
+			}
+		}
+	}
+	compactCode(c)
+	return changed
+}
+
+// foldInstr returns the instruction replacing instr (nil to remove it) and
+// true, or false if instr cannot be simplified.
+func (o *optimizer) foldInstr(instr Instruction, known map[Register]Constant, loaded map[Register]uint) (Instruction, bool) {
+	switch x := instr.(type) {
+	case LoadConst:
+		if k, ok := loaded[x.Dst]; ok && k == x.Kidx {
+			return nil, true
+		}
+	case Combine:
+		kl, okl := known[x.Lsrc]
+		kr, okr := known[x.Rsrc]
+		if okl && okr {
+			if k, ok := foldBinOp(x.Op, kl, kr); ok {
+				return LoadConst{Dst: x.Dst, Kidx: o.constIndex(k)}, true
+			}
+		}
+	case Transform:
+		if ks, ok := known[x.Src]; ok {
+			if k, ok := foldUnOp(x.Op, ks); ok {
+				return LoadConst{Dst: x.Dst, Kidx: o.constIndex(k)}, true
+			}
+		}
+	case JumpIf:
+		if k, ok := known[x.Cond]; ok {
+			if isTrue(k) != x.Not {
+				return Jump{Label: x.Label}, true
+			}
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+// removeUnreachableCode removes instructions which follow an unconditional jump
+// or a tail call, up to the next label.  It also removes jumps to the label
+// which follows.  Instructions which are only hints for the register allocator
+// or debug information are kept.
+func removeUnreachableCode(c *Code) bool {
+	changed := false
+	reachable := true
+	for i, instr := range c.Instructions {
+		switch x := instr.(type) {
+		case nil, TakeRegister, ReleaseRegister, DeclareLocal:
+		case DeclareLabel:
+			reachable = true
+		default:
+			if !reachable {
+				c.Instructions[i] = nil
+				changed = true
+				break
+			}
+			switch x := x.(type) {
+			case Jump:
+				reachable = false
+			case Call:
+				reachable = !x.Tail
+			}
+		}
+	}
+	for i, instr := range c.Instructions {
+		if j, ok := instr.(Jump); ok && jumpsToNext(c.Instructions[i+1:], j.Label) {
+			c.Instructions[i] = nil
+			changed = true
+		}
+	}
+	compactCode(c)
+	return changed
+}
+
+// Returns true if lbl is declared in instrs before any real instruction.
+func jumpsToNext(instrs []Instruction, lbl Label) bool {
+	for _, instr := range instrs {
+		switch x := instr.(type) {
+		case nil, TakeRegister, ReleaseRegister, DeclareLocal:
+		case DeclareLabel:
+			if x.Label == lbl {
+				return true
+			}
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// propagateCopies replaces reads of a register which contains a copy of another
+// register (or the same constant as another register) with reads of that other
+// register, when it is safe to do so.  The copies may then become dead stores.
+func propagateCopies(c *Code) bool {
+	var (
+		changed bool
+		copies  = map[Register]Register{} // dst => src if dst contains a copy of src
+		holders = map[uint]Register{}     // k => register containing constant k
+		takes   = newTakeCounter(c)
+	)
+	isCell := func(r Register) bool {
+		return c.Registers[r].IsCell
+	}
+	forget := func(r Register) {
+		delete(copies, r)
+		for dst, src := range copies {
+			if src == r {
+				delete(copies, dst)
+			}
+		}
+		for k, h := range holders {
+			if h == r {
+				delete(holders, k)
+			}
+		}
+	}
+	for i, instr := range c.Instructions {
+		if _, ok := instr.(DeclareLabel); ok {
+			copies = map[Register]Register{}
+			holders = map[uint]Register{}
+			continue
+		}
+		if len(copies) > 0 {
+			newInstr := replaceReadRegisters(instr, func(r Register) Register {
+				if src, ok := copies[r]; ok {
+					return src
+				}
+				return r
+			})
+			if newInstr != nil {
+				instr = newInstr
+				c.Instructions[i] = instr
+				changed = true
+			}
+		}
+		takes.update(instr)
+		for _, src := range copies {
+			if !takes.isTaken(src) {
+				forget(src)
+			}
+		}
+		for _, h := range holders {
+			if !takes.isTaken(h) {
+				forget(h)
+			}
+		}
+		for _, r := range writtenRegisters(instr) {
+			forget(r)
+		}
+		switch x := instr.(type) {
+		case Transform:
+			if x.Op == ops.OpId && x.Dst != x.Src && !isCell(x.Dst) && !isCell(x.Src) {
+				copies[x.Dst] = x.Src
+			}
+		case LoadConst:
+			if isCell(x.Dst) {
+				break
+			}
+			if h, ok := holders[x.Kidx]; ok {
+				copies[x.Dst] = h
+			} else {
+				holders[x.Kidx] = x.Dst
+			}
+		}
+	}
+	return changed
+}
+
+// removeDeadStores removes instructions without side effects which set a
+// register which is never read.  Cells, upvalues and local variables (for
+// debug information) are always kept.
+func removeDeadStores(c *Code) bool {
+	keep := make([]bool, len(c.Registers))
+	for _, r := range c.UpvalueDests {
+		keep[r] = true
+	}
+	for _, instr := range c.Instructions {
+		if l, ok := instr.(DeclareLocal); ok {
+			keep[l.Reg] = true
+		}
+		for _, r := range readRegisters(instr) {
+			keep[r] = true
+		}
+	}
+	changed := false
+	for i, instr := range c.Instructions {
+		var dst Register
+		switch x := instr.(type) {
+		case LoadConst:
+			dst = x.Dst
+		case Transform:
+			if x.Op != ops.OpId && x.Op != ops.OpNot {
+				continue
+			}
+			dst = x.Dst
+		case MkClosure:
+			dst = x.Dst
+		case MkTable:
+			dst = x.Dst
+		case EtcLookup:
+			dst = x.Dst
+		default:
+			continue
+		}
+		if !keep[dst] && !c.Registers[dst].IsCell {
+			c.Instructions[i] = nil
+			changed = true
+		}
+	}
+	compactCode(c)
+	return changed
+}
+
+// Removes the nil instructions from c.
+func compactCode(c *Code) {
+	j := 0
+	for i, instr := range c.Instructions {
+		if instr != nil {
+			c.Instructions[j] = instr
+			c.Lines[j] = c.Lines[i]
+			j++
+		}
+	}
+	c.Instructions = c.Instructions[:j]
+	c.Lines = c.Lines[:j]
+}
+
+// A takeCounter counts how many times registers are taken.  The register
+// allocator of the ircomp package may give the code register of an IR register
+// which is not taken to another IR register.  So the optimizations can only
+// reuse the value of a register later if it stays taken in between.
+type takeCounter []int
+
+func newTakeCounter(c *Code) takeCounter {
+	t := make(takeCounter, len(c.Registers))
+	for _, r := range c.UpvalueDests {
+		t[r]++
+	}
+	return t
+}
+
+func (t takeCounter) isTaken(r Register) bool {
+	return t[r] > 0
+}
+
+// Updates the counts after instr.
+func (t takeCounter) update(instr Instruction) {
+	switch x := instr.(type) {
+	case TakeRegister:
+		t[x.Reg]++
+	case ReleaseRegister:
+		t[x.Reg]--
+	}
+}
+
+// readRegisters returns the registers whose values are read by instr.
+func readRegisters(instr Instruction) []Register {
+	switch x := instr.(type) {
+	case Combine:
+		return []Register{x.Lsrc, x.Rsrc}
+	case Transform:
+		return []Register{x.Src}
+	case Push:
+		return []Register{x.Cont, x.Item}
+	case JumpIf:
+		return []Register{x.Cond}
+	case Call:
+		return []Register{x.Cont}
+	case MkClosure:
+		return x.Upvalues
+	case MkCont:
+		return []Register{x.Closure}
+	case Lookup:
+		return []Register{x.Table, x.Index}
+	case SetIndex:
+		return []Register{x.Table, x.Index, x.Src}
+	case EtcLookup:
+		return []Register{x.Etc}
+	case FillTable:
+		return []Register{x.Etc, x.Dst}
+	case PushCloseStack:
+		return []Register{x.Src}
+	case PrepForLoop:
+		return []Register{x.Start, x.Stop, x.Step}
+	case AdvForLoop:
+		return []Register{x.Start, x.Stop, x.Step}
+	}
+	return nil
+}
+
+// writtenRegisters returns the registers whose values are set by instr.
+func writtenRegisters(instr Instruction) []Register {
+	switch x := instr.(type) {
+	case SetRegInstruction:
+		return []Register{x.DestReg()}
+	case ClearReg:
+		return []Register{x.Dst}
+	case MkTable:
+		return []Register{x.Dst}
+	case Receive:
+		return x.Dst
+	case ReceiveEtc:
+		return append(x.Dst[:len(x.Dst):len(x.Dst)], x.Etc)
+	case PrepForLoop:
+		return []Register{x.Start, x.Stop, x.Step}
+	case AdvForLoop:
+		return []Register{x.Start, x.Stop, x.Step}
+	}
+	return nil
+}
+
+// replaceReadRegisters returns a copy of instr where the registers it reads are
+// replaced with f(register), or nil if no register is replaced.  Instructions
+// which write to the registers they read are left alone.
+func replaceReadRegisters(instr Instruction, f func(Register) Register) Instruction {
+	replaced := false
+	g := func(r Register) Register {
+		r1 := f(r)
+		if r1 != r {
+			replaced = true
+		}
+		return r1
+	}
+	switch x := instr.(type) {
+	case Combine:
+		x.Lsrc, x.Rsrc = g(x.Lsrc), g(x.Rsrc)
+		instr = x
+	case Transform:
+		x.Src = g(x.Src)
+		instr = x
+	case Push:
+		x.Cont, x.Item = g(x.Cont), g(x.Item)
+		instr = x
+	case JumpIf:
+		x.Cond = g(x.Cond)
+		instr = x
+	case Call:
+		x.Cont = g(x.Cont)
+		instr = x
+	case MkClosure:
+		upvalues := make([]Register, len(x.Upvalues))
+		for i, r := range x.Upvalues {
+			upvalues[i] = g(r)
+		}
+		x.Upvalues = upvalues
+		instr = x
+	case MkCont:
+		x.Closure = g(x.Closure)
+		instr = x
+	case Lookup:
+		x.Table, x.Index = g(x.Table), g(x.Index)
+		instr = x
+	case SetIndex:
+		x.Table, x.Index, x.Src = g(x.Table), g(x.Index), g(x.Src)
+		instr = x
+	case EtcLookup:
+		x.Etc = g(x.Etc)
+		instr = x
+	case FillTable:
+		x.Etc, x.Dst = g(x.Etc), g(x.Dst)
+		instr = x
+	case PushCloseStack:
+		x.Src = g(x.Src)
+		instr = x
+	}
+	if !replaced {
+		return nil
+	}
+	return instr
+}
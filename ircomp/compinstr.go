@@ -12,17 +12,18 @@ import (
 type instrCompiler struct {
 	*ConstantCompiler
 	*regAllocator
-	line int
+	localVars *localVarTracker
+	line      int
 }
 
 var _ ir.InstrProcessor = instrCompiler{}
 
-func (ic instrCompiler) Emit(opcode code.Opcode) {
-	ic.builder.Emit(opcode, ic.line)
+func (ic instrCompiler) Emit(instr code.Instr) {
+	ic.builder.Emit(instr, ic.line)
 }
 
-func (ic instrCompiler) EmitJump(opcode code.Opcode, lbl code.Label) {
-	ic.builder.EmitJump(opcode, lbl, ic.line)
+func (ic instrCompiler) EmitJump(instr code.Instr, lbl code.Label) {
+	ic.builder.EmitJump(instr, lbl, ic.line)
 }
 
 // ProcessCombineInstr compiles a Combine instruction.
@@ -31,8 +32,14 @@ func (ic instrCompiler) ProcessCombineInstr(c ir.Combine) {
 	if !ok {
 		panic(fmt.Sprintf("Cannot compile %v: invalid op", c))
 	}
-	opcode := code.Combine(codeOp, ic.codeReg(c.Dst), ic.codeReg(c.Lsrc), ic.codeReg(c.Rsrc))
-	ic.Emit(opcode)
+	dst, lsrc, rsrc := ic.codeReg(c.Dst), ic.codeReg(c.Lsrc), ic.codeReg(c.Rsrc)
+	if c.Int {
+		if opcode, ok := code.CombineInt(codeOp, dst, lsrc, rsrc); ok {
+			ic.Emit(opcode)
+			return
+		}
+	}
+	ic.Emit(code.Combine(codeOp, dst, lsrc, rsrc))
 }
 
 var codeBinOp = map[ops.Op]code.BinOp{
@@ -77,7 +84,7 @@ var codeUnOp = map[ops.Op]code.UnOp{
 func (ic instrCompiler) ProcessLoadConstInstr(l ir.LoadConst) {
 	k := ic.GetConstant(l.Kidx)
 	dst := ic.codeReg(l.Dst)
-	var opcode code.Opcode
+	var opcode code.Instr
 	var inlined bool
 	// Short strings and small integers are inlined.
 	switch kk := k.(type) {
@@ -99,7 +106,7 @@ func (ic instrCompiler) ProcessLoadConstInstr(l ir.LoadConst) {
 
 // ProcessPushInstr compiles a Push instruction.
 func (ic instrCompiler) ProcessPushInstr(p ir.Push) {
-	var opcode code.Opcode
+	var opcode code.Instr
 	if p.Etc {
 		opcode = code.PushEtc(ic.codeReg(p.Cont), ic.codeReg(p.Item))
 	} else {
@@ -117,7 +124,7 @@ func (ic instrCompiler) ProcessJumpInstr(j ir.Jump) {
 
 // ProcessJumpIfInstr compiles a JumpIf instruction.
 func (ic instrCompiler) ProcessJumpIfInstr(j ir.JumpIf) {
-	var opcode code.Opcode
+	var opcode code.Instr
 	if j.Not {
 		opcode = code.JumpIfNot(0, ic.codeReg(j.Cond))
 	} else {
@@ -149,7 +156,7 @@ func (ic instrCompiler) ProcessMkClosureInstr(m ir.MkClosure) {
 
 // ProcessMkContInstr compiles a MkCont instruction.
 func (ic instrCompiler) ProcessMkContInstr(m ir.MkCont) {
-	var opcode code.Opcode
+	var opcode code.Instr
 	if m.Tail {
 		opcode = code.TailCont(ic.codeReg(m.Dst), ic.codeReg(m.Closure))
 	} else {
@@ -199,24 +206,24 @@ func (ic instrCompiler) ProcessReceiveEtcInstr(r ir.ReceiveEtc) {
 
 // ProcessEtcLookupInstr compiles a EtcLookup instruction.
 func (ic instrCompiler) ProcessEtcLookupInstr(l ir.EtcLookup) {
-	if l.Idx < 0 || l.Idx >= 256 {
-		panic("Etc lookup index out of range")
+	if l.Idx < 0 || l.Idx > math.MaxUint16 {
+		panic(newPanic("etc lookup index out of range"))
 	}
 	ic.Emit(code.LoadEtcLookup(ic.codeReg(l.Dst), ic.codeReg(l.Etc), l.Idx))
 }
 
 // ProcessFillTableInstr compiles a FillTable instruction.
 func (ic instrCompiler) ProcessFillTableInstr(f ir.FillTable) {
-	if f.Idx < 0 || f.Idx >= 256 {
-		panic("Fill table index out of range")
+	if f.Idx < 0 || f.Idx > math.MaxUint16 {
+		panic(newPanic("fill table index out of range"))
 	}
 	ic.Emit(code.FillTable(ic.codeReg(f.Dst), ic.codeReg(f.Etc), f.Idx))
 }
 
 // ProcessTruncateCloseStackInstr compiles a TruncateCloseStack instruction.
 func (ic instrCompiler) ProcessTruncateCloseStackInstr(t ir.TruncateCloseStack) {
-	if t.Height < 0 || t.Height >= 65536 {
-		panic("close stack height out of range")
+	if t.Height < 0 || t.Height > math.MaxUint16 {
+		panic(newPanic("close stack height out of range"))
 	}
 	ic.Emit(code.ClTrunc(uint16(t.Height)))
 }
@@ -228,12 +235,26 @@ func (ic instrCompiler) ProcessPushCloseStackInstr(p ir.PushCloseStack) {
 
 // ProcessPrepForLoopInstr compiles a PrepForLoop instruction.
 func (ic instrCompiler) ProcessPrepForLoopInstr(i ir.PrepForLoop) {
-	ic.Emit(code.PrepForLoop(ic.codeReg(i.Start), ic.codeReg(i.Stop), ic.codeReg(i.Step)))
+	start, stop, step := ic.codeReg(i.Start), ic.codeReg(i.Stop), ic.codeReg(i.Step)
+	if i.Int {
+		if opcode, ok := code.PrepForLoopInt(start, stop, step); ok {
+			ic.Emit(opcode)
+			return
+		}
+	}
+	ic.Emit(code.PrepForLoop(start, stop, step))
 }
 
 // ProcessAdvForLoopInstr compiles an AdvForLoop instruction.
 func (ic instrCompiler) ProcessAdvForLoopInstr(i ir.AdvForLoop) {
-	ic.Emit(code.AdvForLoop(ic.codeReg(i.Start), ic.codeReg(i.Stop), ic.codeReg(i.Step)))
+	start, stop, step := ic.codeReg(i.Start), ic.codeReg(i.Stop), ic.codeReg(i.Step)
+	if i.Int {
+		if opcode, ok := code.AdvForLoopInt(start, stop, step); ok {
+			ic.Emit(opcode)
+			return
+		}
+	}
+	ic.Emit(code.AdvForLoop(start, stop, step))
 }
 
 func (ic instrCompiler) ProcessTakeRegisterInstr(t ir.TakeRegister) {
@@ -242,12 +263,43 @@ func (ic instrCompiler) ProcessTakeRegisterInstr(t ir.TakeRegister) {
 
 func (ic instrCompiler) ProcessReleaseRegisterInstr(r ir.ReleaseRegister) {
 	ic.releaseRegister(r.Reg)
+	ic.localVars.close(r.Reg, ic.builder.Len())
 }
 
 func (ic instrCompiler) ProcessDeclareLabelInstr(l ir.DeclareLabel) {
 	ic.builder.EmitLabel(code.Label(l.Label))
 }
 
+func (ic instrCompiler) ProcessDeclareLocalInstr(l ir.DeclareLocal) {
+	ic.localVars.open[l.Reg] = len(ic.localVars.vars)
+	ic.localVars.vars = append(ic.localVars.vars, code.LocalVar{
+		Name:    l.Name,
+		Reg:     ic.codeReg(l.Reg),
+		StartPC: ic.builder.Len() - ic.localVars.start,
+	})
+}
+
+// localVarTracker keeps track of the local variables in a function as it is
+// compiled.  A local variable stops being active when its register is released.
+type localVarTracker struct {
+	start uint                // Offset of the function in the code unit
+	vars  []code.LocalVar     // All the local variables seen so far
+	open  map[ir.Register]int // Index in vars of the active local variables
+}
+
+func (t *localVarTracker) close(r ir.Register, offset uint) {
+	if i, ok := t.open[r]; ok {
+		t.vars[i].EndPC = offset - t.start
+		delete(t.open, r)
+	}
+}
+
+func (t *localVarTracker) closeAll(offset uint) {
+	for r := range t.open {
+		t.close(r, offset)
+	}
+}
+
 type regAllocation struct {
 	r    code.Reg
 	done bool
@@ -292,7 +344,7 @@ func (a *regAllocator) codeReg(r ir.Register) code.Reg {
 		return alloc.r
 	}
 	var cr code.Reg
-	var i uint8
+	var i uint16
 	if rData.IsCell {
 		a.cells, i = allocReg(a.cells)
 		cr = code.CellReg(i)
@@ -307,17 +359,21 @@ func (a *regAllocator) codeReg(r ir.Register) code.Reg {
 	return cr
 }
 
-func allocReg(regs []int) ([]int, uint8) {
+// The number of registers of a function is stored as an int16 (see
+// code.Code.RegCount and code.Code.CellCount).
+const maxRegCount = math.MaxInt16
+
+func allocReg(regs []int) ([]int, uint16) {
 	for i, c := range regs {
 		if c == 0 {
-			return regs, uint8(i)
+			return regs, uint16(i)
 		}
 	}
-	if len(regs) == math.MaxUint8 {
-		panic(newPanic("not enough registers"))
+	if len(regs) == maxRegCount {
+		panic(newPanic(fmt.Sprintf("not enough registers (a function can use at most %d)", maxRegCount)))
 	}
 	i := len(regs)
-	return append(regs, 0), uint8(i)
+	return append(regs, 0), uint16(i)
 }
 
 type CompilationPanic struct {
@@ -53,6 +53,21 @@ func (kc *ConstantCompiler) ProcessNil(k ir.NilType) {
 // ProcessCode compiles a Code.
 func (kc *ConstantCompiler) ProcessCode(c ir.Code) {
 	start := kc.builder.Offset()
+	ck := kc.compileCode(c, start)
+	if kc.builder.JumpOverflow() {
+		// Some jumps are too long for their opcode, so compile again with
+		// wide jumps.
+		kc.builder.Rewind(start)
+		kc.builder.SetWideJumps(true)
+		ck = kc.compileCode(c, start)
+		kc.builder.SetWideJumps(false)
+	}
+	ck.EndOffset = kc.builder.Offset()
+	kc.addCompiled(ck)
+}
+
+// compileCode emits the opcodes for c, which start at the given offset.
+func (kc *ConstantCompiler) compileCode(c ir.Code, start uint) code.Code {
 	regAllocator := &regAllocator{
 		registers:   c.Registers,
 		allocations: make([]regAllocation, len(c.Registers)),
@@ -61,24 +76,35 @@ func (kc *ConstantCompiler) ProcessCode(c ir.Code) {
 	for _, r := range c.UpvalueDests {
 		regAllocator.takeRegister(r)
 	}
+	localVars := &localVarTracker{
+		start: start,
+		open:  make(map[ir.Register]int),
+	}
 	ic := instrCompiler{
 		ConstantCompiler: kc,
 		regAllocator:     regAllocator,
+		localVars:        localVars,
 	}
 	for i, instr := range c.Instructions {
 		ic.line = c.Lines[i]
 		instr.ProcessInstr(ic)
 	}
-	end := kc.builder.Offset()
-	kc.addCompiled(code.Code{
+	localVars.closeAll(kc.builder.Len())
+	return code.Code{
 		Name:         c.Name,
 		StartOffset:  start,
-		EndOffset:    end,
 		UpvalueCount: int16(len(c.UpvalueDests)),
 		CellCount:    int16(len(regAllocator.cells)),
 		UpNames:      c.UpNames,
 		RegCount:     int16(len(regAllocator.regs)),
-	})
+		LocalVars:    localVars.vars,
+
+		NameWhat:        c.NameWhat,
+		LineDefined:     int32(c.LineDefined),
+		LastLineDefined: int32(c.LastLineDefined),
+		NParams:         int16(c.NParams),
+		IsVararg:        c.IsVararg,
+	}
 }
 
 func (kc *ConstantCompiler) compileConstant(ki uint) {
@@ -91,7 +91,7 @@ func loadChunk(t *rt.Thread, args []rt.Value) (chunk []byte, chunkName string, e
 	var reader io.Reader
 	if len(args) == 0 {
 		chunkName = "stdin"
-		reader = os.Stdin
+		reader = t.Stdio().Stdin
 	} else {
 		var ok bool
 		chunkName, ok = args[0].TryString()
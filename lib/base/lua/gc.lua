@@ -0,0 +1,57 @@
+-- __gc metamethods are called when objects are collected
+do
+    local function mk(name)
+        setmetatable({}, {__gc = function() print("gc " .. name) end})
+    end
+    mk("a")
+    collectgarbage()
+    --> =gc a
+end
+
+-- The object is passed to the __gc metamethod
+do
+    local meta = {__gc = function(x) print("gc", x.name) end}
+    local function mk(name)
+        setmetatable({name = name}, meta)
+    end
+    mk("foo")
+    collectgarbage()
+    --> =gc	foo
+end
+
+-- Objects which are still reachable are not finalized
+do
+    local t = setmetatable({}, {__gc = function() print("gc t") end})
+    collectgarbage()
+    print("still alive", t ~= nil)
+    --> =still alive	true
+    t = nil
+    collectgarbage()
+    --> =gc t
+end
+
+-- An object is only marked if its metatable has __gc when setmetatable is
+-- called.
+do
+    local function mk()
+        local meta = {}
+        setmetatable({}, meta)
+        meta.__gc = function() print("should not be called") end
+    end
+    mk()
+    collectgarbage()
+    print("not marked")
+    --> =not marked
+end
+
+-- Errors in __gc metamethods are turned into warnings
+do
+    warn("@on")
+    local function mk()
+        setmetatable({}, {__gc = function() error("oops") end})
+    end
+    mk()
+    collectgarbage()
+    --> ~Test warning: error in __gc metamethod \(.*oops\)
+    warn("@off")
+end
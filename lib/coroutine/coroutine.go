@@ -14,7 +14,7 @@ var LibLoader = packagelib.Loader{
 }
 
 func load(r *rt.Runtime) (rt.Value, func()) {
-	pkg := rt.NewTable()
+	pkg := r.NewTable()
 
 	rt.SolemnlyDeclareCompliance(
 		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
@@ -62,6 +62,7 @@ func create(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return nil, err
 	}
 	co := rt.NewThread(t.Runtime)
+	co.InheritHooks(t)
 	co.Start(f)
 	return c.PushingNext1(t.Runtime, rt.ThreadValue(co)), nil
 }
@@ -138,6 +139,7 @@ func wrap(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return nil, err
 	}
 	co := rt.NewThread(t.Runtime)
+	co.InheritHooks(t)
 	co.Start(f)
 	w := rt.NewGoFunction(func(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		res, err := co.Resume(t, c.Etc())
@@ -15,7 +15,7 @@ var LibLoader = packagelib.Loader{
 }
 
 func load(r *rt.Runtime) (rt.Value, func()) {
-	pkg := rt.NewTable()
+	pkg := r.NewTable()
 	pkgVal := rt.TableValue(pkg)
 	r.SetEnv(r.GlobalEnv(), "debug", pkgVal)
 
@@ -24,7 +24,9 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 
 		r.SetEnvGoFunc(pkg, "gethook", gethook, 1, false),
 		r.SetEnvGoFunc(pkg, "getinfo", getinfo, 3, false),
+		r.SetEnvGoFunc(pkg, "getlocal", getlocal, 3, false),
 		r.SetEnvGoFunc(pkg, "getupvalue", getupvalue, 2, false),
+		r.SetEnvGoFunc(pkg, "setlocal", setlocal, 4, false),
 		r.SetEnvGoFunc(pkg, "setupvalue", setupvalue, 3, false),
 		r.SetEnvGoFunc(pkg, "upvaluejoin", upvaluejoin, 4, false),
 		r.SetEnvGoFunc(pkg, "setmetatable", setmetatable, 2, false),
@@ -44,7 +46,7 @@ func getinfo(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		thread *rt.Thread
 		idx    int64
 		cont   rt.Cont
-		what   string
+		what   = "flnSrtu"
 		fIdx   int
 	)
 	thread, ok := c.Arg(0).TryThread()
@@ -71,6 +73,16 @@ func getinfo(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	default:
 		return nil, errors.New("f should be an integer or function")
 	}
+	if c.NArgs() > fIdx+1 {
+		var err error
+		what, err = c.StringArg(fIdx + 1)
+		if err != nil {
+			return nil, err
+		}
+		if strings.Trim(what, "SlnrutfL") != "" {
+			return nil, errors.New("invalid option")
+		}
+	}
 	if cont == nil {
 		cont = thread.CurrentCont()
 	}
@@ -78,23 +90,165 @@ func getinfo(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		cont = cont.Parent()
 		idx--
 	}
-	// TODO: support what arg
-	_ = what
 	next := c.Next()
 	if cont == nil {
 		t.Push1(next, rt.NilValue)
 	} else if info := cont.DebugInfo(); info == nil {
 		t.Push1(next, rt.NilValue)
 	} else {
-		res := rt.NewTable()
-		t.SetEnv(res, "name", rt.StringValue(info.Name))
-		t.SetEnv(res, "currentline", rt.IntValue(int64(info.CurrentLine)))
-		t.SetEnv(res, "source", rt.StringValue(info.Source))
-		t.Push1(next, rt.TableValue(res))
+		t.Push1(next, rt.TableValue(infoTable(t.Runtime, info, what)))
+	}
+	return next, nil
+}
+
+// Returns a table with the fields of info selected by the what option of
+// debug.getinfo.
+func infoTable(r *rt.Runtime, info *rt.DebugInfo, what string) *rt.Table {
+	res := r.NewTable()
+	setField := func(name string, val rt.Value) {
+		r.SetEnv(res, name, val)
+	}
+	for _, opt := range what {
+		switch opt {
+		case 'S':
+			setField("source", rt.StringValue(info.Source))
+			setField("short_src", rt.StringValue(shortSource(info.Source)))
+			setField("linedefined", rt.IntValue(int64(info.LineDefined)))
+			setField("lastlinedefined", rt.IntValue(int64(info.LastLineDefined)))
+			setField("what", rt.StringValue(info.What))
+		case 'l':
+			setField("currentline", rt.IntValue(int64(info.CurrentLine)))
+		case 'n':
+			setField("name", rt.StringValue(info.Name))
+			setField("namewhat", rt.StringValue(info.NameWhat))
+		case 'u':
+			setField("nups", rt.IntValue(int64(info.NUpvalues)))
+			setField("nparams", rt.IntValue(int64(info.NParams)))
+			setField("isvararg", rt.BoolValue(info.IsVararg))
+		case 't':
+			setField("istailcall", rt.BoolValue(info.IsTailCall))
+		case 'r':
+			// Transferred values are only known in call and return hooks in
+			// the reference implementation, which golua does not support.
+			setField("ftransfer", rt.IntValue(0))
+			setField("ntransfer", rt.IntValue(0))
+		case 'f':
+			setField("func", info.Function)
+		case 'L':
+			if clos, ok := info.Function.TryClosure(); ok {
+				lines := r.NewTable()
+				for _, l := range clos.ActiveLines() {
+					r.SetTable(lines, rt.IntValue(int64(l)), rt.BoolValue(true))
+				}
+				setField("activelines", rt.TableValue(lines))
+			}
+		}
+	}
+	return res
+}
+
+// Maximum length of short_src, as in the reference implementation.
+const maxShortSourceLen = 60
+
+// Returns a version of source suitable for error messages.
+func shortSource(source string) string {
+	if len(source) > 0 && (source[0] == '=' || source[0] == '@') {
+		source = source[1:]
+	}
+	if len(source) > maxShortSourceLen {
+		source = "..." + source[len(source)-maxShortSourceLen+3:]
+	}
+	return source
+}
+
+func getlocal(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	thread, argOffset := optThreadArg(t, c)
+	if err := c.CheckNArgs(argOffset + 2); err != nil {
+		return nil, err
+	}
+	n, err := c.IntArg(argOffset + 1)
+	if err != nil {
+		return nil, err
+	}
+	next := c.Next()
+
+	// If f is a function, only parameter names are returned.
+	if f := c.Arg(argOffset); f.Type() == rt.FunctionType {
+		name := rt.NilValue
+		if clos, ok := f.TryClosure(); ok {
+			if s, ok := clos.ParameterName(int(n)); ok {
+				name = rt.StringValue(s)
+			}
+		}
+		t.Push1(next, name)
+		return next, nil
+	}
+	cont, err := levelArg(thread, c, argOffset)
+	if err != nil {
+		return nil, err
+	}
+	if lc, ok := rt.AsLuaCont(cont); ok {
+		if name, val, ok := lc.GetLocal(int(n)); ok {
+			t.Push(next, rt.StringValue(name), val)
+			return next, nil
+		}
+	}
+	t.Push1(next, rt.NilValue)
+	return next, nil
+}
+
+func setlocal(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	thread, argOffset := optThreadArg(t, c)
+	if err := c.CheckNArgs(argOffset + 3); err != nil {
+		return nil, err
+	}
+	n, err := c.IntArg(argOffset + 1)
+	if err != nil {
+		return nil, err
+	}
+	cont, err := levelArg(thread, c, argOffset)
+	if err != nil {
+		return nil, err
+	}
+	next := c.Next()
+	if lc, ok := rt.AsLuaCont(cont); ok {
+		if name, ok := lc.SetLocal(int(n), c.Arg(argOffset+2)); ok {
+			t.Push1(next, rt.StringValue(name))
+			return next, nil
+		}
 	}
+	t.Push1(next, rt.NilValue)
 	return next, nil
 }
 
+// Returns the thread passed as first argument of c if there is one, otherwise
+// t.  The offset of the next argument is also returned.
+func optThreadArg(t *rt.Thread, c *rt.GoCont) (*rt.Thread, int) {
+	if c.NArgs() > 0 {
+		if thread, ok := c.Arg(0).TryThread(); ok {
+			return thread, 1
+		}
+	}
+	return t, 0
+}
+
+// Returns the continuation at the level given by the n-th argument of c in the
+// call stack of thread.
+func levelArg(thread *rt.Thread, c *rt.GoCont, n int) (rt.Cont, error) {
+	level, err := c.IntArg(n)
+	if err != nil {
+		return nil, err
+	}
+	cont := thread.CurrentCont()
+	for ; level > 0 && cont != nil; level-- {
+		cont = cont.Parent()
+	}
+	if level < 0 || cont == nil {
+		return nil, errors.New("level out of range")
+	}
+	return cont, nil
+}
+
 func getupvalue(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if err := c.CheckNArgs(2); err != nil {
 		return nil, err
@@ -110,11 +264,19 @@ func getupvalue(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	up := int(upv) - 1
 	next := c.Next()
 	if up >= 0 && up < int(f.Code.UpvalueCount) {
-		t.Push(next, rt.StringValue(f.Code.UpNames[up]), f.GetUpvalue(up))
+		t.Push(next, rt.StringValue(upvalueName(f, up)), f.GetUpvalue(up))
 	}
 	return next, nil
 }
 
+// Upvalue names are empty in stripped code (see string.dump).
+func upvalueName(f *rt.Closure, up int) string {
+	if name := f.Code.UpNames[up]; name != "" {
+		return name
+	}
+	return "(no name)"
+}
+
 func setupvalue(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if err := c.CheckNArgs(3); err != nil {
 		return nil, err
@@ -130,7 +292,7 @@ func setupvalue(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	up := int(upv) - 1
 	next := c.Next()
 	if up >= 0 && up < int(f.Code.UpvalueCount) {
-		t.Push1(next, rt.StringValue(f.Code.UpNames[up]))
+		t.Push1(next, rt.StringValue(upvalueName(f, up)))
 		f.SetUpvalue(up, c.Arg(2))
 	}
 	return next, nil
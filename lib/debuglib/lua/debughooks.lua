@@ -21,6 +21,17 @@ do
     --> =nil		0
 end
 
+-- Coroutines inherit the hooks of the thread creating them
+do
+    debug.sethook(hook, "l")
+    local co = coroutine.create(function() end)
+    debug.sethook()
+    print(debug.gethook(co))
+    --> ~function.*\tl\t0
+    print(debug.gethook())
+    --> =nil		0
+end
+
 -- Errors
 do
     local co = coroutine.create(function() end)
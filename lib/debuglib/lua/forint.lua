@@ -0,0 +1,47 @@
+-- Loops whose start and step are integer literals are compiled to integer
+-- specialised opcodes.  Changing the loop variable with debug.setlocal must not
+-- break them.
+
+local function collect(...)
+    return table.concat({...}, " ")
+end
+
+do
+    local t = {}
+    for i = 1, 2 do
+        debug.setlocal(1, 3, i + 0.5)
+        t[#t + 1] = math.type(i * 2)
+    end
+    print(collect(table.unpack(t)))
+end
+--> =float float
+
+do
+    local t = {}
+    for i = 1, 1 do
+        debug.setlocal(1, 3, "2")
+        for j = i, 3 do
+            t[#t + 1] = math.type(j)
+        end
+    end
+    print(collect(table.unpack(t)))
+end
+--> =integer integer
+
+do
+    local t = {}
+    for i = 1, 2 do
+        debug.setlocal(1, 3, setmetatable({}, {__add = function() return "add" end}))
+        t[#t + 1] = i + 1
+    end
+    print(collect(table.unpack(t)))
+end
+--> =add add
+
+print(pcall(function()
+    for i = 1, 2 do
+        debug.setlocal(1, 1, "x")
+        return i - 1
+    end
+end))
+--> ~^false\t.*attempt to perform arithmetic on a string value
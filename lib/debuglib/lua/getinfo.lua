@@ -62,3 +62,128 @@ foo(co, 1.0)
 
 print(pcall(foo, co, 1.5))
 --> ~false\t.*
+
+-- The "what" argument selects fields
+
+local function keys(t)
+    local ks = {}
+    for k in pairs(t) do
+        ks[#ks+1] = k
+    end
+    table.sort(ks)
+    return table.concat(ks, " ")
+end
+
+print(keys(debug.getinfo(1, "S")))
+--> =lastlinedefined linedefined short_src source what
+
+print(keys(debug.getinfo(1, "l")))
+--> =currentline
+
+print(keys(debug.getinfo(1, "n")))
+--> =name namewhat
+
+print(keys(debug.getinfo(1, "u")))
+--> =isvararg nparams nups
+
+print(keys(debug.getinfo(1, "tf")))
+--> =func istailcall
+
+print(keys(debug.getinfo(1, "L")))
+--> =activelines
+
+print(keys(debug.getinfo(1)))
+--> =currentline ftransfer func istailcall isvararg lastlinedefined linedefined name namewhat nparams ntransfer nups short_src source what
+
+print(pcall(debug.getinfo, 1, "X"))
+--> ~false\t.*invalid option
+
+-- Function definition info
+
+local up = 1
+local function bar(x, y, ...)
+    return x + up
+end
+
+do
+    local i = debug.getinfo(bar, "Su")
+    print(i.what, i.linedefined, i.lastlinedefined, i.nups, i.nparams, i.isvararg)
+    --> =Lua	104	106	1	2	true
+end
+
+do
+    local i = debug.getinfo(print, "Su")
+    print(i.what, i.source, i.linedefined, i.isvararg)
+    --> =C	[Go]	-1	true
+end
+
+print(debug.getinfo(1, "S").what)
+--> =main
+
+print(debug.getinfo(bar, "f").func == bar)
+--> =true
+
+do
+    local lines = {}
+    for l in pairs(debug.getinfo(bar, "L").activelines) do
+        lines[#lines+1] = l
+    end
+    table.sort(lines)
+    print(table.concat(lines, " "))
+    --> =104 105
+end
+
+-- namewhat is derived from how the function is defined
+
+function gbar() end
+local lbar = function() end
+local t = {f = function() end}
+function t.g() end
+function t:m() end
+
+print(debug.getinfo(gbar, "n").namewhat)
+--> =global
+print(debug.getinfo(lbar, "n").namewhat)
+--> =local
+print(debug.getinfo(bar, "n").namewhat)
+--> =local
+print(debug.getinfo(t.f, "n").namewhat)
+--> =field
+print(debug.getinfo(t.g, "n").namewhat)
+--> =field
+print(debug.getinfo(t.m, "n").namewhat)
+--> =method
+
+local lassigned
+lassigned = function() end
+print(debug.getinfo(lassigned, "n").namewhat)
+--> =local
+
+local function setUpvalue()
+    lassigned = function() end
+end
+setUpvalue()
+print(debug.getinfo(lassigned, "n").namewhat)
+--> =upvalue
+
+gassigned = function() end
+print(debug.getinfo(gassigned, "n").namewhat)
+--> =global
+
+-- istailcall
+
+local function tail()
+    return debug.getinfo(1, "t").istailcall
+end
+
+local function callTail()
+    return tail()
+end
+
+local function callNoTail()
+    local res = tail()
+    return res
+end
+
+print(callTail(), callNoTail())
+--> =true	false
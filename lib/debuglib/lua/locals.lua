@@ -0,0 +1,162 @@
+local function perr(...)
+    local ok, err = pcall(...)
+    if not ok then
+        print(err)
+    end
+end
+
+-- getlocal tests
+do
+    local function f(a, b)
+        local c = a + b
+        do
+            local d = "inner"
+        end
+        local i = 1
+        while true do
+            local name, val = debug.getlocal(1, i)
+            if not name then break end
+            print(name, val)
+            i = i + 1
+        end
+    end
+
+    f(1, 2)
+    --> =a	1
+    --> =b	2
+    --> =c	3
+    --> =i	4
+
+    -- Locals of the calling function
+    local function getlocal2()
+        local name, val = debug.getlocal(2, 2)
+        return name, val
+    end
+    local function g()
+        local x, y = "x", "y"
+        local name, val = getlocal2()
+        return name, val
+    end
+    print(g())
+    --> =y	y
+
+    -- Varargs have negative indices
+    local function h(...)
+        return debug.getlocal(1, -1), debug.getlocal(1, -2), debug.getlocal(1, -3)
+    end
+    print(h(10, 20))
+    --> =(vararg)	(vararg)	nil
+
+    local function h2(...)
+        local name, val = debug.getlocal(1, -2)
+        return val
+    end
+    print(h2(10, 20))
+    --> =20
+
+    -- Internal variables of generic for loops
+    for k in pairs({1}) do
+        local names = {}
+        for i = 1, 20 do
+            local name = debug.getlocal(1, i)
+            if not name then break end
+            names[i] = name
+        end
+        print(table.concat(names, " "))
+        --> =perr f getlocal2 g h h2 (for state) (for state) (for state) k names i
+    end
+
+    -- With a function, only parameter names are returned
+    print(debug.getlocal(f, 1), debug.getlocal(f, 2), debug.getlocal(f, 3))
+    --> =a	b	nil
+
+    print(debug.getlocal(print, 1))
+    --> =nil
+
+    -- Debug info survives string.dump
+    local ff = load(string.dump(f))
+    print(debug.getlocal(ff, 2))
+    --> =b
+
+    -- Coroutines
+    local co = coroutine.create(function(p)
+        local q = p * 2
+        coroutine.yield()
+    end)
+    coroutine.resume(co, 5)
+    print(debug.getlocal(co, 1, 1))
+    --> =p	5
+    print(debug.getlocal(co, 1, 2))
+    --> =q	10
+
+    perr(debug.getlocal)
+    --> ~.*: 2 arguments needed
+
+    perr(debug.getlocal, 1)
+    --> ~.*: 2 arguments needed
+
+    perr(debug.getlocal, 100, 1)
+    --> ~.*: level out of range
+
+    perr(debug.getlocal, 1, "x")
+    --> ~.*: #2 must be an integer
+end
+
+-- setlocal tests
+do
+    local function f(a)
+        local b = 1
+        print(debug.setlocal(1, 2, 42))
+        print(debug.setlocal(1, 3, 42))
+        return a, b
+    end
+    print(f("a"))
+    --> =b
+    --> =nil
+    --> =a	42
+
+    local function g(...)
+        print(debug.setlocal(1, -1, "changed"))
+        print(debug.setlocal(1, -3, "changed"))
+        return ...
+    end
+    print(g(1, 2))
+    --> =(vararg)
+    --> =nil
+    --> =changed	2
+
+    -- Local variables captured by closures
+    local function h()
+        local x = 1
+        local function get() return x end
+        debug.setlocal(1, 1, 2)
+        return get()
+    end
+    print(h())
+    --> =2
+
+    perr(debug.setlocal, 1, 1)
+    --> ~.*: 3 arguments needed
+
+    perr(debug.setlocal, 100, 1, 1)
+    --> ~.*: level out of range
+end
+
+-- Local variables are visible from line hooks
+do
+    local function f(n)
+        local m = n + 1
+        return m
+    end
+    local seen = {}
+    debug.sethook(function()
+        local name, val = debug.getlocal(2, 2)
+        if name == "m" then
+            seen[#seen+1] = name .. "=" .. tostring(val)
+        end
+    end, "l")
+    f(3)
+    debug.sethook()
+    print(seen[1])
+    --> =m=4
+end
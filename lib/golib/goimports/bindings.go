@@ -0,0 +1,210 @@
+package goimports
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// The exported functions and types of a Go package.
+type libModel struct {
+	Package     string // Import path
+	PackageName string
+	Alias       string // Name the package is imported as
+	Funcs       []string
+	Types       []string
+
+	dir string
+}
+
+func newLibModel(pkg string) (*libModel, error) {
+	pkgDir, pkgName, err := getPackagePath(pkg)
+	if err != nil {
+		return nil, err
+	}
+	files, err := parsePackage(&build.Default, pkgDir)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing package: %s", err)
+	}
+	model := &libModel{
+		Package:     pkg,
+		PackageName: pkgName,
+		Alias:       pkgName,
+		dir:         pkgDir,
+	}
+	fillModel(model, files)
+	return model, nil
+}
+
+// Parse the Go files in dir which are part of the package when building with
+// ctx, so that exports which only exist for other platforms or behind build
+// tags are left out.  build.Default targets $GOOS and $GOARCH.
+func parsePackage(ctx *build.Context, dir string) (map[string]*ast.File, error) {
+	bpkg, err := ctx.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	files := make(map[string]*ast.File)
+	for _, names := range [][]string{bpkg.GoFiles, bpkg.CgoFiles} {
+		for _, name := range names {
+			fName := filepath.Join(dir, name)
+			f, err := parser.ParseFile(fset, fName, nil, 0)
+			if err != nil {
+				return nil, err
+			}
+			files[fName] = f
+		}
+	}
+	return files, nil
+}
+
+// NeedsAlias returns true if the package must be imported with an explicit
+// name for the generated code to refer to it by its alias.
+func (m *libModel) NeedsAlias() bool {
+	return m.Alias != path.Base(m.Package)
+}
+
+func getPackagePath(pkg string) (string, string, error) {
+	res, err := exec.Command("go", "list", "-f", "{{.Dir}} {{.Name}}", pkg).Output()
+	if err != nil {
+		return "", "", err
+	}
+	bits := strings.Split(strings.TrimSpace(string(res)), " ")
+	return bits[0], bits[1], nil
+}
+
+func fillModel(model *libModel, files map[string]*ast.File) {
+	fset := token.NewFileSet()
+	pkg, _ := ast.NewPackage(fset, files, nil, nil)
+	for _, obj := range pkg.Scope.Objects {
+		switch obj.Kind {
+		case ast.Fun:
+			fdecl := obj.Decl.(*ast.FuncDecl)
+			// Generic functions cannot be used without instantiating them.
+			if !fdecl.Name.IsExported() || fdecl.Type.TypeParams != nil {
+				continue
+			}
+			name := fdecl.Name.String()
+			model.Funcs = append(model.Funcs, name)
+		case ast.Var:
+			// fmt.Printf("Var: %s %+v\n", obj.Name, obj.Decl)
+		// case ast.Con:
+		case ast.Typ:
+			tdecl := obj.Decl.(*ast.TypeSpec)
+			if !tdecl.Name.IsExported() || tdecl.TypeParams != nil {
+				continue
+			}
+			model.Types = append(model.Types, tdecl.Name.String())
+		default:
+		}
+	}
+	// The scope is a map, so sort names to get a stable output.
+	sort.Strings(model.Funcs)
+	sort.Strings(model.Types)
+}
+
+// WriteBindings writes the source code of a Go file in package pkgName which
+// registers the exports of the Go packages with the given import paths (see
+// Register), so that the golib.import Lua function can import them without
+// building plugins.  Like LoadGoPackage, it requires a Go toolchain, but it is
+// meant to be run with go generate, e.g. via the golua-bindgen command.
+func WriteBindings(out io.Writer, pkgName string, pkgs []string) error {
+	model := bindingsModel{PackageName: pkgName}
+	aliases := map[string]bool{"goimports": true}
+	for _, pkg := range pkgs {
+		lib, err := newLibModel(pkg)
+		if err != nil {
+			return fmt.Errorf("cannot load package %s: %s", pkg, err)
+		}
+		for i := 2; aliases[lib.Alias]; i++ {
+			lib.Alias = lib.PackageName + strconv.Itoa(i)
+		}
+		aliases[lib.Alias] = true
+		model.Libs = append(model.Libs, lib)
+	}
+	return executeTemplate(out, bindingsTemplate, model)
+}
+
+type bindingsModel struct {
+	PackageName string
+	Libs        []*libModel
+}
+
+// Execute the template and format the result as Go source code.
+func executeTemplate(out io.Writer, tpl *template.Template, model interface{}) error {
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, model); err != nil {
+		return err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(src)
+	return err
+}
+
+var (
+	pluginTemplate   = getTemplate(pluginTemplateStr)
+	bindingsTemplate = getTemplate(bindingsTemplateStr)
+)
+
+func getTemplate(s string) *template.Template {
+	tpl := template.Must(template.New("exports").Parse(exportsTemplateStr))
+	return template.Must(tpl.New("lib").Parse(s))
+}
+
+// The value of the Exports map for a package.
+const exportsTemplateStr = `{{ $pkgName := .Alias -}}
+map[string]interface{}{
+
+	// Functions
+{{- range .Funcs }}
+	"{{ . }}": {{ $pkgName }}.{{ . }},
+{{- end }}
+
+	// Types
+{{- range .Types }}
+	"{{ . }}": func(x {{ $pkgName }}.{{ . }}) {{ $pkgName }}.{{ . }} { return x },
+	"new{{ . }}": func() *{{ $pkgName }}.{{ . }} { return new({{ $pkgName }}.{{ . }}) },
+{{- end }}
+}`
+
+const pluginTemplateStr = `
+package main
+
+import "{{ .Package }}"
+
+var Exports = {{ template "exports" . }}
+`
+
+const bindingsTemplateStr = `// Code generated by golua-bindgen; DO NOT EDIT.
+
+package {{ .PackageName }}
+
+import (
+	"github.com/arnodel/golua/lib/golib/goimports"
+{{ range .Libs }}
+	{{ if .NeedsAlias }}{{ .Alias }} {{ end }}"{{ .Package }}"
+{{- end }}
+)
+
+func init() {
+{{- range .Libs }}
+	goimports.Register("{{ .Package }}", {{ template "exports" . }})
+{{- end }}
+}
+`
@@ -0,0 +1,68 @@
+package goimports
+
+import (
+	"bytes"
+	"go/build"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteBindings(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteBindings(&buf, "bindings", []string{"math/rand", "crypto/rand"}); err != nil {
+		t.Fatal(err)
+	}
+	src := buf.String()
+	for _, s := range []string{
+		"// Code generated by golua-bindgen; DO NOT EDIT.\n",
+		"package bindings\n",
+		"\t\"math/rand\"\n",
+		"\trand2 \"crypto/rand\"\n",
+		"goimports.Register(\"math/rand\", map[string]interface{}{",
+		"\"Intn\": ",
+		"rand.Intn,",
+		"\"newRand\": ",
+		"goimports.Register(\"crypto/rand\", map[string]interface{}{",
+		"rand2.Read,",
+	} {
+		if !strings.Contains(src, s) {
+			t.Errorf("generated code does not contain %q:\n%s", s, src)
+		}
+	}
+}
+
+func TestParsePackageBuildConstraints(t *testing.T) {
+	for _, goos := range []string{"linux", "windows", "darwin"} {
+		ctx := build.Default
+		ctx.GOOS = goos
+		files, err := parsePackage(&ctx, "testdata/tagged")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var model libModel
+		fillModel(&model, files)
+		want := []string{"Common"}
+		switch goos {
+		case "linux":
+			want = append(want, "OnlyLinux")
+		case "windows":
+			want = append(want, "OnlyWindows")
+		}
+		if !reflect.DeepEqual(model.Funcs, want) {
+			t.Errorf("GOOS=%s: got %v, want %v", goos, model.Funcs, want)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	if _, ok := Lookup("example.com/nothere"); ok {
+		t.Error("unexpected package")
+	}
+	exports := map[string]interface{}{"Answer": func() int { return 42 }}
+	Register("example.com/answer", exports)
+	got, ok := Lookup("example.com/answer")
+	if !ok || got["Answer"] == nil {
+		t.Errorf("package not registered")
+	}
+}
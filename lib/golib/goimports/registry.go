@@ -0,0 +1,28 @@
+package goimports
+
+import "sync"
+
+// Exports of Go packages compiled into the program (see Register).
+var (
+	registryMux sync.RWMutex
+	registry    = map[string]map[string]interface{}{}
+)
+
+// Register makes the exports of a Go package available to golib.import under
+// its import path, without building a plugin.  The exports map has the same
+// format as the one returned by LoadGoPackage.  It is meant to be called from
+// the init function of code generated by WriteBindings (see the golua-bindgen
+// command), but exports can also be written by hand.
+func Register(pkg string, exports map[string]interface{}) {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	registry[pkg] = exports
+}
+
+// Lookup returns the exports registered for a Go package, if any.
+func Lookup(pkg string) (map[string]interface{}, bool) {
+	registryMux.RLock()
+	defer registryMux.RUnlock()
+	exports, ok := registry[pkg]
+	return exports, ok
+}
@@ -0,0 +1,3 @@
+package tagged
+
+func Common() {}
@@ -23,13 +23,11 @@ type govalueKeyType struct{}
 var govalueKey = rt.AsValue(govalueKeyType{})
 
 func load(r *rt.Runtime) (rt.Value, func()) {
-	pkg := rt.NewTable()
+	pkg := r.NewTable()
 
-	if goimports.Supported {
-		r.SetEnvGoFunc(pkg, "import", goimport, 1, false)
-	}
+	r.SetEnvGoFunc(pkg, "import", goimport, 1, false)
 
-	meta := rt.NewTable()
+	meta := r.NewTable()
 	r.SetEnvGoFunc(meta, "__index", goValueIndex, 2, false)
 	r.SetEnvGoFunc(meta, "__newindex", goValueSetIndex, 3, false)
 	r.SetEnvGoFunc(meta, "__call", goValueCall, 1, true)
@@ -105,10 +103,9 @@ func goValueCall(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	return c.PushingNext(t.Runtime, res...), nil
 }
 
+// Packages registered with goimports.Register are imported first, then
+// packages are loaded as plugins if supported.
 func goimport(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
-	if pluginsRoot == "" {
-		return nil, rt.NewError(rt.StringValue("cannot import go packages: plugins root not set"))
-	}
 	if err := c.Check1Arg(); err != nil {
 		return nil, err
 	}
@@ -116,6 +113,15 @@ func goimport(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if err != nil {
 		return nil, err
 	}
+	if exports, ok := goimports.Lookup(path); ok {
+		return c.PushingNext1(t.Runtime, NewGoValue(t.Runtime, exports)), nil
+	}
+	if !goimports.Supported {
+		return nil, fmt.Errorf("cannot import go package %s: not registered and plugins not supported", path)
+	}
+	if pluginsRoot == "" {
+		return nil, rt.NewError(rt.StringValue("cannot import go packages: plugins root not set"))
+	}
 	forceBuild := c.NArgs() >= 2 && rt.Truth(c.Arg(1))
 	exports, loadErr := goimports.LoadGoPackage(string(path), pluginsRoot, forceBuild)
 	if loadErr != nil {
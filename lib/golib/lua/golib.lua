@@ -66,8 +66,8 @@ print(tostring(polly))
 
 do
     go = require("golib")
-    if go.import then
-        fmt = go.import("fmt")
+    local ok, fmt = pcall(go.import, "fmt")
+    if ok then
         sprintf = fmt.Sprintf
         print(sprintf("-%s-", "hello"))
     else
@@ -76,6 +76,17 @@ do
 end
 --> =-hello-
 
+-- strconv is registered by the generated strconv_bindings_test.go, so it does
+-- not need to be built as a plugin.
+do
+    local strconv = go.import("strconv")
+    print(strconv.Itoa(42) .. "!", strconv.Quote("a\tb"))
+    --> =42!	"a\tb"
+
+    print(strconv.Atoi("12"))
+    --> =12	nil
+end
+
 print(pcall(ben))
 --> ~false\t.*not a function
 
@@ -1,5 +1,7 @@
 package golib_test
 
+//go:generate go run ../../cmd/golua-bindgen -o strconv_bindings_test.go strconv
+
 import (
 	"fmt"
 	"testing"
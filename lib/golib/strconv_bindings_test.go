@@ -0,0 +1,54 @@
+// Code generated by golua-bindgen; DO NOT EDIT.
+
+package golib_test
+
+import (
+	"github.com/arnodel/golua/lib/golib/goimports"
+
+	"strconv"
+)
+
+func init() {
+	goimports.Register("strconv", map[string]interface{}{
+
+		// Functions
+		"AppendBool":               strconv.AppendBool,
+		"AppendFloat":              strconv.AppendFloat,
+		"AppendInt":                strconv.AppendInt,
+		"AppendQuote":              strconv.AppendQuote,
+		"AppendQuoteRune":          strconv.AppendQuoteRune,
+		"AppendQuoteRuneToASCII":   strconv.AppendQuoteRuneToASCII,
+		"AppendQuoteRuneToGraphic": strconv.AppendQuoteRuneToGraphic,
+		"AppendQuoteToASCII":       strconv.AppendQuoteToASCII,
+		"AppendQuoteToGraphic":     strconv.AppendQuoteToGraphic,
+		"AppendUint":               strconv.AppendUint,
+		"Atoi":                     strconv.Atoi,
+		"CanBackquote":             strconv.CanBackquote,
+		"FormatBool":               strconv.FormatBool,
+		"FormatComplex":            strconv.FormatComplex,
+		"FormatFloat":              strconv.FormatFloat,
+		"FormatInt":                strconv.FormatInt,
+		"FormatUint":               strconv.FormatUint,
+		"IsGraphic":                strconv.IsGraphic,
+		"IsPrint":                  strconv.IsPrint,
+		"Itoa":                     strconv.Itoa,
+		"ParseBool":                strconv.ParseBool,
+		"ParseComplex":             strconv.ParseComplex,
+		"ParseFloat":               strconv.ParseFloat,
+		"ParseInt":                 strconv.ParseInt,
+		"ParseUint":                strconv.ParseUint,
+		"Quote":                    strconv.Quote,
+		"QuoteRune":                strconv.QuoteRune,
+		"QuoteRuneToASCII":         strconv.QuoteRuneToASCII,
+		"QuoteRuneToGraphic":       strconv.QuoteRuneToGraphic,
+		"QuoteToASCII":             strconv.QuoteToASCII,
+		"QuoteToGraphic":           strconv.QuoteToGraphic,
+		"QuotedPrefix":             strconv.QuotedPrefix,
+		"Unquote":                  strconv.Unquote,
+		"UnquoteChar":              strconv.UnquoteChar,
+
+		// Types
+		"NumError":    func(x strconv.NumError) strconv.NumError { return x },
+		"newNumError": func() *strconv.NumError { return new(strconv.NumError) },
+	})
+}
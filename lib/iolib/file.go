@@ -8,6 +8,7 @@ import (
 	"io/fs"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"runtime"
 	"strings"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/arnodel/golua/safeio"
 	"github.com/arnodel/golua/scanner"
 	"github.com/arnodel/golua/token"
+	"github.com/arnodel/golua/vfs"
 )
 
 const (
@@ -31,12 +33,15 @@ var (
 	errInvalidBufferSize = errors.New("invalid buffer size")
 )
 
-// A File wraps an os.File for manipulation by iolib.
+// A File wraps a vfs.File for manipulation by iolib.
 type File struct {
-	file   *os.File
+	file   vfs.File
+	fsys   vfs.FS // The file system of a temporary file (to remove it)
 	status fileStatus
 	reader bufReader
 	writer bufWriter
+	cmd    *exec.Cmd // The process the file is connected to (io.popen)
+	std    string    // The name of the standard stream the file is for, if any
 }
 
 type fileStatus int
@@ -47,8 +52,8 @@ const (
 	statusNotClosable
 )
 
-// NewFile returns a new *File from an *os.File.
-func NewFile(file *os.File, options int) *File {
+// NewFile returns a new *File from a vfs.File (e.g. an *os.File).
+func NewFile(file vfs.File, options int) *File {
 	f := &File{file: file}
 	// TODO: find out if there is mileage in having unbuffered readers.
 	if true || options&bufferedRead != 0 {
@@ -71,6 +76,58 @@ func NewFile(file *os.File, options int) *File {
 	return f
 }
 
+// Returns a vfs.File for a standard stream, which is either a file (e.g.
+// os.Stdout) or a plain reader or writer.
+func streamFile(name string, stream interface{}) vfs.File {
+	if f, ok := stream.(vfs.File); ok {
+		return f
+	}
+	s := &stdStream{name: name}
+	s.r, _ = stream.(io.Reader)
+	s.w, _ = stream.(io.Writer)
+	return s
+}
+
+// A stdStream turns a reader or a writer into a vfs.File which cannot be
+// seeked, like a pipe.
+type stdStream struct {
+	name string
+	r    io.Reader
+	w    io.Writer
+}
+
+var errBadStream = errors.New("bad file descriptor")
+
+func (s *stdStream) Read(p []byte) (int, error) {
+	if s.r == nil {
+		return 0, &fs.PathError{Op: "read", Path: s.name, Err: errBadStream}
+	}
+	return s.r.Read(p)
+}
+
+func (s *stdStream) Write(p []byte) (int, error) {
+	if s.w == nil {
+		return 0, &fs.PathError{Op: "write", Path: s.name, Err: errBadStream}
+	}
+	return s.w.Write(p)
+}
+
+func (s *stdStream) Seek(offset int64, whence int) (int64, error) {
+	return 0, &fs.PathError{Op: "seek", Path: s.name, Err: errors.New("illegal seek")}
+}
+
+func (s *stdStream) Close() error {
+	return nil
+}
+
+func (s *stdStream) Name() string {
+	return s.name
+}
+
+func (s *stdStream) Sync() error {
+	return nil
+}
+
 // OpenFile opens a file with the given name in the given lua mode.
 func OpenFile(r *rt.Runtime, name, mode string) (*File, error) {
 	var flag, options int
@@ -103,6 +160,48 @@ func OpenFile(r *rt.Runtime, name, mode string) (*File, error) {
 	return NewFile(f, options), nil
 }
 
+// OpenProcess starts a process running cmdline in the system shell and returns
+// a file connected to its standard output (mode "r") or standard input (mode
+// "w").  Closing the file waits for the process to exit.  If the file is never
+// closed, the process is waited for when the file is garbage collected so that
+// it does not become a zombie.
+func OpenProcess(r *rt.Runtime, cmdline, mode string) (*File, error) {
+	var options int
+	switch mode {
+	case "r":
+		options = bufferedRead
+	case "w":
+		options = bufferedWrite
+	default:
+		return nil, errors.New("invalid mode")
+	}
+	cmd := safeio.ShellCommand(cmdline)
+	stdio := r.Stdio()
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdio.Stdin, stdio.Stdout, stdio.Stderr
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	// f is the end of the pipe used by Lua, the other end is given to the
+	// process.
+	f, other := pr, pw
+	if mode == "r" {
+		cmd.Stdout = pw
+	} else {
+		cmd.Stdin = pr
+		f, other = pw, pr
+	}
+	err = safeio.StartCommand(r, cmd)
+	other.Close()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	ff := NewFile(f, options)
+	ff.cmd = cmd
+	return ff, nil
+}
+
 // TempFile tries to make a temporary file, and if successful schedules the file
 // to be removed when the process dies.
 func TempFile(r *rt.Runtime) (*File, error) {
@@ -111,6 +210,7 @@ func TempFile(r *rt.Runtime) (*File, error) {
 		return nil, err
 	}
 	ff := NewFile(f, bufferedRead|bufferedWrite|tempFile)
+	ff.fsys = r.FS()
 	return ff, nil
 }
 
@@ -132,6 +232,23 @@ func ValueToFile(v rt.Value) (*File, bool) {
 	return nil, false
 }
 
+// Clone implements rt.Cloneable.  The files for the standard streams are
+// replaced with new files for the standard streams of the runtime they are
+// copied to, so that runtimes created from a snapshot do not share buffers.
+// They are not buffered as nothing flushes them when the runtime is discarded.
+// Other files are shared.
+func (f *File) Clone(c *rt.Cloner) interface{} {
+	if f.std == "" {
+		return f
+	}
+	r := c.Runtime()
+	cf := newStdFile(r, f.std, false)
+	if f.std == "stdout" && r.Stdout == nil {
+		r.Stdout = cf.writer
+	}
+	return cf
+}
+
 // IsClosed returns true if the file is closed.
 func (f *File) IsClosed() bool {
 	return f.status&statusClosed != 0
@@ -163,12 +280,31 @@ func (f *File) Close() error {
 	f.status |= statusClosed
 	errFlush := f.writer.Flush()
 	err := f.file.Close()
+	if f.cmd != nil {
+		// The process exiting with an error is not an error closing the file.
+		// It is reported by ProcessState().
+		if errWait := f.cmd.Wait(); err == nil {
+			if _, ok := errWait.(*exec.ExitError); !ok {
+				err = errWait
+			}
+		}
+	}
 	if err == nil {
 		return errFlush
 	}
 	return err
 }
 
+// ProcessState returns the state of the process the file is connected to, if
+// it was opened with OpenProcess and has been closed.  Otherwise it returns
+// nil.
+func (f *File) ProcessState() *os.ProcessState {
+	if f.cmd == nil {
+		return nil
+	}
+	return f.cmd.ProcessState
+}
+
 // Flush attempts to sync the file, returns an error if a problem occurs.
 func (f *File) Flush() error {
 	if err := f.writer.Flush(); err != nil {
@@ -320,10 +456,16 @@ func (f *File) Name() string {
 
 // Best effort to flush and close files when they are no longer accessible.
 func (f *File) cleanup() {
+	if f.cmd != nil && !f.IsClosed() {
+		// Closing waits for the process to exit, which could block the
+		// goroutine running finalizers for a long time.
+		go f.Close()
+		return
+	}
 	if !f.IsClosed() {
 		f.Close()
 	}
-	if f.IsTemp() {
-		_ = os.Remove(f.Name())
+	if f.IsTemp() && f.fsys != nil {
+		_ = f.fsys.Remove(f.Name())
 	}
 }
@@ -1,6 +1,7 @@
 package iolib
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"io"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/arnodel/golua/lib/packagelib"
 	rt "github.com/arnodel/golua/runtime"
+	"github.com/arnodel/golua/safeio"
 )
 
 // BufferedStdFiles sets wether std files should be buffered
@@ -24,9 +26,9 @@ var LibLoader = packagelib.Loader{
 }
 
 func load(r *rt.Runtime) (rt.Value, func()) {
-	methods := rt.NewTable()
+	methods := r.NewTable()
 
-	meta := rt.NewTable()
+	meta := r.NewTable()
 	r.SetEnv(meta, "__name", rt.StringValue("file"))
 	r.SetEnv(meta, "__index", rt.TableValue(methods))
 
@@ -51,19 +53,7 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 		r.SetEnvGoFunc(meta, "__tostring", tostring, 1, false),
 	)
 
-	var (
-		stdoutOpts = statusNotClosable
-		stderrOpts = statusNotClosable
-		stdinOpts  = statusNotClosable
-	)
-	if BufferedStdFiles {
-		stdoutOpts |= bufferedWrite
-		stdinOpts |= bufferedRead
-	}
-
-	stdinFile := NewFile(os.Stdin, stdinOpts)
-	stdoutFile := NewFile(os.Stdout, stdoutOpts)
-	stderrFile := NewFile(os.Stderr, stderrOpts)
+	stdinFile, stdoutFile, stderrFile := newStdFiles(r)
 	// This is not a good pattern - it has to do for now.
 	if r.Stdout == nil {
 		r.Stdout = stdoutFile.writer
@@ -77,7 +67,7 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 		defaultInput:  stdin,
 		metatable:     meta,
 	}))
-	pkg := rt.NewTable()
+	pkg := r.NewTable()
 	r.SetEnv(pkg, "stdin", rt.UserDataValue(stdin))
 	r.SetEnv(pkg, "stdout", rt.UserDataValue(stdout))
 	r.SetEnv(pkg, "stderr", rt.UserDataValue(stderr))
@@ -91,7 +81,7 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 		r.SetEnvGoFunc(pkg, "lines", iolines, 1, true),
 		r.SetEnvGoFunc(pkg, "open", open, 2, false),
 		r.SetEnvGoFunc(pkg, "output", output, 1, false),
-		// TODO: popen,
+		r.SetEnvGoFunc(pkg, "popen", popen, 2, false),
 		r.SetEnvGoFunc(pkg, "read", ioread, 0, true),
 		r.SetEnvGoFunc(pkg, "tmpfile", tmpfile, 0, false),
 		r.SetEnvGoFunc(pkg, "write", iowrite, 0, true),
@@ -113,12 +103,60 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 	return rt.TableValue(pkg), cleanup
 }
 
+// Make the files for the standard streams of r (see rt.WithStdio).  Streams
+// which are not files are not buffered, that is left to their implementation.
+func newStdFiles(r *rt.Runtime) (stdin, stdout, stderr *File) {
+	stdin = newStdFile(r, "stdin", BufferedStdFiles)
+	stdout = newStdFile(r, "stdout", BufferedStdFiles)
+	stderr = newStdFile(r, "stderr", false)
+	return
+}
+
+// Make the file for the standard stream of r with the given name.
+func newStdFile(r *rt.Runtime, name string, buffered bool) *File {
+	var (
+		opts  = statusNotClosable
+		stdio = r.Stdio()
+		f     *File
+	)
+	switch name {
+	case "stdin":
+		if buffered {
+			opts |= bufferedRead
+		}
+		f = NewFile(streamFile(name, stdio.Stdin), opts)
+		if r.IsDeterministic() {
+			// Reads from stdin are host inputs which may be recorded or replayed.
+			f.reader = bufio.NewReader(r.HostReader("io.stdin", stdio.Stdin))
+		}
+	case "stdout":
+		if _, ok := stdio.Stdout.(*os.File); ok && buffered {
+			opts |= bufferedWrite
+		}
+		f = NewFile(streamFile(name, stdio.Stdout), opts)
+	default:
+		f = NewFile(streamFile(name, stdio.Stderr), opts)
+	}
+	f.std = name
+	return f
+}
+
 type ioData struct {
 	defaultOutput *rt.UserData
 	defaultInput  *rt.UserData
 	metatable     *rt.Table
 }
 
+// Clone implements rt.Cloneable so that copies of a runtime have their own
+// default input and output.
+func (d *ioData) Clone(c *rt.Cloner) interface{} {
+	return &ioData{
+		defaultOutput: c.UserData(d.defaultOutput),
+		defaultInput:  c.UserData(d.defaultInput),
+		metatable:     c.Table(d.metatable),
+	}
+}
+
 func getIoData(r *rt.Runtime) *ioData {
 	return r.Registry(ioKey).Interface().(*ioData)
 }
@@ -151,7 +189,12 @@ func ioclose(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 			return nil, err
 		}
 	}
-	return pushingNextIoResult(t.Runtime, c, f.Close())
+	closeErr := f.Close()
+	if ps := f.ProcessState(); ps != nil && closeErr == nil {
+		// The file was opened with io.popen
+		return c.PushingNext(t.Runtime, safeio.ExitStatus(ps)...), nil
+	}
+	return pushingNextIoResult(t.Runtime, c, closeErr)
 }
 
 func fileclose(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
@@ -359,6 +402,29 @@ func open(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	return c.PushingNext(t.Runtime, rt.UserDataValue(u)), nil
 }
 
+func popen(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.Check1Arg(); err != nil {
+		return nil, err
+	}
+	cmdline, err := c.StringArg(0)
+	if err != nil {
+		return nil, err
+	}
+	mode := "r"
+	if c.NArgs() >= 2 {
+		mode, err = c.StringArg(1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	f, err := OpenProcess(t.Runtime, cmdline, mode)
+	if err != nil {
+		return nil, err
+	}
+	u := newFileUserData(f, getIoData(t.Runtime).metatable)
+	return c.PushingNext(t.Runtime, rt.UserDataValue(u)), nil
+}
+
 func typef(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if err := c.Check1Arg(); err != nil {
 		return nil, err
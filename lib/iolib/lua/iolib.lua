@@ -327,4 +327,39 @@ do
 
     print(pcall(close, {}))
     --> ~false\t.*#1 must be a file
-end
\ No newline at end of file
+end
+-- io.popen
+
+do
+    local f = io.popen("echo hello")
+    print(io.type(f))
+    --> =file
+    print(f:read("l"))
+    --> =hello
+    print(f:close())
+    --> =true	exit	0
+
+    f = io.popen("echo foo; echo bar", "r")
+    for line in f:lines() do
+        print(line)
+    end
+    --> =foo
+    --> =bar
+    f:close()
+
+    print(io.popen("exit 3"):close())
+    --> =false	exit	3
+
+    f = io.popen("cat > files/popentest.txt", "w")
+    f:write("written by popen")
+    print(f:close())
+    --> =true	exit	0
+    print(io.open("files/popentest.txt"):read("a"))
+    --> =written by popen
+
+    print(pcall(io.popen))
+    --> ~false\t.*value needed
+
+    print(pcall(io.popen, "ls", "rw"))
+    --> ~false\t.*invalid mode
+end
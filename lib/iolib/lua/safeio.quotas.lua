@@ -19,6 +19,14 @@ runtime.callcontext({flags="iosafe"}, function()
     print(pcall(io.tmpfile))
     --> ~false\t.*: safeio: operation not allowed
 
+    print(pcall(io.popen, "echo hello"))
+    --> ~false\t.*: safeio: operation not allowed
+
+    -- os.execute cannot start processes either
+
+    print(pcall(os.execute, "echo hello"))
+    --> ~false\t.*: safeio: operation not allowed
+
 end)
 
 -- But functions operating on open files still work
@@ -0,0 +1,110 @@
+package iolib_test
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+func TestStdio(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test uses a POSIX shell")
+	}
+	var stdout, stderr bytes.Buffer
+	r := rt.New(nil, rt.WithStdio(rt.Stdio{
+		Stdin:  strings.NewReader("hello\nworld\n"),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}))
+	defer lib.LoadAll(r)()
+
+	const src = `
+io.write(io.read(), "\n")
+io.stderr:write("error\n")
+print(io.stdout:seek())
+os.execute("echo execute")
+local f = io.popen("echo popen")
+io.write(f:read(), io.read(), "\n")
+f:close()
+os.execute("echo stderr >&2")
+`
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte(src), rt.TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Call(r.MainThread(), rt.FunctionValue(clos), nil, rt.NewTerminationWith(nil, 0, false)); err != nil {
+		t.Fatal(err)
+	}
+	const wantOut = "hello\nnil\tseek stdout: illegal seek\nexecute\npopenworld\n"
+	if got := stdout.String(); got != wantOut {
+		t.Errorf("got stdout %q, want %q", got, wantOut)
+	}
+	if got := stderr.String(); got != "error\nstderr\n" {
+		t.Errorf("got stderr %q", got)
+	}
+}
+
+// Runtimes created from a snapshot must have their own standard files, so
+// they can run concurrently (run with -race).
+func TestSnapshotStdio(t *testing.T) {
+	snapshot := newSnapshot(t)
+	const n = 8
+	var outs [n]bytes.Buffer
+	runConcurrently(t, n, func(i int) (*rt.Runtime, string) {
+		r := snapshot.NewRuntime(nil, rt.WithStdio(rt.Stdio{Stdout: &outs[i]}))
+		return r, fmt.Sprintf(`for i = 1, 100 do io.write(%d, "\n") end print("done")`, i)
+	})
+	for i := 0; i < n; i++ {
+		want := strings.Repeat(fmt.Sprintf("%d\n", i), 100) + "done\n"
+		if got := outs[i].String(); got != want {
+			t.Errorf("runtime %d: got stdout %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestRuntimePoolStdio(t *testing.T) {
+	pool := rt.NewRuntimePool(newSnapshot(t), rt.RuntimeContextDef{})
+	runConcurrently(t, 8, func(i int) (*rt.Runtime, string) {
+		return pool.Get(nil), `io.stdout:setvbuf("no") io.write("") io.stdout:setvbuf("full") io.flush()`
+	})
+}
+
+func newSnapshot(t *testing.T) *rt.Snapshot {
+	r := rt.New(nil)
+	lib.LoadAll(r)
+	snapshot, err := r.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snapshot
+}
+
+// Run n chunks concurrently, each in the runtime returned by start(i).
+func runConcurrently(t *testing.T, n int, start func(i int) (*rt.Runtime, string)) {
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		r, src := start(i)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clos, err := r.CompileAndLoadLuaChunk("test", []byte(src), rt.TableValue(r.GlobalEnv()))
+			if err == nil {
+				err = rt.Call(r.MainThread(), rt.FunctionValue(clos), nil, rt.NewTerminationWith(nil, 0, false))
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -17,7 +17,7 @@ var LibLoader = packagelib.Loader{
 }
 
 func load(r *rt.Runtime) (rt.Value, func()) {
-	pkg := rt.NewTable()
+	pkg := r.NewTable()
 	r.SetEnv(pkg, "huge", rt.FloatValue(math.Inf(1)))
 	r.SetEnv(pkg, "maxinteger", rt.IntValue(math.MaxInt64))
 	r.SetEnv(pkg, "mininteger", rt.IntValue(math.MinInt64))
@@ -307,21 +307,47 @@ func rad(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	return c.PushingNext1(t.Runtime, y), nil
 }
 
-// TODO: have a per runtime random generator
+// The pseudo-random generator used by math.random.
+type randGen interface {
+	Float64() float64
+	Uint64() uint64
+	Int63() int64
+	Int63n(n int64) int64
+	Seed(seed int64)
+}
+
+// The generator of the rand package, shared by all runtimes.
+type globalRand struct{}
+
+func (globalRand) Float64() float64     { return rand.Float64() }
+func (globalRand) Uint64() uint64       { return rand.Uint64() }
+func (globalRand) Int63() int64         { return rand.Int63() }
+func (globalRand) Int63n(n int64) int64 { return rand.Int63n(n) }
+func (globalRand) Seed(seed int64)      { rand.Seed(seed) }
+
+// Deterministic runtimes have their own generator.
+func getRand(t *rt.Thread) randGen {
+	if rnd := t.Rand(); rnd != nil {
+		return rnd
+	}
+	return globalRand{}
+}
+
 func random(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	var (
 		err error
 		m   int64 = 1
 		n   int64
+		rnd = getRand(t)
 	)
 	switch c.NArgs() {
 	case 0:
-		return c.PushingNext1(t.Runtime, rt.FloatValue(rand.Float64())), nil
+		return c.PushingNext1(t.Runtime, rt.FloatValue(rnd.Float64())), nil
 	case 1:
 		n, err = c.IntArg(0)
 		// Special case, new in Lua 5.4: math.random(0) returns a uniform integer.
 		if n == 0 {
-			return c.PushingNext1(t.Runtime, rt.IntValue(int64(rand.Uint64()))), nil
+			return c.PushingNext1(t.Runtime, rt.IntValue(int64(rnd.Uint64()))), nil
 		}
 	case 2:
 		m, err = c.IntArg(0)
@@ -339,15 +365,15 @@ func random(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if m <= 0 && m+math.MaxInt64 < n {
 		// There's >= 50% chance the loop stops at each iteration so we're OK!
 		for {
-			r = int64(rand.Uint64())
+			r = int64(rnd.Uint64())
 			if r >= m && r <= n {
 				break
 			}
 		}
 	} else if m+math.MaxInt64 == n {
-		r = rand.Int63()
+		r = rnd.Int63()
 	} else {
-		r = rand.Int63n(n - m + 1)
+		r = rnd.Int63n(n - m + 1)
 	}
 	return c.PushingNext1(t.Runtime, rt.IntValue(m+r)), nil
 }
@@ -359,6 +385,11 @@ func randomseed(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	)
 	switch c.NArgs() {
 	case 0:
+		if rnd := t.Rand(); rnd != nil {
+			// Deterministic runtimes make the seed from their generator.
+			seed = rnd.Int63()
+			break
+		}
 		// We need something as random as possible to make a seed.
 		readErr := binary.Read(crypto.Reader, binary.LittleEndian, &seed)
 		if readErr != nil {
@@ -381,7 +412,7 @@ func randomseed(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		// In Go the seed is only 64 bits so we mangle the seeds
 		seed ^= seed2
 	}
-	rand.Seed(seed)
+	getRand(t).Seed(seed)
 	return c.PushingNext(t.Runtime, rt.IntValue(seed), rt.IntValue(0)), nil
 }
 
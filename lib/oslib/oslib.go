@@ -3,8 +3,7 @@ package oslib
 import (
 	"fmt"
 	"os"
-	"runtime"
-	"syscall"
+	"os/exec"
 	"time"
 
 	"github.com/arnodel/golua/lib/packagelib"
@@ -20,7 +19,7 @@ var LibLoader = packagelib.Loader{
 }
 
 func load(r *rt.Runtime) (rt.Value, func()) {
-	pkg := rt.NewTable()
+	pkg := r.NewTable()
 
 	rt.SolemnlyDeclareCompliance(
 		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
@@ -43,6 +42,14 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 	return rt.TableValue(pkg), nil
 }
 
+func clock(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	secs := cpuTime()
+	if d, ok := t.ElapsedTime(); ok {
+		secs = d.Seconds()
+	}
+	return c.PushingNext1(t.Runtime, rt.FloatValue(secs)), nil
+}
+
 func date(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	var (
 		err    error
@@ -67,14 +74,14 @@ func date(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 
 	// Get the time value
 	if c.NArgs() > 1 {
-		var t int64
-		t, err = c.IntArg(1)
+		var secs int64
+		secs, err = c.IntArg(1)
 		if err != nil {
 			return nil, err
 		}
-		now = time.Unix(t, 0)
+		now = time.Unix(secs, 0).In(t.Location())
 	} else {
-		now = time.Now()
+		now = t.Now()
 	}
 	if utc {
 		now = now.UTC()
@@ -82,7 +89,7 @@ func date(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	switch format {
 	case "*t":
 		{
-			tbl := rt.NewTable()
+			tbl := t.NewTable()
 			setTableFields(t.Runtime, tbl, now)
 			date = rt.TableValue(tbl)
 		}
@@ -136,7 +143,7 @@ func exit(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 
 func timef(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if c.NArgs() == 0 {
-		now := time.Now().Unix()
+		now := t.Now().Unix()
 		return c.PushingNext1(t.Runtime, rt.IntValue(now)), nil
 	}
 	tbl, err := c.TableArg(0)
@@ -183,7 +190,7 @@ func timef(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	}
 	// TODO: deal with DST - I have no idea how to do that.
 
-	date := time.Date(year, time.Month(month), day, hour, min, sec, 0, time.Local)
+	date := time.Date(year, time.Month(month), day, hour, min, sec, 0, t.Location())
 	setTableFields(t.Runtime, tbl, date)
 	return c.PushingNext1(t.Runtime, rt.IntValue(date.Unix())), nil
 }
@@ -211,7 +218,12 @@ func getenv(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if err != nil {
 		return nil, err
 	}
-	val, ok := os.LookupEnv(name)
+	val, ok, err := t.HostInput("os.getenv("+name+")", func() (string, bool) {
+		return os.LookupEnv(name)
+	})
+	if err != nil {
+		return nil, err
+	}
 	valV := rt.NilValue
 	if ok {
 		t.RequireBytes(len(val))
@@ -294,52 +306,22 @@ func execute(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if err != nil {
 		return nil, err
 	}
-	// this is what gopher-lua does, so i just copied it
-	var procAttr os.ProcAttr
-	procAttr.Files = []*os.File{os.Stdin, os.Stdout, os.Stderr}
-	cmd, args := cmdArgs(cm)
-	args = append([]string{cmd}, args...)
-	process, err := os.StartProcess(cmd, args, &procAttr)
-	if err != nil {
+	cmd := safeio.ShellCommand(cm)
+	stdio := t.Stdio()
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdio.Stdin, stdio.Stdout, stdio.Stderr
+	if err := safeio.StartCommand(t.Runtime, cmd); err != nil {
 		return nil, err
 	}
-
-	ps, err := process.Wait()
-	if err != nil {
-		return nil, err
-	}
-
-	success := rt.BoolValue(true)
-	if !ps.Success() {
-		success = rt.BoolValue(false)
-	}
-
-	exit := rt.StringValue("exit")
-	code := rt.IntValue(int64(ps.ExitCode()))
-	if !ps.Exited() {
-		// terminated by signal
-		exit = rt.StringValue("signal")
-		if runtime.GOOS != "windows" {
-			// i am not sure how this is on windows...
-			ws := ps.Sys().(syscall.WaitStatus)
-			sig := ws.Signal()
-			code = rt.IntValue(int64(sig)) // syscall signal, which is an int
+	if err := cmd.Wait(); err != nil {
+		// The command failing is not an error, it is reported in the exit
+		// status.
+		if _, ok := err.(*exec.ExitError); !ok {
+			return nil, err
 		}
 	}
-
-	return c.PushingNext(t.Runtime, success, exit, code), nil
+	return c.PushingNext(t.Runtime, safeio.ExitStatus(cmd.ProcessState)...), nil
 }
 
-func cmdArgs(arg string) (string, []string) {
-	cmd := "/bin/sh"
-	args := []string{"-c"}
-	if runtime.GOOS == "windows" {
-		cmd = "C:\\Windows\\system32\\cmd.exe"
-		args = []string{"/c"}
-	}
-	args = append(args, arg)
-	return cmd, args
-}
 //
 // Utils
 //
@@ -10,7 +10,7 @@ import (
 var contextRegistryKey = rt.AsValue(contextRegistry{})
 
 func createContextMetatable(r *rt.Runtime) {
-	contextMeta := rt.NewTable()
+	contextMeta := r.NewTable()
 
 	rt.SolemnlyDeclareCompliance(
 		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
@@ -19,7 +19,7 @@ func createContextMetatable(r *rt.Runtime) {
 		r.SetEnvGoFunc(contextMeta, "__tostring", context__tostring, 1, false),
 	)
 
-	resourcesMeta := rt.NewTable()
+	resourcesMeta := r.NewTable()
 	rt.SolemnlyDeclareCompliance(
 		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
 
@@ -37,6 +37,14 @@ type contextRegistry struct {
 	resourcesMeta *rt.Table
 }
 
+// Clone implements rt.Cloneable.
+func (cr *contextRegistry) Clone(c *rt.Cloner) interface{} {
+	return &contextRegistry{
+		contextMeta:   c.Table(cr.contextMeta),
+		resourcesMeta: c.Table(cr.resourcesMeta),
+	}
+}
+
 func getRegistry(r *rt.Runtime) *contextRegistry {
 	return r.Registry(contextRegistryKey).Interface().(*contextRegistry)
 }
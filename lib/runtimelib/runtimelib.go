@@ -18,7 +18,7 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 	if !rt.QuotasAvailable {
 		return rt.NilValue, nil
 	}
-	pkg := rt.NewTable()
+	pkg := r.NewTable()
 
 	rt.SolemnlyDeclareCompliance(
 		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
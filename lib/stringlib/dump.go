@@ -18,15 +18,13 @@ func dump(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if c.NArgs() >= 2 {
 		strip = rt.Truth(c.Arg(1))
 	}
-	// TODO: support strip
-	_ = strip
 	var w bytes.Buffer
 	code := t.RefactorCodeConsts(cl.Code)
-	used, mErr := rt.MarshalConst(&w, rt.CodeValue(code), t.LinearUnused(10))
+	used, mErr := rt.MarshalConst(&w, rt.CodeValue(code), t.LinearUnused(10), strip)
 	// This will cause a panic if MarshalConst was interupted, so no need to
 	// worry about the rest of this codepath in this case.
 	t.LinearRequire(10, used)
-	if err != nil {
+	if mErr != nil {
 		return nil, mErr
 	}
 	return c.PushingNext1(t.Runtime, rt.StringValue(w.String())), nil
@@ -376,6 +376,35 @@ do
     --> =Working...
     --> =10
 
+    -- Stripped code has no debug information
+    local function h(x)
+        local y = x
+        error("oops")
+    end
+    print(#string.dump(h, true) < #string.dump(h))
+    --> =true
+
+    print(pcall(dl(h, true), 1))
+    --> =false	oops
+
+    print(debug.getlocal(dl(h), 1), debug.getlocal(dl(h, true), 1))
+    --> =x	nil
+
+    print(debug.getinfo(dl(h, true), "S").source)
+    --> ==?
+
+    print(debug.getupvalue(dl(g, true), 1))
+    --> ~^\(no name\)\ttable:
+
+    print(load("\27GoLua\0\0"))
+    --> ~nil	.*format version 0 not supported
+
+    print(load("\6\0\4"))
+    --> ~nil	.*older golua version
+
+    print(load("\27Lua"))
+    --> ~nil	.*not a golua binary chunk
+
     local errd = errtest(string.dump)
 
     errd()
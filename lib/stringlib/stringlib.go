@@ -17,7 +17,7 @@ var LibLoader = packagelib.Loader{
 }
 
 func load(r *rt.Runtime) (rt.Value, func()) {
-	pkg := rt.NewTable()
+	pkg := r.NewTable()
 	pkgVal := rt.TableValue(pkg)
 
 	rt.SolemnlyDeclareCompliance(
@@ -42,7 +42,7 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 		r.SetEnvGoFunc(pkg, "unpack", unpack, 3, false),
 	)
 
-	stringMeta := rt.NewTable()
+	stringMeta := r.NewTable()
 	r.SetEnv(stringMeta, "__index", pkgVal)
 
 	rt.SolemnlyDeclareCompliance(
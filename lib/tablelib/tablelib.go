@@ -19,7 +19,7 @@ var LibLoader = packagelib.Loader{
 }
 
 func load(r *rt.Runtime) (rt.Value, func()) {
-	pkg := rt.NewTable()
+	pkg := r.NewTable()
 
 	rt.SolemnlyDeclareCompliance(
 		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
@@ -257,7 +257,7 @@ func move(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 }
 
 func pack(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
-	tbl := rt.NewTable()
+	tbl := t.NewTable()
 	// We can use t.SetTable() because tbl has no metatable
 	for i, v := range c.Etc() {
 		// SetTable always consumes CPU so the loop is protected.
@@ -0,0 +1,233 @@
+// Package lint finds likely mistakes in Lua source code without running it.
+//
+// It works on the AST of the code, resolving the scope of names the same way
+// the compiler does.  The problems reported are:
+//   - globals which are used but never set, or set but never used;
+//   - local variables which are never used;
+//   - local variables which shadow other local variables;
+//   - code which cannot be reached;
+//   - misuses of goto, labels and break;
+//   - assignments to <const> and <close> local variables;
+//   - wrong numbers of arguments to functions of the standard library.
+package lint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/parsing"
+	"github.com/arnodel/golua/scanner"
+)
+
+// A Check is a kind of problem that can be reported.
+type Check string
+
+// The available checks.
+const (
+	SyntaxError     Check = "syntax"           // The file cannot be parsed
+	UndefinedGlobal Check = "undefined-global" // A global is used but never set
+	UnusedGlobal    Check = "unused-global"    // A global is set but never used
+	UnusedLocal     Check = "unused-local"     // A local variable is never used
+	Shadowing       Check = "shadowing"        // A local variable shadows another
+	Unreachable     Check = "unreachable"      // Code that cannot be executed
+	GotoMisuse      Check = "goto"             // Invalid or unused goto, label or break
+	ConstAssign     Check = "const-assign"     // Assignment to a <const> or <close> local
+	ArgCount        Check = "arg-count"        // Wrong number of arguments to a standard function
+)
+
+// AllChecks contains all the checks, in the order they are documented above.
+var AllChecks = []Check{
+	SyntaxError,
+	UndefinedGlobal,
+	UnusedGlobal,
+	UnusedLocal,
+	Shadowing,
+	Unreachable,
+	GotoMisuse,
+	ConstAssign,
+	ArgCount,
+}
+
+// ParseCheck returns the check with the given name.
+func ParseCheck(name string) (Check, bool) {
+	for _, c := range AllChecks {
+		if string(c) == name {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// A Diagnostic is a problem found in a file.
+type Diagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Check   Check  `json:"check"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s (%s)", d.File, d.Line, d.Column, d.Message, d.Check)
+}
+
+// Config controls what problems are reported.
+type Config struct {
+	Globals []string // Globals defined outside of the linted files
+	Disable []Check  // Checks that are not run
+}
+
+// A File is Lua source code to lint.
+type File struct {
+	Name   string
+	Source []byte
+}
+
+// Lint checks the given files and returns the problems found, sorted by file
+// (in the order they were given) and position.  The files are linted together,
+// so a global set in one file can be used in another.
+func Lint(files []File, cfg Config) []Diagnostic {
+	l := newLinter(cfg)
+	for _, f := range files {
+		l.lintFile(f)
+	}
+	l.checkGlobals()
+	return l.sortedDiagnostics()
+}
+
+// WriteText writes the diagnostics to w, one per line.
+func WriteText(w io.Writer, diags []Diagnostic) error {
+	for _, d := range diags {
+		if _, err := fmt.Fprintln(w, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the diagnostics to w as a JSON array.
+func WriteJSON(w io.Writer, diags []Diagnostic) error {
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(diags)
+}
+
+type linter struct {
+	disabled  map[Check]bool
+	knownGlbs map[string]bool
+	globals   map[string]*globalUses
+	files     map[string]int // Order of the files, to sort diagnostics
+	diags     []Diagnostic
+}
+
+// Where a global is set and used across all the files.
+type globalUses struct {
+	sets, gets []Diagnostic // Only File, Line and Column are filled
+}
+
+func newLinter(cfg Config) *linter {
+	l := &linter{
+		disabled:  map[Check]bool{},
+		knownGlbs: map[string]bool{},
+		globals:   map[string]*globalUses{},
+		files:     map[string]int{},
+	}
+	for _, c := range cfg.Disable {
+		l.disabled[c] = true
+	}
+	for _, name := range stdGlobals {
+		l.knownGlbs[name] = true
+	}
+	for _, name := range cfg.Globals {
+		l.knownGlbs[name] = true
+	}
+	return l
+}
+
+func (l *linter) lintFile(f File) {
+	if _, ok := l.files[f.Name]; !ok {
+		l.files[f.Name] = len(l.files)
+	}
+	chunk, err := parsing.ParseChunk(scanner.New(f.Name, f.Source))
+	if err != nil {
+		d := Diagnostic{File: f.Name, Check: SyntaxError, Message: err.Error()}
+		var parseErr parsing.Error
+		if errors.As(err, &parseErr) {
+			// The message starts with the position, which is already in the
+			// diagnostic.
+			d.Line, d.Column = parseErr.Got.Line, parseErr.Got.Column
+			d.Message = strings.TrimPrefix(d.Message, fmt.Sprintf("%d:%d: ", d.Line, d.Column))
+		}
+		l.report(d)
+		return
+	}
+	fl := &fileLinter{linter: l, file: f.Name}
+	fl.lintChunk(chunk)
+}
+
+func (l *linter) report(d Diagnostic) {
+	if !l.disabled[d.Check] {
+		l.diags = append(l.diags, d)
+	}
+}
+
+// Report globals which are used but never set and globals which are set but
+// never used.  This can only be done once all the files have been linted.
+func (l *linter) checkGlobals() {
+	for name, uses := range l.globals {
+		if l.knownGlbs[name] {
+			continue
+		}
+		if len(uses.sets) == 0 {
+			for _, d := range uses.gets {
+				d.Check = UndefinedGlobal
+				d.Message = fmt.Sprintf("undefined global '%s'", name)
+				l.report(d)
+			}
+		}
+		if len(uses.gets) == 0 {
+			for _, d := range uses.sets {
+				d.Check = UnusedGlobal
+				d.Message = fmt.Sprintf("global '%s' is set but never used", name)
+				l.report(d)
+			}
+		}
+	}
+}
+
+func (l *linter) sortedDiagnostics() []Diagnostic {
+	diags := l.diags
+	sort.SliceStable(diags, func(i, j int) bool {
+		di, dj := diags[i], diags[j]
+		if di.File != dj.File {
+			return l.files[di.File] < l.files[dj.File]
+		}
+		if di.Line != dj.Line {
+			return di.Line < dj.Line
+		}
+		if di.Column != dj.Column {
+			return di.Column < dj.Column
+		}
+		return di.Message < dj.Message
+	})
+	return diags
+}
+
+// Position of a node, (0, 0) if it is not known.
+func position(n ast.Locator) (line, column int) {
+	if n == nil {
+		return
+	}
+	if pos := n.Locate().StartPos(); pos != nil {
+		return pos.Line, pos.Column
+	}
+	return
+}
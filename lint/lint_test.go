@@ -0,0 +1,291 @@
+package lint
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Lint src and return the diagnostics as "line:column: message (check)".
+func lintSource(src string, cfg Config) []string {
+	var res []string
+	for _, d := range Lint([]File{{Name: "test.lua", Source: []byte(src)}}, cfg) {
+		res = append(res, fmt.Sprintf("%d:%d: %s (%s)", d.Line, d.Column, d.Message, d.Check))
+	}
+	return res
+}
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "clean",
+			src: `
+local Point = {}
+Point.__index = Point
+function Point.new(x, y)
+    return setmetatable({x = x, y = y}, Point)
+end
+function Point:norm()
+    return math.sqrt(self.x * self.x + self.y * self.y)
+end
+local function fact(n)
+    if n <= 1 then return 1 end
+    return n * fact(n - 1)
+end
+for i, v in ipairs({fact(3), Point.new(1, 2):norm()}) do
+    print(i, v)
+end
+local _unused, _ = 1, 2
+local f <close> = nil
+print(string.format("%d %s", select("#", ...), tostring(...)))`,
+		},
+		{
+			name: "globals",
+			src: `
+counter = 0
+function incr() counter = counter + 1 end
+unused = 1
+print(undefined, counter)
+incr()`,
+			want: []string{
+				"4:1: global 'unused' is set but never used (unused-global)",
+				"5:7: undefined global 'undefined' (undefined-global)",
+			},
+		},
+		{
+			name: "local _ENV",
+			src: `
+local _ENV = {print = print}
+x = 1
+print(y)`,
+		},
+		{
+			name: "unused locals",
+			src: `
+local a, b = 1, 2
+local function f() end
+local c
+c = 3
+print(b)`,
+			want: []string{
+				"2:7: unused local 'a' (unused-local)",
+				"3:16: unused local function 'f' (unused-local)",
+				"4:7: unused local 'c' (unused-local)",
+			},
+		},
+		{
+			name: "repeat scope",
+			src: `
+repeat
+    local done = true
+until done`,
+		},
+		{
+			name: "shadowing",
+			src: `
+local x = 1
+local function f(x)
+    for x = 1, x do
+        print(x)
+    end
+end
+local x = f
+print(x)`,
+			want: []string{
+				"2:7: unused local 'x' (unused-local)",
+				"3:18: local 'x' shadows the local declared on line 2 (shadowing)",
+				"4:9: local 'x' shadows the local declared on line 3 (shadowing)",
+				"8:7: local 'x' shadows the local declared on line 2 (shadowing)",
+			},
+		},
+		{
+			name: "unreachable",
+			src: `
+local function f(x)
+    while x do
+        if x > 0 then
+            return 1
+        else
+            break
+        end
+        x = x - 1
+    end
+    do return 2 end
+    print(x)
+end
+while true do print(f(1)) end
+print("never")`,
+			want: []string{
+				"9:9: unreachable code (unreachable)",
+				"12:5: unreachable code (unreachable)",
+				"15:1: unreachable code (unreachable)",
+			},
+		},
+		{
+			name: "reachable",
+			src: `
+for i = 1, 3 do
+    if i == 2 then goto continue end
+    print(i)
+    ::continue::
+end
+while true do
+    if os.time() then break end
+end
+do
+    goto skip
+    print("skipped")
+    ::skip::
+end
+print("end")`,
+			want: []string{
+				"12:5: unreachable code (unreachable)",
+			},
+		},
+		{
+			name: "goto",
+			src: `
+do
+    goto l1
+    local a = 1
+    print(a)
+    ::l1::
+    print("l1")
+end
+do
+    goto l2
+    local b = 1
+    print(b)
+    ::l2::
+end
+goto l3
+::l4::
+::l4::
+local function f()
+    goto l4
+end
+f()
+break`,
+			want: []string{
+				"3:5: goto 'l1' jumps into the scope of local 'a' (goto)",
+				"4:11: unreachable code (unreachable)",
+				"11:11: unreachable code (unreachable)",
+				"15:1: no visible label 'l3' for goto (goto)",
+				"16:3: unused label 'l4' (goto)",
+				"17:3: label 'l4' already defined on line 16 (goto)",
+				"19:5: no visible label 'l4' for goto (goto)",
+				"22:1: break outside a loop (goto)",
+			},
+		},
+		{
+			name: "const assign",
+			src: `
+local k <const> = 1
+local f <close> = nil
+k, f = 2, 3
+local v = 4
+v = 5
+print(k, v)`,
+			want: []string{
+				"4:1: attempt to assign to const variable 'k' (const-assign)",
+				"4:4: attempt to assign to const variable 'f' (const-assign)",
+			},
+		},
+		{
+			name: "arg count",
+			src: `
+local function g(...) return ... end
+print(tostring())
+print(string.rep("x"), table.insert({}))
+print(math.floor(1.5, 2), setmetatable({}, {}, 1))
+print(string.rep(g("x", 2)), math.floor(g()), tostring(1, g()))
+print(("x"):rep(1, 2, 3, 4))
+local string = {rep = g}
+print(string.rep())`,
+			want: []string{
+				"3:7: 'tostring' expects 1 argument, got 0 (arg-count)",
+				"4:7: 'string.rep' expects at least 2 arguments, got 1 (arg-count)",
+				"4:24: 'table.insert' expects at least 2 arguments, got 1 (arg-count)",
+				"5:7: 'math.floor' expects 1 argument, got 2 (arg-count)",
+				"5:27: 'setmetatable' expects 2 arguments, got 3 (arg-count)",
+			},
+		},
+		{
+			name: "syntax error",
+			src:  "local x = = 1",
+			want: []string{
+				"1:11: unexpected symbol near '=' (syntax)",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := lintSource(test.src, Config{})
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got:\n%q\nwant:\n%q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	src := `
+local x = 1
+print(undefined)`
+	got := lintSource(src, Config{Globals: []string{"undefined"}, Disable: []Check{UnusedLocal}})
+	if got != nil {
+		t.Errorf("expected no diagnostics, got %q", got)
+	}
+}
+
+func TestLintFilesTogether(t *testing.T) {
+	files := []File{
+		{Name: "b.lua", Source: []byte("print(helper(), missing)")},
+		{Name: "a.lua", Source: []byte("function helper() return 1 end")},
+	}
+	diags := Lint(files, Config{})
+	want := []Diagnostic{{
+		File:    "b.lua",
+		Line:    1,
+		Column:  17,
+		Check:   UndefinedGlobal,
+		Message: "undefined global 'missing'",
+	}}
+	if !reflect.DeepEqual(diags, want) {
+		t.Errorf("got %v, want %v", diags, want)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteText(&buf, diags); err != nil {
+		t.Fatal(err)
+	}
+	if text := buf.String(); text != "b.lua:1:17: undefined global 'missing' (undefined-global)\n" {
+		t.Errorf("unexpected text output %q", text)
+	}
+
+	buf.Reset()
+	if err := WriteJSON(&buf, diags); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []Diagnostic
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("JSON output %s does not decode to %v", buf.Bytes(), want)
+	}
+
+	buf.Reset()
+	if err := WriteJSON(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "[]\n" {
+		t.Errorf("expected an empty JSON array, got %q", buf.String())
+	}
+}
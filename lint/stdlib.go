@@ -0,0 +1,145 @@
+package lint
+
+// Globals defined by golua (see lib.LoadAll) and by the golua command.
+var stdGlobals = []string{
+	"_G", "_VERSION", "arg", "assert", "collectgarbage", "coroutine", "debug",
+	"dofile", "error", "getmetatable", "golib", "io", "ipairs", "load",
+	"loadfile", "math", "next", "os", "package", "pairs", "pcall", "print",
+	"rawequal", "rawget", "rawlen", "rawset", "require", "runtime", "select",
+	"setmetatable", "string", "table", "tonumber", "tostring", "type", "utf8",
+	"warn", "xpcall",
+}
+
+// The number of arguments a function accepts.
+type argCount struct {
+	min, max int // max is -1 if there is no maximum
+}
+
+// varargs means any number of arguments from min.
+const varargs = -1
+
+// Number of arguments accepted by functions of the standard library, as
+// described in the Lua 5.4 manual.  Functions with an optional first argument
+// of a different type (e.g. the thread argument of debug functions) are not
+// included.
+var stdFunctions = map[string]argCount{
+	"assert":         {1, varargs},
+	"collectgarbage": {0, 2},
+	"dofile":         {0, 1},
+	"error":          {0, 2},
+	"getmetatable":   {1, 1},
+	"ipairs":         {1, 1},
+	"load":           {1, 4},
+	"loadfile":       {0, 3},
+	"next":           {1, 2},
+	"pairs":          {1, 1},
+	"pcall":          {1, varargs},
+	"print":          {0, varargs},
+	"rawequal":       {2, 2},
+	"rawget":         {2, 2},
+	"rawlen":         {1, 1},
+	"rawset":         {3, 3},
+	"require":        {1, 1},
+	"select":         {1, varargs},
+	"setmetatable":   {2, 2},
+	"tonumber":       {1, 2},
+	"tostring":       {1, 1},
+	"type":           {1, 1},
+	"warn":           {1, varargs},
+	"xpcall":         {2, varargs},
+
+	"coroutine.close":       {1, 1},
+	"coroutine.create":      {1, 1},
+	"coroutine.isyieldable": {0, 1},
+	"coroutine.resume":      {1, varargs},
+	"coroutine.running":     {0, 0},
+	"coroutine.status":      {1, 1},
+	"coroutine.wrap":        {1, 1},
+	"coroutine.yield":       {0, varargs},
+
+	"debug.getupvalue":   {2, 2},
+	"debug.setmetatable": {2, 2},
+	"debug.setupvalue":   {3, 3},
+	"debug.upvalueid":    {2, 2},
+	"debug.upvaluejoin":  {4, 4},
+
+	"io.close":   {0, 1},
+	"io.flush":   {0, 0},
+	"io.input":   {0, 1},
+	"io.lines":   {0, varargs},
+	"io.open":    {1, 2},
+	"io.output":  {0, 1},
+	"io.popen":   {1, 2},
+	"io.read":    {0, varargs},
+	"io.tmpfile": {0, 0},
+	"io.type":    {1, 1},
+	"io.write":   {0, varargs},
+
+	"math.abs":        {1, 1},
+	"math.acos":       {1, 1},
+	"math.asin":       {1, 1},
+	"math.atan":       {1, 2},
+	"math.ceil":       {1, 1},
+	"math.cos":        {1, 1},
+	"math.deg":        {1, 1},
+	"math.exp":        {1, 1},
+	"math.floor":      {1, 1},
+	"math.fmod":       {2, 2},
+	"math.log":        {1, 2},
+	"math.max":        {1, varargs},
+	"math.min":        {1, varargs},
+	"math.modf":       {1, 1},
+	"math.rad":        {1, 1},
+	"math.random":     {0, 2},
+	"math.randomseed": {0, 2},
+	"math.sin":        {1, 1},
+	"math.sqrt":       {1, 1},
+	"math.tan":        {1, 1},
+	"math.tointeger":  {1, 1},
+	"math.type":       {1, 1},
+	"math.ult":        {2, 2},
+
+	"os.clock":     {0, 0},
+	"os.date":      {0, 2},
+	"os.difftime":  {2, 2},
+	"os.execute":   {0, 1},
+	"os.exit":      {0, 2},
+	"os.getenv":    {1, 1},
+	"os.remove":    {1, 1},
+	"os.rename":    {2, 2},
+	"os.setlocale": {0, 2},
+	"os.time":      {0, 1},
+	"os.tmpname":   {0, 0},
+
+	"string.byte":     {1, 3},
+	"string.char":     {0, varargs},
+	"string.dump":     {1, 2},
+	"string.find":     {2, 4},
+	"string.format":   {1, varargs},
+	"string.gmatch":   {2, 3},
+	"string.gsub":     {3, 4},
+	"string.len":      {1, 1},
+	"string.lower":    {1, 1},
+	"string.match":    {2, 3},
+	"string.pack":     {1, varargs},
+	"string.packsize": {1, 1},
+	"string.rep":      {2, 3},
+	"string.reverse":  {1, 1},
+	"string.sub":      {2, 3},
+	"string.unpack":   {2, 3},
+	"string.upper":    {1, 1},
+
+	"table.concat": {1, 4},
+	"table.insert": {2, 3},
+	"table.move":   {4, 5},
+	"table.pack":   {0, varargs},
+	"table.remove": {1, 2},
+	"table.sort":   {1, 2},
+	"table.unpack": {1, 3},
+
+	"utf8.char":      {0, varargs},
+	"utf8.codepoint": {1, 4},
+	"utf8.codes":     {1, 2},
+	"utf8.len":       {1, 4},
+	"utf8.offset":    {2, 3},
+}
@@ -0,0 +1,701 @@
+package lint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/arnodel/golua/ast"
+)
+
+// The kinds of local variables.
+type varKind uint8
+
+const (
+	localVar     varKind = iota // Declared with local
+	localFuncVar                // Declared with local function
+	paramVar                    // Function parameter
+	loopVar                     // Variable of a for loop
+)
+
+type variable struct {
+	decl   ast.Name
+	kind   varKind
+	attrib ast.LocalAttrib
+	used   bool
+}
+
+// A scope contains the local variables declared in a block.
+type scope struct {
+	parent *scope
+	vars   []*variable
+}
+
+// A block of statements, used to resolve goto labels.
+type block struct {
+	parent  *block // nil for the body of a function
+	stats   []ast.Stat
+	current int // Index of the statement being linted
+	labels  map[string]*label
+}
+
+type label struct {
+	name  ast.Name
+	index int  // Index of the label statement in the block
+	atEnd bool // True if only void statements follow the label in the block
+	used  bool
+}
+
+// State of the function being linted.
+type function struct {
+	block     *block
+	loopDepth int
+}
+
+// A fileLinter walks the AST of a file, keeping track of the scopes of local
+// variables and labels.  It implements ast.StatProcessor, ast.ExpProcessor and
+// ast.VarProcessor.
+type fileLinter struct {
+	*linter
+	file  string
+	scope *scope
+	fn    *function
+}
+
+var _ ast.StatProcessor = (*fileLinter)(nil)
+var _ ast.ExpProcessor = (*fileLinter)(nil)
+var _ ast.VarProcessor = (*fileLinter)(nil)
+
+func (l *fileLinter) lintChunk(chunk ast.BlockStat) {
+	l.lintFunction(nil, chunk)
+}
+
+func (l *fileLinter) reportAt(n ast.Locator, c Check, tpl string, args ...interface{}) {
+	line, column := position(n)
+	l.report(Diagnostic{
+		File:    l.file,
+		Line:    line,
+		Column:  column,
+		Check:   c,
+		Message: fmt.Sprintf(tpl, args...),
+	})
+}
+
+//
+// Scopes
+//
+
+func (l *fileLinter) pushScope() {
+	l.scope = &scope{parent: l.scope}
+}
+
+// Pop the current scope, reporting its unused local variables.  Names starting
+// with "_" are meant to be unused and <close> variables are used when they go
+// out of scope.
+func (l *fileLinter) popScope() {
+	for _, v := range l.scope.vars {
+		if v.used || strings.HasPrefix(v.decl.Val, "_") || v.attrib == ast.CloseAttrib {
+			continue
+		}
+		switch v.kind {
+		case localVar:
+			l.reportAt(v.decl, UnusedLocal, "unused local '%s'", v.decl.Val)
+		case localFuncVar:
+			l.reportAt(v.decl, UnusedLocal, "unused local function '%s'", v.decl.Val)
+		}
+	}
+	l.scope = l.scope.parent
+}
+
+func (l *fileLinter) declare(name ast.Name, kind varKind, attrib ast.LocalAttrib) {
+	// The implicit self parameter of methods has no location, it is not
+	// reported.
+	if prev := l.lookup(name.Val); prev != nil && name.Val != "_" && name.StartPos() != nil {
+		if line, _ := position(prev.decl); line > 0 {
+			l.reportAt(name, Shadowing, "local '%s' shadows the local declared on line %d", name.Val, line)
+		} else {
+			l.reportAt(name, Shadowing, "local '%s' shadows another local", name.Val)
+		}
+	}
+	l.scope.vars = append(l.scope.vars, &variable{decl: name, kind: kind, attrib: attrib})
+}
+
+// Return the local variable visible with the given name, nil if there is none.
+func (l *fileLinter) lookup(name string) *variable {
+	for s := l.scope; s != nil; s = s.parent {
+		for i := len(s.vars) - 1; i >= 0; i-- {
+			if v := s.vars[i]; v.decl.Val == name {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// Returns true if name refers to a global variable.  It is not the case if it
+// is a local variable or if _ENV has been redefined as a local variable.
+func (l *fileLinter) isGlobal(name string) bool {
+	return name != "_ENV" && l.lookup(name) == nil && l.lookup("_ENV") == nil
+}
+
+func (l *fileLinter) useName(n ast.Name) {
+	if v := l.lookup(n.Val); v != nil {
+		v.used = true
+	} else if l.isGlobal(n.Val) {
+		l.globalUses(n.Val).gets = append(l.globalUses(n.Val).gets, l.where(n))
+	} else if env := l.lookup("_ENV"); env != nil {
+		env.used = true
+	}
+}
+
+func (l *fileLinter) globalUses(name string) *globalUses {
+	uses := l.globals[name]
+	if uses == nil {
+		uses = new(globalUses)
+		l.globals[name] = uses
+	}
+	return uses
+}
+
+func (l *fileLinter) where(n ast.Locator) Diagnostic {
+	line, column := position(n)
+	return Diagnostic{File: l.file, Line: line, Column: column}
+}
+
+//
+// Functions and blocks
+//
+
+func (l *fileLinter) lintFunction(params []ast.Name, body ast.BlockStat) {
+	saved := l.fn
+	l.fn = &function{}
+	l.pushScope()
+	for _, p := range params {
+		l.declare(p, paramVar, ast.NoAttrib)
+	}
+	l.lintBlock(body, false)
+	l.popScope()
+	l.fn = saved
+}
+
+// Lint the statements in a block.  The caller is responsible for the scope of
+// local variables, as it may contain more than the block (e.g. the variables of
+// a for loop).  If isRepeat is true, the block is the body of a repeat
+// statement so its labels are not at the end of the block.
+func (l *fileLinter) lintBlock(b ast.BlockStat, isRepeat bool) {
+	l.pushBlock(b, isRepeat)
+	reachable := true
+	for i, s := range b.Stats {
+		l.fn.block.current = i
+		switch s.(type) {
+		case ast.LabelStat:
+			// A label can be reached with goto
+			reachable = true
+		case ast.EmptyStat:
+			// That doesn't count
+		default:
+			if !reachable {
+				// Only report the first unreachable statement
+				l.reportAt(s, Unreachable, "unreachable code")
+				reachable = true
+			}
+		}
+		s.ProcessStat(l)
+		if terminates(s) {
+			reachable = false
+		}
+	}
+	if len(b.Return) > 0 && !reachable {
+		l.reportAt(b.Return[0], Unreachable, "unreachable code")
+	}
+	for _, e := range b.Return {
+		l.lintExp(e)
+	}
+	l.popBlock()
+}
+
+// Push a block, declaring its labels.
+func (l *fileLinter) pushBlock(b ast.BlockStat, isRepeat bool) {
+	blk := &block{parent: l.fn.block, stats: b.Stats, labels: map[string]*label{}}
+	for i, s := range b.Stats {
+		ls, ok := s.(ast.LabelStat)
+		if !ok {
+			continue
+		}
+		if _, prev := findLabel(blk, ls.Name.Val); prev != nil {
+			line, _ := position(prev.name)
+			l.reportAt(ls.Name, GotoMisuse, "label '%s' already defined on line %d", ls.Name.Val, line)
+			continue
+		}
+		blk.labels[ls.Name.Val] = &label{
+			name:  ls.Name,
+			index: i,
+			atEnd: !isRepeat && b.Return == nil && onlyVoid(b.Stats[i+1:]),
+		}
+	}
+	l.fn.block = blk
+}
+
+// Pop the current block, reporting its unused labels.
+func (l *fileLinter) popBlock() {
+	blk := l.fn.block
+	for _, lbl := range blk.labels {
+		if !lbl.used {
+			l.reportAt(lbl.name, GotoMisuse, "unused label '%s'", lbl.name.Val)
+		}
+	}
+	l.fn.block = blk.parent
+}
+
+// Find the label with the given name visible from blk.  The block containing
+// the label is returned as well.
+func findLabel(blk *block, name string) (*block, *label) {
+	for ; blk != nil; blk = blk.parent {
+		if lbl := blk.labels[name]; lbl != nil {
+			return blk, lbl
+		}
+	}
+	return nil, nil
+}
+
+// Returns true if stats only contains void statements, i.e. labels and empty
+// statements.
+func onlyVoid(stats []ast.Stat) bool {
+	for _, s := range stats {
+		switch s.(type) {
+		case ast.LabelStat, ast.EmptyStat:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the name of the first local variable declared in stats.
+func firstLocal(stats []ast.Stat) (ast.Name, bool) {
+	for _, s := range stats {
+		switch s := s.(type) {
+		case ast.LocalStat:
+			return s.NameAttribs[0].Name, true
+		case ast.LocalFunctionStat:
+			return s.Name, true
+		}
+	}
+	return ast.Name{}, false
+}
+
+//
+// Reachability
+//
+
+// Returns true if execution never continues to the statement following s.
+func terminates(s ast.Stat) bool {
+	switch s := s.(type) {
+	case ast.BreakStat, ast.GotoStat:
+		return true
+	case ast.BlockStat:
+		return blockTerminates(s)
+	case ast.IfStat:
+		if s.Else == nil || !blockTerminates(s.If.Body) || !blockTerminates(*s.Else) {
+			return false
+		}
+		for _, c := range s.ElseIfs {
+			if !blockTerminates(c.Body) {
+				return false
+			}
+		}
+		return true
+	case ast.WhileStat:
+		return isTruthy(s.Cond) && !canExitLoop(s.Body.Stats, true)
+	case ast.RepeatStat:
+		return isFalsy(s.Cond) && !canExitLoop(s.Body.Stats, true)
+	}
+	return false
+}
+
+// Returns true if execution never reaches the end of b.
+func blockTerminates(b ast.BlockStat) bool {
+	if b.Return != nil {
+		return true
+	}
+	reachable := true
+	for _, s := range b.Stats {
+		if _, ok := s.(ast.LabelStat); ok {
+			reachable = true
+		} else if terminates(s) {
+			reachable = false
+		}
+	}
+	return !reachable
+}
+
+// Returns true if stats contain a statement that may exit the loop they are
+// in, i.e. a break (if breaks is true) or a goto.
+func canExitLoop(stats []ast.Stat, breaks bool) bool {
+	for _, s := range stats {
+		var exits bool
+		switch s := s.(type) {
+		case ast.BreakStat:
+			exits = breaks
+		case ast.GotoStat:
+			exits = true
+		case ast.BlockStat:
+			exits = canExitLoop(s.Stats, breaks)
+		case ast.IfStat:
+			exits = canExitLoop(s.If.Body.Stats, breaks)
+			for _, c := range s.ElseIfs {
+				exits = exits || canExitLoop(c.Body.Stats, breaks)
+			}
+			if s.Else != nil {
+				exits = exits || canExitLoop(s.Else.Stats, breaks)
+			}
+		// In nested loops, break statements exit the nested loop.
+		case ast.WhileStat:
+			exits = canExitLoop(s.Body.Stats, false)
+		case ast.RepeatStat:
+			exits = canExitLoop(s.Body.Stats, false)
+		case *ast.ForStat:
+			exits = canExitLoop(s.Body.Stats, false)
+		case *ast.ForInStat:
+			exits = canExitLoop(s.Body.Stats, false)
+		}
+		if exits {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if e is a constant whose value is true as a condition.
+func isTruthy(e ast.ExpNode) bool {
+	switch x := e.(type) {
+	case ast.Bool:
+		return x.Val
+	case ast.Int, ast.Float, ast.String:
+		return true
+	}
+	return false
+}
+
+// Returns true if e is a constant whose value is false as a condition.
+func isFalsy(e ast.ExpNode) bool {
+	switch x := e.(type) {
+	case ast.Bool:
+		return !x.Val
+	case ast.Nil:
+		return true
+	}
+	return false
+}
+
+//
+// Statements
+//
+
+func (l *fileLinter) lintExp(e ast.ExpNode) {
+	e.ProcessExp(l)
+}
+
+// ProcessAssignStat lints an AssignStat.
+func (l *fileLinter) ProcessAssignStat(s ast.AssignStat) {
+	for _, e := range s.Src {
+		l.lintExp(e)
+	}
+	for _, v := range s.Dest {
+		v.ProcessVar(l)
+	}
+}
+
+// ProcessBlockStat lints a BlockStat.
+func (l *fileLinter) ProcessBlockStat(s ast.BlockStat) {
+	l.pushScope()
+	l.lintBlock(s, false)
+	l.popScope()
+}
+
+// ProcessBreakStat lints a BreakStat.
+func (l *fileLinter) ProcessBreakStat(s ast.BreakStat) {
+	if l.fn.loopDepth == 0 {
+		l.reportAt(s, GotoMisuse, "break outside a loop")
+	}
+}
+
+// ProcessEmptyStat lints an EmptyStat.
+func (l *fileLinter) ProcessEmptyStat(s ast.EmptyStat) {}
+
+// ProcessForInStat lints a ForInStat.
+func (l *fileLinter) ProcessForInStat(s ast.ForInStat) {
+	for _, e := range s.Params {
+		l.lintExp(e)
+	}
+	l.pushScope()
+	for _, v := range s.Vars {
+		l.declare(v, loopVar, ast.NoAttrib)
+	}
+	l.lintLoopBody(s.Body)
+	l.popScope()
+}
+
+// ProcessForStat lints a ForStat.
+func (l *fileLinter) ProcessForStat(s ast.ForStat) {
+	l.lintExp(s.Start)
+	l.lintExp(s.Stop)
+	l.lintExp(s.Step)
+	l.pushScope()
+	l.declare(s.Var, loopVar, ast.NoAttrib)
+	l.lintLoopBody(s.Body)
+	l.popScope()
+}
+
+func (l *fileLinter) lintLoopBody(b ast.BlockStat) {
+	l.fn.loopDepth++
+	l.lintBlock(b, false)
+	l.fn.loopDepth--
+}
+
+// ProcessFunctionCallStat lints a function call statement.
+func (l *fileLinter) ProcessFunctionCallStat(f ast.FunctionCall) {
+	l.lintCall(*f.BFunctionCall)
+}
+
+// ProcessGotoStat lints a GotoStat.
+func (l *fileLinter) ProcessGotoStat(s ast.GotoStat) {
+	blk, lbl := findLabel(l.fn.block, s.Label.Val)
+	if lbl == nil {
+		l.reportAt(s, GotoMisuse, "no visible label '%s' for goto", s.Label.Val)
+		return
+	}
+	lbl.used = true
+	if lbl.index > blk.current && !lbl.atEnd {
+		if v, ok := firstLocal(blk.stats[blk.current+1 : lbl.index]); ok {
+			l.reportAt(s, GotoMisuse, "goto '%s' jumps into the scope of local '%s'", s.Label.Val, v.Val)
+		}
+	}
+}
+
+// ProcessIfStat lints an IfStat.
+func (l *fileLinter) ProcessIfStat(s ast.IfStat) {
+	l.lintCond(s.If)
+	for _, c := range s.ElseIfs {
+		l.lintCond(c)
+	}
+	if s.Else != nil {
+		l.ProcessBlockStat(*s.Else)
+	}
+}
+
+func (l *fileLinter) lintCond(c ast.CondStat) {
+	l.lintExp(c.Cond)
+	l.ProcessBlockStat(c.Body)
+}
+
+// ProcessLabelStat lints a LabelStat.  Labels are declared when their block is
+// linted.
+func (l *fileLinter) ProcessLabelStat(s ast.LabelStat) {}
+
+// ProcessLocalFunctionStat lints a LocalFunctionStat.
+func (l *fileLinter) ProcessLocalFunctionStat(s ast.LocalFunctionStat) {
+	l.declare(s.Name, localFuncVar, ast.NoAttrib)
+	l.lintFunction(s.Params, s.Body)
+}
+
+// ProcessLocalStat lints a LocalStat.
+func (l *fileLinter) ProcessLocalStat(s ast.LocalStat) {
+	for _, e := range s.Values {
+		l.lintExp(e)
+	}
+	for _, na := range s.NameAttribs {
+		l.declare(na.Name, localVar, na.Attrib)
+	}
+}
+
+// ProcessRepeatStat lints a RepeatStat.  The condition is in the scope of the
+// body.
+func (l *fileLinter) ProcessRepeatStat(s ast.RepeatStat) {
+	l.pushScope()
+	l.fn.loopDepth++
+	l.lintBlock(s.Body, true)
+	l.fn.loopDepth--
+	l.lintExp(s.Cond)
+	l.popScope()
+}
+
+// ProcessWhileStat lints a WhileStat.
+func (l *fileLinter) ProcessWhileStat(s ast.WhileStat) {
+	l.lintExp(s.Cond)
+	l.pushScope()
+	l.lintLoopBody(s.Body)
+	l.popScope()
+}
+
+//
+// Assignment targets
+//
+
+// ProcessIndexExpVar lints an IndexExp as an assignment target.
+func (l *fileLinter) ProcessIndexExpVar(e ast.IndexExp) {
+	l.lintExp(e.Coll)
+	l.lintExp(e.Idx)
+}
+
+// ProcessNameVar lints a Name as an assignment target.
+func (l *fileLinter) ProcessNameVar(n ast.Name) {
+	if v := l.lookup(n.Val); v != nil {
+		if v.attrib != ast.NoAttrib {
+			l.reportAt(n, ConstAssign, "attempt to assign to const variable '%s'", n.Val)
+		}
+	} else if l.isGlobal(n.Val) {
+		l.globalUses(n.Val).sets = append(l.globalUses(n.Val).sets, l.where(n))
+	} else if env := l.lookup("_ENV"); env != nil {
+		env.used = true
+	}
+}
+
+//
+// Expressions
+//
+
+// ProcessBFunctionCallExp lints a function call whose result is truncated to
+// one value.
+func (l *fileLinter) ProcessBFunctionCallExp(f ast.BFunctionCall) {
+	l.lintCall(f)
+}
+
+// ProcessBinOpExp lints a BinOp.
+func (l *fileLinter) ProcessBinOpExp(b ast.BinOp) {
+	l.lintExp(b.Left)
+	for _, r := range b.Right {
+		l.lintExp(r.Operand)
+	}
+}
+
+// ProcesBoolExp lints a Bool.
+func (l *fileLinter) ProcesBoolExp(b ast.Bool) {}
+
+// ProcessEtcExp lints an Etc.
+func (l *fileLinter) ProcessEtcExp(e ast.Etc) {}
+
+// ProcessFunctionExp lints a Function.
+func (l *fileLinter) ProcessFunctionExp(f ast.Function) {
+	l.lintFunction(f.Params, f.Body)
+}
+
+// ProcessFunctionCallExp lints a FunctionCall.
+func (l *fileLinter) ProcessFunctionCallExp(f ast.FunctionCall) {
+	l.lintCall(*f.BFunctionCall)
+}
+
+// ProcessIndexExp lints an IndexExp.
+func (l *fileLinter) ProcessIndexExp(e ast.IndexExp) {
+	l.lintExp(e.Coll)
+	l.lintExp(e.Idx)
+}
+
+// ProcessNameExp lints a Name.
+func (l *fileLinter) ProcessNameExp(n ast.Name) {
+	l.useName(n)
+}
+
+// ProcessNilExp lints a Nil.
+func (l *fileLinter) ProcessNilExp(n ast.Nil) {}
+
+// ProcessIntExp lints an Int.
+func (l *fileLinter) ProcessIntExp(n ast.Int) {}
+
+// ProcessFloatExp lints a Float.
+func (l *fileLinter) ProcessFloatExp(f ast.Float) {}
+
+// ProcessStringExp lints a String.
+func (l *fileLinter) ProcessStringExp(s ast.String) {}
+
+// ProcessTableConstructorExp lints a TableConstructor.
+func (l *fileLinter) ProcessTableConstructorExp(t ast.TableConstructor) {
+	for _, f := range t.Fields {
+		if _, ok := f.Key.(ast.NoTableKey); !ok {
+			l.lintExp(f.Key)
+		}
+		l.lintExp(f.Value)
+	}
+}
+
+// ProcessUnOpExp lints a UnOp.
+func (l *fileLinter) ProcessUnOpExp(u ast.UnOp) {
+	l.lintExp(u.Operand)
+}
+
+//
+// Function calls
+//
+
+func (l *fileLinter) lintCall(f ast.BFunctionCall) {
+	l.lintExp(f.Target)
+	for _, e := range f.Args {
+		l.lintExp(e)
+	}
+	if f.Method.Val != "" {
+		return
+	}
+	name, ok := l.stdFunctionName(f.Target)
+	if !ok {
+		return
+	}
+	count, ok := stdFunctions[name]
+	if !ok {
+		return
+	}
+	n := len(f.Args)
+	// If the last argument is a function call or '...', it can give any number
+	// of values.
+	multi := n > 0 && isMultiValue(f.Args[n-1])
+	if multi {
+		n--
+	}
+	switch {
+	case !multi && n < count.min:
+		if count.min == count.max {
+			l.reportAt(f, ArgCount, "'%s' expects %s, got %d", name, arguments(count.min), n)
+		} else {
+			l.reportAt(f, ArgCount, "'%s' expects at least %s, got %d", name, arguments(count.min), n)
+		}
+	case count.max != varargs && n > count.max:
+		if count.min == count.max {
+			l.reportAt(f, ArgCount, "'%s' expects %s, got %d", name, arguments(count.max), n)
+		} else {
+			l.reportAt(f, ArgCount, "'%s' expects at most %s, got %d", name, arguments(count.max), n)
+		}
+	}
+}
+
+// Returns the name of the standard function e refers to (e.g. "print" or
+// "string.format"), provided the names involved are globals.
+func (l *fileLinter) stdFunctionName(e ast.ExpNode) (string, bool) {
+	switch x := e.(type) {
+	case ast.Name:
+		return x.Val, l.isGlobal(x.Val)
+	case ast.IndexExp:
+		lib, ok := x.Coll.(ast.Name)
+		if !ok || !l.isGlobal(lib.Val) {
+			return "", false
+		}
+		fn, ok := x.Idx.(ast.String)
+		if !ok {
+			return "", false
+		}
+		return lib.Val + "." + string(fn.Val), true
+	}
+	return "", false
+}
+
+func isMultiValue(e ast.ExpNode) bool {
+	switch e.(type) {
+	case ast.FunctionCall, ast.Etc:
+		return true
+	}
+	return false
+}
+
+func arguments(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
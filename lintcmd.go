@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/arnodel/golua/lint"
+)
+
+// Run "golua lint [flags] [file...]", which reports likely mistakes in Lua
+// files.  It returns 1 if any problem was found.
+func lintMain(args []string) int {
+	var (
+		jsonFlag    bool
+		globalsFlag string
+		disableFlag string
+	)
+	flags := flag.NewFlagSet("golua lint", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: golua lint [flags] [file...]\n\nRead from stdin if no file is given.  Flags:\n")
+		flags.PrintDefaults()
+		checks := make([]string, len(lint.AllChecks))
+		for i, c := range lint.AllChecks {
+			checks[i] = string(c)
+		}
+		fmt.Fprintf(flags.Output(), "\nChecks: %s\n", strings.Join(checks, ", "))
+	}
+	flags.BoolVar(&jsonFlag, "json", false, "Output the problems found as JSON")
+	flags.StringVar(&globalsFlag, "globals", "", "comma separated `names` of globals defined outside of the linted files")
+	flags.StringVar(&disableFlag, "disable", "", "comma separated `checks` to disable")
+	flags.Parse(args)
+
+	var cfg lint.Config
+	if globalsFlag != "" {
+		cfg.Globals = strings.Split(globalsFlag, ",")
+	}
+	if disableFlag != "" {
+		for _, name := range strings.Split(disableFlag, ",") {
+			check, ok := lint.ParseCheck(name)
+			if !ok {
+				return fatal("Unknown check: %s", name)
+			}
+			cfg.Disable = append(cfg.Disable, check)
+		}
+	}
+
+	var files []lint.File
+	if flags.NArg() == 0 {
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return fatal("Error reading <stdin>: %s", err)
+		}
+		files = append(files, lint.File{Name: "<stdin>", Source: src})
+	}
+	for _, name := range flags.Args() {
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			return fatal("Error reading '%s': %s", name, err)
+		}
+		files = append(files, lint.File{Name: name, Source: src})
+	}
+
+	diags := lint.Lint(files, cfg)
+	var err error
+	if jsonFlag {
+		err = lint.WriteJSON(os.Stdout, diags)
+	} else {
+		err = lint.WriteText(os.Stdout, diags)
+	}
+	if err != nil {
+		return fatal("Error writing output: %s", err)
+	}
+	if len(diags) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,153 @@
+package luabind
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/arnodel/golua/luaconv"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// An argConverter converts a Lua argument to the type of a Go parameter.  If
+// it cannot, it returns a message explaining why, e.g. "number expected, got
+// table".
+type argConverter func(v rt.Value) (reflect.Value, string)
+
+var (
+	valueType    = reflect.TypeOf(rt.Value{})
+	tableType    = reflect.TypeOf((*rt.Table)(nil))
+	userDataType = reflect.TypeOf((*rt.UserData)(nil))
+	callableType = reflect.TypeOf((*rt.Callable)(nil)).Elem()
+)
+
+// Returns the converter for parameters of type tp and the name of the Lua type
+// it expects ("" if it accepts any value).
+func argConverterFor(tp reflect.Type) (argConverter, string) {
+	switch tp {
+	case valueType:
+		return func(v rt.Value) (reflect.Value, string) {
+			return reflect.ValueOf(v), ""
+		}, ""
+	case tableType:
+		return func(v rt.Value) (reflect.Value, string) {
+			t, ok := v.TryTable()
+			if !ok {
+				return reflect.Value{}, typeError("table", v)
+			}
+			return reflect.ValueOf(t), ""
+		}, "table"
+	case userDataType:
+		return func(v rt.Value) (reflect.Value, string) {
+			u, ok := v.TryUserData()
+			if !ok {
+				return reflect.Value{}, typeError("userdata", v)
+			}
+			return reflect.ValueOf(u), ""
+		}, "userdata"
+	case threadType:
+		return func(v rt.Value) (reflect.Value, string) {
+			t, ok := v.TryThread()
+			if !ok {
+				return reflect.Value{}, typeError("thread", v)
+			}
+			return reflect.ValueOf(t), ""
+		}, "thread"
+	case callableType:
+		return func(v rt.Value) (reflect.Value, string) {
+			c, ok := v.TryCallable()
+			if !ok {
+				return reflect.Value{}, typeError("function", v)
+			}
+			return reflect.ValueOf(&c).Elem(), ""
+		}, "function"
+	}
+	switch tp.Kind() {
+	case reflect.Bool:
+		return func(v rt.Value) (reflect.Value, string) {
+			return reflect.ValueOf(rt.Truth(v)).Convert(tp), ""
+		}, ""
+	case reflect.String:
+		return func(v rt.Value) (reflect.Value, string) {
+			s, ok := stringArg(v)
+			if !ok {
+				return reflect.Value{}, typeError("string", v)
+			}
+			return reflect.ValueOf(s).Convert(tp), ""
+		}, "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(v rt.Value) (reflect.Value, string) {
+			n, msg := intArg(v)
+			if msg != "" {
+				return reflect.Value{}, msg
+			}
+			x := reflect.New(tp).Elem()
+			if x.OverflowInt(n) {
+				return reflect.Value{}, "value out of range"
+			}
+			x.SetInt(n)
+			return x, ""
+		}, "number"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return func(v rt.Value) (reflect.Value, string) {
+			n, msg := intArg(v)
+			if msg != "" {
+				return reflect.Value{}, msg
+			}
+			x := reflect.New(tp).Elem()
+			if n < 0 || x.OverflowUint(uint64(n)) {
+				return reflect.Value{}, "value out of range"
+			}
+			x.SetUint(uint64(n))
+			return x, ""
+		}, "number"
+	case reflect.Float32, reflect.Float64:
+		return func(v rt.Value) (reflect.Value, string) {
+			f, ok := rt.ToFloat(v)
+			if !ok {
+				return reflect.Value{}, typeError("number", v)
+			}
+			return reflect.ValueOf(f).Convert(tp), ""
+		}, "number"
+	case reflect.Slice:
+		if tp.Elem().Kind() == reflect.Uint8 {
+			return func(v rt.Value) (reflect.Value, string) {
+				s, ok := stringArg(v)
+				if !ok {
+					return reflect.Value{}, typeError("string", v)
+				}
+				return reflect.ValueOf([]byte(s)).Convert(tp), ""
+			}, "string"
+		}
+	}
+	return func(v rt.Value) (reflect.Value, string) {
+		x := reflect.New(tp)
+		if err := luaconv.Decode(v, x.Interface()); err != nil {
+			return reflect.Value{}, err.Error()
+		}
+		return x.Elem(), ""
+	}, ""
+}
+
+// Like the Lua standard library, accept numbers where strings are expected.
+func stringArg(v rt.Value) (string, bool) {
+	switch v.Type() {
+	case rt.StringType, rt.IntType, rt.FloatType:
+		return v.ToString()
+	}
+	return "", false
+}
+
+func intArg(v rt.Value) (int64, string) {
+	n, ok := rt.ToInt(v)
+	if ok {
+		return n, ""
+	}
+	if _, isNum := rt.ToFloat(v); isNum {
+		return 0, "number has no integer representation"
+	}
+	return 0, typeError("number", v)
+}
+
+func typeError(expected string, v rt.Value) string {
+	return fmt.Sprintf("%s expected, got %s", expected, typeName(v))
+}
@@ -0,0 +1,288 @@
+package luabind
+
+import (
+	"fmt"
+	"reflect"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// A Class describes how Go values of some type are exposed to Lua as userdata:
+// which methods and properties they have and which metamethods they implement.
+// Instances of the class made with New share a metatable, which is built the
+// first time it is needed in a Runtime and kept in its registry.  So a class
+// should be fully defined before it is used.
+//
+// Methods, property accessors and metamethods are Go functions whose first
+// parameter is the receiver.  The receiver accepts instances whose Go value is
+// assignable to its type, or which embed a value of its type in an exported
+// field (so that methods of a class work on instances of classes which extend
+// it).  Other parameters and results are converted as for Func.
+//
+// For example, given a Go type Counter with a field N, this makes a Lua class
+// where counters have a read-only property n and an incr method:
+//
+//	counterClass := luabind.NewClass("Counter").
+//		Property("n", func(c *Counter) int { return c.N }, nil).
+//		Method("incr", func(c *Counter, step int) { c.N += step }, luabind.Defaults(1))
+type Class struct {
+	Name       string
+	parent     *Class
+	methods    map[string]*rt.GoFunction
+	props      map[string]*property
+	metamethod map[string]*rt.GoFunction
+}
+
+type property struct {
+	get, set *rt.GoFunction
+}
+
+// NewClass returns a new class with no methods.  The name is used in error
+// messages and as the __name field of the metatable.
+func NewClass(name string) *Class {
+	return &Class{
+		Name:       name,
+		methods:    map[string]*rt.GoFunction{},
+		props:      map[string]*property{},
+		metamethod: map[string]*rt.GoFunction{},
+	}
+}
+
+// Extend makes c inherit the methods, properties and metamethods of parent
+// that it does not define itself.
+func (c *Class) Extend(parent *Class) *Class {
+	for p := parent; p != nil; p = p.parent {
+		if p == c {
+			panic(fmt.Sprintf("luabind: class %s cannot extend itself", c.Name))
+		}
+	}
+	c.parent = parent
+	return c
+}
+
+// Method adds a method to the class.  The receiver of fn is the first
+// argument, so the method is usually called with the obj:method(...) syntax.
+func (c *Class) Method(name string, fn interface{}, opts ...Option) *Class {
+	c.methods[name] = c.bind(name, fn, opts)
+	return c
+}
+
+// Property adds a property to the class.  Getting the property calls get,
+// which takes the receiver and returns the value of the property.  Setting it
+// calls set, which takes the receiver and the new value (and may return an
+// error).  If set is nil, the property is read-only.
+func (c *Class) Property(name string, get, set interface{}) *Class {
+	p := &property{get: c.bind(name, get, nil)}
+	if set != nil {
+		p.set = c.bind(name, set, nil)
+	}
+	c.props[name] = p
+	return c
+}
+
+// Metamethod sets a metamethod of the class, e.g. "__len" or "__call".  The
+// receiver of fn is the first argument of the metamethod.  It should not be
+// used for "__index" and "__newindex", which implement methods and properties.
+func (c *Class) Metamethod(event string, fn interface{}, opts ...Option) *Class {
+	c.metamethod[event] = c.bind(event, fn, opts)
+	return c
+}
+
+// ToString sets the __tostring metamethod of the class.  fn takes the receiver
+// and returns a string.
+func (c *Class) ToString(fn interface{}) *Class {
+	return c.Metamethod("__tostring", fn)
+}
+
+// Close sets the __close metamethod of the class, so that instances can be
+// to-be-closed variables.  fn takes the receiver and optionally the error
+// value which caused the variable to be closed (nil if there is none).
+func (c *Class) Close(fn interface{}) *Class {
+	return c.Metamethod("__close", fn)
+}
+
+// Eq sets the __eq metamethod of the class.  fn takes two receivers and
+// returns a boolean.  Instances are never equal to values which cannot be
+// converted to the type of its receivers.
+func (c *Class) Eq(fn interface{}) *Class {
+	b := c.newMethodBinding("__eq", fn)
+	if len(b.params) != 2 {
+		panic(fmt.Sprintf("luabind: __eq of %s must take two arguments", c.Name))
+	}
+	b.params[1] = c.receiverParam(b.params[1].tp)
+	f := b.goFunction(nil)
+	c.metamethod["__eq"] = rt.NewGoFunction(func(t *rt.Thread, gc *rt.GoCont) (rt.Cont, error) {
+		for i, p := range b.params {
+			if _, msg := p.convert(gc.Arg(i)); msg != "" {
+				return gc.PushingNext1(t.Runtime, rt.BoolValue(false)), nil
+			}
+		}
+		cont := f.Continuation(t, gc.Next())
+		t.Push1(cont, gc.Arg(0))
+		t.Push1(cont, gc.Arg(1))
+		return cont, nil
+	}, "__eq", 2, false)
+	return c
+}
+
+// New returns a new instance of the class in r, whose Go value is x.
+func (c *Class) New(r *rt.Runtime, x interface{}) rt.Value {
+	return rt.UserDataValue(rt.NewUserData(x, c.Metatable(r)))
+}
+
+// Metatable returns the metatable of instances of the class in r.
+func (c *Class) Metatable(r *rt.Runtime) *rt.Table {
+	key := rt.AsValue(c)
+	if meta, ok := r.Registry(key).TryTable(); ok {
+		return meta
+	}
+	meta := c.buildMetatable(r)
+	r.SetRegistry(key, rt.TableValue(meta))
+	return meta
+}
+
+func (c *Class) buildMetatable(r *rt.Runtime) *rt.Table {
+	var (
+		methods = r.NewTable()
+		props   = map[string]*property{}
+		meta    = r.NewTable()
+	)
+	// Go up the class hierarchy, only adding what is not already defined.
+	for cls := c; cls != nil; cls = cls.parent {
+		for name, f := range cls.methods {
+			if methods.Get(rt.StringValue(name)).IsNil() {
+				r.SetEnv(methods, name, rt.FunctionValue(f))
+			}
+		}
+		for name, p := range cls.props {
+			if _, ok := props[name]; !ok {
+				props[name] = p
+			}
+		}
+		for event, f := range cls.metamethod {
+			if meta.Get(rt.StringValue(event)).IsNil() {
+				r.SetEnv(meta, event, rt.FunctionValue(f))
+			}
+		}
+	}
+	r.SetEnv(meta, "__name", rt.StringValue(c.Name))
+	if len(props) == 0 {
+		r.SetEnv(meta, "__index", rt.TableValue(methods))
+	} else {
+		r.SetEnvGoFunc(meta, "__index", c.index(methods, props), 2, false)
+	}
+	r.SetEnvGoFunc(meta, "__newindex", c.newIndex(props), 3, false)
+	return meta
+}
+
+func (c *Class) index(methods *rt.Table, props map[string]*property) rt.GoFunctionFunc {
+	return func(t *rt.Thread, gc *rt.GoCont) (rt.Cont, error) {
+		if err := gc.CheckNArgs(2); err != nil {
+			return nil, err
+		}
+		key := gc.Arg(1)
+		if m := methods.Get(key); !m.IsNil() {
+			return gc.PushingNext1(t.Runtime, m), nil
+		}
+		if name, ok := key.TryString(); ok {
+			if p := props[name]; p != nil {
+				cont := p.get.Continuation(t, gc.Next())
+				t.Push1(cont, gc.Arg(0))
+				return cont, nil
+			}
+		}
+		return gc.PushingNext1(t.Runtime, rt.NilValue), nil
+	}
+}
+
+func (c *Class) newIndex(props map[string]*property) rt.GoFunctionFunc {
+	return func(t *rt.Thread, gc *rt.GoCont) (rt.Cont, error) {
+		if err := gc.CheckNArgs(3); err != nil {
+			return nil, err
+		}
+		name, _ := gc.Arg(1).ToString()
+		p := props[name]
+		if p == nil {
+			return nil, fmt.Errorf("%s has no property '%s'", c.Name, name)
+		}
+		if p.set == nil {
+			return nil, fmt.Errorf("property '%s' of %s is read-only", name, c.Name)
+		}
+		cont := p.set.Continuation(t, gc.Next())
+		t.Push1(cont, gc.Arg(0))
+		t.Push1(cont, gc.Arg(2))
+		return cont, nil
+	}
+}
+
+func (c *Class) bind(name string, fn interface{}, opts []Option) *rt.GoFunction {
+	return c.newMethodBinding(name, fn).goFunction(opts)
+}
+
+func (c *Class) newMethodBinding(name string, fn interface{}) *binding {
+	b := newBinding(name, reflect.ValueOf(fn))
+	if len(b.params) == 0 {
+		panic(fmt.Sprintf("luabind: %s of %s has no receiver", name, c.Name))
+	}
+	b.params[0] = c.receiverParam(b.params[0].tp)
+	return b
+}
+
+// Returns a parameter accepting instances whose Go value can be used as a
+// value of type tp.
+func (c *Class) receiverParam(tp reflect.Type) param {
+	return param{
+		tp:       tp,
+		expected: c.Name,
+		convert: func(v rt.Value) (reflect.Value, string) {
+			if u, ok := v.TryUserData(); ok {
+				if x, ok := receiver(reflect.ValueOf(u.Value()), tp); ok {
+					return x, ""
+				}
+			}
+			return reflect.Value{}, fmt.Sprintf("%s expected, got %s", c.Name, typeName(v))
+		},
+	}
+}
+
+// Returns x or a value of type tp embedded in x.
+func receiver(x reflect.Value, tp reflect.Type) (reflect.Value, bool) {
+	if !x.IsValid() {
+		return x, false
+	}
+	if x.Type().AssignableTo(tp) {
+		return x, true
+	}
+	if x.Kind() != reflect.Ptr || x.IsNil() || x.Elem().Kind() != reflect.Struct {
+		return x, false
+	}
+	s := x.Elem()
+	if s.Type().AssignableTo(tp) {
+		return s, true
+	}
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Type().Field(i)
+		if !f.Anonymous || !f.IsExported() {
+			continue
+		}
+		fv := s.Field(i)
+		if fv.Kind() == reflect.Struct {
+			fv = fv.Addr()
+		}
+		if r, ok := receiver(fv, tp); ok {
+			return r, true
+		}
+	}
+	return x, false
+}
+
+// Like the Lua standard library, use the __name field of the metatable of a
+// value if it has one.
+func typeName(v rt.Value) string {
+	if u, ok := v.TryUserData(); ok && u.Metatable() != nil {
+		if name, ok := u.Metatable().Get(rt.StringValue("__name")).TryString(); ok {
+			return name
+		}
+	}
+	return v.TypeName()
+}
@@ -0,0 +1,130 @@
+package luabind
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/golua/lib/base"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+type Shape struct {
+	Name   string
+	closed bool
+}
+
+type rect struct {
+	Shape
+	W, H float64
+}
+
+var (
+	shapeClass = NewClass("Shape").
+			Property("name", func(s *Shape) string { return s.Name }, func(s *Shape, name string) { s.Name = name }).
+			Property("closed", func(s *Shape) bool { return s.closed }, nil).
+			Method("describe", func(s *Shape, prefix string) string { return prefix + s.Name }, Defaults("shape ")).
+			ToString(func(s *Shape) string { return "shape " + s.Name }).
+			Close(func(s *Shape) { s.closed = true })
+
+	rectClass = NewClass("Rect").Extend(shapeClass).
+			Method("area", func(r *rect) float64 { return r.W * r.H }).
+			Method("scale", func(r *rect, k float64) *rect { return &rect{Shape: r.Shape, W: r.W * k, H: r.H * k} }).
+			Eq(func(r1, r2 *rect) bool { return r1.W == r2.W && r1.H == r2.H }).
+			ToString(func(r *rect) string { return fmt.Sprintf("rect %s %gx%g", r.Name, r.W, r.H) })
+)
+
+func newClassTestRuntime() *rt.Runtime {
+	r := rt.New(nil)
+	base.Load(r)
+	env := r.GlobalEnv()
+	SetFunc(r, env, "newshape", func(t *rt.Thread, name string) rt.Value {
+		return shapeClass.New(t.Runtime, &Shape{Name: name})
+	})
+	SetFunc(r, env, "newrect", func(t *rt.Thread, name string, w, h float64) rt.Value {
+		return rectClass.New(t.Runtime, &rect{Shape: Shape{Name: name}, W: w, H: h})
+	})
+	return r
+}
+
+func TestClass(t *testing.T) {
+	r := newClassTestRuntime()
+	tests := []struct {
+		src  string
+		want []rt.Value
+	}{
+		{`local s = newshape("blob") return s.name, s:describe(), s:describe("a ")`, []rt.Value{
+			rt.StringValue("blob"), rt.StringValue("shape blob"), rt.StringValue("a blob"),
+		}},
+		{`local s = newshape("blob") s.name = "blip" return s.name, tostring(s)`, []rt.Value{
+			rt.StringValue("blip"), rt.StringValue("shape blip"),
+		}},
+		{`local s = newshape("x") do local c <close> = s end return s.closed`, []rt.Value{rt.BoolValue(true)}},
+		{`return newshape("x").foo`, []rt.Value{rt.NilValue}},
+
+		// Inheritance
+		{`local r = newrect("r", 2, 3) return r.name, r:describe(), r:area(), tostring(r)`, []rt.Value{
+			rt.StringValue("r"), rt.StringValue("shape r"), rt.FloatValue(6), rt.StringValue("rect r 2x3"),
+		}},
+		{`local r = newrect("r", 2, 3) do local c <close> = r end return r.closed`, []rt.Value{rt.BoolValue(true)}},
+
+		// Equality
+		{`return newrect("a", 1, 2) == newrect("b", 1, 2), newrect("a", 1, 2) == newrect("a", 2, 1)`, []rt.Value{
+			rt.BoolValue(true), rt.BoolValue(false),
+		}},
+		{`return newrect("a", 1, 2) == newshape("a")`, []rt.Value{rt.BoolValue(false)}},
+	}
+	for _, test := range tests {
+		got := run(t, r, test.src)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %d values, want %d", test.src, len(got), len(test.want))
+			continue
+		}
+		for i, v := range got {
+			if v != test.want[i] {
+				t.Errorf("%s: value #%d is %v, want %v", test.src, i+1, v, test.want[i])
+			}
+		}
+	}
+}
+
+func TestClassErrors(t *testing.T) {
+	r := newClassTestRuntime()
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`newshape("s").closed = true`, "property 'closed' of Shape is read-only"},
+		{`newshape("s").foo = 1`, "Shape has no property 'foo'"},
+		{`newshape("s").name = {}`, "bad argument #2 to 'name' (string expected, got table)"},
+		{`local r = newrect("r", 1, 1) r.area(newshape("s"))`, "bad argument #1 to 'area' (Rect expected, got Shape)"},
+		{`local r = newrect("r", 1, 1) r.area(1)`, "bad argument #1 to 'area' (Rect expected, got number)"},
+		{`newrect("r", 1, 1):scale()`, "bad argument #2 to 'scale' (number expected, got no value)"},
+	}
+	for _, test := range tests {
+		got := run(t, r, `local ok, err = pcall(function() `+test.src+` end) return err`)
+		if len(got) != 1 || !strings.HasSuffix(got[0].AsString(), test.want) {
+			t.Errorf("%s: got %v, want error ending with %q", test.src, got, test.want)
+		}
+	}
+}
+
+func TestClassMetatable(t *testing.T) {
+	r1, r2 := rt.New(nil), rt.New(nil)
+	m1 := rectClass.Metatable(r1)
+	if rectClass.Metatable(r1) != m1 {
+		t.Error("metatable not cached")
+	}
+	if rectClass.Metatable(r2) == m1 {
+		t.Error("metatable shared between runtimes")
+	}
+	if m1.Get(rt.StringValue("__name")) != rt.StringValue("Rect") {
+		t.Error("__name not set")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for cyclic inheritance")
+		}
+	}()
+	shapeClass.Extend(rectClass)
+}
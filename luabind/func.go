@@ -0,0 +1,226 @@
+// Package luabind makes it easy to expose Go code to Lua.
+//
+// Func turns an ordinary Go function into a Lua function, checking and
+// converting its arguments and results so that the Go function does not need
+// to deal with Lua values.
+package luabind
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/arnodel/golua/luaconv"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// An Option configures a function made by Func.
+type Option func(*binding)
+
+// Defaults makes the last len(vals) parameters of the function optional.
+// When the corresponding argument is nil or missing, the parameter gets the
+// default value, which must be assignable to its type.
+func Defaults(vals ...interface{}) Option {
+	return func(b *binding) {
+		b.defaults = vals
+	}
+}
+
+// Comply declares that the function complies with the given flags (see
+// quotas.md and GoFunction.SolemnlyDeclareCompliance).
+func Comply(flags rt.ComplianceFlags) Option {
+	return func(b *binding) {
+		b.flags |= flags
+	}
+}
+
+// Func returns a Lua function with the given name which calls fn, a Go
+// function.  It panics if fn is not a function or if the options do not match
+// its signature.
+//
+// Arguments are converted to the types of the parameters of fn, raising
+// Lua-style errors such as "bad argument #2 to 'rep' (number expected, got
+// string)" when they cannot be.  Following the conventions of the Lua standard
+// library, numbers are accepted for strings and strings representing numbers
+// for numbers, and any value is accepted for a bool (with Lua's notion of
+// truth).  Parameters of type rt.Value receive the Lua value unchanged, and
+// parameters of other types (e.g. structs, maps or slices) are decoded with
+// luaconv.Decode.  If fn is variadic, extra arguments are converted to the
+// type of its last parameter.  If the first parameter of fn has type
+// *rt.Thread, it receives the thread calling the function rather than an
+// argument.
+//
+// The results of fn are converted to Lua values with luaconv.Encode (apart
+// from rt.Value results, which are returned unchanged).  If the last result
+// has type error, it is not returned but raised as a Lua error when it is not
+// nil.
+func Func(name string, fn interface{}, opts ...Option) *rt.GoFunction {
+	return newBinding(name, reflect.ValueOf(fn)).goFunction(opts)
+}
+
+// SetFunc sets t[name] to the Lua function Func(name, fn, opts...) and returns
+// it, in the same way as Runtime.SetEnvGoFunc.
+func SetFunc(r *rt.Runtime, t *rt.Table, name string, fn interface{}, opts ...Option) *rt.GoFunction {
+	f := Func(name, fn, opts...)
+	r.SetEnv(t, name, rt.FunctionValue(f))
+	return f
+}
+
+type binding struct {
+	name       string
+	fn         reflect.Value
+	withThread bool    // True if the first parameter is the calling thread
+	params     []param // Parameters which receive arguments
+	etc        *param  // The variadic parameter if any
+	returnsErr bool    // True if the last result is an error
+
+	// Set by options
+	defaults []interface{}
+	flags    rt.ComplianceFlags
+}
+
+type param struct {
+	tp       reflect.Type
+	convert  argConverter
+	expected string        // Name of the Lua type expected
+	def      reflect.Value // Default value (valid if the parameter is optional)
+}
+
+func newParam(tp reflect.Type) param {
+	convert, expected := argConverterFor(tp)
+	return param{tp: tp, convert: convert, expected: expected}
+}
+
+var (
+	threadType = reflect.TypeOf((*rt.Thread)(nil))
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+func newBinding(name string, fn reflect.Value) *binding {
+	if fn.Kind() != reflect.Func {
+		panic(fmt.Sprintf("luabind: %s is not a function", fn.Type()))
+	}
+	tp := fn.Type()
+	b := &binding{name: name, fn: fn}
+	n := tp.NumIn()
+	i := 0
+	if n > 0 && tp.In(0) == threadType {
+		b.withThread = true
+		i++
+	}
+	if tp.IsVariadic() {
+		n--
+		etc := newParam(tp.In(n).Elem())
+		b.etc = &etc
+	}
+	for ; i < n; i++ {
+		b.params = append(b.params, newParam(tp.In(i)))
+	}
+	nOut := tp.NumOut()
+	b.returnsErr = nOut > 0 && tp.Out(nOut-1) == errorType
+	return b
+}
+
+// Apply the options and make the Lua function.
+func (b *binding) goFunction(opts []Option) *rt.GoFunction {
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.applyDefaults()
+	f := rt.NewGoFunction(b.call, b.name, len(b.params), b.etc != nil)
+	if b.flags != 0 {
+		f.SolemnlyDeclareCompliance(b.flags)
+	}
+	return f
+}
+
+func (b *binding) applyDefaults() {
+	if len(b.defaults) > len(b.params) {
+		panic(fmt.Sprintf("luabind: %d defaults given for %d parameters of %s", len(b.defaults), len(b.params), b.name))
+	}
+	offset := len(b.params) - len(b.defaults)
+	for i, x := range b.defaults {
+		paramType := b.params[offset+i].tp
+		def := reflect.ValueOf(x)
+		if !def.IsValid() {
+			def = reflect.Zero(paramType)
+		}
+		if !def.Type().AssignableTo(paramType) {
+			panic(fmt.Sprintf("luabind: default value %v of %s cannot be assigned to %s", x, b.name, paramType))
+		}
+		b.params[offset+i].def = def
+	}
+}
+
+func (b *binding) call(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	in := make([]reflect.Value, 0, len(b.params)+1)
+	if b.withThread {
+		in = append(in, reflect.ValueOf(t))
+	}
+	for i, p := range b.params {
+		var arg rt.Value
+		present := i < c.NArgs()
+		if present {
+			arg = c.Arg(i)
+		}
+		if arg.IsNil() && p.def.IsValid() {
+			in = append(in, p.def)
+			continue
+		}
+		x, msg := p.convert(arg)
+		if msg != "" {
+			if !present {
+				msg = "value expected"
+				if p.expected != "" {
+					msg = p.expected + " expected, got no value"
+				}
+			}
+			return nil, b.argError(i, msg)
+		}
+		in = append(in, x)
+	}
+	if b.etc != nil {
+		for i, arg := range c.Etc() {
+			x, msg := b.etc.convert(arg)
+			if msg != "" {
+				return nil, b.argError(len(b.params)+i, msg)
+			}
+			in = append(in, x)
+		}
+	}
+	out := b.fn.Call(in)
+	if b.returnsErr {
+		last := out[len(out)-1]
+		if !last.IsNil() {
+			return nil, last.Interface().(error)
+		}
+		out = out[:len(out)-1]
+	}
+	next := c.Next()
+	for _, x := range out {
+		v, err := resultToValue(t.Runtime, x)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert result of '%s': %s", b.name, err)
+		}
+		t.Push1(next, v)
+	}
+	return next, nil
+}
+
+func (b *binding) argError(i int, msg string) error {
+	return fmt.Errorf("bad argument #%d to '%s' (%s)", i+1, b.name, msg)
+}
+
+func resultToValue(r *rt.Runtime, x reflect.Value) (rt.Value, error) {
+	switch x.Kind() {
+	case reflect.String:
+		r.RequireBytes(x.Len())
+		return rt.StringValue(x.String()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rt.IntValue(x.Int()), nil
+	case reflect.Float32, reflect.Float64:
+		return rt.FloatValue(x.Float()), nil
+	case reflect.Bool:
+		return rt.BoolValue(x.Bool()), nil
+	}
+	return luaconv.Encode(r, x.Interface())
+}
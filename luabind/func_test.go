@@ -0,0 +1,178 @@
+package luabind
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/golua/lib/base"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+type point struct {
+	X, Y int
+}
+
+func newTestRuntime() *rt.Runtime {
+	r := rt.New(nil)
+	base.Load(r)
+	env := r.GlobalEnv()
+	SetFunc(r, env, "rep", func(s string, n int64, sep string) string {
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = s
+		}
+		return strings.Join(parts, sep)
+	}, Defaults(""))
+	SetFunc(r, env, "sum", func(xs ...float64) float64 {
+		var s float64
+		for _, x := range xs {
+			s += x
+		}
+		return s
+	})
+	SetFunc(r, env, "div", func(a, b int) (int, int, error) {
+		if b == 0 {
+			return 0, 0, errors.New("division by zero")
+		}
+		return a / b, a % b, nil
+	})
+	SetFunc(r, env, "norm1", func(p point) int {
+		return abs(p.X) + abs(p.Y)
+	})
+	SetFunc(r, env, "mirror", func(p *point) *point {
+		return &point{X: p.Y, Y: p.X}
+	})
+	SetFunc(r, env, "typeof", func(v rt.Value) string {
+		return v.TypeName()
+	})
+	SetFunc(r, env, "apply", func(t *rt.Thread, f rt.Callable, args ...rt.Value) (rt.Value, error) {
+		res := rt.NewTerminationWith(nil, 1, false)
+		if err := rt.Call(t, rt.FunctionValue(f), args, res); err != nil {
+			return rt.NilValue, err
+		}
+		return res.Get(0), nil
+	})
+	SetFunc(r, env, "small", func(n uint8, flag bool) (uint8, bool) {
+		return n, !flag
+	})
+	return r
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func run(t *testing.T, r *rt.Runtime, src string) []rt.Value {
+	t.Helper()
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte(src), rt.TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := rt.NewTerminationWith(nil, 0, true)
+	if err := rt.Call(r.MainThread(), rt.FunctionValue(clos), nil, res); err != nil {
+		t.Fatal(err)
+	}
+	return res.Etc()
+}
+
+func TestFunc(t *testing.T) {
+	r := newTestRuntime()
+	tests := []struct {
+		src  string
+		want []rt.Value
+	}{
+		{`return rep("ab", 3)`, []rt.Value{rt.StringValue("ababab")}},
+		{`return rep("ab", "2", ", ")`, []rt.Value{rt.StringValue("ab, ab")}},
+		{`return rep(12, 2.0, nil)`, []rt.Value{rt.StringValue("1212")}},
+		{`return sum()`, []rt.Value{rt.FloatValue(0)}},
+		{`return sum(1, 2.5, "3")`, []rt.Value{rt.FloatValue(6.5)}},
+		{`return div(7, 2)`, []rt.Value{rt.IntValue(3), rt.IntValue(1)}},
+		{`return norm1({X=3, Y=-4})`, []rt.Value{rt.IntValue(7)}},
+		{`local p = mirror({X=1, Y=2}) return p.X, p.Y`, []rt.Value{rt.IntValue(2), rt.IntValue(1)}},
+		{`return typeof(), typeof({})`, []rt.Value{rt.StringValue("nil"), rt.StringValue("table")}},
+		{`return apply(function(a, b) return a .. b end, "x", "y")`, []rt.Value{rt.StringValue("xy")}},
+		{`return small(255)`, []rt.Value{rt.IntValue(255), rt.BoolValue(true)}},
+	}
+	for _, test := range tests {
+		got := run(t, r, test.src)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %d values, want %d", test.src, len(got), len(test.want))
+			continue
+		}
+		for i, v := range got {
+			if v != test.want[i] {
+				t.Errorf("%s: value #%d is %v, want %v", test.src, i+1, v, test.want[i])
+			}
+		}
+	}
+}
+
+func TestFuncErrors(t *testing.T) {
+	r := newTestRuntime()
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`rep("x", "y")`, "bad argument #2 to 'rep' (number expected, got string)"},
+		{`rep("x")`, "bad argument #2 to 'rep' (number expected, got no value)"},
+		{`rep("x", 1.5)`, "bad argument #2 to 'rep' (number has no integer representation)"},
+		{`rep({}, 1)`, "bad argument #1 to 'rep' (string expected, got table)"},
+		{`rep("x", 1, false)`, "bad argument #3 to 'rep' (string expected, got boolean)"},
+		{`sum(1, 2, {})`, "bad argument #3 to 'sum' (number expected, got table)"},
+		{`div(1, 0)`, "division by zero"},
+		{`norm1({X="a"})`, `bad argument #1 to 'norm1' (X: expected integer, got string)`},
+		{`small(256)`, "bad argument #1 to 'small' (value out of range)"},
+		{`apply(1)`, "bad argument #1 to 'apply' (function expected, got number)"},
+	}
+	for _, test := range tests {
+		got := run(t, r, `local ok, err = pcall(function() `+test.src+` end) return err`)
+		if len(got) != 1 || !strings.HasSuffix(got[0].AsString(), test.want) {
+			t.Errorf("%s: got %v, want error ending with %q", test.src, got, test.want)
+		}
+	}
+}
+
+func TestFuncPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   interface{}
+		opts []Option
+	}{
+		{"notfunc", 42, nil},
+		{"toomany", func(int) {}, []Option{Defaults(1, 2)}},
+		{"badtype", func(int) {}, []Option{Defaults("x")}},
+	}
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected panic", test.name)
+				}
+			}()
+			Func(test.name, test.fn, test.opts...)
+		}()
+	}
+}
+
+func TestComply(t *testing.T) {
+	r := rt.New(nil)
+	flags := rt.ComplyCpuSafe | rt.ComplyMemSafe
+	safe := Func("safe", func(x int) int { return x }, Comply(flags))
+	unsafe := Func("unsafe", func(x int) int { return x })
+	call := func(f *rt.GoFunction) error {
+		_, err := r.MainThread().CallContext(rt.RuntimeContextDef{RequiredFlags: flags}, func() error {
+			return rt.Call(r.MainThread(), rt.FunctionValue(f), []rt.Value{rt.IntValue(1)}, rt.NewTerminationWith(nil, 1, false))
+		})
+		return err
+	}
+	if err := call(safe); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := call(unsafe); err == nil {
+		t.Error("expected error calling a function which does not comply")
+	}
+}
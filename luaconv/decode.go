@@ -0,0 +1,330 @@
+package luaconv
+
+import (
+	"errors"
+	"reflect"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// Decode converts the Lua value v into the Go value that out points to (see
+// the package documentation for the conversion rules).  Decoding into an empty
+// interface gives nil, bool, int64, float64 or string for the corresponding Lua
+// values, []interface{} for non-empty sequences, map[string]interface{} for
+// tables with string keys, map[interface{}]interface{} for other tables and
+// the Go value of userdata.  Other values (e.g. functions) are kept as
+// rt.Value.
+//
+// Struct fields whose key is absent from the table are left unchanged.
+func Decode(v rt.Value, out interface{}) error {
+	dst := reflect.ValueOf(out)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return errors.New("luaconv: Decode requires a non-nil pointer")
+	}
+	d := decoder{shared: map[sharedKey]reflect.Value{}, decoding: map[*rt.Table]bool{}}
+	if t, ok := v.TryTable(); ok {
+		d.shared[sharedKey{t, dst.Type()}] = dst
+	}
+	return d.decode(v, dst.Elem(), nil)
+}
+
+type decoder struct {
+	// Go values already decoded from tables, when they can be shared (pointers
+	// and maps).
+	shared map[sharedKey]reflect.Value
+
+	// Tables being decoded into values which cannot be shared, to detect
+	// cycles.
+	decoding map[*rt.Table]bool
+}
+
+type sharedKey struct {
+	t  *rt.Table
+	tp reflect.Type
+}
+
+var emptyInterfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+func (d *decoder) decode(v rt.Value, dst reflect.Value, p *path) error {
+	tp := dst.Type()
+	if tp == valueType {
+		dst.Set(reflect.ValueOf(v))
+		return nil
+	}
+	if u, ok := v.TryUserData(); ok {
+		gv := reflect.ValueOf(u.Value())
+		if gv.IsValid() && gv.Type().AssignableTo(tp) {
+			dst.Set(gv)
+			return nil
+		}
+		return p.errorf("cannot decode userdata of type %T into %s", u.Value(), tp)
+	}
+	if v.IsNil() {
+		dst.Set(reflect.Zero(tp))
+		return nil
+	}
+	switch tp.Kind() {
+	case reflect.Bool:
+		b, ok := v.TryBool()
+		if !ok {
+			return p.typeError("boolean", v)
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := rt.ToIntNoString(v)
+		if !ok {
+			return p.typeError("integer", v)
+		}
+		if dst.OverflowInt(n) {
+			return p.errorf("%d out of range for %s", n, tp)
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := rt.ToIntNoString(v)
+		if !ok {
+			return p.typeError("integer", v)
+		}
+		if n < 0 || dst.OverflowUint(uint64(n)) {
+			return p.errorf("%d out of range for %s", n, tp)
+		}
+		dst.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		if n, ok := v.TryInt(); ok {
+			dst.SetFloat(float64(n))
+		} else if f, ok := v.TryFloat(); ok {
+			dst.SetFloat(f)
+		} else {
+			return p.typeError("number", v)
+		}
+	case reflect.String:
+		s, ok := v.TryString()
+		if !ok {
+			return p.typeError("string", v)
+		}
+		dst.SetString(s)
+	case reflect.Slice:
+		if tp.Elem().Kind() == reflect.Uint8 {
+			s, ok := v.TryString()
+			if !ok {
+				return p.typeError("string", v)
+			}
+			dst.SetBytes([]byte(s))
+			return nil
+		}
+		t, err := d.table(v, tp, p)
+		if err != nil {
+			return err
+		}
+		defer d.done(t)
+		n := int(t.Len())
+		s := reflect.MakeSlice(tp, n, n)
+		if err := d.decodeSequence(t, s, p); err != nil {
+			return err
+		}
+		dst.Set(s)
+	case reflect.Array:
+		t, err := d.table(v, tp, p)
+		if err != nil {
+			return err
+		}
+		defer d.done(t)
+		if n := int(t.Len()); n > dst.Len() {
+			return p.errorf("sequence of length %d too long for %s", n, tp)
+		}
+		return d.decodeSequence(t, dst, p)
+	case reflect.Struct:
+		t, err := d.table(v, tp, p)
+		if err != nil {
+			return err
+		}
+		defer d.done(t)
+		for _, f := range structFields(tp) {
+			fv := t.Get(rt.StringValue(f.name))
+			if fv.IsNil() {
+				continue
+			}
+			if err := d.decode(fv, dst.FieldByIndex(f.index), p.withField(f.name)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		t, ok := v.TryTable()
+		if !ok {
+			return p.typeError("table", v)
+		}
+		if m, ok := d.shared[sharedKey{t, tp}]; ok {
+			dst.Set(m)
+			return nil
+		}
+		m := reflect.MakeMap(tp)
+		d.shared[sharedKey{t, tp}] = m
+		if err := d.decodeMap(t, m, p); err != nil {
+			return err
+		}
+		dst.Set(m)
+	case reflect.Ptr:
+		t, isTable := v.TryTable()
+		if isTable {
+			if ptr, ok := d.shared[sharedKey{t, tp}]; ok {
+				dst.Set(ptr)
+				return nil
+			}
+		}
+		ptr := reflect.New(tp.Elem())
+		if isTable {
+			d.shared[sharedKey{t, tp}] = ptr
+		}
+		if err := d.decode(v, ptr.Elem(), p); err != nil {
+			return err
+		}
+		dst.Set(ptr)
+	case reflect.Interface:
+		if tp.NumMethod() > 0 {
+			return p.errorf("cannot decode %s into %s", v.TypeName(), tp)
+		}
+		x, err := d.decodeAny(v, p)
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			dst.Set(reflect.Zero(tp))
+		} else {
+			dst.Set(reflect.ValueOf(x))
+		}
+	default:
+		return p.errorf("cannot decode into %s", tp)
+	}
+	return nil
+}
+
+// Return the table v, marking it as being decoded into a value of type tp,
+// which cannot be shared.  The caller should call d.done(t) when it has
+// finished decoding it.
+func (d *decoder) table(v rt.Value, tp reflect.Type, p *path) (*rt.Table, error) {
+	t, ok := v.TryTable()
+	if !ok {
+		return nil, p.typeError("table", v)
+	}
+	if d.decoding[t] {
+		return nil, p.errorf("cyclic table cannot be decoded into %s", tp)
+	}
+	d.decoding[t] = true
+	return t, nil
+}
+
+func (d *decoder) done(t *rt.Table) {
+	delete(d.decoding, t)
+}
+
+func (d *decoder) decodeSequence(t *rt.Table, dst reflect.Value, p *path) error {
+	for i := 0; i < dst.Len(); i++ {
+		item := t.Get(rt.IntValue(int64(i + 1)))
+		if err := d.decode(item, dst.Index(i), p.withIndex(i+1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) decodeMap(t *rt.Table, m reflect.Value, p *path) error {
+	tp := m.Type()
+	var k, v rt.Value
+	var ok bool
+	for {
+		k, v, ok = t.Next(k)
+		if !ok || k.IsNil() {
+			return nil
+		}
+		kp := p.withKey(k.Interface())
+		gk := reflect.New(tp.Key()).Elem()
+		if tp.Key() == emptyInterfaceType && k.Type() == rt.TableType {
+			// Go maps cannot be map keys, so keep the table as is.
+			gk.Set(reflect.ValueOf(k))
+		} else if err := d.decode(k, gk, kp); err != nil {
+			return err
+		}
+		gv := reflect.New(tp.Elem()).Elem()
+		if err := d.decode(v, gv, kp); err != nil {
+			return err
+		}
+		m.SetMapIndex(gk, gv)
+	}
+}
+
+var (
+	stringMapType = reflect.TypeOf(map[string]interface{}(nil))
+	anyMapType    = reflect.TypeOf(map[interface{}]interface{}(nil))
+	anySliceType  = reflect.TypeOf([]interface{}(nil))
+)
+
+// Decode v into an empty interface.
+func (d *decoder) decodeAny(v rt.Value, p *path) (interface{}, error) {
+	switch v.Type() {
+	case rt.NilType:
+		return nil, nil
+	case rt.BoolType:
+		return v.AsBool(), nil
+	case rt.IntType:
+		return v.AsInt(), nil
+	case rt.FloatType:
+		return v.AsFloat(), nil
+	case rt.StringType:
+		return v.AsString(), nil
+	case rt.UserDataType:
+		return v.AsUserData().Value(), nil
+	case rt.TableType:
+		t := v.AsTable()
+		tp := anyMapType
+		if isSequence(t) {
+			tp = anySliceType
+		} else if hasStringKeys(t) {
+			tp = stringMapType
+		}
+		x := reflect.New(tp).Elem()
+		if err := d.decode(v, x, p); err != nil {
+			return nil, err
+		}
+		return x.Interface(), nil
+	default:
+		return v, nil
+	}
+}
+
+// Returns true if t is a non-empty sequence.
+func isSequence(t *rt.Table) bool {
+	n := t.Len()
+	if n == 0 {
+		return false
+	}
+	var count int64
+	var k rt.Value
+	for {
+		var ok bool
+		k, _, ok = t.Next(k)
+		if !ok || k.IsNil() {
+			return count == n
+		}
+		if i, isInt := k.TryInt(); !isInt || i < 1 || i > n {
+			return false
+		}
+		count++
+	}
+}
+
+func hasStringKeys(t *rt.Table) bool {
+	var k rt.Value
+	for {
+		var ok bool
+		k, _, ok = t.Next(k)
+		if !ok || k.IsNil() {
+			return true
+		}
+		if _, isString := k.TryString(); !isString {
+			return false
+		}
+	}
+}
+
+func (p *path) typeError(expected string, v rt.Value) error {
+	return p.errorf("expected %s, got %s", expected, v.TypeName())
+}
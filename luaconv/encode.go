@@ -0,0 +1,176 @@
+package luaconv
+
+import (
+	"math"
+	"reflect"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// Encode converts the Go value x to a Lua value (see the package documentation
+// for the conversion rules).  Tables are created in the runtime r, which
+// accounts for the memory they require.
+func Encode(r *rt.Runtime, x interface{}) (rt.Value, error) {
+	e := encoder{r: r, tables: map[refKey]*rt.Table{}}
+	return e.encode(reflect.ValueOf(x), nil)
+}
+
+type encoder struct {
+	r *rt.Runtime
+
+	// Tables already encoded for Go values which can be shared (pointers, maps
+	// and slices).
+	tables map[refKey]*rt.Table
+}
+
+// Identifies a Go value which can be referenced more than once.
+type refKey struct {
+	ptr uintptr
+	tp  reflect.Type
+	len int
+}
+
+func (e *encoder) encode(v reflect.Value, p *path) (rt.Value, error) {
+	if !v.IsValid() {
+		return rt.NilValue, nil
+	}
+	if v.CanInterface() {
+		switch x := v.Interface().(type) {
+		case rt.Value:
+			return x, nil
+		case *rt.Table:
+			if x == nil {
+				return rt.NilValue, nil
+			}
+			return rt.TableValue(x), nil
+		case *rt.UserData:
+			if x == nil {
+				return rt.NilValue, nil
+			}
+			return rt.UserDataValue(x), nil
+		}
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return rt.BoolValue(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rt.IntValue(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := v.Uint()
+		if n > math.MaxInt64 {
+			return rt.NilValue, p.errorf("%d does not fit in a Lua integer", n)
+		}
+		return rt.IntValue(int64(n)), nil
+	case reflect.Float32, reflect.Float64:
+		return rt.FloatValue(v.Float()), nil
+	case reflect.String:
+		e.r.RequireBytes(v.Len())
+		return rt.StringValue(v.String()), nil
+	case reflect.Interface:
+		if v.IsNil() {
+			return rt.NilValue, nil
+		}
+		return e.encode(v.Elem(), p)
+	case reflect.Ptr:
+		if v.IsNil() {
+			return rt.NilValue, nil
+		}
+		elem := v.Elem()
+		switch elem.Kind() {
+		case reflect.Struct:
+			return e.encodeShared(v, refKey{ptr: v.Pointer(), tp: v.Type()}, p, func(t *rt.Table) error {
+				return e.fillStruct(t, elem, p)
+			})
+		case reflect.Array:
+			return e.encodeShared(v, refKey{ptr: v.Pointer(), tp: v.Type()}, p, func(t *rt.Table) error {
+				return e.fillSequence(t, elem, p)
+			})
+		}
+		return e.encode(elem, p)
+	case reflect.Slice:
+		if v.IsNil() {
+			return rt.NilValue, nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.r.RequireBytes(v.Len())
+			return rt.StringValue(string(v.Bytes())), nil
+		}
+		return e.encodeShared(v, refKey{ptr: v.Pointer(), tp: v.Type(), len: v.Len()}, p, func(t *rt.Table) error {
+			return e.fillSequence(t, v, p)
+		})
+	case reflect.Map:
+		if v.IsNil() {
+			return rt.NilValue, nil
+		}
+		return e.encodeShared(v, refKey{ptr: v.Pointer(), tp: v.Type()}, p, func(t *rt.Table) error {
+			return e.fillMap(t, v, p)
+		})
+	case reflect.Array:
+		t := e.r.NewTable()
+		return rt.TableValue(t), e.fillSequence(t, v, p)
+	case reflect.Struct:
+		t := e.r.NewTable()
+		return rt.TableValue(t), e.fillStruct(t, v, p)
+	}
+	return rt.NilValue, p.errorf("cannot encode value of type %s", v.Type())
+}
+
+// Encode a value which may be referenced more than once.  The table is
+// registered before it is filled so that cycles terminate.
+func (e *encoder) encodeShared(v reflect.Value, key refKey, p *path, fill func(*rt.Table) error) (rt.Value, error) {
+	if t, ok := e.tables[key]; ok {
+		return rt.TableValue(t), nil
+	}
+	t := e.r.NewTable()
+	e.tables[key] = t
+	if err := fill(t); err != nil {
+		return rt.NilValue, err
+	}
+	return rt.TableValue(t), nil
+}
+
+func (e *encoder) fillSequence(t *rt.Table, v reflect.Value, p *path) error {
+	for i := 0; i < v.Len(); i++ {
+		x, err := e.encode(v.Index(i), p.withIndex(i+1))
+		if err != nil {
+			return err
+		}
+		e.r.SetTable(t, rt.IntValue(int64(i+1)), x)
+	}
+	return nil
+}
+
+func (e *encoder) fillMap(t *rt.Table, v reflect.Value, p *path) error {
+	iter := v.MapRange()
+	for iter.Next() {
+		k, err := e.encode(iter.Key(), p)
+		if err != nil {
+			return err
+		}
+		kp := p.withKey(k.Interface())
+		if k.IsNil() || k.IsNaN() {
+			return kp.errorf("cannot use %s as a table key", k.TypeName())
+		}
+		x, err := e.encode(iter.Value(), kp)
+		if err != nil {
+			return err
+		}
+		e.r.SetTable(t, k, x)
+	}
+	return nil
+}
+
+func (e *encoder) fillStruct(t *rt.Table, v reflect.Value, p *path) error {
+	for _, f := range structFields(v.Type()) {
+		fv := v.FieldByIndex(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		x, err := e.encode(fv, p.withField(f.name))
+		if err != nil {
+			return err
+		}
+		e.r.SetTable(t, rt.StringValue(f.name), x)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+// Package luaconv converts between Go values and Lua values.
+//
+// Encode turns a Go value into a Lua value, and Decode turns a Lua value into
+// a typed Go value.  The conversion rules are as follows.
+//
+//   - Booleans, integers, floats and strings map to the Lua types of the same
+//     kind.  Byte slices map to strings.
+//   - Slices and arrays map to sequences (tables with keys 1, 2, ..., n).
+//   - Maps map to tables with the converted keys and values.
+//   - Structs map to tables with one key per exported field.  The key is the
+//     name of the field, unless the field has a "lua" tag giving a different
+//     name.  As with encoding/json, the tag "-" omits the field and the
+//     "omitempty" option omits it when encoding a zero value.  The fields of
+//     embedded structs are promoted to the enclosing table.
+//   - Pointers and interfaces map to the value they point to or contain, nil
+//     mapping to nil.
+//   - rt.Value is left unchanged.
+//
+// Go values referenced more than once (through pointers, maps or slices) are
+// encoded as one table, so cyclic data structures become cyclic tables.
+// Conversely, a table decoded more than once into a pointer or a map gives the
+// same Go value, so cyclic tables can be decoded into types that allow cycles.
+//
+// Conversion errors are of type *Error and give the path to the value that
+// could not be converted, e.g. "servers[2].port: expected integer, got
+// string".
+package luaconv
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/arnodel/golua/luastrings"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// Error is the type of errors returned by Encode and Decode.
+type Error struct {
+	Path string // Path to the value which could not be converted (empty for the root value)
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// A path to a value, built as the conversion recurses.  It is only turned
+// into a string when an error occurs.
+type path struct {
+	parent *path
+	field  string      // Set for struct fields
+	index  int         // Set for slice items (the Lua index, starting from 1)
+	key    interface{} // Set for map keys (the Go value of a Lua key)
+}
+
+func (p *path) withField(name string) *path {
+	return &path{parent: p, field: name}
+}
+
+func (p *path) withIndex(i int) *path {
+	return &path{parent: p, index: i}
+}
+
+func (p *path) withKey(k interface{}) *path {
+	return &path{parent: p, key: k}
+}
+
+func (p *path) String() string {
+	if p == nil {
+		return ""
+	}
+	var b strings.Builder
+	p.write(&b)
+	return b.String()
+}
+
+func (p *path) write(b *strings.Builder) {
+	if p == nil {
+		return
+	}
+	p.parent.write(b)
+	switch {
+	case p.field != "":
+		if p.parent != nil {
+			b.WriteByte('.')
+		}
+		b.WriteString(p.field)
+	case p.key != nil:
+		switch k := p.key.(type) {
+		case string:
+			b.WriteByte('[')
+			b.WriteString(luastrings.Quote(k, '"'))
+			b.WriteByte(']')
+		case int64, float64, bool:
+			fmt.Fprintf(b, "[%v]", k)
+		default:
+			b.WriteString("[?]")
+		}
+	default:
+		fmt.Fprintf(b, "[%d]", p.index)
+	}
+}
+
+func (p *path) errorf(format string, args ...interface{}) error {
+	return &Error{Path: p.String(), Err: fmt.Errorf(format, args...)}
+}
+
+// A struct field that can be converted.
+type field struct {
+	name      string
+	index     []int // For reflect.Value.FieldByIndex
+	omitEmpty bool
+}
+
+// Return the fields of a struct type which can be converted, including the
+// promoted fields of embedded structs.  As with encoding/json, fields at a
+// shallower depth take precedence.
+func structFields(tp reflect.Type) []field {
+	var fields []field
+	seen := map[string]bool{}
+	var collect func(tp reflect.Type, index []int)
+	var embedded []func()
+	collect = func(tp reflect.Type, index []int) {
+		for i := 0; i < tp.NumField(); i++ {
+			f := tp.Field(i)
+			tag := f.Tag.Get("lua")
+			if tag == "-" {
+				continue
+			}
+			name, opts := tag, ""
+			if j := strings.IndexByte(tag, ','); j >= 0 {
+				name, opts = tag[:j], tag[j+1:]
+			}
+			fieldIndex := append(append([]int(nil), index...), i)
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				embedded = append(embedded, func() {
+					collect(f.Type, fieldIndex)
+				})
+				continue
+			}
+			if f.PkgPath != "" {
+				// Unexported field
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			fields = append(fields, field{
+				name:      name,
+				index:     fieldIndex,
+				omitEmpty: opts == "omitempty",
+			})
+		}
+	}
+	collect(tp, nil)
+	// Breadth first, so that shallower fields are seen first.
+	for len(embedded) > 0 {
+		next := embedded
+		embedded = nil
+		for _, f := range next {
+			f()
+		}
+	}
+	return fields
+}
+
+var valueType = reflect.TypeOf(rt.Value{})
@@ -0,0 +1,187 @@
+package luaconv
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+type server struct {
+	Host    string `lua:"host"`
+	Port    uint16 `lua:"port"`
+	Tags    []string
+	Comment string `lua:",omitempty"`
+	secret  string
+	Ignored int `lua:"-"`
+}
+
+type config struct {
+	Name    string
+	Servers []*server `lua:"servers"`
+	Limits  map[string]float64
+	Extra   interface{}
+	Meta
+}
+
+type Meta struct {
+	Version int32
+}
+
+type node struct {
+	Value int
+	Next  *node
+}
+
+type graph map[string]graph
+
+func eval(t *testing.T, r *rt.Runtime, src string) rt.Value {
+	t.Helper()
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte("return "+src), rt.TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := rt.NewTerminationWith(nil, 1, false)
+	if err := rt.Call(r.MainThread(), rt.FunctionValue(clos), nil, res); err != nil {
+		t.Fatal(err)
+	}
+	return res.Get(0)
+}
+
+func TestRoundTrip(t *testing.T) {
+	r := rt.New(nil)
+	cfg := config{
+		Name: "prod",
+		Servers: []*server{
+			{Host: "a", Port: 80, Tags: []string{"x", "y"}, secret: "s", Ignored: 3},
+			{Host: "b", Port: 8080, Comment: "backup"},
+		},
+		Limits: map[string]float64{"cpu": 1.5},
+		Extra:  []interface{}{int64(1), "two"},
+		Meta:   Meta{Version: 3},
+	}
+	v, err := Encode(r, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl := v.AsTable()
+	s1 := tbl.Get(rt.StringValue("servers")).AsTable().Get(rt.IntValue(1)).AsTable()
+	if s1.Get(rt.StringValue("host")) != rt.StringValue("a") || s1.Get(rt.StringValue("port")) != rt.IntValue(80) {
+		t.Errorf("unexpected server table")
+	}
+	for _, k := range []string{"Comment", "secret", "Ignored"} {
+		if !s1.Get(rt.StringValue(k)).IsNil() {
+			t.Errorf("field %s should not be encoded", k)
+		}
+	}
+	if tbl.Get(rt.StringValue("Version")) != rt.IntValue(3) {
+		t.Errorf("embedded field not promoted")
+	}
+
+	var got config
+	if err := Decode(v, &got); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Servers[0].secret = ""
+	cfg.Servers[0].Ignored = 0
+	if !reflect.DeepEqual(got, cfg) {
+		t.Errorf("got %+v, want %+v", got, cfg)
+	}
+}
+
+func TestDecodeLua(t *testing.T) {
+	r := rt.New(nil)
+	v := eval(t, r, `{Name="dev", servers={{host="h", port=22, Tags={"ssh"}}}, Extra={a=1, b={true, 2.5}}}`)
+	var cfg config
+	if err := Decode(v, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "dev" || len(cfg.Servers) != 1 || cfg.Servers[0].Port != 22 || cfg.Servers[0].Tags[0] != "ssh" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	want := map[string]interface{}{"a": int64(1), "b": []interface{}{true, 2.5}}
+	if !reflect.DeepEqual(cfg.Extra, want) {
+		t.Errorf("got extra %#v, want %#v", cfg.Extra, want)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	r := rt.New(nil)
+	tests := []struct {
+		src  string
+		out  interface{}
+		want string
+	}{
+		{`{servers={{port=80}, {port="http"}}}`, new(config), `servers[2].port: expected integer, got string`},
+		{`{servers={{port=70000}}}`, new(config), `servers[1].port: 70000 out of range for uint16`},
+		{`{Limits={cpu={}}}`, new(config), `Limits["cpu"]: expected number, got table`},
+		{`{[true]=1}`, new(map[string]int), `[true]: expected string, got boolean`},
+		{`1.5`, new(int), `expected integer, got number`},
+		{`"x"`, new(config), `expected table, got string`},
+		{`{1, 2, 3}`, new([2]int), `sequence of length 3 too long for [2]int`},
+	}
+	for _, test := range tests {
+		err := Decode(eval(t, r, test.src), test.out)
+		var convErr *Error
+		if !errors.As(err, &convErr) {
+			t.Errorf("%s: expected *Error, got %v", test.src, err)
+			continue
+		}
+		if err.Error() != test.want {
+			t.Errorf("%s: got error %q, want %q", test.src, err, test.want)
+		}
+	}
+	if err := Decode(rt.NilValue, config{}); err == nil {
+		t.Error("expected error when not decoding into a pointer")
+	}
+}
+
+func TestCycles(t *testing.T) {
+	r := rt.New(nil)
+	n := &node{Value: 1}
+	n.Next = &node{Value: 2, Next: n}
+	v, err := Encode(r, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl := v.AsTable()
+	if tbl.Get(rt.StringValue("Next")).AsTable().Get(rt.StringValue("Next")).AsTable() != tbl {
+		t.Error("cycle not encoded")
+	}
+
+	var got node
+	if err := Decode(v, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Value != 1 || got.Next.Value != 2 || got.Next.Next != &got {
+		t.Errorf("cycle not decoded: %+v", got)
+	}
+
+	// A cyclic table cannot be decoded into a slice.
+	cyclic := eval(t, r, `(function() local t = {} t[1] = t return t end)()`)
+	var s []interface{}
+	if err := Decode(cyclic, &s); err == nil {
+		t.Error("expected error decoding a cyclic table into a slice")
+	}
+	// It can be decoded into a map.
+	var m graph
+	if err := Decode(eval(t, r, `(function() local t = {} t.self = t return t end)()`), &m); err != nil {
+		t.Fatal(err)
+	}
+	if reflect.ValueOf(m["self"]).Pointer() != reflect.ValueOf(m).Pointer() {
+		t.Errorf("cycle not decoded: %v", m)
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	r := rt.New(nil)
+	_, err := Encode(r, map[string]interface{}{"f": func() {}})
+	if err == nil || err.Error() != `["f"]: cannot encode value of type func()` {
+		t.Errorf("unexpected error %v", err)
+	}
+	_, err = Encode(r, []uint64{1, 1 << 63})
+	if err == nil || err.Error() != `[2]: 9223372036854775808 does not fit in a Lua integer` {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -5,13 +5,20 @@ import (
 	"fmt"
 	"io/fs"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/arnodel/golua/coverage"
 	"github.com/arnodel/golua/runtime"
 )
 
+// CoverageDirEnvVar is the environment variable which, when set to a
+// directory, makes RunLuaTestsInDir collect the coverage of the tests it runs
+// and write it to that directory in LCOV format.
+const CoverageDirEnvVar = "GOLUA_COVERAGE_DIR"
+
 // RunSource compiles and runs some source code, outputting to the
 // provided io.Writer.
 func RunSource(r *runtime.Runtime, source []byte) {
@@ -22,7 +29,7 @@ func RunSource(r *runtime.Runtime, source []byte) {
 		fmt.Fprintf(r.Stdout, "!!! parsing: %s", err)
 		return
 	}
-	cerr := runtime.Call(t, runtime.FunctionValue(clos), nil, runtime.NewTerminationWith(nil, 0, false))
+	cerr := r.SafeCall(runtime.FunctionValue(clos), nil, runtime.NewTerminationWith(nil, 0, false))
 	if cerr != nil {
 		fmt.Fprintf(r.Stdout, "!!! runtime: %s", cerr)
 	}
@@ -48,6 +55,7 @@ func RunLuaTestFile(t *testing.T, path string, setup func(*runtime.Runtime) func
 		return
 	}
 	isQuotasTest := strings.HasSuffix(path, ".quotas.lua")
+	isWeakTest := strings.HasSuffix(path, ".weak.lua")
 	t.Run(path, func(t *testing.T) {
 		if isQuotasTest {
 			if !runtime.QuotasAvailable {
@@ -55,6 +63,12 @@ func RunLuaTestFile(t *testing.T, path string, setup func(*runtime.Runtime) func
 				return
 			}
 		}
+		if isWeakTest {
+			if !runtime.WeakRefsAvailable {
+				t.Skip("Skipping weak tables test as build does not support weak references")
+				return
+			}
+		}
 		src, err := ioutil.ReadFile(path)
 		if err != nil {
 			t.Error(err)
@@ -68,17 +82,76 @@ func RunLuaTestFile(t *testing.T, path string, setup func(*runtime.Runtime) func
 }
 
 // RunLuaTestsInDir runs a test for each .lua file in the directory provided.
+//
+// If the environment variable named by CoverageDirEnvVar is set, the coverage
+// of the tests is written to a file in the directory it names, and a summary
+// of it is logged.
 func RunLuaTestsInDir(t *testing.T, dirpath string, setup func(*runtime.Runtime) func(), filters ...string) {
+	coverageDir := os.Getenv(CoverageDirEnvVar)
+	var units []*runtime.UnitCoverage
 	runTest := func(path string, entry fs.DirEntry, err error) error {
 		for _, filter := range filters {
 			if !strings.Contains(entry.Name(), filter) {
 				return nil
 			}
 		}
-		RunLuaTestFile(t, path, setup)
+		if coverageDir == "" {
+			RunLuaTestFile(t, path, setup)
+			return nil
+		}
+		collector := runtime.NewCoverageCollector()
+		RunLuaTestFile(t, path, func(r *runtime.Runtime) func() {
+			r.SetCoverageCollector(collector)
+			if setup == nil {
+				return func() {}
+			}
+			return setup(r)
+		})
+		units = append(units, testFileCoverage(collector, path)...)
 		return nil
 	}
 	if err := filepath.WalkDir(dirpath, runTest); err != nil {
 		t.Error(err)
 	}
+	if coverageDir != "" {
+		if err := writeCoverage(t, coverageDir, dirpath, units); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// The test source is loaded with the chunk name "luatest", so give it the
+// path of the test file instead.
+func testFileCoverage(c *runtime.CoverageCollector, path string) []*runtime.UnitCoverage {
+	if abspath, err := filepath.Abs(path); err == nil {
+		path = abspath
+	}
+	units := c.Units()
+	for _, u := range units {
+		if u.Source == "luatest" {
+			u.Source = path
+		}
+	}
+	return units
+}
+
+func writeCoverage(t *testing.T, coverageDir, dirpath string, units []*runtime.UnitCoverage) error {
+	abspath, err := filepath.Abs(dirpath)
+	if err != nil {
+		return err
+	}
+	name := strings.ReplaceAll(strings.Trim(filepath.ToSlash(abspath), "/"), "/", "_") + ".lcov"
+	f, err := os.Create(filepath.Join(coverageDir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	report := coverage.NewReport(units)
+	if err := report.WriteLCOV(f); err != nil {
+		return err
+	}
+	var summary strings.Builder
+	report.WriteSummary(&summary)
+	t.Logf("Coverage of %s:\n%s", dirpath, summary.String())
+	return f.Close()
 }
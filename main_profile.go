@@ -11,6 +11,9 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "lint" {
+		os.Exit(lintMain(os.Args[2:]))
+	}
 	cmd := new(luaCmd)
 	cmd.setFlags()
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to `file`")
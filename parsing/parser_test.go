@@ -989,7 +989,8 @@ func TestParser_Local(t *testing.T) {
 			want: ast.LocalFunctionStat{
 				Name: name("f"),
 				Function: ast.Function{
-					Name: "f",
+					Name:     "f",
+					NameWhat: "local",
 					ParList: ast.ParList{
 						Params: []ast.Name{name("x")},
 					},
@@ -1087,8 +1088,9 @@ func TestParser_FunctionStat(t *testing.T) {
 			want: ast.AssignStat{
 				Dest: []ast.Var{name("foo")},
 				Src: []ast.ExpNode{ast.Function{
-					Name: "foo",
-					Body: ast.BlockStat{Return: []ast.ExpNode{}},
+					Name:     "foo",
+					NameWhat: "global",
+					Body:     ast.BlockStat{Return: []ast.ExpNode{}},
 				}},
 			},
 			want1: tok(token.EOF, ""),
@@ -1106,8 +1108,9 @@ func TestParser_FunctionStat(t *testing.T) {
 						Idx: str("baz"),
 					}},
 				Src: []ast.ExpNode{ast.Function{
-					Name: "baz",
-					Body: ast.BlockStat{Return: []ast.ExpNode{}},
+					Name:     "baz",
+					NameWhat: "field",
+					Body:     ast.BlockStat{Return: []ast.ExpNode{}},
 				}},
 			},
 			want1: tok(token.EOF, ""),
@@ -1122,9 +1125,10 @@ func TestParser_FunctionStat(t *testing.T) {
 						Idx:  str("bark"),
 					}},
 				Src: []ast.ExpNode{ast.Function{
-					Name:    "bark",
-					ParList: ast.ParList{Params: []ast.Name{name("self"), name("at")}},
-					Body:    ast.BlockStat{Return: []ast.ExpNode{}},
+					Name:     "bark",
+					NameWhat: "method",
+					ParList:  ast.ParList{Params: []ast.Name{name("self"), name("at")}},
+					Body:     ast.BlockStat{Return: []ast.ExpNode{}},
 				}},
 			},
 			want1: tok(token.EOF, ""),
@@ -1248,8 +1252,9 @@ func TestParser_Stat(t *testing.T) {
 			want: ast.AssignStat{
 				Dest: []ast.Var{name("foo")},
 				Src: []ast.ExpNode{ast.Function{
-					Name: "foo",
-					Body: ast.BlockStat{Return: []ast.ExpNode{}},
+					Name:     "foo",
+					NameWhat: "global",
+					Body:     ast.BlockStat{Return: []ast.ExpNode{}},
 				}},
 			},
 			want1: tok(token.EOF, ""),
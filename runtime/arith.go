@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"math"
+
+	"github.com/arnodel/golua/code"
 )
 
 // Unm returns (z, true) where z is the value representing -x if x is a number,
@@ -254,3 +256,30 @@ func unaryArithFallback(t *Thread, op string, x Value) (Value, error) {
 func UnaryArithmeticError(op string, x Value) error {
 	return fmt.Errorf("attempt to %s a '%s'", op, x.CustomTypeName())
 }
+
+// intArithFallback computes x op y when the compiler expected x and y to be
+// integers but at least one of them is not.
+func intArithFallback(t *Thread, op code.IntOp, x, y Value) (Value, error) {
+	var (
+		res Value
+		ok  bool
+		evt string
+	)
+	switch op {
+	case code.OpAddInt:
+		res, ok = Add(x, y)
+		evt = "__add"
+	case code.OpSubInt:
+		res, ok = Sub(x, y)
+		evt = "__sub"
+	case code.OpMulInt:
+		res, ok = Mul(x, y)
+		evt = "__mul"
+	default:
+		panic("unsupported")
+	}
+	if ok {
+		return res, nil
+	}
+	return binaryArithFallback(t, evt, x, y)
+}
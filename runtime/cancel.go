@@ -0,0 +1,79 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// A ContextDoneError is returned by CallContextUntil and CallWithContext when
+// execution was stopped because their Go context was done.  It wraps the error
+// of the Go context, so e.g. errors.Is(err, context.Canceled) can be used to
+// find out why.
+type ContextDoneError struct {
+	Err error
+}
+
+var _ error = (*ContextDoneError)(nil)
+
+func (e *ContextDoneError) Error() string {
+	return "execution stopped: " + e.Err.Error()
+}
+
+// Unwrap returns the error of the Go context.
+func (e *ContextDoneError) Unwrap() error {
+	return e.Err
+}
+
+// CallContextUntil is like CallContext, but the runtime context is killed when
+// the Go context goCtx is done (as if SetStopLevel(HardStop) was called on it),
+// in which case the returned error is a *ContextDoneError.  Contexts pushed
+// while f() is running are killed too.
+//
+// Execution can only be stopped while Lua code is running or Go functions
+// require CPU, so e.g. a Go function blocked on I/O is not interrupted.
+// Cancellation is not available when quotas are not (i.e. when built with the
+// noquotas tag).
+func (t *Thread) CallContextUntil(goCtx context.Context, def RuntimeContextDef, f func() error) (RuntimeContext, error) {
+	if err := goCtx.Err(); err != nil {
+		return nil, &ContextDoneError{Err: err}
+	}
+	done := goCtx.Done()
+	if done == nil {
+		// The Go context can never be done.
+		return t.CallContext(def, f)
+	}
+	var interrupt int32
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-done:
+			atomic.StoreInt32(&interrupt, 1)
+		case <-stop:
+		}
+	}()
+	ctx, err := t.callContext(def, &interrupt, f)
+	if ctx != nil && ctx.Status() == StatusKilled && atomic.LoadInt32(&interrupt) != 0 {
+		err = &ContextDoneError{Err: goCtx.Err()}
+	}
+	return ctx, err
+}
+
+// CallWithContext calls f with arguments args, like Call, but stops execution
+// when the Go context goCtx is done, returning a *ContextDoneError.  The call
+// runs in a new runtime context without any additional limits (see
+// Thread.CallContextUntil); if that context is killed for another reason, e.g.
+// because the limits of the current context are reached, an error is returned
+// too.
+func CallWithContext(goCtx context.Context, t *Thread, f Value, args []Value, next Cont) error {
+	ctx, err := t.CallContextUntil(goCtx, RuntimeContextDef{}, func() error {
+		return Call(t, f, args, next)
+	})
+	if err == nil && ctx != nil && ctx.Status() == StatusKilled {
+		err = errContextKilled
+	}
+	return err
+}
+
+var errContextKilled = errors.New("runtime context killed")
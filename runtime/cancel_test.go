@@ -0,0 +1,77 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func loadChunk(t *testing.T, r *Runtime, src string) Value {
+	t.Helper()
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte(src), TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return FunctionValue(clos)
+}
+
+func TestCallWithContext(t *testing.T) {
+	if !QuotasAvailable {
+		t.Skip("cancellation requires quotas")
+	}
+	r := New(nil)
+	loop := loadChunk(t, r, `while true do end`)
+
+	goCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := CallWithContext(goCtx, r.MainThread(), loop, nil, NewTerminationWith(nil, 0, false))
+	var doneErr *ContextDoneError
+	if !errors.As(err, &doneErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// The runtime can still be used.
+	res := NewTerminationWith(nil, 1, false)
+	if err := CallWithContext(context.Background(), r.MainThread(), loadChunk(t, r, `return 42`), nil, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Get(0) != IntValue(42) {
+		t.Errorf("unexpected result %v", res.Get(0))
+	}
+
+	// A context already done stops the call before it starts.
+	goCtx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = CallWithContext(goCtx, r.MainThread(), loop, nil, NewTerminationWith(nil, 0, false))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled, got %v", err)
+	}
+}
+
+func TestCallContextUntilNested(t *testing.T) {
+	if !QuotasAvailable {
+		t.Skip("cancellation requires quotas")
+	}
+	r := New(nil)
+	th := r.MainThread()
+	loop := loadChunk(t, r, `while true do end`)
+	goCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	ctx, err := th.CallContextUntil(goCtx, RuntimeContextDef{}, func() error {
+		// Killing the inner context also kills the outer one.
+		_, _ = th.CallContext(RuntimeContextDef{}, func() error {
+			return Call(th, loop, nil, NewTerminationWith(nil, 0, false))
+		})
+		return errors.New("outer context not killed")
+	})
+	if ctx.Status() != StatusKilled {
+		t.Errorf("unexpected status %s", ctx.Status())
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled, got %v", err)
+	}
+}
@@ -8,6 +8,8 @@ type Closure struct {
 	*Code
 	Upvalues     []Cell
 	upvalueIndex int
+	caches       inlineCaches // See inlinecache.go
+	eph          ephemerons   // See weakref.go
 }
 
 var _ Callable = (*Closure)(nil)
@@ -92,12 +92,14 @@ func eq(t *Thread, x, y Value) (bool, error) {
 	if res, ok := RawEqual(x, y); ok {
 		return res, nil
 	}
-	if _, ok := x.TryTable(); ok {
-		if _, ok := y.TryTable(); !ok {
+	// The __eq metamethod is only tried when comparing two tables or two
+	// userdata.
+	switch x.Type() {
+	case TableType, UserDataType:
+		if y.Type() != x.Type() {
 			return false, nil
 		}
-	} else {
-		// TODO: deal with UserData
+	default:
 		return false, nil
 	}
 	res, err, ok := metabin(t, "__eq", x, y)
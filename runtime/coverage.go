@@ -0,0 +1,82 @@
+package runtime
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/arnodel/golua/code"
+)
+
+//
+// Coverage.
+//
+// When a CoverageCollector is set on a runtime, each code unit loaded gets
+// counters for the number of times each of its instructions is executed and
+// the number of times each of its conditional jumps is taken.  Together with
+// the line information in the unit, this gives line and branch coverage (see
+// the coverage package).
+//
+
+// A CoverageCollector collects the coverage of code units loaded in runtimes
+// it is set on.  It can be shared between runtimes, including runtimes running
+// concurrently (e.g. created from a Snapshot) as counters are updated
+// atomically.
+type CoverageCollector struct {
+	mux   sync.Mutex
+	units []*UnitCoverage
+}
+
+// UnitCoverage holds the coverage counters of a code unit.
+type UnitCoverage struct {
+	Source string     // Initially the source of the unit
+	Unit   *code.Unit // The unit
+	Hits   []uint64   // Number of times each instruction was executed
+	Jumps  []uint64   // Number of times each conditional jump was taken
+}
+
+// Counts returns the number of times the instruction at pc was executed and
+// the number of times it jumped, if it is a conditional jump.  Unlike reading
+// Hits and Jumps directly, it is safe while code runs.
+func (u *UnitCoverage) Counts(pc int) (hits, jumps uint64) {
+	return atomic.LoadUint64(&u.Hits[pc]), atomic.LoadUint64(&u.Jumps[pc])
+}
+
+// NewCoverageCollector returns a new CoverageCollector.
+func NewCoverageCollector() *CoverageCollector {
+	return &CoverageCollector{}
+}
+
+// Units returns the coverage of the units loaded so far.  While code runs in
+// runtimes the collector is set on, counters must be read with
+// UnitCoverage.Counts.
+func (c *CoverageCollector) Units() []*UnitCoverage {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return append([]*UnitCoverage(nil), c.units...)
+}
+
+func (c *CoverageCollector) addUnit(unit *code.Unit) *UnitCoverage {
+	u := &UnitCoverage{
+		Source: unit.Source,
+		Unit:   unit,
+		Hits:   make([]uint64, len(unit.Code)),
+		Jumps:  make([]uint64, len(unit.Code)),
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.units = append(c.units, u)
+	return u
+}
+
+// SetCoverageCollector makes c collect the coverage of code units loaded
+// subsequently by the runtime.  If c is nil, units loaded subsequently have no
+// coverage.
+func (r *Runtime) SetCoverageCollector(c *CoverageCollector) {
+	r.coverage = c
+}
+
+// The coverage counters of a function.
+type codeCoverage struct {
+	hits  []uint64
+	jumps []uint64
+}
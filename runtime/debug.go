@@ -8,6 +8,19 @@ type DebugInfo struct {
 	Source      string
 	Name        string
 	CurrentLine int32
+
+	// The fields below are mostly useful to implement debug.getinfo and have
+	// the same meaning as in the reference implementation.
+
+	NameWhat        string // "global", "local", "upvalue", "method", "field" or ""
+	What            string // "Lua", "main" or "C" (for Go functions)
+	LineDefined     int32  // -1 for Go functions
+	LastLineDefined int32  // -1 for Go functions
+	NUpvalues       int
+	NParams         int
+	IsVararg        bool
+	IsTailCall      bool  // True if the function was called by a tail call
+	Function        Value // The function running in the continuation
 }
 
 // String formats the data contained in DebugInfo in a human-readable way.
@@ -51,6 +51,14 @@ func (h *DebugHooks) SetupHooks(newHooks DebugHooks) {
 	*h = newHooks
 }
 
+// InheritHooks sets the debug hooks of t to those of parent.  As in Lua,
+// coroutines inherit the hooks of the thread that creates them, so that e.g. a
+// debugger also controls them.
+func (t *Thread) InheritHooks(parent *Thread) {
+	t.DebugHooks = parent.DebugHooks
+	t.DebugHookFlags &= ^hookFlagInHook
+}
+
 var (
 	callHookString     = StringValue("call")
 	tailCallHookString = StringValue("tail call")
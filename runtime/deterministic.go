@@ -0,0 +1,278 @@
+package runtime
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeterministicOptions configure a deterministic runtime (see
+// WithDeterministic).
+type DeterministicOptions struct {
+	// Clock returns the current time, as seen by os.time, os.date and
+	// os.clock.  If nil, the time is always DeterministicEpoch.
+	Clock func() time.Time
+
+	// Location is the time zone used for local times by os.date and os.time.
+	// If nil, UTC is used.
+	Location *time.Location
+
+	// Seed is the initial seed of the pseudo-random generator used by
+	// math.random.
+	Seed int64
+
+	// Inputs records or replays the inputs the runtime gets from its host.  If
+	// nil, host inputs are neither recorded nor replayed.
+	Inputs *HostInputs
+}
+
+// DeterministicEpoch is the time seen by deterministic runtimes which are not
+// given a clock.
+var DeterministicEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// WithDeterministic makes the runtime deterministic, so that running the same
+// script twice gives the same results.  In a deterministic runtime:
+//   - the time and the time zone are given by opts (and os.clock measures time
+//     elapsed on that clock since the runtime was created);
+//   - math.random uses a pseudo-random generator seeded with opts.Seed;
+//   - tables created by the runtime and its libraries iterate over their keys
+//     in insertion order;
+//   - inputs from the host (e.g. environment variables, stdin) can be recorded
+//     and replayed with opts.Inputs.
+func WithDeterministic(opts DeterministicOptions) RuntimeOption {
+	return func(rtOpts *runtimeOptions) {
+		rtOpts.deterministic = &opts
+	}
+}
+
+type deterministic struct {
+	DeterministicOptions
+	start time.Time
+	rand  *rand.Rand
+}
+
+func newDeterministic(opts *DeterministicOptions) *deterministic {
+	d := &deterministic{
+		DeterministicOptions: *opts,
+		rand:                 rand.New(rand.NewSource(opts.Seed)),
+	}
+	if d.Clock == nil {
+		d.Clock = func() time.Time { return DeterministicEpoch }
+	}
+	if d.Location == nil {
+		d.Location = time.UTC
+	}
+	d.start = d.Clock()
+	return d
+}
+
+// IsDeterministic returns true if r was created with the WithDeterministic
+// option.
+func (r *Runtime) IsDeterministic() bool {
+	return r.deterministic != nil
+}
+
+// NewTable returns a new table.  It is the same as the NewTable function,
+// except that in a deterministic runtime the table iterates over its keys in
+// insertion order.
+func (r *Runtime) NewTable() *Table {
+	t := NewTable()
+	if r.deterministic != nil {
+		t.order = newTableOrder()
+	}
+	return t
+}
+
+// Now returns the current time, as given by the clock of a deterministic
+// runtime or by time.Now() otherwise.
+func (r *Runtime) Now() time.Time {
+	if d := r.deterministic; d != nil {
+		return d.Clock().In(d.Location)
+	}
+	return time.Now()
+}
+
+// Location returns the time zone of local times (time.Local unless the runtime
+// is deterministic).
+func (r *Runtime) Location() *time.Location {
+	if d := r.deterministic; d != nil {
+		return d.Location
+	}
+	return time.Local
+}
+
+// ElapsedTime returns the time elapsed on the clock of a deterministic runtime
+// since it was created.  The boolean is false if the runtime is not
+// deterministic.
+func (r *Runtime) ElapsedTime() (time.Duration, bool) {
+	if d := r.deterministic; d != nil {
+		return d.Clock().Sub(d.start), true
+	}
+	return 0, false
+}
+
+// Rand returns the pseudo-random generator of a deterministic runtime, or nil
+// if the runtime is not deterministic.
+func (r *Runtime) Rand() *rand.Rand {
+	if d := r.deterministic; d != nil {
+		return d.rand
+	}
+	return nil
+}
+
+// HostInput returns the result of get(), which is some input from the host
+// (e.g. the value of an environment variable), identified by name.  If the
+// runtime records its host inputs, the result is recorded.  If it replays
+// them, get is not called and the recorded value is returned instead, or an
+// error if the next recorded input does not have the same name.
+func (r *Runtime) HostInput(name string, get func() (string, bool)) (string, bool, error) {
+	if d := r.deterministic; d != nil && d.Inputs != nil {
+		return d.Inputs.input(name, get)
+	}
+	s, ok := get()
+	return s, ok, nil
+}
+
+// HostReader returns a reader which reads from rd, recording what it reads as
+// host inputs called name if the runtime records its host inputs, or a reader
+// which replays the recorded inputs instead if it replays them.
+func (r *Runtime) HostReader(name string, rd io.Reader) io.Reader {
+	if d := r.deterministic; d != nil && d.Inputs != nil {
+		return &hostReader{inputs: d.Inputs, name: name, rd: rd}
+	}
+	return rd
+}
+
+// HostInputs is a log of inputs from the host of a deterministic runtime.  It
+// is either recording or replaying inputs.
+type HostInputs struct {
+	replay bool
+	inputs []HostInput
+	next   int
+}
+
+// A HostInput is an input from the host, as recorded by HostInputs.
+type HostInput struct {
+	Name  string // What was read, e.g. "os.getenv(HOME)" or "io.stdin"
+	Value string // The value that was read
+	Nil   bool   // True if there was no value (e.g. undefined variable, EOF)
+}
+
+// RecordHostInputs returns a new empty log which records host inputs.
+func RecordHostInputs() *HostInputs {
+	return &HostInputs{}
+}
+
+// ReplayHostInputs returns a log which replays the given inputs.
+func ReplayHostInputs(inputs []HostInput) *HostInputs {
+	return &HostInputs{replay: true, inputs: inputs}
+}
+
+// Inputs returns the inputs in the log.
+func (h *HostInputs) Inputs() []HostInput {
+	return h.inputs
+}
+
+// WriteTo writes the inputs in the log to w, one per line, in a format which
+// can be read by ReadHostInputs.  Names and values are quoted as Go strings so
+// that arbitrary bytes are preserved.
+func (h *HostInputs) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, in := range h.inputs {
+		val := "nil"
+		if !in.Nil {
+			val = strconv.Quote(in.Value)
+		}
+		m, err := fmt.Fprintf(w, "%s %s\n", strconv.Quote(in.Name), val)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ReadHostInputs reads inputs written by HostInputs.WriteTo.
+func ReadHostInputs(rd io.Reader) ([]HostInput, error) {
+	var inputs []HostInput
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(nil, 1<<30)
+	for line := 1; scanner.Scan(); line++ {
+		in, err := parseHostInput(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		inputs = append(inputs, in)
+	}
+	return inputs, scanner.Err()
+}
+
+func parseHostInput(line string) (in HostInput, err error) {
+	name, err := strconv.QuotedPrefix(line)
+	if err != nil {
+		return
+	}
+	in.Name, _ = strconv.Unquote(name)
+	val := strings.TrimPrefix(line[len(name):], " ")
+	if val == "nil" {
+		in.Nil = true
+	} else {
+		in.Value, err = strconv.Unquote(val)
+	}
+	return
+}
+
+var errNoMoreHostInputs = errors.New("no more recorded host inputs")
+
+func (h *HostInputs) input(name string, get func() (string, bool)) (string, bool, error) {
+	if !h.replay {
+		s, ok := get()
+		h.inputs = append(h.inputs, HostInput{Name: name, Value: s, Nil: !ok})
+		return s, ok, nil
+	}
+	if h.next >= len(h.inputs) {
+		return "", false, errNoMoreHostInputs
+	}
+	in := h.inputs[h.next]
+	if in.Name != name {
+		return "", false, fmt.Errorf("host input %q does not match recorded input %q", name, in.Name)
+	}
+	h.next++
+	return in.Value, !in.Nil, nil
+}
+
+type hostReader struct {
+	inputs *HostInputs
+	name   string
+	rd     io.Reader
+}
+
+func (r *hostReader) Read(b []byte) (int, error) {
+	h := r.inputs
+	if !h.replay {
+		n, err := r.rd.Read(b)
+		if n > 0 {
+			h.inputs = append(h.inputs, HostInput{Name: r.name, Value: string(b[:n])})
+		}
+		if err != nil {
+			// Replaying this gives io.EOF
+			h.inputs = append(h.inputs, HostInput{Name: r.name, Nil: true})
+		}
+		return n, err
+	}
+	s, ok, err := h.input(r.name, nil)
+	switch {
+	case err != nil:
+		return 0, err
+	case !ok:
+		return 0, io.EOF
+	case len(s) > len(b):
+		return 0, errors.New("recorded host input too long")
+	}
+	return copy(b, s), nil
+}
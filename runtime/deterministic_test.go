@@ -0,0 +1,223 @@
+package runtime_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+func newDeterministicRuntime(opts rt.DeterministicOptions) *rt.Runtime {
+	r := rt.New(nil, rt.WithDeterministic(opts))
+	lib.LoadAll(r)
+	return r
+}
+
+func TestDeterministic(t *testing.T) {
+	const src = `
+local t = {}
+for i = 1, 20 do
+	t["k" .. i] = i
+	t[i * 1.5] = i
+end
+t.k3 = nil
+t.k30 = 30
+local keys = {}
+for k in pairs(t) do
+	keys[#keys + 1] = tostring(k)
+end
+for i = 1, 5 do
+	keys[#keys + 1] = math.random(1000)
+end
+return table.concat(keys, " ") .. " " .. os.time() .. " " .. os.date("%Y-%m-%d %H:%M") .. " " .. os.clock()
+`
+	var results []rt.Value
+	for i := 0; i < 2; i++ {
+		r := newDeterministicRuntime(rt.DeterministicOptions{Seed: 42})
+		if !r.IsDeterministic() {
+			t.Fatal("runtime is not deterministic")
+		}
+		results = append(results, runChunk(t, r, src))
+	}
+	if results[0] != results[1] {
+		t.Errorf("results differ:\n%v\n%v", results[0], results[1])
+	}
+	s, _ := results[0].TryString()
+	if !strings.HasPrefix(s, "k1 1.5 k2 3 4.5 k4 6 ") {
+		t.Errorf("keys not in insertion order: %s", s)
+	}
+	if !strings.HasSuffix(s, " 946684800 2000-01-01 00:00 0") {
+		t.Errorf("unexpected time: %s", s)
+	}
+}
+
+func TestDeterministicClock(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	r := newDeterministicRuntime(rt.DeterministicOptions{
+		Clock:    func() time.Time { return now },
+		Location: time.FixedZone("X", 3600),
+	})
+	now = now.Add(1500 * time.Millisecond)
+	res := runChunk(t, r, `return os.date("%H:%M:%S") .. " " .. os.date("!%H:%M:%S") .. " " .. os.clock()`)
+	if res != rt.StringValue("13:00:01 12:00:01 1.5") {
+		t.Errorf("unexpected result %v", res)
+	}
+}
+
+func TestDeterministicHostInputs(t *testing.T) {
+	os.Setenv("GOLUA_TEST_VAR", "hello")
+	defer os.Unsetenv("GOLUA_TEST_VAR")
+	const src = `return tostring(os.getenv("GOLUA_TEST_VAR")) .. " " .. tostring(os.getenv("GOLUA_UNDEFINED_VAR"))`
+
+	rec := rt.RecordHostInputs()
+	r := newDeterministicRuntime(rt.DeterministicOptions{Inputs: rec})
+	if res := runChunk(t, r, src); res != rt.StringValue("hello nil") {
+		t.Fatalf("unexpected result %v", res)
+	}
+	var buf bytes.Buffer
+	if _, err := rec.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "\"os.getenv(GOLUA_TEST_VAR)\" \"hello\"\n\"os.getenv(GOLUA_UNDEFINED_VAR)\" nil\n" {
+		t.Errorf("unexpected log %q", buf.String())
+	}
+	inputs, err := rt.ReadHostInputs(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(inputs, rec.Inputs()) {
+		t.Errorf("log not read back: %v", inputs)
+	}
+
+	// The replayed runtime gets the recorded values.
+	os.Setenv("GOLUA_TEST_VAR", "bye")
+	r = newDeterministicRuntime(rt.DeterministicOptions{Inputs: rt.ReplayHostInputs(inputs)})
+	if res := runChunk(t, r, src); res != rt.StringValue("hello nil") {
+		t.Errorf("unexpected replayed result %v", res)
+	}
+
+	// Replaying inputs in a different order is an error.
+	r = newDeterministicRuntime(rt.DeterministicOptions{Inputs: rt.ReplayHostInputs(inputs)})
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte(`return os.getenv("HOME")`), rt.TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.SafeCall(rt.FunctionValue(clos), nil, rt.NewTerminationWith(nil, 0, false))
+	if err == nil || !strings.Contains(err.Error(), `host input "os.getenv(HOME)" does not match recorded input "os.getenv(GOLUA_TEST_VAR)"`) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestDeterministicHostReader(t *testing.T) {
+	rec := rt.RecordHostInputs()
+	r := rt.New(nil, rt.WithDeterministic(rt.DeterministicOptions{Inputs: rec}))
+	var out bytes.Buffer
+	if _, err := out.ReadFrom(r.HostReader("input", strings.NewReader("some data"))); err != nil {
+		t.Fatal(err)
+	}
+	r = rt.New(nil, rt.WithDeterministic(rt.DeterministicOptions{Inputs: rt.ReplayHostInputs(rec.Inputs())}))
+	out.Reset()
+	if _, err := out.ReadFrom(r.HostReader("input", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "some data" {
+		t.Errorf("unexpected replayed data %q", out.String())
+	}
+}
+
+func TestDeterministicTableOrder(t *testing.T) {
+	r := newDeterministicRuntime(rt.DeterministicOptions{})
+	res := runChunk(t, r, `
+local t = {}
+for i = 1, 100 do t["x" .. i] = i end
+-- Clearing fields during traversal is allowed.
+local n = 0
+for k, v in pairs(t) do
+	if v % 2 == 0 then t[k] = nil end
+	n = n + 1
+end
+assert(n == 100)
+-- Removed keys are compacted away and new keys come last.
+for i = 1, 100 do t["y" .. i] = i end
+local keys = {}
+for k in pairs(t) do keys[#keys + 1] = k end
+return #keys .. " " .. keys[1] .. " " .. keys[50] .. " " .. keys[51] .. " " .. keys[#keys]
+`)
+	if res != rt.StringValue("150 x1 x99 y1 y100") {
+		t.Errorf("unexpected result %v", res)
+	}
+}
+
+func TestDeterministicWeakTableOrder(t *testing.T) {
+	r := newDeterministicRuntime(rt.DeterministicOptions{})
+	res := runChunk(t, r, `
+local t = {}
+for i = 1, 20 do t["x" .. i] = {} end
+setmetatable(t, {__mode = "v"})
+local k = {}
+t[k] = 1
+setmetatable(t, {__mode = "k"})
+t.y = k
+local keys = {}
+for k in pairs(t) do keys[#keys + 1] = type(k) == "table" and "{}" or k end
+return table.concat(keys, " ")
+`)
+	want := "x1 x2 x3 x4 x5 x6 x7 x8 x9 x10 x11 x12 x13 x14 x15 x16 x17 x18 x19 x20 {} y"
+	if res != rt.StringValue(want) {
+		t.Errorf("unexpected result %v", res)
+	}
+}
+
+// Iteration order must not depend on the process, as hashes are seeded
+// differently in each process.  The test runs itself in a few subprocesses and
+// compares their output.
+func TestDeterministicAcrossProcesses(t *testing.T) {
+	const src = `
+local out = {}
+local function keys(name, t)
+	local ks = {}
+	for k in pairs(t) do ks[#ks + 1] = tostring(k) end
+	out[#out + 1] = name .. ": " .. table.concat(ks, " ")
+end
+keys("date", os.date("*t"))
+keys("pack", table.pack(1, 2, 3))
+for _, name in ipairs{"_G", "coroutine", "debug", "io", "math", "os", "package", "string", "table", "utf8"} do
+	keys(name, _G[name])
+end
+keys("package.loaded", package.loaded)
+keys("file", getmetatable(io.stdout))
+keys("string meta", getmetatable(""))
+keys("getinfo", debug.getinfo(1))
+return table.concat(out, "\n")
+`
+	if os.Getenv("GOLUA_DETERMINISTIC_CHILD") != "" {
+		r := newDeterministicRuntime(rt.DeterministicOptions{})
+		s, _ := runChunk(t, r, src).TryString()
+		os.Stdout.WriteString(s + "\n")
+		return
+	}
+	var outputs []string
+	for i := 0; i < 3; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestDeterministicAcrossProcesses$")
+		cmd.Env = append(os.Environ(), "GOLUA_DETERMINISTIC_CHILD=1")
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+		outputs = append(outputs, string(out))
+	}
+	for _, out := range outputs[1:] {
+		if out != outputs[0] {
+			t.Fatalf("outputs differ:\n%s\n%s", outputs[0], out)
+		}
+	}
+	if !strings.Contains(outputs[0], "date: year month day hour min sec wday yday isdst") {
+		t.Errorf("unexpected output:\n%s", outputs[0])
+	}
+}
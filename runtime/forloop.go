@@ -0,0 +1,159 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+//
+// Numeric for loops (see notes/numeric-for.md)
+//
+
+// prepForLoop returns the values that the start, stop and step registers of a
+// numeric for loop should contain when the loop starts.  The returned start
+// value is nil if the loop body should not be executed at all.
+//
+// When start and step are both integers, the loop is done with integers and the
+// returned stop value is an integer as well.  Otherwise all three values are
+// floats.  This means that start, stop and step always have the same numeric
+// type after the loop is prepared.
+func prepForLoop(start, stop, step Value) (Value, Value, Value, error) {
+	start, tstart := ToNumberValue(start)
+	stop, tstop := ToNumberValue(stop)
+	step, tstep := ToNumberValue(step)
+	if tstart == NaN || tstop == NaN || tstep == NaN {
+		var (
+			role string
+			val  Value
+		)
+		switch {
+		case tstart == NaN:
+			role, val = "initial value", start
+		case tstop == NaN:
+			role, val = "limit", stop
+		default:
+			role, val = "step", step
+		}
+		return NilValue, NilValue, NilValue, fmt.Errorf("'for' %s: expected number, got %s", role, val.CustomTypeName())
+	}
+	// A 0 step is an error
+	if isZero(step) {
+		return NilValue, NilValue, NilValue, errors.New("'for' step is zero")
+	}
+	if tstart == IsInt && tstep == IsInt {
+		return prepForLoopInt(start.AsInt(), stop, step.AsInt())
+	}
+	// Turn everything into floats
+	if tstart == IsInt {
+		start = FloatValue(float64(start.AsInt()))
+	}
+	if tstop == IsInt {
+		stop = FloatValue(float64(stop.AsInt()))
+	}
+	if tstep == IsInt {
+		step = FloatValue(float64(step.AsInt()))
+	}
+	// Check the loop is not already finished. If so, start is set to nil.  The
+	// comparisons are written so that a NaN limit finishes the loop.
+	var run bool
+	if isPositive(step) {
+		run = start.AsFloat() <= stop.AsFloat()
+	} else {
+		run = start.AsFloat() >= stop.AsFloat()
+	}
+	if !run {
+		start = NilValue
+	}
+	return start, stop, step, nil
+}
+
+// prepForLoopInt is like prepForLoop when start and step are integers, step is
+// not zero and stop is a number.  The limit of the loop is turned into an
+// integer so that advancing the loop only involves integer arithmetic.
+func prepForLoopInt(start int64, stop Value, step int64) (Value, Value, Value, error) {
+	limit, skip := forLoopLimit(start, stop, step)
+	startVal := IntValue(start)
+	if skip {
+		startVal = NilValue
+	}
+	return startVal, IntValue(limit), IntValue(step), nil
+}
+
+// forLoopLimit returns the integer limit of a loop with integer start and step
+// and a numeric stop value.  It also returns true if the loop body should not
+// be executed at all.  A float stop value is rounded down (or up if the step is
+// negative) and clipped to the range of integers.
+func forLoopLimit(start int64, stop Value, step int64) (limit int64, skip bool) {
+	if n, ok := stop.TryInt(); ok {
+		limit = n
+	} else {
+		f := stop.AsFloat()
+		if step > 0 {
+			f = math.Floor(f)
+		} else {
+			f = math.Ceil(f)
+		}
+		var tp NumberType
+		limit, tp = FloatToInt(f)
+		if tp != IsInt || f >= math.MaxInt64 || f < math.MinInt64 {
+			// f is out of the range of integers or is NaN.
+			switch {
+			case f > 0:
+				if step < 0 {
+					return 0, true
+				}
+				limit = math.MaxInt64
+			case f < 0:
+				if step > 0 {
+					return 0, true
+				}
+				limit = math.MinInt64
+			default:
+				// NaN: the loop body is never executed
+				return 0, true
+			}
+		}
+	}
+	if step > 0 {
+		skip = start > limit
+	} else {
+		skip = start < limit
+	}
+	return
+}
+
+// advForLoop returns the next value of the control variable of a loop prepared
+// with prepForLoop, or nil if the loop is done.
+func advForLoop(start, stop, step Value) Value {
+	if n, ok := start.TryInt(); ok {
+		// It is an integer loop so stop and step are integers too.
+		return advForLoopInt(n, stop.AsInt(), step.AsInt())
+	}
+	x, limit, dx := start.AsFloat(), stop.AsFloat(), step.AsFloat()
+	next := x + dx
+	var run bool
+	if dx > 0 {
+		run = next <= limit
+	} else {
+		run = next >= limit
+	}
+	if !run {
+		return NilValue
+	}
+	return FloatValue(next)
+}
+
+// advForLoopInt is advForLoop for an integer loop.  The loop is done when the
+// control variable goes past the limit, which includes wrapping around.
+func advForLoopInt(n, limit, step int64) Value {
+	next := n + step
+	if step > 0 {
+		if next > limit || next < n {
+			return NilValue
+		}
+	} else if next < limit || next > n {
+		return NilValue
+	}
+	return IntValue(next)
+}
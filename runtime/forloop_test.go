@@ -0,0 +1,86 @@
+package runtime_test
+
+import (
+	"testing"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// Loops with integer literal start and step use integer specialised opcodes.
+// The Generic loop has the same bounds but is compiled to generic opcodes, as
+// its start value is not known at compile time.
+func BenchmarkForLoop(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		src  string
+	}{
+		{
+			name: "Empty",
+			src: `
+return function()
+    for i = 1, 10000 do end
+end`,
+		},
+		{
+			name: "Arith",
+			src: `
+return function()
+    local n = 0
+    for i = 1, 10000 do
+        n = n + (i * 2 - 1)
+    end
+    return n
+end`,
+		},
+		{
+			name: "Nested",
+			src: `
+return function()
+    local n = 0
+    for i = 1, 100 do
+        for j = i, 100 do
+            n = n + (i * j + 1)
+        end
+    end
+    return n
+end`,
+		},
+		{
+			name: "Generic",
+			src: `
+local one = tonumber("1")
+return function()
+    local n = 0
+    for i = one, 10000 do
+        n = n + (i * 2 - 1)
+    end
+    return n
+end`,
+		},
+		{
+			name: "Float",
+			src: `
+return function()
+    local n = 0
+    for i = 1.0, 10000 do
+        n = n + (i * 2 - 1)
+    end
+    return n
+end`,
+		},
+	}
+	for _, bb := range benchmarks {
+		b.Run(bb.name, func(b *testing.B) {
+			r := rt.New(nil)
+			lib.LoadAll(r)
+			f := runChunk(b, r, bb.src)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := r.SafeCall(f, nil, rt.NewTerminationWith(nil, 1, false)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
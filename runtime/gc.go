@@ -0,0 +1,160 @@
+package runtime
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//
+// Support for the __gc metamethod.
+//
+// When a table or userdata is given a metatable with a "__gc" field, it is
+// "marked for finalization" by registering a Go finalizer on it.  When the Go
+// garbage collector finds the object unreachable, the Go finalizer does not
+// call the __gc metamethod directly (it runs in its own goroutine, which is not
+// a Lua thread).  Instead it puts the object in a queue owned by the Runtime,
+// which resurrects it.  The queue is then emptied at "safe points" on a Lua
+// thread, i.e. between continuations in Thread.RunContinuation or when
+// collectgarbage() is called.  Finalizers run in the current runtime context
+// so they consume its resources like any other Lua code.
+//
+// A limitation of this approach is that the Go garbage collector does not run
+// finalizers of objects that are part of a reference cycle, so e.g. a table
+// that refers to itself will not be finalized.
+//
+
+// finalizerQueue holds objects which are waiting for their __gc metamethod to
+// be called.  It is filled by Go finalizers, so can be accessed concurrently.
+type finalizerQueue struct {
+	mux     sync.Mutex
+	pending []Value
+	count   int32 // Number of pending values, read atomically
+	running bool  // True when finalizers are being run
+}
+
+func (q *finalizerQueue) push(v Value) {
+	q.mux.Lock()
+	q.pending = append(q.pending, v)
+	atomic.StoreInt32(&q.count, int32(len(q.pending)))
+	q.mux.Unlock()
+}
+
+func (q *finalizerQueue) popAll() []Value {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	pending := q.pending
+	q.pending = nil
+	atomic.StoreInt32(&q.count, 0)
+	return pending
+}
+
+// Important for this function to inline.
+func (q *finalizerQueue) hasPending() bool {
+	return atomic.LoadInt32(&q.count) != 0
+}
+
+// Mark v for finalization if meta has a "__gc" field and v is not already
+// marked.  Only tables and userdata can be marked.
+func (r *Runtime) markForFinalization(v Value, meta *Table) {
+	if RawGet(meta, StringValue("__gc")).IsNil() {
+		return
+	}
+	switch x := v.iface.(type) {
+	case *Table:
+		if !x.marked {
+			x.marked = true
+			runtime.SetFinalizer(x, func(x *Table) {
+				x.marked = false
+				r.finalizerQueue.push(TableValue(x))
+			})
+		}
+	case *UserData:
+		if !x.marked {
+			x.marked = true
+			runtime.SetFinalizer(x, func(x *UserData) {
+				x.marked = false
+				r.finalizerQueue.push(UserDataValue(x))
+			})
+		}
+	}
+}
+
+// RunPendingFinalizers calls the __gc metamethods of all the objects which have
+// been found unreachable since the last time finalizers were run.  It does
+// nothing if called from a finalizer.  As in Lua 5.4, errors in __gc
+// metamethods are turned into warnings.
+func (t *Thread) RunPendingFinalizers() {
+	q := &t.finalizerQueue
+	if q.running || !q.hasPending() {
+		return
+	}
+	// Calling the metamethods changes the current continuation, so it must
+	// be restored for the caller.
+	cont := t.currentCont
+	q.running = true
+	defer func() {
+		q.running = false
+		t.currentCont = cont
+	}()
+	for q.hasPending() {
+		for _, v := range q.popAll() {
+			gc := t.metaGetS(v, "__gc")
+			if _, ok := gc.TryCallable(); !ok {
+				// Non-callable values are silently ignored.
+				continue
+			}
+			term := NewTerminationWith(t.CurrentCont(), 0, false)
+			if err := Call(t, gc, []Value{v}, term); err != nil {
+				t.Warn(fmt.Sprintf("error in __gc metamethod (%s)", err))
+			}
+		}
+	}
+}
+
+// CollectGarbage performs a full garbage collection cycle, removes collected
+// entries from weak tables, then calls the __gc metamethods of the objects
+// which were found unreachable.
+func (t *Thread) CollectGarbage() {
+	// Go finalizers are run asynchronously after the collection cycle, so
+	// wait for them.  Two cycles are needed to be sure that all finalizers
+	// queued by the first one have run.
+	for i := 0; i < 2; i++ {
+		collectAndWaitForFinalizers()
+	}
+	t.sweepWeakTables()
+	t.RunPendingFinalizers()
+}
+
+// Remove collected entries from weak tables, giving back the memory they used.
+func (r *Runtime) sweepWeakTables() {
+	mem := r.weakTables.sweep() * tableEntrySize
+
+	// The entries may have been inserted in a parent context, so do not
+	// release more memory than the current context uses.
+	if used := r.UsedResources().Memory; mem > used {
+		mem = used
+	}
+	r.ReleaseMem(mem)
+}
+
+// A value with a pointer field is needed so that it is not allocated by the Go
+// tiny allocator (otherwise its finalizer may never run).
+type gcSentinel struct {
+	_ *gcSentinel
+}
+
+// Maximum time to wait for Go finalizers to run after a garbage collection.
+const finalizerWaitTimeout = time.Second
+
+func collectAndWaitForFinalizers() {
+	done := make(chan struct{})
+	runtime.SetFinalizer(new(gcSentinel), func(*gcSentinel) { close(done) })
+	runtime.GC()
+	select {
+	case <-done:
+	case <-time.After(finalizerWaitTimeout):
+	}
+}
@@ -0,0 +1,67 @@
+package runtime
+
+import "testing"
+
+func TestThread_CollectGarbage(t *testing.T) {
+	r := New(nil)
+	th := r.MainThread()
+	var finalized []interface{}
+	gc := NewGoFunction(func(t *Thread, c *GoCont) (Cont, error) {
+		finalized = append(finalized, c.Arg(0).AsUserData().Value())
+		return c.Next(), nil
+	}, "__gc", 1, false)
+	meta := NewTable()
+	r.SetEnv(meta, "__gc", FunctionValue(gc))
+
+	func() {
+		r.SetRawMetatable(UserDataValue(NewUserData("marked", nil)), meta)
+		NewUserData("not marked", meta)
+	}()
+	th.CollectGarbage()
+	if len(finalized) != 1 || finalized[0] != "marked" {
+		t.Fatalf("expected one finalized userdata, got %v", finalized)
+	}
+
+	// Finalizers are only run once.
+	th.CollectGarbage()
+	if len(finalized) != 1 {
+		t.Fatalf("finalizer called again: %v", finalized)
+	}
+}
+
+func TestThread_RunPendingFinalizersKeepsCurrentCont(t *testing.T) {
+	r := New(nil)
+	th := r.MainThread()
+	gcCalled := false
+	gc := NewGoFunction(func(t *Thread, c *GoCont) (Cont, error) {
+		gcCalled = true
+		return c.Next(), nil
+	}, "__gc", 1, false)
+	meta := NewTable()
+	r.SetEnv(meta, "__gc", FunctionValue(gc))
+
+	func() {
+		r.SetRawMetatable(UserDataValue(NewUserData("marked", nil)), meta)
+	}()
+	for i := 0; i < 2; i++ {
+		collectAndWaitForFinalizers()
+	}
+
+	// The finalizer is run before f, which should still be the current
+	// continuation when it runs.
+	var current Cont
+	var self Cont
+	f := NewGoFunction(func(t *Thread, c *GoCont) (Cont, error) {
+		current, self = t.CurrentCont(), c
+		return c.Next(), nil
+	}, "f", 0, false)
+	if err := Call(th, FunctionValue(f), nil, NewTerminationWith(nil, 0, false)); err != nil {
+		t.Fatal(err)
+	}
+	if !gcCalled {
+		t.Fatal("finalizer not called")
+	}
+	if current != self {
+		t.Fatalf("wrong current continuation: %v", current)
+	}
+}
@@ -102,6 +102,9 @@ func (c *GoCont) RunInThread(t *Thread) (next Cont, err error) {
 	}
 	next, err = c.f(t, c)
 	_ = t.triggerReturn(t, c)
+	if t.profiler != nil && t.sampleDue() {
+		t.profiler.sample(t.Runtime, c)
+	}
 
 	if err != nil {
 		// If there is an error, c is still potentially needed for error
@@ -135,9 +138,15 @@ func (c *GoCont) DebugInfo() *DebugInfo {
 		name = "<go function>"
 	}
 	return &DebugInfo{
-		Source:      "[Go]",
-		CurrentLine: 0,
-		Name:        name,
+		Source:          "[Go]",
+		CurrentLine:     0,
+		Name:            name,
+		What:            "C",
+		LineDefined:     -1,
+		LastLineDefined: -1,
+		NParams:         c.nArgs,
+		IsVararg:        c.hasEtc,
+		Function:        FunctionValue(c.GoFunction),
 	}
 }
 
@@ -9,6 +9,7 @@ type GoFunction struct {
 	name        string
 	nArgs       int
 	hasEtc      bool
+	eph         ephemerons // See weakref.go
 }
 
 var _ Callable = (*GoFunction)(nil)
@@ -0,0 +1,201 @@
+//go:build !noinlinecache
+// +build !noinlinecache
+
+package runtime
+
+import (
+	"unsafe"
+
+	"github.com/arnodel/golua/code"
+)
+
+//
+// Inline caches
+//
+// Each table lookup opcode (rA <- rB[rC] or rB[rC] <- rA) in a closure has an
+// inline cache.  When the key is a string, it remembers where the lookup was
+// resolved last time, so that e.g. obj:method() does not need to go through the
+// '__index' chain of obj again if nothing changed.  A cache entry records the
+// tables it depends on together with their version (see Table.version), so it
+// is valid as long as none of these tables changed.
+//
+// The entries hold references to the tables they depend on and to the value
+// found, so these are kept alive until the entry is replaced or the closure is
+// collected.  Weak tables are never cached for this reason.
+//
+// Caches are stored in the Closure rather than the Code, as a Code instance can
+// be shared by runtimes which are used concurrently (see Snapshot).
+//
+
+// Maximum number of tables a cache entry can depend on.  A lookup through an
+// '__index' chain depends on two tables per level of the chain, so this allows
+// inheritance to a depth of 4.
+const maxInlineCacheChain = 8
+
+// inlineCacheSlots maps each lookup opcode of a Code to an index in its
+// closures' caches.
+type inlineCacheSlots struct {
+	slots []int32 // slots[pc] is the index of the cache for the opcode at pc
+	count int     // number of lookup opcodes
+}
+
+func newInlineCacheSlots(opcodes []code.Opcode) inlineCacheSlots {
+	slots := make([]int32, len(opcodes))
+	count := 0
+	for pc, op := range opcodes {
+		if op.TypePfx() == code.Type2Pfx {
+			slots[pc] = int32(count)
+			count++
+		}
+	}
+	return inlineCacheSlots{slots: slots, count: count}
+}
+
+// inlineCaches holds the inline caches of a Closure.  It is only allocated when
+// the first cache entry is stored.
+type inlineCaches []inlineCache
+
+type inlineCache struct {
+	key      string
+	n        int // number of tables the entry depends on, 0 if the entry is empty
+	tables   [maxInlineCacheChain]*Table
+	versions [maxInlineCacheChain]uint64
+	val      Value  // the result of a lookup
+	cpu      uint64 // CPU that Index would consume
+}
+
+// Returns true if the entry is valid for looking up the key s in a value whose
+// metatable is meta.  By construction, meta is the first table in the entry.
+func (e *inlineCache) matches(meta *Table, s string) bool {
+	if e.n == 0 || e.tables[0] != meta || e.key != s {
+		return false
+	}
+	for i, t := range e.tables[:e.n] {
+		if t.version != e.versions[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Add t to the tables the entry depends on.  Returns false if the entry cannot
+// depend on t.
+func (e *inlineCache) add(t *Table) bool {
+	if e.n == maxInlineCacheChain || t.mode != 0 {
+		return false
+	}
+	e.tables[e.n] = t
+	e.versions[e.n] = t.version
+	e.n++
+	return true
+}
+
+var indexKey = StringValue("__index")
+var newIndexKey = StringValue("__newindex")
+
+// Resolve the lookup of k in a value whose metatable is meta, provided it does
+// not have k as a key itself.  Returns false if the result cannot be cached,
+// e.g. because the '__index' metamethod is a function.
+func (e *inlineCache) resolveIndex(meta *Table, k Value, isTable bool) bool {
+	e.cpu = 1
+	for meta != nil {
+		if !e.add(meta) {
+			return false
+		}
+		tbl, ok := meta.Get(indexKey).TryTable()
+		if !ok {
+			break
+		}
+		if !e.add(tbl) {
+			return false
+		}
+		e.cpu++
+		if v := tbl.Get(k); !v.IsNil() {
+			e.val = v
+			return true
+		}
+		meta, isTable = tbl.meta, true
+	}
+	// If there is an '__index' field at this point, it is not a table.
+	if !isTable || (meta != nil && !meta.Get(indexKey).IsNil()) {
+		return false
+	}
+	e.val = NilValue
+	return true
+}
+
+func (c *Closure) inlineCache(pc int) *inlineCache {
+	if c.caches == nil {
+		return nil
+	}
+	return &c.caches[c.cacheSlots.slots[pc]]
+}
+
+func (c *Closure) setInlineCache(t *Thread, pc int, e *inlineCache) {
+	if c.caches == nil {
+		t.RequireArrSize(unsafe.Sizeof(inlineCache{}), c.cacheSlots.count)
+		c.caches = make(inlineCaches, c.cacheSlots.count)
+	}
+	c.caches[c.cacheSlots.slots[pc]] = *e
+}
+
+// index is like Index(t, coll, k), using the inline cache of the opcode at pc.
+// It consumes the same amount of CPU as Index.
+func (c *Closure) index(t *Thread, pc int, coll, k Value) (Value, error) {
+	s, ok := k.TryString()
+	if !ok {
+		return Index(t, coll, k)
+	}
+	var meta *Table
+	tbl, isTable := coll.TryTable()
+	if isTable {
+		if v := tbl.Get(k); !v.IsNil() {
+			t.RequireCPU(1)
+			return v, nil
+		}
+		meta = tbl.meta
+	} else {
+		meta = t.RawMetatable(coll)
+	}
+	if e := c.inlineCache(pc); e != nil && e.matches(meta, s) {
+		t.RequireCPU(e.cpu)
+		return e.val, nil
+	}
+	e := inlineCache{key: s}
+	if !e.resolveIndex(meta, k, isTable) {
+		return Index(t, coll, k)
+	}
+	if e.n > 0 {
+		c.setInlineCache(t, pc, &e)
+	}
+	t.RequireCPU(e.cpu)
+	return e.val, nil
+}
+
+// setIndex is like SetIndex(t, coll, k, val), using the inline cache of the
+// opcode at pc.  It consumes the same amount of CPU as SetIndex.
+func (c *Closure) setIndex(t *Thread, pc int, coll, k, val Value) error {
+	s, ok := k.TryString()
+	tbl, isTable := coll.TryTable()
+	if !ok || !isTable {
+		return SetIndex(t, coll, k, val)
+	}
+	if meta := tbl.meta; meta != nil {
+		if e := c.inlineCache(pc); e == nil || !e.matches(meta, s) {
+			if !meta.Get(newIndexKey).IsNil() {
+				return SetIndex(t, coll, k, val)
+			}
+			// The new entry records that meta has no '__newindex' field.
+			ne := inlineCache{key: s}
+			if ne.add(meta) {
+				c.setInlineCache(t, pc, &ne)
+			}
+		}
+	}
+	// Setting coll[k] does not involve metamethods now.
+	t.RequireCPU(1)
+	if !tbl.Reset(k, val) {
+		t.SetTable(tbl, k, val)
+	}
+	return nil
+}
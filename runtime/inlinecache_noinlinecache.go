@@ -0,0 +1,25 @@
+//go:build noinlinecache
+// +build noinlinecache
+
+// This version disables inline caches for table lookups.  It makes it possible
+// to measure their effect in benchmarks.
+
+package runtime
+
+import "github.com/arnodel/golua/code"
+
+type inlineCacheSlots struct{}
+
+func newInlineCacheSlots(opcodes []code.Opcode) inlineCacheSlots {
+	return inlineCacheSlots{}
+}
+
+type inlineCaches struct{}
+
+func (c *Closure) index(t *Thread, pc int, coll, k Value) (Value, error) {
+	return Index(t, coll, k)
+}
+
+func (c *Closure) setIndex(t *Thread, pc int, coll, k, val Value) error {
+	return SetIndex(t, coll, k, val)
+}
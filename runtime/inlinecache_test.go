@@ -0,0 +1,110 @@
+package runtime_test
+
+import (
+	"testing"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// Compare with the results obtained with the noinlinecache build tag to see
+// the effect of inline caches.
+func BenchmarkInlineCache(b *testing.B) {
+	const classes = `
+local Point = {}
+Point.__index = Point
+
+function Point.new(x, y)
+    return setmetatable({x = x, y = y}, Point)
+end
+
+function Point:norm2()
+    return self.x * self.x + self.y * self.y
+end
+
+function Point:add(p)
+    self.x = self.x + p.x
+    self.y = self.y + p.y
+end
+
+local Point3 = setmetatable({}, Point)
+Point3.__index = Point3
+
+function Point3.new(x, y, z)
+    local p = Point.new(x, y)
+    p.z = z
+    return setmetatable(p, Point3)
+end
+
+function Point3:volume()
+    return self.x * self.y * self.z
+end
+`
+	benchmarks := []struct {
+		name string
+		src  string
+	}{
+		{
+			name: "Method",
+			src: `
+local p, q = Point.new(1, 2), Point.new(3, 4)
+return function()
+    local n = 0
+    for i = 1, 1000 do
+        p:add(q)
+        n = n + p:norm2()
+    end
+    return n
+end`,
+		},
+		{
+			name: "InheritedMethod",
+			src: `
+local p = Point3.new(1, 2, 3)
+return function()
+    local n = 0
+    for i = 1, 1000 do
+        n = n + p:norm2() + p:volume()
+    end
+    return n
+end`,
+		},
+		{
+			name: "StringMethod",
+			src: `
+local s = "abc"
+return function()
+    local n = 0
+    for i = 1, 1000 do
+        n = n + s:len() + #s:sub(2)
+    end
+    return n
+end`,
+		},
+		{
+			name: "Field",
+			src: `
+local p = Point.new(1, 2)
+return function()
+    local n = 0
+    for i = 1, 1000 do
+        n = n + p.x + p.y
+    end
+    return n
+end`,
+		},
+	}
+	for _, bb := range benchmarks {
+		b.Run(bb.name, func(b *testing.B) {
+			r := rt.New(nil)
+			lib.LoadAll(r)
+			f := runChunk(b, r, classes+bb.src)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := r.SafeCall(f, nil, rt.NewTerminationWith(nil, 1, false)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -323,8 +323,8 @@ func (r *Runtime) compileLuaStat(name string, stat *ast.BlockStat, statSize uint
 
 	statSize = 0 // So that the deferred function above doesn't release the memory again.
 
-	// "Optimise" the ir code
-	constants = ir.FoldConstants(constants, ir.DefaultFold)
+	// Optimise the ir code
+	constants = ir.Optimize(constants, r.optLevel)
 
 	// Set up the IR to code compiler
 	kc := ircomp.NewConstantCompiler(constants, code.NewBuilder(name))
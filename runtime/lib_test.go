@@ -75,3 +75,30 @@ func TestRuntime_CompileAndLoadLuaChunkOrExp(t *testing.T) {
 		})
 	}
 }
+
+func TestRuntime_OptimizationLevel(t *testing.T) {
+	source := []byte(`
+local x = 1 + 2 * 3
+local y = x
+if false then
+	print("never")
+end
+local t = {}
+t[x] = y
+print(t[x], "a" .. "b", 2 < 3)
+return t
+`)
+	var sizes []int
+	for level := 0; level <= 2; level++ {
+		r := New(nil)
+		r.SetOptimizationLevel(level)
+		unit, _, err := r.CompileLuaChunk("test", source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, len(unit.Code))
+	}
+	if !(sizes[0] > sizes[1] && sizes[1] > sizes[2]) {
+		t.Errorf("expected fewer instructions at higher levels, got %v", sizes)
+	}
+}
@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"sort"
 	"unsafe"
 
 	"github.com/arnodel/golua/code"
@@ -17,6 +18,43 @@ type Code struct {
 	UpNames      []string
 	RegCount     int16
 	CellCount    int16
+	localVars    []code.LocalVar
+	coverage     codeCoverage     // Empty unless coverage is collected
+	cacheSlots   inlineCacheSlots // See inlinecache.go
+	// Information about the function definition
+	nameWhat                     string
+	lineDefined, lastLineDefined int32
+	nParams                      int16
+	isVararg                     bool
+}
+
+// ParameterName returns the name of the n-th parameter (starting from 1) of the
+// function, if it is known.
+func (c *Code) ParameterName(n int) (string, bool) {
+	for _, v := range c.localVars {
+		if v.Name != varargsLocalName && v.IsActive(0) {
+			n--
+			if n == 0 {
+				return v.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// ActiveLines returns the lines of the function which contain code, in
+// increasing order.
+func (c *Code) ActiveLines() []int32 {
+	var lines []int32
+	seen := map[int32]bool{}
+	for _, l := range c.lines {
+		if l > 0 && !seen[l] {
+			seen[l] = true
+			lines = append(lines, l)
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i] < lines[j] })
+	return lines
 }
 
 // RefactorConsts returns an equivalent *Code this consts "refactored", which
@@ -25,34 +63,48 @@ type Code struct {
 func (r *Runtime) RefactorCodeConsts(c *Code) *Code {
 	r.RequireArrSize(unsafe.Sizeof(code.Opcode(0)), len(c.code))
 	opcodes := make([]code.Opcode, len(c.code))
+	copy(opcodes, c.code)
 	var consts []Value
 	constMap := map[code.KIndex]code.KIndex{}
 
-	// Require CPU for the loop below
-	r.RequireCPU(uint64(len(c.code)))
+	// Require CPU for the loops below
+	r.RequireCPU(2 * uint64(len(c.code)))
 
-	for i, op := range c.code {
-		if op.TypePfx() == code.Type3Pfx {
-			unop := op.GetY()
-			if unop.LoadsK() {
-				// We are loading a constant
-				n := op.GetKIndex()
-				m, ok := constMap[n]
-				if !ok {
-					m = code.KIndexFromInt(len(consts))
-					constMap[n] = m
-					newConst := c.consts[n]
-					if unop == code.OpClosureK {
-						// It's a closure so we need to refactor its consts
-						newConst = CodeValue(r.RefactorCodeConsts(newConst.AsCode()))
+	// Constants loaded by opcodes without a wide prefix are renumbered first so
+	// that their new index fits in the opcode as well.
+	for _, wide := range [2]bool{false, true} {
+		var prefix code.Opcode
+		for i, op := range c.code {
+			if op.IsWidePrefix() {
+				prefix = op
+				continue
+			}
+			if op.TypePfx() == code.Type3Pfx && (prefix != 0) == wide {
+				unop := op.GetY()
+				if unop.LoadsK() {
+					// We are loading a constant
+					n := op.GetWideKIndex(prefix)
+					m, ok := constMap[n]
+					if !ok {
+						m = code.KIndexFromInt(len(consts))
+						constMap[n] = m
+						newConst := c.consts[n]
+						if unop == code.OpClosureK {
+							// It's a closure so we need to refactor its consts
+							newConst = CodeValue(r.RefactorCodeConsts(newConst.AsCode()))
+						}
+						r.RequireSize(unsafe.Sizeof(Value{}))
+						consts = append(consts, newConst)
+					}
+					instr, _ := code.Instr{Prefix: prefix, Opcode: op}.SetKIndex(m)
+					opcodes[i] = instr.Opcode
+					if wide {
+						opcodes[i-1] = instr.Prefix
 					}
-					r.RequireSize(unsafe.Sizeof(Value{}))
-					consts = append(consts, newConst)
 				}
-				op = op.SetKIndex(m)
 			}
+			prefix = 0
 		}
-		opcodes[i] = op
 	}
 	cc := *c
 	cc.code = opcodes
@@ -69,10 +121,16 @@ func (r *Runtime) LoadLuaUnit(unit *code.Unit, env Value) *Closure {
 	// code.Code case below
 	r.RequireArrSize(unsafe.Sizeof(code.Opcode(0)), len(unit.Code))
 	r.RequireArrSize(4, len(unit.Lines))
+	r.RequireArrSize(4, len(unit.Code)) // for the inline cache slots
 
 	// Require CPU for the loop below
 	r.RequireCPU(uint64(len(unit.Constants)))
 
+	var unitCov *UnitCoverage
+	if r.coverage != nil {
+		unitCov = r.coverage.addUnit(unit)
+	}
+
 	for i, ck := range unit.Constants {
 		switch k := ck.(type) {
 		case code.Int:
@@ -92,16 +150,31 @@ func (r *Runtime) LoadLuaUnit(unit *code.Unit, env Value) *Closure {
 			if unit.Lines != nil {
 				lines = unit.Lines[k.StartOffset:k.EndOffset]
 			}
+			var cov codeCoverage
+			if unitCov != nil {
+				cov.hits = unitCov.Hits[k.StartOffset:k.EndOffset]
+				cov.jumps = unitCov.Jumps[k.StartOffset:k.EndOffset]
+			}
+			opcodes := unit.Code[k.StartOffset:k.EndOffset]
 			constants[i] = CodeValue(&Code{
 				source:       unit.Source,
 				name:         k.Name,
-				code:         unit.Code[k.StartOffset:k.EndOffset],
+				code:         opcodes,
 				lines:        lines,
 				consts:       constants,
 				UpvalueCount: k.UpvalueCount,
 				UpNames:      k.UpNames,
 				RegCount:     k.RegCount,
 				CellCount:    k.CellCount,
+				localVars:    k.LocalVars,
+				coverage:     cov,
+				cacheSlots:   newInlineCacheSlots(opcodes),
+
+				nameWhat:        k.NameWhat,
+				lineDefined:     k.LineDefined,
+				lastLineDefined: k.LastLineDefined,
+				nParams:         k.NParams,
+				isVararg:        k.IsVararg,
 			})
 		default:
 			panic("Unsupported constant type")
@@ -0,0 +1,134 @@
+-- Loops whose start and step are integer literals are compiled to integer
+-- specialised opcodes.  These tests check they behave like generic loops.
+
+local function collect(...)
+    return table.concat({...}, " ")
+end
+
+do
+    local t = {}
+    for i = 1, 5 do
+        t[#t + 1] = i * 2 - 1
+    end
+    print(collect(table.unpack(t)))
+end
+--> =1 3 5 7 9
+
+do
+    local t = {}
+    for i = 10, 1, -3 do
+        t[#t + 1] = i
+    end
+    print(collect(table.unpack(t)))
+end
+--> =10 7 4 1
+
+-- The loop variable is an integer even with a float limit
+do
+    local t = {}
+    for i = 1, 3.5 do
+        t[#t + 1] = math.type(i)
+    end
+    print(collect(table.unpack(t)))
+end
+--> =integer integer integer
+
+do
+    local n = 0
+    for i = -1, -3.5, -1 do
+        n = n + i
+    end
+    print(n)
+end
+--> =-6
+
+-- Huge and NaN limits
+do
+    local n = 0
+    for i = 1, -math.huge do n = n + 1 end
+    for i = 1, 0 / 0 do n = n + 1 end
+    for i = 1, 0 / 0, -1 do n = n + 1 end
+    print(n)
+end
+--> =0
+
+do
+    local n = 0
+    for i = -2, math.huge do
+        n = n + 1
+        if i == 2 then break end
+    end
+    print(n)
+end
+--> =5
+
+-- A limit given as a string
+do
+    local n = 0
+    for i = 1, "3" do n = n + i end
+    print(n)
+end
+--> =6
+
+-- No wrapping around
+do
+    local n = 0
+    local max = math.maxinteger
+    for i = 1, max, max // 2 do
+        n = n + 1
+    end
+    print(n)
+end
+--> =3
+
+do
+    local n = 0
+    for i = -1, math.mininteger, math.mininteger do
+        n = n + 1
+    end
+    print(n)
+end
+--> =1
+
+-- Arithmetic on the loop variable may overflow, like with generic loops
+do
+    local last
+    for i = 0, 1 do
+        last = i + math.maxinteger
+    end
+    print(last == math.mininteger)
+end
+--> =true
+
+-- Assigning to the loop variable turns off specialisation
+do
+    local t = {}
+    for i = 1, 3 do
+        i = i / 2
+        t[#t + 1] = math.type(i + 1)
+    end
+    print(collect(table.unpack(t)))
+end
+--> =float float float
+
+-- The loop variable can be captured
+do
+    local fs = {}
+    for i = 1, 3 do
+        fs[i] = function() return i * 10 end
+    end
+    print(fs[1](), fs[2](), fs[3]())
+end
+--> =10	20	30
+
+-- Nested loops using the outer loop variable
+do
+    local n = 0
+    for i = 1, 4 do
+        for j = i, 4 do
+            n = n + i * j
+        end
+    end
+    print(n)
+end
+--> =65
@@ -0,0 +1,168 @@
+-- Lookups are repeated in a loop so that the inline cache of the lookup opcode
+-- is used.  Each test checks that the cache does not return stale results.
+
+local function lookup(x, n)
+    local res = {}
+    for i = 1, n do
+        res[i] = tostring(x.f)
+    end
+    return table.concat(res, " ")
+end
+
+-- A method in the class
+local Class = {}
+Class.__index = Class
+function Class.f() return "m" end
+local obj = setmetatable({}, Class)
+print(lookup(obj, 2))
+--> ~^function: .* function: .*$
+
+-- Changing the method
+Class.f = 1
+print(lookup(obj, 2))
+--> =1 1
+
+-- A field in the object shadows the method
+obj.f = 2
+print(lookup(obj, 2))
+--> =2 2
+
+obj.f = nil
+print(lookup(obj, 2))
+--> =1 1
+
+-- Removing the method
+Class.f = nil
+print(lookup(obj, 2))
+--> =nil nil
+
+Class.f = 3
+print(lookup(obj, 2))
+--> =3 3
+
+-- Changing the '__index' field of the metatable
+local Other = {f = 4}
+Class.__index = Other
+print(lookup(obj, 2))
+--> =4 4
+Class.__index = Class
+
+-- Changing the metatable of the object
+setmetatable(obj, {__index = Other})
+print(lookup(obj, 2))
+--> =4 4
+setmetatable(obj, Class)
+print(lookup(obj, 2))
+--> =3 3
+
+-- Objects of the same class share the cache entry
+local function lookupAll(xs)
+    local res = {}
+    for i, x in ipairs(xs) do
+        res[i] = tostring(x.f)
+    end
+    return table.concat(res, " ")
+end
+print(lookupAll({obj, setmetatable({}, Class), setmetatable({f = 5}, Class), obj}))
+--> =3 3 5 3
+
+-- Inheritance
+local Base = {f = "base"}
+Base.__index = Base
+local Derived = setmetatable({}, Base)
+Derived.__index = Derived
+local dobj = setmetatable({}, Derived)
+print(lookup(dobj, 2))
+--> =base base
+
+Derived.f = "derived"
+print(lookup(dobj, 2))
+--> =derived derived
+
+Derived.f = nil
+Base.f = "base2"
+print(lookup(dobj, 2))
+--> =base2 base2
+
+-- Changing the metatable of a class in the chain
+setmetatable(Derived, {__index = {f = "other"}})
+print(lookup(dobj, 2))
+--> =other other
+
+setmetatable(Derived, nil)
+print(lookup(dobj, 2))
+--> =nil nil
+
+-- An '__index' function is called each time
+local count = 0
+local fobj = setmetatable({}, {__index = function(t, k) count = count + 1 return count end})
+print(lookup(fobj, 3))
+--> =1 2 3
+
+-- Strings use their metatable
+local function upper(s, n)
+    local res = {}
+    for i = 1, n do
+        res[i] = s:upper()
+    end
+    return table.concat(res, " ")
+end
+print(upper("abc", 2))
+--> =ABC ABC
+
+local upper0 = string.upper
+string.upper = function() return "no" end
+print(upper("abc", 2))
+--> =no no
+string.upper = upper0
+
+-- Non-string keys are not cached
+local mt = {__index = {[1] = "one", [true] = "yes"}}
+local nobj = setmetatable({}, mt)
+for _, k in ipairs({1, true, 1}) do
+    print(nobj[k])
+end
+--> =one
+--> =yes
+--> =one
+
+-- Errors are still reported
+print(pcall(lookup, 1, 1))
+--> ~^false\t.*attempt to index a number value
+
+print(pcall(lookup, setmetatable({}, {__index = 1}), 1))
+--> ~^false\t.*attempt to call a number value
+
+-- Assigning fields
+local function assign(x, v, n)
+    for i = 1, n do
+        x.g = v
+    end
+end
+
+local log = {}
+local SetClass = {}
+local sobj = setmetatable({}, SetClass)
+assign(sobj, 1, 2)
+print(rawget(sobj, "g"))
+--> =1
+
+-- Adding a '__newindex' field to the metatable
+sobj.g = nil
+SetClass.__newindex = function(t, k, v) log[#log + 1] = v end
+assign(sobj, 2, 2)
+print(rawget(sobj, "g"), table.concat(log, " "))
+--> =nil	2 2
+
+-- '__newindex' is not used when the key is present
+rawset(sobj, "g", 0)
+assign(sobj, 3, 2)
+print(rawget(sobj, "g"), table.concat(log, " "))
+--> =3	2 2
+
+-- Removing the '__newindex' field
+sobj.g = nil
+SetClass.__newindex = nil
+assign(sobj, 4, 1)
+print(rawget(sobj, "g"))
+--> =4
@@ -0,0 +1,47 @@
+-- Opcodes have limited room for jump offsets, register indexes and constant
+-- indexes.  Larger values are encoded with a wide prefix, so big generated
+-- chunks still compile.
+
+-- Forward jump over more than 32767 opcodes
+local f = load("local x = 0 if x then " .. string.rep("x = x + 1 ", 20000) .. "end return x")
+print(f())
+--> =20000
+
+-- Backward jump over more than 32767 opcodes
+f = load("local i, x = 0, 0 while i < 2 do " .. string.rep("x = x + 1 ", 20000) .. "i = i + 1 end return x")
+print(f())
+--> =40000
+
+-- More than 256 registers and cells
+local names, args = {}, {}
+for i = 1, 300 do
+    names[i] = "v" .. i
+    args[i] = i
+end
+f = load("local " .. table.concat(names, ", ") .. " = ... return v1 + v300, function() return v256 end, {...}")
+local s, g, t = f(table.unpack(args))
+print(s, g(), #t, t[300])
+--> =301	256	300	300
+
+-- More than 65536 constants
+local items = {}
+for i = 1, 70000 do
+    items[i] = "'s" .. i .. "'"
+end
+f = load("return {" .. table.concat(items, ", ") .. "}")
+t = f()
+print(#t, t[1], t[70000])
+--> =70000	s1	s70000
+
+-- Functions with wide opcodes can be dumped
+t = load(string.dump(f))()
+print(#t, t[65537])
+--> =70000	s65537
+
+-- Limits that remain are reported as errors
+local locals = {}
+for i = 1, 33000 do
+    locals[i] = "local x" .. i
+end
+print(load(table.concat(locals, " ")))
+--> ~^nil\t.*not enough registers
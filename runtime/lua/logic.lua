@@ -0,0 +1,31 @@
+-- and / or return one of their operands and only evaluate the right one if
+-- needed.
+
+print(1 and 2, nil and 2, false and 2, 1 and nil)
+--> =2	nil	false	nil
+
+print(1 or 2, nil or 2, false or nil, nil or false)
+--> =1	2	nil	false
+
+local function f(x)
+    print("f", x)
+    return x
+end
+
+print(f(false) and f(1))
+--> =f	false
+--> =false
+
+print(f(1) or f(2))
+--> =f	1
+--> =1
+
+print(f(1) and f(nil) or f(3))
+--> =f	1
+--> =f	nil
+--> =f	3
+--> =3
+
+local x, y = 5, nil
+print(x > 1 and x < 10, y and y.z, not y and "no y")
+--> =true	nil	no y
@@ -0,0 +1,155 @@
+local function count(t)
+    local n = 0
+    for _ in pairs(t) do
+        n = n + 1
+    end
+    return n
+end
+
+-- Weak values
+do
+    local t = setmetatable({}, {__mode = "v"})
+    local keep = {}
+    t[1] = keep
+    t[2] = {}
+    t.x = {}
+    t.y = keep
+    t.s = "strings are not collected"
+    collectgarbage()
+    print(t[1] == keep, t[2], t.x, t.y == keep, t.s)
+    --> =true	nil	nil	true	strings are not collected
+    print(count(t))
+    --> =3
+end
+
+-- Weak keys
+do
+    local t = setmetatable({}, {__mode = "k"})
+    local keep = {}
+    t[keep] = 1
+    t[{}] = 2
+    t[function() end] = 3
+    t.x = {}
+    collectgarbage()
+    print(t[keep], count(t))
+    --> =1	2
+end
+
+-- Weak keys and values
+do
+    local t = setmetatable({}, {__mode = "kv"})
+    local k, v = {}, {}
+    t[k] = {}
+    t[{}] = v
+    t[k] = v
+    t[{}] = {}
+    collectgarbage()
+    print(t[k] == v, count(t))
+    --> =true	1
+end
+
+-- Values are kept alive as long as their key is (ephemerons)
+do
+    local t = setmetatable({}, {__mode = "k"})
+    local k = {}
+    t[k] = {"value"}
+    collectgarbage()
+    print(t[k][1])
+    --> =value
+    k = nil
+    collectgarbage()
+    print(count(t))
+    --> =0
+end
+
+-- A value referring to its key does not keep the entry alive
+do
+    local t = setmetatable({}, {__mode = "k"})
+    local keep = {}
+    t[keep] = {keep}
+    local function fill()
+        local k1, k2 = {}, {}
+        t[k1] = {k1}
+        t[k2] = {k1 = k1, k2 = k2}
+        local k3 = {}
+        t[k3] = function() return k3 end
+    end
+    fill()
+    collectgarbage()
+    print(t[keep][1] == keep, count(t))
+    --> =true	1
+end
+
+-- Chains of ephemerons are kept alive from the first key
+do
+    local t = setmetatable({}, {__mode = "k"})
+    local k1 = {}
+    local function fill()
+        local k2, k3 = {}, {}
+        t[k1] = k2
+        t[k2] = k3
+        t[k3] = {k1}
+        -- Cycles between entries are collected
+        local c1, c2 = {}, {}
+        t[c1] = c2
+        t[c2] = c1
+    end
+    fill()
+    collectgarbage()
+    local function check()
+        return t[t[t[k1]]][1] == k1
+    end
+    print(count(t), check())
+    --> =3	true
+    k1 = nil
+    collectgarbage()
+    print(count(t))
+    --> =0
+end
+
+-- Values stay alive while their key is alive even if the entry is changed
+do
+    local t = setmetatable({}, {__mode = "k"})
+    local k = {}
+    t[k] = {1}
+    t[k] = {2}
+    t[k] = "not collectable"
+    t[k] = {3}
+    collectgarbage()
+    print(t[k][1])
+    --> =3
+    -- The table is no longer an ephemeron table
+    setmetatable(t, nil)
+    collectgarbage()
+    print(t[k][1])
+    --> =3
+end
+
+-- A table can become weak after it has been populated
+do
+    local t = {}
+    t[1] = {}
+    t[2] = 2
+    setmetatable(t, {__mode = "v"})
+    collectgarbage()
+    print(t[1], t[2])
+    --> =nil	2
+end
+
+-- Iterating over a weak table skips collected entries
+do
+    local t = setmetatable({}, {__mode = "v"})
+    local keep = {}
+    local function fill()
+        for i = 1, 10 do
+            t[i] = {}
+        end
+    end
+    fill()
+    t[5] = keep
+    collectgarbage()
+    for k, v in pairs(t) do
+        print(k, v == keep)
+    end
+    --> =5	true
+end
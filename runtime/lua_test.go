@@ -3,10 +3,23 @@ package runtime_test
 import (
 	"testing"
 
+	"github.com/arnodel/golua/ir"
 	"github.com/arnodel/golua/lib"
 	"github.com/arnodel/golua/luatesting"
+	rt "github.com/arnodel/golua/runtime"
 )
 
 func TestRuntime(t *testing.T) {
 	luatesting.RunLuaTestsInDir(t, "lua", lib.LoadAll)
 }
+
+func TestRuntimeOptimized(t *testing.T) {
+	for _, level := range []int{ir.OptBasic, ir.OptFull} {
+		level := level
+		setup := func(r *rt.Runtime) func() {
+			r.SetOptimizationLevel(level)
+			return lib.LoadAll(r)
+		}
+		luatesting.RunLuaTestsInDir(t, "lua", setup)
+	}
+}
@@ -2,7 +2,7 @@ package runtime
 
 import (
 	"errors"
-	"fmt"
+	"sync/atomic"
 	"unsafe"
 
 	"github.com/arnodel/golua/code"
@@ -14,11 +14,13 @@ type LuaCont struct {
 	*Closure
 	registers      []Value
 	cells          []Cell
-	pc             int16
+	pc             int
 	acc            []Value
 	running        bool
 	borrowedCells  bool
 	closeStackBase int
+	tailCall       bool  // true if the continuation was made for a tail call
+	lastLine       int32 // last line reported to the line hook
 }
 
 var _ Cont = (*LuaCont)(nil)
@@ -69,16 +71,23 @@ func (c *LuaCont) release(r *Runtime) {
 
 // Push implements Cont.Push.
 func (c *LuaCont) Push(r *Runtime, val Value) {
-	opcode := c.code[c.pc]
+	pc := c.pc
+	opcode := c.code[pc]
+	var wide code.Opcode
+	if opcode.IsWidePrefix() {
+		wide = opcode
+		pc++
+		opcode = c.code[pc]
+	}
 	if opcode.HasType0() {
 		r.RequireCPU(1)
-		dst := opcode.GetA()
+		dst := opcode.GetWideA(wide)
 		if opcode.GetF() {
 			// It's an etc
 			r.RequireSize(unsafe.Sizeof(Value{}))
 			c.acc = append(c.acc, val)
 		} else {
-			c.pc++
+			c.pc = pc + 1
 			setReg(c.registers, c.cells, dst, val)
 		}
 	}
@@ -109,29 +118,52 @@ func (c *LuaCont) RunInThread(t *Thread) (Cont, error) {
 	pc := c.pc
 	consts := c.consts
 	lines := c.lines
-	var lastLine int32
 	c.running = true
 	opcodes := c.code
 	regs := c.registers
 	cells := c.cells
+	hits := c.coverage.hits
+	lastPC := pc // The instruction that ran last, for the profiler
 RunLoop:
 	for {
-		t.RequireCPU(1)
+		if t.requireInstrCPU() {
+			// A profile sample is due, it is attributed to the instruction
+			// that just ran.
+			c.pc = lastPC
+			t.profiler.sample(t.Runtime, c)
+			t.RequireCPU(1)
+		}
+		lastPC = pc
 
-		if t.DebugHooks.areFlagsEnabled(HookFlagLine) {
+		// Stripped code has no line information.
+		if t.DebugHooks.areFlagsEnabled(HookFlagLine) && int(pc) < len(lines) {
 			line := lines[pc]
-			if line > 0 && line != lastLine {
-				lastLine = line
+			if line > 0 && line != c.lastLine {
+				c.lastLine = line
+				// The hook may inspect local variables, which depend on
+				// the current pc.
+				c.pc = pc
 				if err := t.triggerLine(t, c, line); err != nil {
 					return nil, err
 				}
 			}
 		}
 		opcode := opcodes[pc]
+		var wide code.Opcode
+		if opcode.IsWidePrefix() {
+			// The fields of the opcode do not all fit in it, their high bits
+			// are in this prefix.
+			wide = opcode
+			pc++
+			opcode = opcodes[pc]
+		}
+		if hits != nil {
+			atomic.AddUint64(&hits[pc], 1)
+		}
 		if opcode.HasType1() {
-			dst := opcode.GetA()
-			x := getReg(regs, cells, opcode.GetB())
-			y := getReg(regs, cells, opcode.GetC())
+			dst := opcode.GetWideA(wide)
+			x := getReg(regs, cells, opcode.GetWideB(wide))
+			y := getReg(regs, cells, opcode.GetWideC(wide))
 			var res Value
 			var err error
 			var ok bool
@@ -220,7 +252,7 @@ RunLoop:
 		}
 		switch opcode.TypePfx() {
 		case code.Type0Pfx:
-			dst := opcode.GetA()
+			dst := opcode.GetWideA(wide)
 			if opcode.GetF() {
 				// It's an etc
 				setReg(regs, cells, dst, ArrayValue(c.acc))
@@ -230,18 +262,18 @@ RunLoop:
 			pc++
 			continue RunLoop
 		case code.Type2Pfx:
-			reg := opcode.GetA()
-			coll := getReg(regs, cells, opcode.GetB())
-			idx := getReg(regs, cells, opcode.GetC())
+			reg := opcode.GetWideA(wide)
+			coll := getReg(regs, cells, opcode.GetWideB(wide))
+			idx := getReg(regs, cells, opcode.GetWideC(wide))
 			if !opcode.GetF() {
-				val, err := Index(t, coll, idx)
+				val, err := c.index(t, pc, coll, idx)
 				if err != nil {
 					c.pc = pc
 					return nil, err
 				}
 				setReg(regs, cells, reg, val)
 			} else {
-				err := SetIndex(t, coll, idx, getReg(regs, cells, reg))
+				err := c.setIndex(t, pc, coll, idx, getReg(regs, cells, reg))
 				if err != nil {
 					c.pc = pc
 					return nil, err
@@ -258,13 +290,13 @@ RunLoop:
 			case code.OpStr2:
 				val = StringValue(string(code.Lit16(n).ToStr2()))
 			case code.OpK:
-				val = consts[n]
+				val = consts[opcode.GetWideKIndex(wide)]
 			case code.OpClosureK:
-				val = FunctionValue(NewClosure(t.Runtime, consts[n].AsCode()))
+				val = FunctionValue(NewClosure(t.Runtime, consts[opcode.GetWideKIndex(wide)].AsCode()))
 			default:
 				panic("Unsupported opcode")
 			}
-			dst := opcode.GetA()
+			dst := opcode.GetWideA(wide)
 			if opcode.GetF() {
 				// dst must contain a continuation
 				cont := getReg(regs, cells, dst).AsCont()
@@ -275,12 +307,12 @@ RunLoop:
 			pc++
 			continue RunLoop
 		case code.Type4Pfx:
-			dst := opcode.GetA()
+			dst := opcode.GetWideA(wide)
 			var res Value
 			var ok bool
 			var err error
 			if opcode.HasType4a() {
-				val := getReg(regs, cells, opcode.GetB())
+				val := getReg(regs, cells, opcode.GetWideB(wide))
 				switch opcode.GetUnOp() {
 				case code.OpNeg:
 					res, ok = Unm(val)
@@ -298,6 +330,9 @@ RunLoop:
 				case code.OpTailCont:
 					var cont Cont
 					cont, err = Continue(t, val, c.Next())
+					if lc, ok := cont.(*LuaCont); ok {
+						lc.tailCall = true
+					}
 					res = ContValue(cont)
 				case code.OpId:
 					res = val
@@ -313,7 +348,7 @@ RunLoop:
 					res = BoolValue(!Truth(val))
 				case code.OpUpvalue:
 					// TODO: wasteful as we already have got getReg
-					cell := c.getRegCell(opcode.GetB())
+					cell := c.getRegCell(opcode.GetWideB(wide))
 					getReg(regs, cells, dst).AsClosure().AddUpvalue(cell)
 					pc++
 					continue RunLoop
@@ -326,7 +361,7 @@ RunLoop:
 				case code.OpCC:
 					res = ContValue(c)
 				case code.OpTable:
-					res = TableValue(NewTable())
+					res = TableValue(t.NewTable())
 				case code.OpStr0:
 					res = StringValue("")
 				case code.OpStr1:
@@ -358,12 +393,15 @@ RunLoop:
 		case code.Type5Pfx:
 			switch opcode.GetJ() {
 			case code.OpJump:
-				pc += int16(opcode.GetOffset())
+				pc += int(opcode.GetWideOffset(wide))
 				continue RunLoop
 			case code.OpJumpIf:
-				test := Truth(getReg(regs, cells, opcode.GetA()))
+				test := Truth(getReg(regs, cells, opcode.GetWideA(wide)))
 				if test == opcode.GetF() {
-					pc += int16(opcode.GetOffset())
+					if hits != nil {
+						atomic.AddUint64(&c.coverage.jumps[pc], 1)
+					}
+					pc += int(opcode.GetWideOffset(wide))
 				} else {
 					pc++
 				}
@@ -373,7 +411,7 @@ RunLoop:
 				c.pc = pc
 				c.acc = nil
 				c.running = false
-				contReg := opcode.GetA()
+				contReg := opcode.GetWideA(wide)
 				isTail := opcode.GetF() // Can mean tail call or simple return
 				next := getReg(regs, cells, contReg).AsCont()
 
@@ -402,6 +440,10 @@ RunLoop:
 					}
 				}
 
+				if t.profiler != nil && t.sampleDue() {
+					t.profiler.sample(t.Runtime, c)
+				}
+
 				if isTail {
 					// It's a tail call.  There is no error, so nothing will
 					// reference c anymore, therefore we are safe to give it to
@@ -413,7 +455,7 @@ RunLoop:
 			case code.OpClStack:
 				if opcode.GetF() {
 					// Push to close stack
-					v := getReg(regs, cells, opcode.GetA())
+					v := getReg(regs, cells, opcode.GetWideA(wide))
 					if Truth(v) && t.metaGetS(v, "__close").IsNil() {
 						c.pc = pc
 						return nil, errors.New("to be closed value missing a __close metamethod")
@@ -433,9 +475,9 @@ RunLoop:
 				panic("unsupported")
 			}
 		case code.Type6Pfx:
-			dst := opcode.GetA()
-			etc := getReg(regs, cells, opcode.GetB()).AsArray()
-			idx := int(opcode.GetM())
+			dst := opcode.GetWideA(wide)
+			etc := getReg(regs, cells, opcode.GetWideB(wide)).AsArray()
+			idx := opcode.GetWideM(wide)
 			var val Value
 			if idx < len(etc) {
 				val = etc[idx]
@@ -451,77 +493,76 @@ RunLoop:
 			pc++
 			continue RunLoop
 		case code.Type7Pfx:
-			startReg, stopReg, stepReg := opcode.GetA(), opcode.GetB(), opcode.GetC()
+			startReg, stopReg, stepReg := opcode.GetWideA(wide), opcode.GetWideB(wide), opcode.GetWideC(wide)
 			start := getReg(regs, cells, startReg)
 			stop := getReg(regs, cells, stopReg)
 			step := getReg(regs, cells, stepReg)
 			if opcode.GetF() {
 				// Advance for loop.  All registers are assumed to contain
 				// numeric values because they have been prepared previously.
-				nextStart, _ := Add(start, step)
-
-				// Check if the loop is done.  It can be done if we have gone
-				// over the stop value or if there has been overflow /
-				// underflow.
-				var done bool
-				if isPositive(step) {
-					done = numIsLessThan(stop, nextStart) || numIsLessThan(nextStart, start)
-				} else {
-					done = numIsLessThan(nextStart, stop) || numIsLessThan(start, nextStart)
-				}
-				if done {
-					nextStart = NilValue
-				}
-				setReg(regs, cells, startReg, nextStart)
+				setReg(regs, cells, startReg, advForLoop(start, stop, step))
 			} else {
 				// Prepare for loop
-				start, tstart := ToNumberValue(start)
-				stop, tstop := ToNumberValue(stop)
-				step, tstep := ToNumberValue(step)
-				if tstart == NaN || tstop == NaN || tstep == NaN {
+				start, stop, step, err := prepForLoop(start, stop, step)
+				if err != nil {
 					c.pc = pc
-					var (
-						role string
-						val  Value
-					)
-					switch {
-					case tstart == NaN:
-						role, val = "initial value", start
-					case tstop == NaN:
-						role, val = "limit", stop
-					default:
-						role, val = "step", step
-					}
-					return nil, fmt.Errorf("'for' %s: expected number, got %s", role, val.CustomTypeName())
+					return nil, err
 				}
-				// Make sure start and step have the same numeric type
-				if tstart != tstep {
-					// One is a float, one is an int, turn them both to floats
-					if tstart == IsInt {
-						start = FloatValue(float64(start.AsInt()))
-					} else {
-						step = FloatValue(float64(step.AsInt()))
-					}
+				setReg(regs, cells, startReg, start)
+				setReg(regs, cells, stopReg, stop)
+				setReg(regs, cells, stepReg, step)
+			}
+			pc++
+			continue RunLoop
+		case code.Type8Pfx:
+			// The compiler only emits these opcodes when it knows the values
+			// are integers and all registers are value registers.  The debug
+			// library can change the value of local variables though, so the
+			// types are still checked.
+			rA, rB, rC := opcode.GetWideValueRegs(wide)
+			a, b, cc := rA.Idx(), rB.Idx(), rC.Idx()
+			var err error
+			switch op := opcode.GetIntOp(); op {
+			case code.OpPrepForInt:
+				// This is only executed once per loop, so the generic version
+				// is good enough.
+				var start, stop, step Value
+				start, stop, step, err = prepForLoop(regs[a], regs[b], regs[cc])
+				if err == nil {
+					regs[a], regs[b], regs[cc] = start, stop, step
 				}
-				// A 0 step is an error
-				if isZero(step) {
-					c.pc = pc
-					return nil, errors.New("'for' step is zero")
+			case code.OpAdvForInt:
+				if n, ok := regs[a].TryInt(); ok {
+					regs[a] = advForLoopInt(n, regs[b].AsInt(), regs[cc].AsInt())
+				} else {
+					regs[a] = advForLoop(regs[a], regs[b], regs[cc])
 				}
-				// Check the loop is not already finished. If so, startReg is
-				// set to nil.
-				var done bool
-				if isPositive(step) {
-					done, _ = isLessThan(stop, start)
+			default:
+				x, y := regs[b], regs[cc]
+				n, ok1 := x.TryInt()
+				m, ok2 := y.TryInt()
+				var res Value
+				if ok1 && ok2 {
+					switch op {
+					case code.OpAddInt:
+						res = IntValue(n + m)
+					case code.OpSubInt:
+						res = IntValue(n - m)
+					case code.OpMulInt:
+						res = IntValue(n * m)
+					default:
+						panic("unsupported")
+					}
 				} else {
-					done, _ = isLessThan(start, stop)
+					res, err = intArithFallback(t, op, x, y)
 				}
-				if done {
-					start = NilValue
+				if err == nil {
+					regs[a] = res
 				}
-				setReg(regs, cells, startReg, start)
-				setReg(regs, cells, stopReg, stop)
-				setReg(regs, cells, stepReg, step)
+			}
+			if err != nil {
+				c.pc = pc
+				return nil, err
 			}
 			pc++
 			continue RunLoop
@@ -539,16 +580,126 @@ func (c *LuaCont) DebugInfo() *DebugInfo {
 	var currentLine int32 = -1
 	if pc >= 0 && int(pc) < len(c.lines) {
 		currentLine = c.lines[pc]
+		// Some instructions have no line (e.g. jumps at the end of loops), so
+		// use the line of the closest instruction before them.
+		for currentLine == 0 && pc > 0 {
+			pc--
+			currentLine = c.lines[pc]
+		}
 	}
 	name := c.name
 	if name == "" {
 		name = "<lua function>"
 	}
+	what := "Lua"
+	if c.lineDefined == 0 {
+		what = "main"
+	}
 	return &DebugInfo{
-		Source:      c.source,
-		Name:        name,
-		CurrentLine: currentLine,
+		Source:          c.source,
+		Name:            name,
+		CurrentLine:     currentLine,
+		NameWhat:        c.nameWhat,
+		What:            what,
+		LineDefined:     c.lineDefined,
+		LastLineDefined: c.lastLineDefined,
+		NUpvalues:       int(c.UpvalueCount),
+		NParams:         int(c.nParams),
+		IsVararg:        c.isVararg,
+		IsTailCall:      c.tailCall,
+		Function:        FunctionValue(c.Closure),
+	}
+}
+
+// AsLuaCont returns the Lua continuation that c stands for, if there is one.  A
+// Termination stands for its parent (as e.g. in Termination.DebugInfo), which is
+// the case for debug hooks.
+func AsLuaCont(c Cont) (*LuaCont, bool) {
+	if term, ok := c.(*Termination); ok && term.parent != nil {
+		c = term.parent
+	}
+	lc, ok := c.(*LuaCont)
+	return lc, ok
+}
+
+// GetLocal returns the name and value of the n-th local variable (starting
+// from 1) which is active at the current point of execution.  If n is
+// negative, it returns the -n-th vararg of the function, with name "(vararg)".
+// It returns ok = false if there is no such variable.
+func (c *LuaCont) GetLocal(n int) (name string, val Value, ok bool) {
+	name, ptr := c.getLocalPtr(n)
+	if ptr == nil {
+		return "", NilValue, false
+	}
+	return name, *ptr, true
+}
+
+// SetLocal sets the value of the n-th local variable (see GetLocal) and returns
+// its name.  It returns ok = false if there is no such variable.
+func (c *LuaCont) SetLocal(n int, val Value) (name string, ok bool) {
+	name, ptr := c.getLocalPtr(n)
+	if ptr == nil {
+		return "", false
+	}
+	*ptr = val
+	return name, true
+}
+
+// LocalNames returns the names of the local variables which are active at the
+// current point of execution, in the order they are declared.
+func (c *LuaCont) LocalNames() []string {
+	var names []string
+	pc := c.currentPC()
+	for _, v := range c.localVars {
+		if v.Name != varargsLocalName && v.IsActive(pc) {
+			names = append(names, v.Name)
+		}
+	}
+	return names
+}
+
+const varargsLocalName = "..."
+
+func (c *LuaCont) getLocalPtr(n int) (string, *Value) {
+	pc := c.currentPC()
+	if n < 0 {
+		for _, v := range c.localVars {
+			if v.Name == varargsLocalName && v.IsActive(pc) {
+				etc, _ := c.getRegPtr(v.Reg).iface.([]Value)
+				if -n > len(etc) {
+					return "", nil
+				}
+				return "(vararg)", &etc[-n-1]
+			}
+		}
+		return "", nil
+	}
+	for _, v := range c.localVars {
+		if v.Name == varargsLocalName || !v.IsActive(pc) {
+			continue
+		}
+		n--
+		if n == 0 {
+			return v.Name, c.getRegPtr(v.Reg)
+		}
+	}
+	return "", nil
+}
+
+// The pc of the opcode being executed.
+func (c *LuaCont) currentPC() uint {
+	pc := c.pc
+	if !c.running {
+		pc--
+	}
+	return uint(pc)
+}
+
+func (c *LuaCont) getRegPtr(reg code.Reg) *Value {
+	if reg.IsCell() {
+		return c.cells[reg.Idx()].ref
 	}
+	return &c.registers[reg.Idx()]
 }
 
 func (c *LuaCont) getRegCell(reg code.Reg) Cell {
@@ -4,46 +4,115 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 
 	"github.com/arnodel/golua/code"
 )
 
-var marshalPrefix = []byte{6, 0, 4}
-var ErrInvalidMarshalPrefix = errors.New("Invalid marshal prefix")
+//
+// Binary chunk format.
+//
+// Values (in practice the code of a function, see string.dump) are serialized
+// as follows.  All numbers are little endian.
+//
+//   - a 6 byte signature "\x1bGoLua";
+//   - a 1 byte format version (MarshalFormatVersion);
+//   - a 1 byte flags field, where bit 0 is set if debug information was
+//     stripped and bit 1 is set if the chunk is a coroutine (see
+//     marshalthread.go), the other bits being 0;
+//   - the value itself.
+//
+// A value starts with a 1 byte type (the ValueType) followed by:
+//
+//   - for an integer: an int64;
+//   - for a float: a float64;
+//   - for a string: its length as an int64, followed by its bytes;
+//   - for code: the source and name as strings, the opcodes and lines as an
+//     int64 length followed by as many uint32 / int32, the constants as an
+//     int64 count followed by as many values, the upvalue, register and cell
+//     counts as int16s, the upvalue names as an int64 count followed by as
+//     many strings, the local variables as an int64 count followed by, for
+//     each, the name as a string, the register type as a uint8, the register
+//     index as a uint16 and the start and end pc as uint32s, then the "name
+//     what" as a string, the lines where the function is defined and ends as
+//     int32s, the number of parameters as an int16 and whether it is variadic
+//     as a byte.
+//
+// When debug information is stripped, the source is "=?", there are no lines
+// or local variables and upvalue names are empty.
+//
+// The format version is increased each time the format (including the
+// encoding of opcodes) changes, so that chunks produced by other versions are
+// rejected with a clear error.
+//
+
+// MarshalFormatVersion is the version of the binary chunk format.
+const MarshalFormatVersion = 3
 
-// HasMarshalPrefix returns true if the byte slice passed starts witht the magic
-// prefix for Lua marshalled values.
+const (
+	marshalSignature = "\x1bGoLua"
+	marshalStripped  = 1 // Flag set when debug information is stripped
+)
+
+// Chunks produced by versions of golua before the format was versioned start
+// with this prefix.
+var legacyMarshalPrefix = []byte{6, 0, 4}
+
+var (
+	ErrInvalidMarshalPrefix = errors.New("not a golua binary chunk")
+	ErrLegacyMarshalFormat  = errors.New("binary chunk made by an older golua version, it must be recompiled")
+)
+
+// HasMarshalPrefix returns true if the byte slice passed looks like a binary
+// chunk.  As in Lua, binary chunks start with the escape character.  Chunks in
+// the format of older golua versions are also recognised so that loading them
+// fails with a clear error.
 func HasMarshalPrefix(bs []byte) bool {
-	return len(bs) >= len(marshalPrefix) && bytes.Equal(marshalPrefix, bs[:len(marshalPrefix)])
+	return len(bs) > 0 && bs[0] == marshalSignature[0] || bytes.HasPrefix(bs, legacyMarshalPrefix)
 }
 
-// MarshalConst serializes a const value to the writer w.
-func MarshalConst(w io.Writer, c Value, budget uint64) (used uint64, err error) {
+// MarshalConst serializes a const value to the writer w.  If strip is true,
+// debug information is omitted from code.
+func MarshalConst(w io.Writer, c Value, budget uint64, strip bool) (used uint64, err error) {
 	defer func() {
 		if r := recover(); r == budgetConsumed {
 			used = budget
 		}
 	}()
-	if _, err := w.Write(marshalPrefix); err != nil {
+	var flags byte
+	if strip {
+		flags |= marshalStripped
+	}
+	header := append([]byte(marshalSignature), MarshalFormatVersion, flags)
+	if _, err := w.Write(header); err != nil {
 		return 0, err
 	}
-	bw := bwriter{w: w, budget: budget}
+	bw := bwriter{w: w, budget: budget, strip: strip}
 	bw.writeConst(c)
 	return budget - bw.budget, bw.err
 }
 
-// UnmarshalConst reads from r to deserialize a const value.
+// UnmarshalConst reads from r to deserialize a const value.  It returns an
+// error if the data does not start with the header of a binary chunk with the
+// current format version.
 func UnmarshalConst(r io.Reader, budget uint64) (v Value, used uint64, err error) {
 	defer func() {
 		if r := recover(); r == budgetConsumed {
 			used = budget
 		}
 	}()
-	pfx := make([]byte, len(marshalPrefix))
-	_, err = r.Read(pfx)
-	if !bytes.Equal(pfx, marshalPrefix) {
+	header := make([]byte, len(marshalSignature)+2)
+	_, err = io.ReadFull(r, header)
+	switch {
+	case bytes.HasPrefix(header, legacyMarshalPrefix):
+		err = ErrLegacyMarshalFormat
+	case err != nil || string(header[:len(marshalSignature)]) != marshalSignature:
 		err = ErrInvalidMarshalPrefix
+	case header[len(marshalSignature)] != MarshalFormatVersion:
+		err = fmt.Errorf("binary chunk format version %d not supported (expected %d)", header[len(marshalSignature)], MarshalFormatVersion)
+	case header[len(marshalSignature)+1]&marshalThread != 0:
+		err = errors.New("binary chunk is a coroutine")
 	}
 	if err != nil {
 		return
@@ -57,8 +126,9 @@ func UnmarshalConst(r io.Reader, budget uint64) (v Value, used uint64, err error
 // bwriter: helper data struture to serialise values
 //
 type bwriter struct {
-	w   io.Writer
-	err error
+	w     io.Writer
+	err   error
+	strip bool // Omit debug information
 
 	budget uint64
 }
@@ -91,28 +161,60 @@ func (w *bwriter) writeConst(c Value) {
 }
 
 func (w *bwriter) writeCode(c *Code) {
-	w.consumeBudget(1 + 0 + 0 + 8 + 8 + 8)
+	w.writeCodeWith(c, func(consts []Value) {
+		w.consumeBudget(8)
+		w.write(int64(len(consts)))
+		for _, k := range consts {
+			w.writeConst(k)
+		}
+	})
+}
+
+// Write the code c, using writeConsts to write its constants.
+func (w *bwriter) writeCodeWith(c *Code, writeConsts func([]Value)) {
+	source, lines, upNames, localVars := c.source, c.lines, c.UpNames, c.localVars
+	if w.strip {
+		source, lines, upNames, localVars = "=?", nil, make([]string, len(upNames)), nil
+	}
+	w.consumeBudget(1 + 0 + 0 + 8 + 8)
 	w.write(
 		CodeType,
-		c.source,
+		source,
 		c.name,
 		int64(len(c.code)), c.code,
-		int64(len(c.lines)), c.lines,
-		int64(len(c.consts)),
+		int64(len(lines)), lines,
 	)
-	for _, k := range c.consts {
-		w.writeConst(k)
-	}
+	writeConsts(c.consts)
 	w.consumeBudget(2 + 2 + 2 + 8)
 	w.write(
 		c.UpvalueCount,
 		c.RegCount,
 		c.CellCount,
-		int64(len(c.UpNames)),
+		int64(len(upNames)),
 	)
-	for _, n := range c.UpNames {
+	for _, n := range upNames {
 		w.writeString(n)
 	}
+	w.consumeBudget(8)
+	w.write(int64(len(localVars)))
+	for _, v := range localVars {
+		w.consumeBudget(1 + 2 + 4 + 4)
+		w.write(
+			v.Name,
+			uint8(v.Reg.RegType()),
+			v.Reg.Idx(),
+			uint32(v.StartPC),
+			uint32(v.EndPC),
+		)
+	}
+	w.consumeBudget(0 + 4 + 4 + 2 + 1)
+	w.write(
+		c.nameWhat,
+		c.lineDefined,
+		c.lastLineDefined,
+		c.nParams,
+		c.isVararg,
+	)
 }
 
 func (w *bwriter) write(xs ...interface{}) {
@@ -204,6 +306,19 @@ func (r *breader) readConst() (v Value) {
 }
 
 func (r *breader) readCode(c *Code) {
+	r.readCodeWith(c, func() []Value {
+		var sz int64
+		r.read(8, &sz)
+		consts := make([]Value, sz)
+		for i := range consts {
+			consts[i] = r.readConst()
+		}
+		return consts
+	})
+}
+
+// Read code into c, using readConsts to read its constants.
+func (r *breader) readCodeWith(c *Code, readConsts func() []Value) {
 	var sz int64
 	r.read(
 		0+0+8,
@@ -219,14 +334,11 @@ func (r *breader) readCode(c *Code) {
 	)
 	c.lines = make([]int32, sz)
 	r.read(
-		4*uint64(sz)+8,
+		4*uint64(sz),
 		c.lines,
-		&sz,
 	)
-	c.consts = make([]Value, sz)
-	for i := range c.consts {
-		c.consts[i] = r.readConst()
-	}
+	c.cacheSlots = newInlineCacheSlots(c.code)
+	c.consts = readConsts()
 	r.read(
 		2+2+2+8,
 		&c.UpvalueCount,
@@ -238,6 +350,33 @@ func (r *breader) readCode(c *Code) {
 	for i := range c.UpNames {
 		c.UpNames[i] = r.readString()
 	}
+	r.read(8, &sz)
+	if sz > 0 {
+		c.localVars = make([]code.LocalVar, sz)
+	}
+	for i := range c.localVars {
+		var (
+			tp         uint8
+			idx        uint16
+			start, end uint32
+		)
+		v := &c.localVars[i]
+		r.read(1+2+4+4, &v.Name, &tp, &idx, &start, &end)
+		if code.RegType(tp) == code.CellRegType {
+			v.Reg = code.CellReg(idx)
+		} else {
+			v.Reg = code.ValueReg(idx)
+		}
+		v.StartPC, v.EndPC = uint(start), uint(end)
+	}
+	r.read(
+		0+4+4+2+1,
+		&c.nameWhat,
+		&c.lineDefined,
+		&c.lastLineDefined,
+		&c.nParams,
+		&c.isVararg,
+	)
 }
 
 func (r *breader) read(sz uint64, xs ...interface{}) {
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"io"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/arnodel/golua/code"
 )
 
 func TestMarshalConst(t *testing.T) {
@@ -46,7 +49,7 @@ func TestMarshalConst(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			w := &bytes.Buffer{}
-			gotUsed, err := MarshalConst(w, tt.args.c, tt.args.budget)
+			gotUsed, err := MarshalConst(w, tt.args.c, tt.args.budget, false)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("MarshalConst() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -76,7 +79,7 @@ func TestUnmarshalConst(t *testing.T) {
 		{
 			name: "consume the budget",
 			args: args{
-				r:      bytes.NewBuffer([]byte{6, 0, 4, byte(StringType), 1, 1, 1, 1, 1, 1, 1, 1}), // would be very long
+				r:      header(byte(StringType), 1, 1, 1, 1, 1, 1, 1, 1), // would be very long
 				budget: 1000,
 			},
 			wantUsed: 1000,
@@ -84,16 +87,38 @@ func TestUnmarshalConst(t *testing.T) {
 		{
 			name: "wrong prefix",
 			args: args{
-				r:      bytes.NewBuffer([]byte{6, 1, 4, byte(StringType), 1, 1, 1, 1, 1, 1, 1, 1}), // would be very long
+				r:      bytes.NewBuffer([]byte("\x1bLua\x54\x00")),
 				budget: 1000,
 			},
 			wantErr: true,
 		},
+		{
+			name: "legacy prefix",
+			args: args{
+				r:      bytes.NewBuffer([]byte{6, 0, 4, byte(StringType), 1, 1, 1, 1, 1, 1, 1, 1}),
+				budget: 1000,
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong version",
+			args: args{
+				r: bytes.NewBuffer([]byte("\x1bGoLua\x00\x00\x03\x01\x00\x00\x00\x00\x00\x00\x00")),
+			},
+			wantErr: true,
+		},
+		{
+			name: "read an int",
+			args: args{
+				r: header(byte(IntType), 1, 0, 0, 0, 0, 0, 0, 0),
+			},
+			wantV: IntValue(1),
+		},
 
 		{
 			name: "read wrong type",
 			args: args{
-				r: bytes.NewBuffer([]byte{6, 0, 4, byte(FunctionType)}),
+				r: header(byte(FunctionType)),
 			},
 			wantErr: true,
 		},
@@ -114,3 +139,74 @@ func TestUnmarshalConst(t *testing.T) {
 		})
 	}
 }
+
+func header(bs ...byte) *bytes.Buffer {
+	return bytes.NewBuffer(append([]byte{0x1b, 'G', 'o', 'L', 'u', 'a', MarshalFormatVersion, 0}, bs...))
+}
+
+func TestMarshalStrip(t *testing.T) {
+	r := New(nil)
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte("local x = 1\nreturn function() return x end"), TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := r.RefactorCodeConsts(clos.Code)
+	var full, stripped bytes.Buffer
+	if _, err := MarshalConst(&full, CodeValue(code), 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MarshalConst(&stripped, CodeValue(code), 0, true); err != nil {
+		t.Fatal(err)
+	}
+	if stripped.Len() >= full.Len() {
+		t.Errorf("stripped chunk is not smaller: %d >= %d", stripped.Len(), full.Len())
+	}
+	if flags := stripped.Bytes()[7]; flags != marshalStripped {
+		t.Errorf("expected stripped flag, got %d", flags)
+	}
+	v, _, err := UnmarshalConst(&stripped, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := v.AsCode()
+	if c.source != "=?" || len(c.lines) != 0 || len(c.localVars) != 0 {
+		t.Errorf("debug information not stripped: %q %v %v", c.source, c.lines, c.localVars)
+	}
+	var inner *Code
+	for _, k := range c.consts {
+		if k.Type() == CodeType {
+			inner = k.AsCode()
+		}
+	}
+	if len(inner.UpNames) != 1 || inner.UpNames[0] != "" {
+		t.Errorf("unexpected upvalue names %q", inner.UpNames)
+	}
+}
+
+// Chunks with Type8 opcodes were read as wide prefixes by version 2 of the
+// format, so they must not be accepted with a version 2 header.
+func TestUnmarshalOlderVersion(t *testing.T) {
+	r := New(nil)
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte("local n = 0\nfor i = 1, 10 do n = n + i end\nreturn n"), TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := r.RefactorCodeConsts(clos.Code)
+	hasType8 := false
+	for _, op := range c.code {
+		if op.TypePfx() == code.Type8Pfx && !op.IsWidePrefix() {
+			hasType8 = true
+		}
+	}
+	if !hasType8 {
+		t.Fatal("expected a Type8 opcode")
+	}
+	var buf bytes.Buffer
+	if _, err := MarshalConst(&buf, CodeValue(c), 0, false); err != nil {
+		t.Fatal(err)
+	}
+	buf.Bytes()[len(marshalSignature)] = 2
+	if _, _, err := UnmarshalConst(&buf, 0); err == nil || !strings.Contains(err.Error(), "version 2 not supported") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,655 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"unsafe"
+)
+
+//
+// Serialization of suspended coroutines.
+//
+// A coroutine is serialized in the binary chunk format (see marshal.go) with
+// the marshalThread flag set.  After the header comes the continuation the
+// coroutine resumes into, then the coroutine's stack of to-be-closed values as
+// an int64 count followed by as many values.
+//
+// Values are written as in constants, with these additions:
+//
+//   - nil is the NilType byte, a boolean is the BoolType byte followed by a
+//     byte;
+//   - code is written as in constants, except that its constants are
+//     tagConsts followed by an int64 count and as many values (functions
+//     loaded from the same chunk share their constants);
+//   - code, constants, tables, closures, cells and Lua continuations are given
+//     an index in the order they are first written (the coroutine itself has
+//     index 0) and are written as tagRef followed by the index as an int64
+//     when they are met again;
+//   - a table is the TableType byte followed by its metatable (a value), its
+//     number of entries as an int64 and each key and value;
+//   - a closure is the FunctionType byte followed by its code (a value) and
+//     its upvalues as an int64 count followed by as many cells;
+//   - a cell is tagCell followed by its value, or the NilType byte for an
+//     empty cell;
+//   - a Lua continuation is tagLuaCont followed by its closure, the pc as an
+//     int32, whether its cells are borrowed from the closure and whether it was
+//     made for a tail call as bytes, the base of its close stack as an int64,
+//     its registers, its cells (if they are not borrowed) and its accumulated
+//     values, each as an int64 count followed by as many values / cells;
+//   - the continuation which receives the values returned by the coroutine
+//     body is tagTermination;
+//   - an array of values (holding varargs) is tagArray followed by an int64
+//     count and as many values;
+//   - values which belong to the runtime rather than the coroutine (the global
+//     environment, the modules in package.loaded and their fields) are
+//     tagPermanent followed by their name as a string, e.g. "_G",
+//     "string.format".
+//
+
+const marshalThread = 2 // Flag set when the chunk is a coroutine
+
+const (
+	tagRef ValueType = UnknownType + 1 + iota
+	tagPermanent
+	tagCell
+	tagLuaCont
+	tagTermination
+	tagArray
+	tagConsts
+)
+
+// MarshalThread serializes the suspended coroutine co to w, so that it can be
+// resumed later, possibly in another runtime (see UnmarshalThread).  All the
+// values reachable from the coroutine's stack are serialized with it, except
+// for the global environment, the modules in package.loaded and their fields,
+// which are referred to by name.  So e.g. changes made to global variables by
+// the coroutine are not saved.
+//
+// The coroutine must be suspended in a call to coroutine.yield (or another Go
+// function which returns the values the coroutine is resumed with) and all the
+// other functions on its stack must be Lua functions.  Values that cannot be
+// serialized (e.g. other coroutines or userdata which are not module fields)
+// result in an error.  Debug information is kept.
+func MarshalThread(w io.Writer, co *Thread) error {
+	co.mux.Lock()
+	defer co.mux.Unlock()
+	if co.status != ThreadSuspended || co.IsMain() {
+		return errors.New("coroutine is not suspended")
+	}
+	yieldCont, ok := co.currentCont.(*GoCont)
+	if !ok {
+		return errors.New("coroutine has not started")
+	}
+	top, ok := yieldCont.Next().(*LuaCont)
+	if !ok {
+		return errors.New("coroutine not suspended in a Lua function")
+	}
+	header := append([]byte(marshalSignature), MarshalFormatVersion, marshalThread)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	tw := &twriter{
+		bwriter:    bwriter{w: w},
+		co:         co,
+		refs:       map[interface{}]int64{co: 0},
+		permanents: permanentNames(co.Runtime),
+	}
+	tw.writeCont(top)
+	tw.write(int64(len(co.closeStack.stack)))
+	for _, v := range co.closeStack.stack {
+		tw.writeValue(v)
+	}
+	return tw.err
+}
+
+// UnmarshalThread reads a coroutine serialized by MarshalThread from rd and
+// returns it as a new suspended coroutine in r.  Values serialized by name are
+// replaced with the values with the same name in r, and it is an error if some
+// of them do not exist.  When resumed, the coroutine continues from the point
+// where it was suspended.
+func UnmarshalThread(r *Runtime, rd io.Reader) (*Thread, error) {
+	header := make([]byte, len(marshalSignature)+2)
+	_, err := io.ReadFull(rd, header)
+	switch {
+	case err != nil || string(header[:len(marshalSignature)]) != marshalSignature:
+		return nil, ErrInvalidMarshalPrefix
+	case header[len(marshalSignature)] != MarshalFormatVersion:
+		return nil, fmt.Errorf("binary chunk format version %d not supported (expected %d)", header[len(marshalSignature)], MarshalFormatVersion)
+	case header[len(marshalSignature)+1]&marshalThread == 0:
+		return nil, errors.New("binary chunk is not a coroutine")
+	}
+	co := NewThread(r)
+	tr := &treader{
+		breader:    breader{r: rd},
+		r:          r,
+		objs:       []interface{}{co},
+		permanents: permanentValues(r),
+		bottom:     NewTerminationWith(nil, 0, true),
+	}
+	top, ok := tr.readValue().TryCont()
+	var n int64
+	tr.read(8, &n)
+	closeStack := make([]Value, 0, tr.checkCount(n))
+	for i := int64(0); i < n && tr.err == nil; i++ {
+		closeStack = append(closeStack, tr.readValue())
+	}
+	if tr.err == nil && !ok {
+		tr.err = errors.New("invalid coroutine continuation")
+	}
+	if tr.err != nil {
+		return nil, tr.err
+	}
+	co.closeStack.stack = closeStack
+	co.start(func(args []Value) ([]Value, error) {
+		co.Push(top, args...)
+		err := co.RunContinuation(top)
+		return tr.bottom.Etc(), err
+	})
+	return co, nil
+}
+
+// Returns the values which are not serialized with coroutines, by name.  These
+// are the global environment, the modules in package.loaded and their fields.
+func permanentValues(r *Runtime) map[string]Value {
+	perms := map[string]Value{}
+	addModule := func(name string, mod Value) {
+		perms[name] = mod
+		t, ok := mod.TryTable()
+		if !ok {
+			return
+		}
+		var k, v Value
+		for {
+			k, v, _ = t.Next(k)
+			if k.IsNil() {
+				return
+			}
+			if s, ok := k.TryString(); ok && isPermanentType(v) {
+				perms[name+"."+s] = v
+			}
+		}
+	}
+	addModule("_G", TableValue(r.globalEnv))
+	pkg, _ := RawGet(r.globalEnv, StringValue("package")).TryTable()
+	loaded, _ := RawGet(pkg, StringValue("loaded")).TryTable()
+	var k, v Value
+	for loaded != nil {
+		k, v, _ = loaded.Next(k)
+		if k.IsNil() {
+			break
+		}
+		if s, ok := k.TryString(); ok && isPermanentType(v) {
+			addModule(s, v)
+		}
+	}
+	return perms
+}
+
+// Returns the names of the values which are not serialized with coroutines.
+// When a value has several names, the first one in alphabetical order is used.
+func permanentNames(r *Runtime) map[interface{}]string {
+	values := permanentValues(r)
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	perms := make(map[interface{}]string, len(names))
+	for _, name := range names {
+		x := values[name].iface
+		if _, ok := perms[x]; !ok {
+			perms[x] = name
+		}
+	}
+	return perms
+}
+
+func isPermanentType(v Value) bool {
+	switch v.iface.(type) {
+	case *Table, *GoFunction, *Closure, *UserData:
+		return true
+	default:
+		return false
+	}
+}
+
+// twriter: helper data structure to serialize coroutines
+type twriter struct {
+	bwriter
+	co         *Thread
+	refs       map[interface{}]int64
+	permanents map[interface{}]string
+}
+
+// Writes a reference to x if it was already written and returns true.
+// Otherwise gives x an index and returns false.
+func (w *twriter) writeRef(x interface{}) bool {
+	if i, ok := w.refs[x]; ok {
+		w.write(tagRef, i)
+		return true
+	}
+	w.refs[x] = int64(len(w.refs))
+	return false
+}
+
+func (w *twriter) writePermanent(x interface{}) bool {
+	name, ok := w.permanents[x]
+	if ok {
+		w.write(tagPermanent, name)
+	}
+	return ok
+}
+
+func (w *twriter) writeValue(v Value) {
+	if w.err != nil {
+		return
+	}
+	switch v.Type() {
+	case NilType:
+		w.write(NilType)
+		return
+	case BoolType:
+		w.write(BoolType, v.AsBool())
+		return
+	case IntType, FloatType, StringType:
+		w.writeConst(v)
+		return
+	}
+	switch x := v.iface.(type) {
+	case *Code:
+		if !w.writeRef(x) {
+			w.writeCodeWith(x, w.writeConsts)
+		}
+	case *Table:
+		if w.writePermanent(x) || w.writeRef(x) {
+			return
+		}
+		w.write(TableType)
+		w.writeValue(tableOrNil(x.meta))
+		var entries []Value
+		var k, val Value
+		for {
+			k, val, _ = x.Next(k)
+			if k.IsNil() {
+				break
+			}
+			entries = append(entries, k, val)
+		}
+		w.write(int64(len(entries) / 2))
+		for _, e := range entries {
+			w.writeValue(e)
+		}
+	case *Closure:
+		if w.writePermanent(x) || w.writeRef(x) {
+			return
+		}
+		w.write(FunctionType)
+		w.writeValue(CodeValue(x.Code))
+		w.write(int64(len(x.Upvalues)))
+		for _, cell := range x.Upvalues {
+			w.writeCell(cell)
+		}
+	case *GoFunction:
+		if !w.writePermanent(x) {
+			w.err = fmt.Errorf("cannot marshal Go function '%s'", x.name)
+		}
+	case *UserData:
+		if !w.writePermanent(x) {
+			w.err = fmt.Errorf("cannot marshal %s", v.CustomTypeName())
+		}
+	case *Thread:
+		if x != w.co {
+			w.err = errors.New("cannot marshal another coroutine")
+			return
+		}
+		w.writeRef(x)
+	case []Value:
+		w.write(tagArray, int64(len(x)))
+		for _, v := range x {
+			w.writeValue(v)
+		}
+	case Cont:
+		w.writeCont(x)
+	default:
+		w.err = fmt.Errorf("cannot marshal %s", v.CustomTypeName())
+	}
+}
+
+// Key identifying a slice of constants (code loaded from the same chunk
+// shares its constants).
+type constsKey struct {
+	first *Value
+}
+
+func (w *twriter) writeConsts(consts []Value) {
+	if len(consts) > 0 && w.writeRef(constsKey{&consts[0]}) {
+		return
+	}
+	w.write(tagConsts, int64(len(consts)))
+	for _, v := range consts {
+		w.writeValue(v)
+	}
+}
+
+func (w *twriter) writeCell(c Cell) {
+	if c.ref == nil {
+		w.write(NilType)
+		return
+	}
+	if !w.writeRef(c.ref) {
+		w.write(tagCell)
+		w.writeValue(*c.ref)
+	}
+}
+
+func (w *twriter) writeCont(c Cont) {
+	switch x := c.(type) {
+	case *LuaCont:
+		if w.writeRef(x) {
+			return
+		}
+		w.write(tagLuaCont)
+		w.writeValue(FunctionValue(x.Closure))
+		w.write(int32(x.pc), x.borrowedCells, x.tailCall, int64(x.closeStackBase), int64(len(x.registers)))
+		for _, v := range x.registers {
+			w.writeValue(v)
+		}
+		if !x.borrowedCells {
+			w.write(int64(len(x.cells)))
+			for _, cell := range x.cells {
+				w.writeCell(cell)
+			}
+		}
+		w.write(int64(len(x.acc)))
+		for _, v := range x.acc {
+			w.writeValue(v)
+		}
+	case *Termination:
+		if x.parent != nil {
+			w.err = errors.New("coroutine not suspended in a Lua function")
+			return
+		}
+		w.write(tagTermination)
+	default:
+		w.err = errors.New("coroutine not suspended in a Lua function")
+	}
+}
+
+func tableOrNil(t *Table) Value {
+	if t == nil {
+		return NilValue
+	}
+	return TableValue(t)
+}
+
+// treader: helper data structure to deserialize coroutines
+type treader struct {
+	breader
+	r          *Runtime
+	objs       []interface{}
+	permanents map[string]Value
+	bottom     *Termination
+}
+
+func (r *treader) readValue() (v Value) {
+	var tp ValueType
+	r.read(1, &tp)
+	if r.err != nil {
+		return NilValue
+	}
+	switch tp {
+	case NilType:
+	case BoolType:
+		var b bool
+		r.read(1, &b)
+		v = BoolValue(b)
+	case IntType:
+		var x int64
+		r.read(8, &x)
+		v = IntValue(x)
+	case FloatType:
+		var x float64
+		r.read(8, &x)
+		v = FloatValue(x)
+	case StringType:
+		v = StringValue(r.readString())
+	case CodeType:
+		x := new(Code)
+		r.objs = append(r.objs, x)
+		r.readCodeWith(x, r.readConsts)
+		v = CodeValue(x)
+	case TableType:
+		v = TableValue(r.readTable())
+	case FunctionType:
+		v = FunctionValue(r.readClosure())
+	case tagRef:
+		v = r.readRef()
+	case tagPermanent:
+		name := r.readString()
+		var ok bool
+		v, ok = r.permanents[name]
+		if !ok && r.err == nil {
+			r.err = fmt.Errorf("unknown value '%s'", name)
+		}
+	case tagArray:
+		var n int64
+		r.read(8, &n)
+		vals := make([]Value, r.checkCount(n))
+		for i := range vals {
+			vals[i] = r.readValue()
+		}
+		v = ArrayValue(vals)
+	case tagLuaCont:
+		v = ContValue(r.readLuaCont())
+	case tagTermination:
+		v = ContValue(r.bottom)
+	default:
+		r.err = errInvalidValueType
+	}
+	if r.err != nil {
+		return NilValue
+	}
+	return v
+}
+
+func (r *treader) readRef() Value {
+	var i int64
+	r.read(8, &i)
+	if r.err != nil {
+		return NilValue
+	}
+	if i < 0 || i >= int64(len(r.objs)) {
+		r.err = errors.New("invalid reference")
+		return NilValue
+	}
+	switch x := r.objs[i].(type) {
+	case *Code:
+		return CodeValue(x)
+	case *Table:
+		return TableValue(x)
+	case *Closure:
+		return FunctionValue(x)
+	case *Thread:
+		return ThreadValue(x)
+	case *LuaCont:
+		return ContValue(x)
+	default:
+		// Cells are only referred to by readCell
+		r.err = errors.New("invalid reference")
+		return NilValue
+	}
+}
+
+func (r *treader) readTable() *Table {
+	t := r.r.NewTable()
+	r.objs = append(r.objs, t)
+	meta := r.readValue()
+	var n int64
+	r.read(8, &n)
+	for i := int64(0); i < n && r.err == nil; i++ {
+		k := r.readValue()
+		v := r.readValue()
+		if k.IsNil() || k.IsNaN() || v.IsNil() {
+			r.err = errors.New("invalid table entry")
+			break
+		}
+		r.r.SetTable(t, k, v)
+	}
+	if mt, ok := meta.TryTable(); ok {
+		r.r.SetRawMetatable(TableValue(t), mt)
+	}
+	return t
+}
+
+func (r *treader) readClosure() *Closure {
+	clos := new(Closure)
+	r.objs = append(r.objs, clos)
+	code, ok := r.readValue().TryCode()
+	var n int64
+	r.read(8, &n)
+	if r.err != nil {
+		return clos
+	}
+	if !ok || n != int64(code.UpvalueCount) {
+		r.err = errors.New("invalid closure")
+		return clos
+	}
+	clos.Code = code
+	clos.Upvalues = make([]Cell, n)
+	for i := range clos.Upvalues {
+		clos.Upvalues[i] = r.readCell()
+	}
+	clos.upvalueIndex = len(clos.Upvalues)
+	return clos
+}
+
+func (r *treader) readConsts() []Value {
+	var tp ValueType
+	var n int64
+	r.read(1+8, &tp, &n)
+	if r.err != nil {
+		return nil
+	}
+	switch tp {
+	case tagConsts:
+		consts := make([]Value, r.checkCount(n))
+		if n > 0 {
+			r.objs = append(r.objs, consts)
+		}
+		for i := range consts {
+			consts[i] = r.readValue()
+		}
+		return consts
+	case tagRef:
+		if n >= 0 && n < int64(len(r.objs)) {
+			if consts, ok := r.objs[n].([]Value); ok {
+				return consts
+			}
+		}
+	}
+	r.err = errors.New("invalid constants")
+	return nil
+}
+
+func (r *treader) readCell() Cell {
+	var tp ValueType
+	r.read(1, &tp)
+	if r.err != nil {
+		return Cell{}
+	}
+	switch tp {
+	case NilType:
+		return Cell{}
+	case tagCell:
+		ref := new(Value)
+		r.objs = append(r.objs, ref)
+		*ref = r.readValue()
+		return Cell{ref: ref}
+	case tagRef:
+		var i int64
+		r.read(8, &i)
+		if r.err == nil && i >= 0 && i < int64(len(r.objs)) {
+			if ref, ok := r.objs[i].(*Value); ok {
+				return Cell{ref: ref}
+			}
+		}
+	}
+	if r.err == nil {
+		r.err = errors.New("invalid cell")
+	}
+	return Cell{}
+}
+
+func (r *treader) readLuaCont() *LuaCont {
+	c := new(LuaCont)
+	r.objs = append(r.objs, c)
+	clos, ok := r.readValue().TryClosure()
+	var (
+		n  int64
+		pc int32
+	)
+	r.read(
+		4+1+1+8,
+		&pc,
+		&c.borrowedCells,
+		&c.tailCall,
+		&n,
+	)
+	c.pc = int(pc)
+	c.closeStackBase = int(n)
+	r.read(8, &n)
+	if r.err != nil {
+		return c
+	}
+	if !ok || clos.Code == nil || n != int64(clos.RegCount) || c.pc < 0 || c.pc >= len(clos.code) {
+		r.err = errors.New("invalid continuation")
+		return c
+	}
+	c.Closure = clos
+	rt := r.r
+	rt.RequireArrSize(unsafe.Sizeof(Value{}), int(clos.RegCount))
+	c.registers = make([]Value, n)
+	for i := range c.registers {
+		c.registers[i] = r.readValue()
+	}
+	if c.borrowedCells {
+		c.cells = clos.Upvalues
+	} else {
+		r.read(8, &n)
+		if r.err == nil && n != int64(clos.CellCount) {
+			r.err = errors.New("invalid continuation")
+			return c
+		}
+		rt.RequireArrSize(unsafe.Sizeof(Cell{}), int(clos.CellCount))
+		c.cells = make([]Cell, r.checkCount(n))
+		for i := range c.cells {
+			c.cells[i] = r.readCell()
+		}
+	}
+	r.read(8, &n)
+	if n > 0 {
+		c.acc = make([]Value, r.checkCount(n))
+		for i := range c.acc {
+			c.acc[i] = r.readValue()
+		}
+	}
+	rt.RequireSize(unsafe.Sizeof(LuaCont{}))
+	return c
+}
+
+// Returns n if it is a plausible number of items to read, 0 otherwise (in which
+// case an error is recorded).
+func (r *treader) checkCount(n int64) int64 {
+	if r.err != nil {
+		return 0
+	}
+	if n < 0 || n > maxMarshalCount {
+		r.err = errors.New("invalid count")
+		return 0
+	}
+	return n
+}
+
+const maxMarshalCount = 1 << 24
@@ -0,0 +1,141 @@
+package runtime_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+func newLibRuntime(stdout *bytes.Buffer) *rt.Runtime {
+	r := rt.New(stdout)
+	lib.LoadAll(r)
+	return r
+}
+
+func TestMarshalThread(t *testing.T) {
+	var out bytes.Buffer
+	r1 := newLibRuntime(&out)
+	co := runChunk(t, r1, `
+local shared = {}
+local count = 0
+local function step(...)
+	count = count + select('#', ...)
+	shared[#shared + 1] = ...
+	return coroutine.yield(count, ...)
+end
+local co = coroutine.create(function(a, ...)
+	local inc = function() count = count + 1 return count end
+	local guard <close> = setmetatable({}, {__close = function() print("closed") end})
+	local x = step(a, ...)
+	inc()
+	local y = step(x, shared)
+	print(string.format("%d %s", count, #shared), y)
+	return table.concat(shared, ","), count
+end)
+assert(coroutine.resume(co, "a", "b"))
+return co
+`)
+	var buf bytes.Buffer
+	if err := rt.MarshalThread(&buf, co.AsThread()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Resume a copy in another runtime.
+	r2 := newLibRuntime(&out)
+	co2, err := rt.UnmarshalThread(r2, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2.GlobalEnv().Set(rt.StringValue("co"), rt.ThreadValue(co2))
+	res := runChunk(t, r2, `
+local ok, n, x, t = coroutine.resume(co, "c")
+assert(ok and n == 5 and x == "c" and type(t) == "table", tostring(x))
+local ok, s, count = coroutine.resume(co, "d")
+assert(ok and coroutine.status(co) == "dead")
+return s .. " " .. count
+`)
+	if res != rt.StringValue("a,c 5") {
+		t.Errorf("unexpected result %v", res)
+	}
+	if got := out.String(); got != "5 2\td\nclosed\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+
+	// The original coroutine is not affected.
+	r1.GlobalEnv().Set(rt.StringValue("co"), co)
+	if res := runChunk(t, r1, `local _, n = coroutine.resume(co, "x") return n`); res != rt.IntValue(5) {
+		t.Errorf("unexpected result %v", res)
+	}
+}
+
+func TestMarshalThreadErrors(t *testing.T) {
+	r := newLibRuntime(nil)
+	tests := []struct {
+		src string
+		err string
+	}{
+		{`return coroutine.create(print)`, "coroutine has not started"},
+		{`local co = coroutine.create(function() end) coroutine.resume(co) return co`, "coroutine is not suspended"},
+		{`local other = coroutine.create(print)
+		local co = coroutine.create(function() coroutine.yield(other) end)
+		coroutine.resume(co)
+		return co`, "cannot marshal another coroutine"},
+		{`local f = io.tmpfile()
+		local co = coroutine.create(function() coroutine.yield(f) end)
+		coroutine.resume(co)
+		return co`, "cannot marshal file"},
+		{`local co = coroutine.create(function() pcall(coroutine.yield) end)
+		coroutine.resume(co)
+		return co`, "coroutine not suspended in a Lua function"},
+	}
+	for _, test := range tests {
+		co := runChunk(t, r, test.src)
+		err := rt.MarshalThread(&bytes.Buffer{}, co.AsThread())
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error %q, got %v", test.src, test.err, err)
+		}
+	}
+
+	// Unmarshalling requires the same modules.
+	co := runChunk(t, r, `
+local co = coroutine.create(function() coroutine.yield() print("done") end)
+coroutine.resume(co)
+return co`)
+	var buf bytes.Buffer
+	if err := rt.MarshalThread(&buf, co.AsThread()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.UnmarshalThread(rt.New(nil), &buf); err == nil || err.Error() != "unknown value 'coroutine.yield'" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestUnmarshalThreadMemory(t *testing.T) {
+	if !rt.QuotasAvailable {
+		t.Skip("quotas are not available")
+	}
+	r := newLibRuntime(nil)
+	co := runChunk(t, r, `
+local big = {}
+for i = 1, 10000 do big["k" .. i] = i end
+local co = coroutine.create(function(t) coroutine.yield() return t end)
+coroutine.resume(co, big)
+return co`)
+	var buf bytes.Buffer
+	if err := rt.MarshalThread(&buf, co.AsThread()); err != nil {
+		t.Fatal(err)
+	}
+	// Restoring the table is charged to the runtime context.
+	r2 := newLibRuntime(nil)
+	ctx, _ := r2.MainThread().CallContext(rt.RuntimeContextDef{
+		HardLimits: rt.RuntimeResources{Memory: 100000},
+	}, func() error {
+		_, err := rt.UnmarshalThread(r2, bytes.NewReader(buf.Bytes()))
+		return err
+	})
+	if ctx.Status() != rt.StatusKilled {
+		t.Errorf("unexpected status %s", ctx.Status())
+	}
+}
@@ -0,0 +1,234 @@
+package runtime
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// A Profile is the result of profiling Lua code (see Runtime.StartProfiling).
+type Profile struct {
+	CpuPeriod uint64 // CPU required between samples
+	MemPeriod uint64 // Memory required between samples
+	Start     time.Time
+	Duration  time.Duration
+	Samples   []*ProfileSample
+}
+
+// A ProfileSample gives the resources required by a call stack.
+type ProfileSample struct {
+	Stack []ProfileFrame // The innermost frame comes first
+	Count uint64         // Number of times the call stack was sampled
+	Cpu   uint64         // CPU required (in the unit of RuntimeResources)
+	Mem   uint64         // Memory required (in bytes)
+}
+
+// A ProfileFrame is an entry in the call stack of a sample.
+type ProfileFrame struct {
+	Function    string // Name of the function
+	Source      string // Source file of the function ("[Go]" for Go functions)
+	Line        int32  // Current line (0 for Go functions)
+	LineDefined int32  // Line where the function is defined (-1 for Go functions)
+}
+
+// ProfileValue selects a value of profile samples.
+type ProfileValue uint8
+
+const (
+	ProfileCpu ProfileValue = iota // Select the CPU
+	ProfileMem                     // Select the memory
+)
+
+func (s *ProfileSample) value(v ProfileValue) uint64 {
+	if v == ProfileMem {
+		return s.Mem
+	}
+	return s.Cpu
+}
+
+// WriteCollapsed writes the profile in the "collapsed stacks" format used to
+// make flame graphs: one line per call stack, with frames separated by
+// semicolons starting from the outermost one, followed by a space and the
+// selected value.  Call stacks with a zero value are omitted.
+func (p *Profile) WriteCollapsed(w io.Writer, v ProfileValue) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range p.Samples {
+		val := s.value(v)
+		if val == 0 {
+			continue
+		}
+		for i := len(s.Stack) - 1; i >= 0; i-- {
+			bw.WriteString(collapsedFrameName(s.Stack[i]))
+			if i > 0 {
+				bw.WriteByte(';')
+			}
+		}
+		fmt.Fprintf(bw, " %d\n", val)
+	}
+	return bw.Flush()
+}
+
+var collapsedReplacer = strings.NewReplacer(";", ":", "\n", " ")
+
+func collapsedFrameName(f ProfileFrame) string {
+	var name string
+	if f.Line > 0 {
+		name = fmt.Sprintf("%s (%s:%d)", f.Function, f.Source, f.Line)
+	} else {
+		name = fmt.Sprintf("%s (%s)", f.Function, f.Source)
+	}
+	return collapsedReplacer.Replace(name)
+}
+
+// WritePprof writes the profile in the gzipped protocol buffer format read by
+// the pprof tool (see https://github.com/google/pprof).  There are three
+// sample values: the number of samples, the CPU and the memory required.  Each
+// distinct function and line of the profile is a location.
+func (p *Profile) WritePprof(w io.Writer) error {
+	var (
+		strIndex  = map[string]int64{"": 0}
+		strTable  = []string{""}
+		functions = map[ProfileFrame]uint64{} // Keys have Line set to 0
+		funcList  []ProfileFrame
+		locations = map[ProfileFrame]uint64{}
+		locList   []ProfileFrame
+	)
+	str := func(s string) int64 {
+		i, ok := strIndex[s]
+		if !ok {
+			i = int64(len(strTable))
+			strIndex[s] = i
+			strTable = append(strTable, s)
+		}
+		return i
+	}
+	var buf protoBuffer
+	valueType := func(tag int, typ, unit string) {
+		buf.message(tag, func(b *protoBuffer) {
+			b.int64(1, str(typ))
+			b.int64(2, str(unit))
+		})
+	}
+	valueType(1, "samples", "count")
+	valueType(1, "cpu", "units")
+	valueType(1, "alloc_space", "bytes")
+
+	for _, s := range p.Samples {
+		locIds := make([]uint64, len(s.Stack))
+		for i, f := range s.Stack {
+			id, ok := locations[f]
+			if !ok {
+				id = uint64(len(locList) + 1)
+				locations[f] = id
+				locList = append(locList, f)
+			}
+			locIds[i] = id
+		}
+		buf.message(2, func(b *protoBuffer) {
+			b.packed(1, locIds)
+			b.packed(2, []uint64{s.Count, s.Cpu, s.Mem})
+		})
+	}
+	for i, loc := range locList {
+		fn := loc
+		fn.Line = 0
+		fnId, ok := functions[fn]
+		if !ok {
+			fnId = uint64(len(funcList) + 1)
+			functions[fn] = fnId
+			funcList = append(funcList, fn)
+		}
+		buf.message(4, func(b *protoBuffer) {
+			b.uint64(1, uint64(i+1))
+			b.message(4, func(b *protoBuffer) {
+				b.uint64(1, fnId)
+				b.int64(2, int64(loc.Line))
+			})
+		})
+	}
+	for i, fn := range funcList {
+		buf.message(5, func(b *protoBuffer) {
+			b.uint64(1, uint64(i+1))
+			b.int64(2, str(fn.Function))
+			b.int64(3, str(fn.Function))
+			b.int64(4, str(fn.Source))
+			if fn.LineDefined > 0 {
+				b.int64(5, int64(fn.LineDefined))
+			}
+		})
+	}
+	buf.int64(9, p.Start.UnixNano())
+	buf.int64(10, int64(p.Duration))
+	valueType(11, "cpu", "units")
+	buf.int64(12, int64(p.CpuPeriod))
+	buf.int64(14, str("cpu"))
+
+	// The string table must be written last as the other fields add to it.
+	for _, s := range strTable {
+		buf.string(6, s)
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(buf.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// protoBuffer encodes protocol buffer messages.  Only the wire types needed
+// by WritePprof are supported.
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protoBuffer) key(tag int, wireType uint64) {
+	b.varint(uint64(tag)<<3 | wireType)
+}
+
+// Zero values are omitted, which is what the protocol buffer encoding does for
+// non-repeated fields.
+func (b *protoBuffer) uint64(tag int, x uint64) {
+	if x != 0 {
+		b.key(tag, 0)
+		b.varint(x)
+	}
+}
+
+func (b *protoBuffer) int64(tag int, x int64) {
+	b.uint64(tag, uint64(x))
+}
+
+func (b *protoBuffer) bytes(tag int, p []byte) {
+	b.key(tag, 2)
+	b.varint(uint64(len(p)))
+	b.data = append(b.data, p...)
+}
+
+func (b *protoBuffer) string(tag int, s string) {
+	b.bytes(tag, []byte(s))
+}
+
+func (b *protoBuffer) packed(tag int, xs []uint64) {
+	var p protoBuffer
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.bytes(tag, p.data)
+}
+
+func (b *protoBuffer) message(tag int, f func(*protoBuffer)) {
+	var m protoBuffer
+	f(&m)
+	b.bytes(tag, m.data)
+}
@@ -0,0 +1,155 @@
+package runtime
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// Lua profiler.
+//
+// The profiler attributes the CPU and memory required by Lua code (in the
+// units of the runtime's resource accounting, see RuntimeResources) to the
+// call stack that required it.  The runtime counts the totals of these
+// resources while profiling is on, and checks whether a sample is due when it
+// requires CPU for each Lua instruction, when a Lua function calls or returns
+// and after each Go function returns.  When one is due, the current call stack
+// is recorded with the resources required since the previous sample.  In the
+// Lua run loop, the check is done before requiring CPU for the next
+// instruction so that the sample is attributed to the instruction that just
+// ran.
+//
+// With the default periods of 1, each instruction or Go function call is
+// accounted for (the profiler is "instrumenting").  Larger periods make it a
+// sampling profiler with less overhead.
+//
+// Resources are only counted when quotas are available (i.e. without the
+// noquotas build tag), otherwise all samples are empty.
+//
+
+// ProfileOptions configure the profiler.
+type ProfileOptions struct {
+	CpuPeriod uint64 // CPU required between samples (1 if 0)
+	MemPeriod uint64 // Memory required between samples (1 if 0)
+}
+
+// A profiler records samples while profiling is on.
+type profiler struct {
+	lastCpu, lastMem uint64 // Totals at the previous sample
+	cpuPeriod        uint64
+	memPeriod        uint64
+	start            time.Time
+	samples          map[string]*ProfileSample
+	order            []string // Keys of samples in the order they were added
+}
+
+var errAlreadyProfiling = errors.New("profiling already started")
+
+// StartProfiling starts recording a profile of the Lua code running in the
+// runtime, until StopProfiling is called.
+func (r *Runtime) StartProfiling(opts ProfileOptions) error {
+	if r.profiler != nil {
+		return errAlreadyProfiling
+	}
+	p := &profiler{
+		cpuPeriod: opts.CpuPeriod,
+		memPeriod: opts.MemPeriod,
+		start:     time.Now(),
+		samples:   map[string]*ProfileSample{},
+	}
+	if p.cpuPeriod == 0 {
+		p.cpuPeriod = 1
+	}
+	if p.memPeriod == 0 {
+		p.memPeriod = 1
+	}
+	r.setCountTotals(true)
+	p.lastCpu, p.lastMem = r.resourceTotals()
+	r.setNextSample(p.lastCpu+p.cpuPeriod, p.lastMem+p.memPeriod)
+	r.profiler = p
+	return nil
+}
+
+// StopProfiling stops recording the profile started with StartProfiling and
+// returns it.  It returns nil if profiling was not started.
+func (r *Runtime) StopProfiling() *Profile {
+	p := r.profiler
+	if p == nil {
+		return nil
+	}
+	r.profiler = nil
+	r.setCountTotals(false)
+	prof := &Profile{
+		CpuPeriod: p.cpuPeriod,
+		MemPeriod: p.memPeriod,
+		Start:     p.start,
+		Duration:  time.Since(p.start),
+		Samples:   make([]*ProfileSample, len(p.order)),
+	}
+	for i, key := range p.order {
+		prof.Samples[i] = p.samples[key]
+	}
+	return prof
+}
+
+// IsProfiling returns true if profiling has been started.
+func (r *Runtime) IsProfiling() bool {
+	return r.profiler != nil
+}
+
+// Record the resources required since the last sample against the call stack
+// of c.
+func (p *profiler) sample(r *Runtime, c Cont) {
+	cpu, mem := r.resourceTotals()
+	if cpu < p.lastCpu || mem < p.lastMem {
+		// Should not happen, but do not record huge values if it does.
+		p.lastCpu, p.lastMem = cpu, mem
+	}
+	stack := profileStack(c)
+	key := stackKey(stack)
+	s := p.samples[key]
+	if s == nil {
+		s = &ProfileSample{Stack: stack}
+		p.samples[key] = s
+		p.order = append(p.order, key)
+	}
+	s.Cpu += cpu - p.lastCpu
+	s.Mem += mem - p.lastMem
+	s.Count++
+	p.lastCpu, p.lastMem = cpu, mem
+	r.setNextSample(cpu+p.cpuPeriod, mem+p.memPeriod)
+}
+
+func profileStack(c Cont) []ProfileFrame {
+	var stack []ProfileFrame
+	for ; c != nil; c = c.Parent() {
+		info := c.DebugInfo()
+		if info == nil {
+			continue
+		}
+		stack = append(stack, ProfileFrame{
+			Function:    info.Name,
+			Source:      info.Source,
+			Line:        info.CurrentLine,
+			LineDefined: info.LineDefined,
+		})
+	}
+	return stack
+}
+
+func stackKey(stack []ProfileFrame) string {
+	var b strings.Builder
+	for _, f := range stack {
+		b.WriteString(f.Function)
+		b.WriteByte(0)
+		b.WriteString(f.Source)
+		b.WriteByte(0)
+		b.WriteString(strconv.Itoa(int(f.LineDefined)))
+		b.WriteByte(0)
+		b.WriteString(strconv.Itoa(int(f.Line)))
+		b.WriteByte(0)
+	}
+	return b.String()
+}
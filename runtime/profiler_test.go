@@ -0,0 +1,139 @@
+//go:build !noquotas
+// +build !noquotas
+
+package runtime
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const profiledSource = `local function f(n)
+    local t = {}
+    for i = 1, n do
+        t[i] = i
+    end
+    return t
+end
+f(10)
+f(100)
+`
+
+func runProfiled(t *testing.T, opts ProfileOptions) *Profile {
+	r := New(nil)
+	if err := r.StartProfiling(opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.StartProfiling(opts); err != errAlreadyProfiling {
+		t.Errorf("expected errAlreadyProfiling, got %v", err)
+	}
+	clos, err := r.CompileAndLoadLuaChunk("prof.lua", []byte(profiledSource), TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Call(r.MainThread(), FunctionValue(clos), nil, NewTerminationWith(nil, 0, false)); err != nil {
+		t.Fatal(err)
+	}
+	prof := r.StopProfiling()
+	if r.IsProfiling() || r.StopProfiling() != nil {
+		t.Error("profiling should be stopped")
+	}
+	return prof
+}
+
+func TestProfiling(t *testing.T) {
+	prof := runProfiled(t, ProfileOptions{})
+	var cpu, mem, loopCpu uint64
+	for _, s := range prof.Samples {
+		cpu += s.Cpu
+		mem += s.Mem
+		if f := s.Stack[0]; f.Function == "f" && f.Line == 4 {
+			loopCpu += s.Cpu
+			if len(s.Stack) != 2 || s.Stack[1].Function != "<main chunk>" {
+				t.Errorf("unexpected stack %+v", s.Stack)
+			}
+		}
+	}
+	if cpu == 0 || mem == 0 {
+		t.Errorf("expected some cpu and memory, got %d and %d", cpu, mem)
+	}
+	// The loop body runs 110 times.
+	if loopCpu < 110 {
+		t.Errorf("expected at least 110 cpu in the loop, got %d", loopCpu)
+	}
+
+	var buf bytes.Buffer
+	if err := prof.WriteCollapsed(&buf, ProfileCpu); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<main chunk> (prof.lua:9);f (prof.lua:4) ") {
+		t.Errorf("unexpected collapsed stacks:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := prof.WritePprof(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"alloc_space", "prof.lua", "<main chunk>"} {
+		if !bytes.Contains(data, []byte(s)) {
+			t.Errorf("pprof profile does not contain %q", s)
+		}
+	}
+}
+
+func TestProfilingPeriod(t *testing.T) {
+	exact := runProfiled(t, ProfileOptions{})
+	sampled := runProfiled(t, ProfileOptions{CpuPeriod: 50, MemPeriod: 1000})
+	count := func(p *Profile) (n uint64) {
+		for _, s := range p.Samples {
+			n += s.Count
+		}
+		return
+	}
+	if count(sampled)*10 > count(exact) {
+		t.Errorf("expected fewer samples, got %d vs %d", count(sampled), count(exact))
+	}
+}
+
+func TestProfilingAttribution(t *testing.T) {
+	r := New(nil)
+	const src = `local function f()
+    local t = {1, 2, 3, 4}
+    local x = 1
+    return t, x
+end
+f()
+`
+	clos, err := r.CompileAndLoadLuaChunk("attr.lua", []byte(src), TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.StartProfiling(ProfileOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Call(r.MainThread(), FunctionValue(clos), nil, NewTerminationWith(nil, 0, false)); err != nil {
+		t.Fatal(err)
+	}
+	prof := r.StopProfiling()
+	lineMem := map[int32]uint64{}
+	for _, s := range prof.Samples {
+		if f := s.Stack[0]; f.Function == "f" {
+			lineMem[f.Line] += s.Mem
+		}
+	}
+	// The memory is required by the table constructor, not the next line.
+	if lineMem[2] == 0 || lineMem[3] != 0 {
+		t.Errorf("unexpected memory per line %v", lineMem)
+	}
+}
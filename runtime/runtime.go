@@ -4,6 +4,8 @@ import (
 	"errors"
 	"io"
 	"os"
+
+	"github.com/arnodel/golua/vfs"
 )
 
 // A Runtime is a Lua runtime.  It contains all the global state of the runtime
@@ -22,6 +24,31 @@ type Runtime struct {
 
 	warner Warner // Lua 5.4 introduces a warning system, implemented by this
 
+	// Objects waiting for their __gc metamethod to be called (see gc.go)
+	finalizerQueue finalizerQueue
+
+	// Tables with weak keys or values, swept after garbage collection.
+	weakTables weakTableSet
+
+	// Set while profiling (see profiler.go)
+	profiler *profiler
+
+	// Set while collecting coverage (see coverage.go)
+	coverage *CoverageCollector
+
+	// Set in deterministic mode (see deterministic.go)
+	deterministic *deterministic
+
+	// The file system used by Lua code (see WithFS)
+	fs vfs.FS
+
+	// The standard streams used by Lua code (see WithStdio)
+	stdio Stdio
+
+	// Optimization level of the IR passes when compiling Lua code (see
+	// ir.Optimize)
+	optLevel int
+
 	// This has an almost empty implementation when the noquotas build tag is
 	// set.  It should allow the compiler to compile away almost all runtime
 	// context manager methods.
@@ -40,13 +67,18 @@ type Runtime struct {
 }
 
 type runtimeOptions struct {
-	regPoolSize  uint
-	regSetMaxAge uint
+	regPoolSize   uint
+	regSetMaxAge  uint
+	deterministic *DeterministicOptions
+	fs            vfs.FS
+	stdio         Stdio
+	optLevel      int
 }
 
 var defaultRuntimeOptions = runtimeOptions{
 	regPoolSize:  10,
 	regSetMaxAge: 10,
+	fs:           vfs.OS,
 }
 
 // A RuntimeOption configures the Runtime.
@@ -68,32 +100,109 @@ func WithRegSetMaxAge(age uint) RuntimeOption {
 	}
 }
 
+// WithFS sets the file system which the io and os libraries, loadfile, dofile
+// and require use to access files.  The default is the host file system,
+// vfs.OS.
+func WithFS(fsys vfs.FS) RuntimeOption {
+	return func(rtOpts *runtimeOptions) {
+		rtOpts.fs = fsys
+	}
+}
+
+// WithOptimizationLevel sets the level of optimizations applied when compiling
+// Lua code (see SetOptimizationLevel).
+func WithOptimizationLevel(level int) RuntimeOption {
+	return func(rtOpts *runtimeOptions) {
+		rtOpts.optLevel = level
+	}
+}
+
+// Stdio contains the standard input, output and error streams of a runtime.
+type Stdio struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// WithStdio sets the standard streams which the io library uses for io.stdin,
+// io.stdout and io.stderr, and which processes started by io.popen and
+// os.execute inherit.  Streams which are nil default to those of the host
+// process (os.Stdin, os.Stdout and os.Stderr).
+func WithStdio(stdio Stdio) RuntimeOption {
+	return func(rtOpts *runtimeOptions) {
+		rtOpts.stdio = stdio
+	}
+}
+
 // New returns a new pointer to a Runtime with the given stdout.
 func New(stdout io.Writer, opts ...RuntimeOption) *Runtime {
+	return newRuntime(stdout, nil, opts)
+}
+
+// Returns a new runtime configured by opts.  If old is not nil, its object
+// pools are reused (see RuntimePool).
+func newRuntime(stdout io.Writer, old *Runtime, opts []RuntimeOption) *Runtime {
 	rtOpts := defaultRuntimeOptions
 	for _, opt := range opts {
 		opt(&rtOpts)
 	}
 	r := &Runtime{
-		globalEnv: NewTable(),
-		Stdout:    stdout,
-		registry:  NewTable(),
-		warner:    NewLogWarner(os.Stderr, "Lua warning: "),
-		regPool:   mkValuePool(rtOpts.regPoolSize, rtOpts.regSetMaxAge),
-		argsPool:  mkValuePool(rtOpts.regPoolSize, rtOpts.regSetMaxAge),
-		cellPool:  mkCellPool(rtOpts.regPoolSize, rtOpts.regSetMaxAge),
-	}
-	mainThread := NewThread(r)
-	mainThread.status = ThreadOK
-	r.mainThread = mainThread
+		Stdout:   stdout,
+		warner:   NewLogWarner(os.Stderr, "Lua warning: "),
+		fs:       rtOpts.fs,
+		stdio:    rtOpts.stdio,
+		optLevel: rtOpts.optLevel,
+	}
+	if old != nil {
+		r.regPool = old.regPool
+		r.argsPool = old.argsPool
+		r.cellPool = old.cellPool
+		r.luaContPool = old.luaContPool
+		r.goContPool = old.goContPool
+	} else {
+		r.regPool = mkValuePool(rtOpts.regPoolSize, rtOpts.regSetMaxAge)
+		r.argsPool = mkValuePool(rtOpts.regPoolSize, rtOpts.regSetMaxAge)
+		r.cellPool = mkCellPool(rtOpts.regPoolSize, rtOpts.regSetMaxAge)
+	}
+	if r.stdio.Stdin == nil {
+		r.stdio.Stdin = os.Stdin
+	}
+	if r.stdio.Stdout == nil {
+		r.stdio.Stdout = os.Stdout
+	}
+	if r.stdio.Stderr == nil {
+		r.stdio.Stderr = os.Stderr
+	}
+	if rtOpts.deterministic != nil {
+		r.deterministic = newDeterministic(rtOpts.deterministic)
+	}
+	r.globalEnv = r.NewTable()
+	r.registry = r.NewTable()
+	r.mainThread = newMainThread(r)
 	return r
 }
 
+func newMainThread(r *Runtime) *Thread {
+	t := NewThread(r)
+	t.status = ThreadOK
+	return t
+}
+
 // GlobalEnv returns the global environment of the runtime.
 func (r *Runtime) GlobalEnv() *Table {
 	return r.globalEnv
 }
 
+// FS returns the file system used by Lua code in the runtime.
+func (r *Runtime) FS() vfs.FS {
+	return r.fs
+}
+
+// Stdio returns the standard streams of the runtime (see WithStdio).
+func (r *Runtime) Stdio() Stdio {
+	return r.stdio
+}
+
 // Registry returns the Value associated with key in the runtime's registry.
 func (r *Runtime) Registry(key Value) Value {
 	return r.registry.Get(key)
@@ -115,6 +224,18 @@ func (r *Runtime) SetStringMeta(meta *Table) {
 	r.stringMeta = meta
 }
 
+// SetOptimizationLevel sets the level of optimizations applied when compiling
+// Lua code (see ir.OptBasic and ir.OptFull).  The default level is 0, which
+// only applies simple folding.
+func (r *Runtime) SetOptimizationLevel(level int) {
+	r.optLevel = level
+}
+
+// OptimizationLevel returns the current optimization level of the runtime.
+func (r *Runtime) OptimizationLevel() int {
+	return r.optLevel
+}
+
 // SetWarner replaces the current warner (Lua 5.4)
 func (r *Runtime) SetWarner(warner Warner) {
 	r.warner = warner
@@ -151,7 +272,9 @@ func (r *Runtime) RawMetatable(v Value) *Table {
 	}
 }
 
-// SetRawMetatable sets the metatable for value v to meta.
+// SetRawMetatable sets the metatable for value v to meta.  If v is a table or
+// userdata and meta has a "__gc" field, v is marked for finalization.  If v is
+// a table and meta has a "__mode" field, v becomes a weak table.
 func (r *Runtime) SetRawMetatable(v Value, meta *Table) {
 	if v.IsNil() {
 		r.nilMeta = meta
@@ -164,9 +287,16 @@ func (r *Runtime) SetRawMetatable(v Value, meta *Table) {
 	case BoolType:
 		r.boolMeta = meta
 	case TableType:
-		v.AsTable().SetMetatable(meta)
+		tbl := v.AsTable()
+		wasWeak := tbl.mode != 0
+		tbl.SetMetatable(meta)
+		if !wasWeak && tbl.mode != 0 {
+			r.weakTables.add(tbl)
+		}
+		r.markForFinalization(v, meta)
 	case UserDataType:
 		v.AsUserData().SetMetatable(meta)
+		r.markForFinalization(v, meta)
 	default:
 		// Shoul there be an error here?
 	}
@@ -33,9 +33,11 @@ const (
 )
 
 // A ContextTerminationError is an error reserved for when the runtime context
-// should be terminated immediately.
+// should be terminated immediately.  It is raised as a panic, which
+// Runtime.SafeCall turns into a *TerminationError.
 type ContextTerminationError struct {
 	message string
+	limit   string // The hard limit that was reached, if any
 }
 
 var _ error = ContextTerminationError{}
@@ -90,7 +92,8 @@ const (
 	ComplyCpuSafe
 
 	// Only execute code that complies with IO restrictions (currently only
-	// functions that do no IO comply with this)
+	// functions that do no IO, or only access files in a virtual file system
+	// set with WithFS, comply with this)
 	ComplyIoSafe
 
 	// Only execute code that is time safe (i.e. it will not block on long
@@ -6,6 +6,7 @@ package runtime
 import (
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -37,6 +38,21 @@ type runtimeContextManager struct {
 	stopLevel        StopLevel
 	startTime        uint64
 	nextCpuThreshold uint64
+
+	// When not nil, another goroutine may set it to a non-zero value to
+	// request a hard stop (see Thread.CallContextUntil).
+	interrupt *int32
+
+	// Total CPU and memory required since totals started being counted.
+	// Unlike usedResources, they are not reset when a context is pushed.  They
+	// are used by the profiler.
+	countTotals bool
+	totalCpu    uint64
+	totalMem    uint64
+
+	// Totals at which the next profile sample is due (see profiler.go).
+	nextSampleCpu uint64
+	nextSampleMem uint64
 }
 
 var _ RuntimeContext = (*runtimeContextManager)(nil)
@@ -112,9 +128,7 @@ func (m *runtimeContextManager) PushContext(ctx RuntimeContextDef) {
 	if ctx.HardLimits.Millis > 0 {
 		m.requiredFlags |= ComplyTimeSafe
 	}
-	m.trackTime = m.hardLimits.Millis > 0 || m.softLimits.Millis > 0
-	m.trackCpu = m.hardLimits.Cpu > 0 || m.softLimits.Cpu > 0 || m.trackTime
-	m.trackMem = m.hardLimits.Memory > 0 || m.softLimits.Memory > 0
+	m.setTracking()
 	m.status = StatusLive
 	m.messageHandler = ctx.MessageHandler
 	m.parent = &parent
@@ -131,12 +145,71 @@ func (m *runtimeContextManager) PopContext() RuntimeContext {
 	m.parent.RequireCPU(m.usedResources.Cpu)
 	m.parent.RequireMem(m.usedResources.Memory)
 	*m = *m.parent
+	m.countTotals, m.totalCpu, m.totalMem = mCopy.countTotals, mCopy.totalCpu, mCopy.totalMem
+	m.nextSampleCpu, m.nextSampleMem = mCopy.nextSampleCpu, mCopy.nextSampleMem
+	m.setTracking()
 	if m.trackTime {
 		m.updateTimeUsed()
 	}
 	return &mCopy
 }
 
+// Make the current context (and contexts pushed from it) stop when *interrupt
+// becomes non-zero.  This is checked each time CPU is required.
+func (m *runtimeContextManager) setInterrupt(interrupt *int32) {
+	m.interrupt = interrupt
+	m.setTracking()
+}
+
+// Decide which resources need to be tracked.
+func (m *runtimeContextManager) setTracking() {
+	m.trackTime = m.hardLimits.Millis > 0 || m.softLimits.Millis > 0
+	m.trackCpu = m.hardLimits.Cpu > 0 || m.softLimits.Cpu > 0 || m.trackTime || m.countTotals || m.interrupt != nil
+	m.trackMem = m.hardLimits.Memory > 0 || m.softLimits.Memory > 0 || m.countTotals
+}
+
+// Start or stop counting the total CPU and memory required.
+func (m *runtimeContextManager) setCountTotals(on bool) {
+	m.countTotals = on
+	m.setTracking()
+}
+
+// Total CPU and memory required since totals started being counted.
+func (m *runtimeContextManager) resourceTotals() (cpu, mem uint64) {
+	return m.totalCpu, m.totalMem
+}
+
+// Set the totals at which the next profile sample is due.
+func (m *runtimeContextManager) setNextSample(cpu, mem uint64) {
+	m.nextSampleCpu, m.nextSampleMem = cpu, mem
+}
+
+// Returns true if a profile sample is due.
+func (m *runtimeContextManager) sampleDue() bool {
+	return m.countTotals && (m.totalCpu >= m.nextSampleCpu || m.totalMem >= m.nextSampleMem)
+}
+
+// Require the CPU for executing a Lua instruction, like RequireCPU(1), unless
+// a profile sample is due.  In that case nothing is required and it returns
+// true, so that the resources required so far can be attributed to the
+// previous instruction before requiring the CPU.  Profiling counts the totals,
+// so this adds no cost to the Lua run loop when there is nothing to track.
+func (m *runtimeContextManager) requireInstrCPU() (sampleDue bool) {
+	if m.trackCpu {
+		return m.requireInstrCPUTracked()
+	}
+	return false
+}
+
+//go:noinline
+func (m *runtimeContextManager) requireInstrCPUTracked() bool {
+	if m.sampleDue() {
+		return true
+	}
+	m.requireCPU(1)
+	return false
+}
+
 func (m *runtimeContextManager) RequireCPU(cpuAmount uint64) {
 	if m.trackCpu {
 		// The path with limit is "outlined" so RequireCPU can be inlined,
@@ -147,18 +220,22 @@ func (m *runtimeContextManager) RequireCPU(cpuAmount uint64) {
 
 //go:noinline
 func (m *runtimeContextManager) requireCPU(cpuAmount uint64) {
+	if m.interrupt != nil && atomic.LoadInt32(m.interrupt) != 0 {
+		m.SetStopLevel(HardStop)
+	}
 	if m.stopLevel&HardStop != 0 {
 		m.KillContext()
 	}
 	cpuUsed := m.usedResources.Cpu + cpuAmount
 	if atLimit(cpuUsed, m.hardLimits.Cpu) {
-		m.TerminateContext("CPU limit of %d exceeded", m.hardLimits.Cpu)
+		m.terminate(cpuLimit, "CPU limit of %d exceeded", m.hardLimits.Cpu)
 	}
 	if m.trackTime && m.nextCpuThreshold <= cpuUsed {
 		m.nextCpuThreshold = cpuUsed + cpuThresholdIncrement
 		m.updateTimeUsed()
 	}
 	m.usedResources.Cpu = cpuUsed
+	m.totalCpu += cpuAmount
 }
 
 func (m *runtimeContextManager) UnusedCPU() uint64 {
@@ -180,9 +257,10 @@ func (m *runtimeContextManager) requireMem(memAmount uint64) {
 	}
 	memUsed := m.usedResources.Memory + memAmount
 	if atLimit(memUsed, m.hardLimits.Memory) {
-		m.TerminateContext("memory limit of %d exceeded", m.hardLimits.Memory)
+		m.terminate(memoryLimit, "memory limit of %d exceeded", m.hardLimits.Memory)
 	}
 	m.usedResources.Memory = memUsed
+	m.totalMem += memAmount
 }
 
 func (m *runtimeContextManager) RequireSize(sz uintptr) (mem uint64) {
@@ -234,7 +312,7 @@ func (m *runtimeContextManager) UnusedMem() uint64 {
 func (m *runtimeContextManager) updateTimeUsed() {
 	m.usedResources.Millis = now() - m.startTime
 	if atLimit(m.usedResources.Millis, m.hardLimits.Millis) {
-		m.TerminateContext("time limit of %d exceeded", m.hardLimits.Millis)
+		m.terminate(timeLimit, "time limit of %d exceeded", m.hardLimits.Millis)
 	}
 }
 
@@ -271,12 +349,19 @@ func (m *runtimeContextManager) KillContext() {
 
 // TerminateContext forcefully terminates the context with the given message.
 func (m *runtimeContextManager) TerminateContext(format string, args ...interface{}) {
+	m.terminate("", format, args...)
+}
+
+// Terminate the context because the given hard limit was reached ("" if no
+// limit was reached).
+func (m *runtimeContextManager) terminate(limit string, format string, args ...interface{}) {
 	if m.status != StatusLive {
 		return
 	}
 	m.status = StatusKilled
 	panic(ContextTerminationError{
 		message: fmt.Sprintf(format, args...),
+		limit:   limit,
 	})
 }
 
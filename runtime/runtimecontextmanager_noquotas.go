@@ -75,6 +75,27 @@ func (m *runtimeContextManager) CallContext(def RuntimeContextDef, f func() erro
 	return nil, f()
 }
 
+func (m *runtimeContextManager) setInterrupt(*int32) {
+}
+
+func (m *runtimeContextManager) setCountTotals(bool) {
+}
+
+func (m *runtimeContextManager) resourceTotals() (cpu, mem uint64) {
+	return
+}
+
+func (m *runtimeContextManager) setNextSample(cpu, mem uint64) {
+}
+
+func (m *runtimeContextManager) sampleDue() bool {
+	return false
+}
+
+func (m *runtimeContextManager) requireInstrCPU() bool {
+	return false
+}
+
 func (m *runtimeContextManager) RequireCPU(cpuAmount uint64) {
 }
 
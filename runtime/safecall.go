@@ -0,0 +1,68 @@
+package runtime
+
+// Names of the hard limits which can be reached (see TerminationError.Limit).
+const (
+	cpuLimit    = "cpu"
+	memoryLimit = "memory"
+	timeLimit   = "time"
+)
+
+// A TerminationError is returned by Runtime.SafeCall and Runtime.SafeRun when
+// the current runtime context is terminated, either because one of its hard
+// limits was reached or because it was killed.
+type TerminationError struct {
+	Status     RuntimeContextStatus // Status of the context (StatusKilled)
+	Limit      string               // "cpu", "memory" or "time" if a hard limit was reached, "" otherwise
+	Used       RuntimeResources     // Resources used by the context
+	HardLimits RuntimeResources     // Hard limits of the context
+
+	err ContextTerminationError
+}
+
+var _ error = (*TerminationError)(nil)
+
+func (e *TerminationError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the ContextTerminationError that terminated the context.
+func (e *TerminationError) Unwrap() error {
+	return e.err
+}
+
+// SafeCall calls f with arguments args in the main thread of r, like Call.  If
+// the current runtime context is terminated during the call, it returns a
+// *TerminationError rather than letting the ContextTerminationError panic
+// propagate.  The terminated context is left in place (so it can be
+// inspected), so PopContext should be called before running more code in it.
+//
+// Unlike CallContext, SafeCall does not push a new context: it is meant to be
+// used by embedders to run code in the runtime's current context.
+func (r *Runtime) SafeCall(f Value, args []Value, next Cont) error {
+	return r.SafeRun(func() error {
+		return Call(r.MainThread(), f, args, next)
+	})
+}
+
+// SafeRun is like SafeCall but runs f(), which is useful to include other
+// operations which require resources, e.g. loading a chunk, or to run code in a
+// thread other than the main thread.
+func (r *Runtime) SafeRun(f func() error) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			termErr, ok := rec.(ContextTerminationError)
+			if !ok {
+				panic(rec)
+			}
+			ctx := r.RuntimeContext()
+			err = &TerminationError{
+				Status:     ctx.Status(),
+				Limit:      termErr.limit,
+				Used:       ctx.UsedResources(),
+				HardLimits: ctx.HardLimits(),
+				err:        termErr,
+			}
+		}
+	}()
+	return f()
+}
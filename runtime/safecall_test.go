@@ -0,0 +1,49 @@
+package runtime
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSafeCall(t *testing.T) {
+	if !QuotasAvailable {
+		t.Skip("termination requires quotas")
+	}
+	tests := []struct {
+		src    string
+		limits RuntimeResources
+		limit  string
+	}{
+		{`while true do end`, RuntimeResources{Cpu: 10000}, cpuLimit},
+		{`local s = "x" while true do s = s .. s end`, RuntimeResources{Memory: 100000}, memoryLimit},
+	}
+	for _, test := range tests {
+		r := New(nil)
+		f := loadChunk(t, r, test.src)
+		r.PushContext(RuntimeContextDef{HardLimits: test.limits})
+		err := r.SafeCall(f, nil, NewTerminationWith(nil, 0, false))
+		var termErr *TerminationError
+		if !errors.As(err, &termErr) {
+			t.Errorf("%s: expected *TerminationError, got %v", test.src, err)
+			continue
+		}
+		if termErr.Status != StatusKilled || termErr.Limit != test.limit || termErr.HardLimits != test.limits {
+			t.Errorf("%s: unexpected error %+v", test.src, termErr)
+		}
+		if termErr.Used == (RuntimeResources{}) {
+			t.Errorf("%s: used resources not reported", test.src)
+		}
+		var ctxErr ContextTerminationError
+		if !errors.As(err, &ctxErr) {
+			t.Errorf("%s: expected error to wrap a ContextTerminationError", test.src)
+		}
+	}
+
+	// Other errors are returned unchanged.
+	r := New(nil)
+	err := r.SafeCall(loadChunk(t, r, `error("oops")`), nil, NewTerminationWith(nil, 0, false))
+	var termErr *TerminationError
+	if err == nil || errors.As(err, &termErr) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,365 @@
+package runtime
+
+import (
+	"errors"
+	"io"
+)
+
+// A Snapshot is a copy of the state of a Runtime (its global environment,
+// registry and metatables) from which new runtimes can be created cheaply, e.g.
+// to avoid loading all the libraries each time a script is run in a fresh
+// runtime.
+//
+// Tables whose keys and values are all strings, numbers, booleans or Go
+// functions (which is the case of most library tables) are not copied when a
+// runtime is created from a snapshot: they share their storage with the
+// snapshot until they are modified (copy-on-write).  Other tables, userdata and
+// closures are copied.
+//
+// A Snapshot is never modified once created, so NewRuntime can be called
+// concurrently from several goroutines.
+type Snapshot struct {
+	state  *Runtime        // Holds the state of the snapshot, never runs any code
+	shared map[*Table]bool // Tables whose storage can be shared
+}
+
+// Snapshot returns a snapshot of the current state of r.  Later changes to r do
+// not affect the snapshot.  It is an error if some coroutine other than the
+// main thread is reachable from the global environment or the registry.
+func (r *Runtime) Snapshot() (*Snapshot, error) {
+	state := New(r.Stdout)
+	c := newCloner(state, nil)
+	c.threads[r.mainThread] = state.mainThread
+	c.restore(state, r)
+	if c.err != nil {
+		return nil, c.err
+	}
+	shared := map[*Table]bool{}
+	for _, t := range c.tables {
+		if canShare(t) {
+			shared[t] = true
+		}
+	}
+	return &Snapshot{state: state, shared: shared}, nil
+}
+
+// NewRuntime returns a new Runtime whose state is a copy of the snapshot.  If
+// stdout is nil, the runtime uses the Stdout of the runtime the snapshot was
+// taken from.
+func (s *Snapshot) NewRuntime(stdout io.Writer, opts ...RuntimeOption) *Runtime {
+	r := New(stdout, opts...)
+	s.restore(r)
+	return r
+}
+
+// Copy the state of the snapshot to r, which must be a new runtime.
+func (s *Snapshot) restore(r *Runtime) {
+	c := newCloner(r, s.shared)
+	c.threads[s.state.mainThread] = r.mainThread
+	c.restore(r, s.state)
+	if r.Stdout == nil {
+		r.Stdout = s.state.Stdout
+	}
+}
+
+// A Cloner copies values from a runtime to another one, making sure that values
+// referenced several times are only copied once.  It is used by Snapshot.
+type Cloner struct {
+	r        *Runtime
+	shared   map[*Table]bool
+	tables   map[*Table]*Table
+	userData map[*UserData]*UserData
+	closures map[*Closure]*Closure
+	cells    map[*Value]*Value
+	threads  map[*Thread]*Thread
+	values   map[interface{}]interface{}
+	err      error
+}
+
+// Cloneable can be implemented by Go values stored in a runtime (e.g. in its
+// registry or as the value of a userdata) which refer to Lua values, so that
+// they are copied when a snapshot is taken or restored.  Go values which do not
+// implement Cloneable are shared between the copies.  Cloneable values must be
+// comparable (e.g. pointers).
+type Cloneable interface {
+	Clone(c *Cloner) interface{}
+}
+
+func newCloner(r *Runtime, shared map[*Table]bool) *Cloner {
+	return &Cloner{
+		r:        r,
+		shared:   shared,
+		tables:   map[*Table]*Table{},
+		userData: map[*UserData]*UserData{},
+		closures: map[*Closure]*Closure{},
+		cells:    map[*Value]*Value{},
+		threads:  map[*Thread]*Thread{},
+		values:   map[interface{}]interface{}{},
+	}
+}
+
+// Runtime returns the runtime values are copied to.
+func (c *Cloner) Runtime() *Runtime {
+	return c.r
+}
+
+// Value returns a copy of v.
+func (c *Cloner) Value(v Value) Value {
+	switch x := v.iface.(type) {
+	case *Table:
+		return TableValue(c.Table(x))
+	case *UserData:
+		return UserDataValue(c.UserData(x))
+	case *Closure:
+		return FunctionValue(c.closure(x))
+	case *Thread:
+		t, ok := c.threads[x]
+		if !ok {
+			c.fail(errors.New("cannot copy a coroutine"))
+			return NilValue
+		}
+		return ThreadValue(t)
+	case Cloneable:
+		return AsValue(c.cloneable(x))
+	default:
+		return v
+	}
+}
+
+// Table returns a copy of t.
+func (c *Cloner) Table(t *Table) *Table {
+	if t == nil {
+		return nil
+	}
+	if ct, ok := c.tables[t]; ok {
+		return ct
+	}
+	ct := new(Table)
+	c.tables[t] = ct
+	ct.meta = c.Table(t.meta)
+	switch {
+	case t.mode != 0:
+		ct.mode = t.mode
+		if t.order != nil || c.r.IsDeterministic() {
+			ct.order = newTableOrder()
+		}
+		c.r.weakTables.add(ct)
+		c.copyEntries(ct, t)
+	case t.order != nil || c.r.IsDeterministic():
+		ct.order = newTableOrder()
+		c.copyEntries(ct, t)
+	case c.shared[t]:
+		ct.mixedTable = t.mixedTable
+		ct.cow = true
+	case hasFlatKeys(t):
+		c.copyStorage(ct, t)
+	default:
+		c.copyEntries(ct, t)
+	}
+	if t.marked {
+		c.r.markForFinalization(TableValue(ct), ct.meta)
+	}
+	return ct
+}
+
+// UserData returns a copy of u.  Its value is copied if it implements Cloneable,
+// otherwise it is shared.
+func (c *Cloner) UserData(u *UserData) *UserData {
+	if u == nil {
+		return nil
+	}
+	if cu, ok := c.userData[u]; ok {
+		return cu
+	}
+	cu := new(UserData)
+	c.userData[u] = cu
+	cu.meta = c.Table(u.meta)
+	cu.value = u.value
+	if x, ok := u.value.(Cloneable); ok {
+		cu.value = c.cloneable(x)
+	}
+	if u.marked {
+		c.r.markForFinalization(UserDataValue(cu), cu.meta)
+	}
+	return cu
+}
+
+func (c *Cloner) cloneable(x Cloneable) interface{} {
+	y, ok := c.values[x]
+	if !ok {
+		y = x.Clone(c)
+		c.values[x] = y
+	}
+	return y
+}
+
+func (c *Cloner) closure(f *Closure) *Closure {
+	if cf, ok := c.closures[f]; ok {
+		return cf
+	}
+	cf := &Closure{
+		Code:         f.Code,
+		Upvalues:     make([]Cell, len(f.Upvalues)),
+		upvalueIndex: f.upvalueIndex,
+	}
+	c.closures[f] = cf
+	for i, cell := range f.Upvalues {
+		cf.Upvalues[i] = c.cell(cell)
+	}
+	return cf
+}
+
+func (c *Cloner) cell(cell Cell) Cell {
+	if cell.ref == nil {
+		return cell
+	}
+	if ref, ok := c.cells[cell.ref]; ok {
+		return Cell{ref: ref}
+	}
+	ref := new(Value)
+	c.cells[cell.ref] = ref
+	*ref = c.Value(*cell.ref)
+	return Cell{ref: ref}
+}
+
+func (c *Cloner) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// Copy the state of the runtime from to r.
+func (c *Cloner) restore(r, from *Runtime) {
+	r.globalEnv = c.Table(from.globalEnv)
+	r.registry = c.Table(from.registry)
+	r.stringMeta = c.Table(from.stringMeta)
+	r.numberMeta = c.Table(from.numberMeta)
+	r.boolMeta = c.Table(from.boolMeta)
+	r.nilMeta = c.Table(from.nilMeta)
+}
+
+// Copy the entries of t to ct one by one.
+func (c *Cloner) copyEntries(ct, t *Table) {
+	var k, v Value
+	for {
+		k, v, _ = t.Next(k)
+		if k.IsNil() {
+			return
+		}
+		ct.Set(c.Value(k), c.Value(v))
+	}
+}
+
+// Copy the storage of t to ct, copying the values it contains.  This is only
+// valid if the keys of t do not need to be copied.
+func (c *Cloner) copyStorage(ct, t *Table) {
+	if h := t.hashTable; h != nil {
+		slots := make([]hashTableSlot, len(h.slots))
+		for i, slot := range h.slots {
+			slot.value = c.Value(slot.value)
+			slots[i] = slot
+		}
+		ct.hashTable = &hashTable{slots: slots, nextFree: h.nextFree, base: h.base}
+	}
+	if a := t.array; a != nil {
+		values := make([]Value, len(a.values))
+		for i, v := range a.values {
+			values[i] = c.Value(v)
+		}
+		ct.array = &array{values: values, len: a.len}
+	}
+}
+
+// Returns true if copies of the table t can share its storage, i.e. none of its
+// keys or values need to be copied.
+func canShare(t *Table) bool {
+	if t.mode != 0 || t.order != nil || !hasFlatKeys(t) {
+		return false
+	}
+	if h := t.hashTable; h != nil {
+		for _, slot := range h.slots {
+			if !isFlat(slot.value) {
+				return false
+			}
+		}
+	}
+	if a := t.array; a != nil {
+		for _, v := range a.values {
+			if !isFlat(v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func hasFlatKeys(t *Table) bool {
+	if h := t.hashTable; h != nil {
+		for _, slot := range h.slots {
+			if !isFlat(slot.key) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns true if v does not need to be copied by a Cloner.
+func isFlat(v Value) bool {
+	switch v.iface.(type) {
+	case *Table, *UserData, *Closure, *Thread, Cloneable:
+		return false
+	default:
+		return true
+	}
+}
+
+// A RuntimePool hands out runtimes created from a snapshot, each running in a
+// new runtime context.  It is safe for concurrent use.
+type RuntimePool struct {
+	snapshot *Snapshot
+	def      RuntimeContextDef
+	opts     []RuntimeOption
+	free     chan *Runtime
+}
+
+// Maximum number of runtimes kept by a RuntimePool for reuse.
+const maxFreeRuntimes = 16
+
+// NewRuntimePool returns a new RuntimePool whose runtimes are copies of the
+// snapshot, running in a runtime context defined by def.  The runtimes are
+// created with the given options (e.g. WithFS, WithStdio, WithDeterministic).
+// Deterministic runtimes should use a snapshot of a deterministic runtime, so
+// that the tables they copy iterate in a stable order.
+func NewRuntimePool(snapshot *Snapshot, def RuntimeContextDef, opts ...RuntimeOption) *RuntimePool {
+	return &RuntimePool{
+		snapshot: snapshot,
+		def:      def,
+		opts:     opts,
+		free:     make(chan *Runtime, maxFreeRuntimes),
+	}
+}
+
+// Get returns a runtime in the state of the pool's snapshot, with a fresh
+// runtime context.
+func (p *RuntimePool) Get(stdout io.Writer) *Runtime {
+	var old *Runtime
+	select {
+	case old = <-p.free:
+	default:
+	}
+	r := newRuntime(stdout, old, p.opts)
+	p.snapshot.restore(r)
+	r.PushContext(p.def)
+	return r
+}
+
+// Put gives r back to the pool.  The object pools of r are reused by a later
+// call to Get, but none of its state, so it is not possible to leak data from
+// one runtime to the next.  r must not be used after it is put back.
+func (p *RuntimePool) Put(r *Runtime) {
+	select {
+	case p.free <- r:
+	default:
+	}
+}
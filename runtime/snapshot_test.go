@@ -0,0 +1,160 @@
+package runtime_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+	"github.com/arnodel/golua/vfs"
+)
+
+func runChunk(t testing.TB, r *rt.Runtime, src string) rt.Value {
+	t.Helper()
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte(src), rt.TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := rt.NewTerminationWith(nil, 1, false)
+	if err := r.SafeCall(rt.FunctionValue(clos), nil, res); err != nil {
+		t.Fatal(err)
+	}
+	return res.Get(0)
+}
+
+func newSnapshot(t testing.TB, src string) *rt.Snapshot {
+	t.Helper()
+	r := rt.New(nil)
+	lib.LoadAll(r)
+	runChunk(t, r, src)
+	s, err := r.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSnapshot(t *testing.T) {
+	s := newSnapshot(t, `
+local n = 0
+function incr() n = n + 1 return n end
+cycle = {}
+cycle.self = cycle
+cycle[cycle] = "key"
+`)
+	var buf1, buf2 bytes.Buffer
+	r1 := s.NewRuntime(&buf1)
+	r2 := s.NewRuntime(&buf2)
+
+	// Changes to library tables and globals are not shared.
+	runChunk(t, r1, `string.foo = "bar"; table.insert = nil; x = 1; incr()`)
+	if v := runChunk(t, r2, `return string.foo == nil and table.insert ~= nil and x == nil and incr()`); v != rt.IntValue(1) {
+		t.Errorf("r2 affected by changes in r1: %v", v)
+	}
+	if v := runChunk(t, r1, `return incr()`); v != rt.IntValue(2) {
+		t.Errorf("unexpected upvalue in r1: %v", v)
+	}
+
+	// Tables are copied with their references.
+	if v := runChunk(t, r1, `return cycle.self == cycle and cycle[cycle]`); v != rt.StringValue("key") {
+		t.Errorf("cycle not preserved: %v", v)
+	}
+
+	// Metatables and libraries work in copies.
+	runChunk(t, r1, `print(("x"):rep(3), io.type(io.output()))`)
+	if got := buf1.String(); got != "xxx\tfile\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+	if buf2.Len() != 0 {
+		t.Errorf("unexpected output in r2: %q", buf2.String())
+	}
+}
+
+func TestSnapshotCoroutine(t *testing.T) {
+	r := rt.New(nil)
+	lib.LoadAll(r)
+	runChunk(t, r, `co = coroutine.create(print)`)
+	if _, err := r.Snapshot(); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestRuntimePool(t *testing.T) {
+	s := newSnapshot(t, `count = 0`)
+	p := rt.NewRuntimePool(s, rt.RuntimeContextDef{
+		HardLimits: rt.RuntimeResources{Cpu: 100000},
+	})
+	for i := 0; i < 3; i++ {
+		r := p.Get(nil)
+		if v := runChunk(t, r, `count = count + 1 return count`); v != rt.IntValue(1) {
+			t.Errorf("runtime not clean: %v", v)
+		}
+		if rt.QuotasAvailable {
+			if r.RuntimeContext().HardLimits().Cpu != 100000 {
+				t.Errorf("unexpected limits %v", r.RuntimeContext().HardLimits())
+			}
+			clos, err := r.CompileAndLoadLuaChunk("test", []byte(`while true do end`), rt.TableValue(r.GlobalEnv()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.SafeCall(rt.FunctionValue(clos), nil, rt.NewTerminationWith(nil, 0, false)); err == nil {
+				t.Error("expected limit to be reached")
+			}
+		}
+		p.Put(r)
+	}
+}
+
+func TestRuntimePoolOptions(t *testing.T) {
+	m := vfs.NewMemFS()
+	m.WriteFile("data.txt", []byte("from memfs"))
+	r := rt.New(nil, rt.WithDeterministic(rt.DeterministicOptions{}))
+	lib.LoadAll(r)
+	s, err := r.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := rt.NewRuntimePool(s, rt.RuntimeContextDef{},
+		rt.WithFS(m),
+		rt.WithDeterministic(rt.DeterministicOptions{}),
+		rt.WithOptimizationLevel(2),
+	)
+	// The second runtime is recycled from the first one.
+	for i := 0; i < 2; i++ {
+		r := p.Get(nil)
+		if r.FS() != m || !r.IsDeterministic() || r.OptimizationLevel() != 2 {
+			t.Errorf("runtime %d does not have the pool options", i)
+		}
+		res := runChunk(t, r, `
+local t = {}
+for k in pairs(os.date("*t")) do t[#t + 1] = k end
+return io.open("data.txt"):read("a") .. " " .. os.time() .. " " .. table.concat(t, " ")`)
+		if res != rt.StringValue("from memfs 946684800 year month day hour min sec wday yday isdst") {
+			t.Errorf("unexpected result %v", res)
+		}
+		p.Put(r)
+	}
+}
+
+func BenchmarkNewRuntime(b *testing.B) {
+	b.Run("LoadAll", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			r := rt.New(nil)
+			lib.LoadAll(r)
+		}
+	})
+	b.Run("Snapshot", func(b *testing.B) {
+		s := newSnapshot(b, ``)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			s.NewRuntime(nil)
+		}
+	})
+	b.Run("Pool", func(b *testing.B) {
+		p := rt.NewRuntimePool(newSnapshot(b, ``), rt.RuntimeContextDef{})
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			p.Put(p.Get(nil))
+		}
+	})
+}
@@ -1,11 +1,22 @@
 package runtime
 
+import "strings"
+
 // Table implements a Lua table.
 type Table struct {
 	// This is where the implementation details are.
 	mixedTable
 
-	meta *Table
+	meta   *Table
+	order  *tableOrder // not nil if the table iterates in insertion order
+	mode   weakMode    // set from the '__mode' field of the metatable
+	marked bool        // true if the table is marked for finalization
+	cow    bool        // true if the table storage is shared (see Snapshot)
+	eph    ephemerons  // values this table is the key of in weak tables
+
+	// Incremented each time the content or the metatable of the table change,
+	// so that inline caches can tell when they are out of date.
+	version uint64
 }
 
 // NewTable returns a new Table.
@@ -18,32 +29,68 @@ func (t *Table) Metatable() *Table {
 	return t.meta
 }
 
-// SetMetatable sets the table's metatable.
+// SetMetatable sets the table's metatable.  If the metatable has a '__mode'
+// field containing "k" and/or "v", then the table gets weak keys and / or
+// values (provided WeakRefsAvailable is true).
 func (t *Table) SetMetatable(m *Table) {
 	t.meta = m
+	t.version++
+	t.setMode(getWeakMode(m))
 }
 
 // Get returns t[k].
 func (t *Table) Get(k Value) Value {
+	if t.mode != 0 {
+		return t.weakGet(k)
+	}
 	return t.get(k)
 }
 
 // Set implements t[k] = v (doesn't check if k is nil).
 func (t *Table) Set(k, v Value) uint64 {
+	if t.cow {
+		t.unshare()
+	}
+	if t.mode != 0 {
+		t.setEphemeron(k, v)
+		k, v = t.weaken(k, v)
+	}
+	t.version++
 	if v.IsNil() {
 		t.mixedTable.remove(k)
 		return 0
 	}
+	if t.order != nil {
+		t.order.add(&t.mixedTable, k)
+	}
 	t.mixedTable.insert(k, v)
-	return 16
+	return tableEntrySize
 }
 
+// Memory accounted for when inserting an entry into a table.
+const tableEntrySize = 16
+
 // Reset implements t[k] = v only if t[k] was already non-nil.
 func (t *Table) Reset(k, v Value) (wasSet bool) {
+	if t.cow {
+		t.unshare()
+	}
+	wk, wv := k, v
+	if t.mode != 0 {
+		wk, wv = t.weaken(k, v)
+	}
 	if v.IsNil() {
-		return t.mixedTable.remove(k)
+		wasSet = t.mixedTable.remove(wk)
+	} else {
+		wasSet = t.mixedTable.reset(wk, wv)
 	}
-	return t.mixedTable.reset(k, v)
+	if wasSet {
+		t.version++
+		if t.mode != 0 {
+			t.setEphemeron(k, v)
+		}
+	}
+	return
 }
 
 // Len returns a length for t (see lua docs for details).
@@ -53,5 +100,279 @@ func (t *Table) Len() int64 {
 
 // Next returns the key-value pair that comes after k in the table t.
 func (t *Table) Next(k Value) (next Value, val Value, ok bool) {
+	if t.mode != 0 {
+		return t.weakNext(k)
+	}
+	if t.order != nil {
+		return t.order.next(&t.mixedTable, k)
+	}
 	return t.mixedTable.next(k)
 }
+
+// Give t its own copy of the storage it shares with other tables, so it can be
+// modified.
+func (t *Table) unshare() {
+	t.cow = false
+	if h := t.hashTable; h != nil {
+		slots := make([]hashTableSlot, len(h.slots))
+		copy(slots, h.slots)
+		t.hashTable = &hashTable{slots: slots, nextFree: h.nextFree, base: h.base}
+	}
+	if a := t.array; a != nil {
+		values := make([]Value, len(a.values))
+		copy(values, a.values)
+		t.array = &array{values: values, len: a.len}
+	}
+}
+
+//
+// Weak tables
+//
+// Collectable keys and / or values are stored as weak references (see
+// weakenValue).  Entries whose key or value has been collected are ignored by
+// Get and Next, and removed by Runtime.sweepWeakTables after a garbage
+// collection.
+//
+// A table with weak keys and strong values is an ephemeron table: a value is
+// only kept alive by the table as long as its key is alive, even if the value
+// refers to the key.  To achieve this, when both the key and the value are
+// collectable, the table stores a weak reference to the value and the key
+// holds a strong reference to it (see ephemerons).  The Go garbage collector
+// can then collect the key and the value together when nothing else refers to
+// them.
+//
+
+// weakMode describes whether a table has weak keys and / or weak values.
+type weakMode uint8
+
+const (
+	weakKeys weakMode = 1 << iota
+	weakValues
+)
+
+func getWeakMode(meta *Table) (mode weakMode) {
+	if !WeakRefsAvailable {
+		return
+	}
+	s, ok := RawGet(meta, StringValue("__mode")).TryString()
+	if !ok {
+		return
+	}
+	if strings.IndexByte(s, 'k') >= 0 {
+		mode |= weakKeys
+	}
+	if strings.IndexByte(s, 'v') >= 0 {
+		mode |= weakValues
+	}
+	return
+}
+
+// Change the mode of the table, converting the references it holds as needed.
+func (t *Table) setMode(mode weakMode) {
+	if mode == t.mode {
+		return
+	}
+	var old mixedTable
+	old, t.mixedTable = t.mixedTable, mixedTable{}
+	oldMode := t.mode
+	t.mode = mode
+	t.cow = false
+	next := old.next
+	if oldOrder := t.order; oldOrder != nil {
+		// The keys may be stored in a different form so the order is rebuilt
+		// by inserting them again in the same order.
+		t.order = newTableOrder()
+		next = func(k Value) (Value, Value, bool) {
+			return oldOrder.next(&old, k)
+		}
+	}
+	var k, v Value
+	for {
+		k, v, _ = next(k)
+		if k.IsNil() {
+			break
+		}
+		sk, kok := strengthenValue(k)
+		sv, vok := strengthenValue(v)
+		if kok && oldMode == weakKeys {
+			// The value is now held by the table (sv keeps it alive until
+			// then), not by the key.
+			if e := ephemeronsOf(sk); e != nil {
+				e.remove(t)
+			}
+		}
+		if kok && vok {
+			t.Set(sk, sv)
+		}
+	}
+}
+
+// Convert k and v to the form they should be stored in the table.
+func (t *Table) weaken(k, v Value) (Value, Value) {
+	if t.mode == weakKeys && ephemeronsOf(k) != nil && ephemeronsOf(v) != nil {
+		// The value is kept alive by the key (see setEphemeron).
+		v = weakenValue(v)
+	}
+	if t.mode&weakKeys != 0 {
+		k = weakenValue(k)
+	}
+	if t.mode&weakValues != 0 {
+		v = weakenValue(v)
+	}
+	return k, v
+}
+
+// In an ephemeron table, make the key k hold a strong reference to the value v
+// it is associated with in t, or forget it if v is nil.
+func (t *Table) setEphemeron(k, v Value) {
+	if t.mode != weakKeys {
+		return
+	}
+	if e := ephemeronsOf(k); e != nil {
+		if ephemeronsOf(v) == nil {
+			// The value is nil or cannot be collected so it is stored in
+			// the table.
+			e.remove(t)
+		} else {
+			e.set(t, v)
+		}
+	}
+}
+
+func (t *Table) weakGet(k Value) Value {
+	if t.mode&weakKeys != 0 {
+		k = weakenValue(k)
+	}
+	v, _ := strengthenValue(t.get(k))
+	return v
+}
+
+// Like mixedTable.next (or tableOrder.next if the table iterates in insertion
+// order) but skips entries whose key or value has been collected.
+func (t *Table) weakNext(k Value) (next Value, val Value, ok bool) {
+	if t.mode&weakKeys != 0 {
+		k = weakenValue(k)
+	}
+	for {
+		if t.order != nil {
+			next, val, ok = t.order.next(&t.mixedTable, k)
+		} else {
+			next, val, ok = t.mixedTable.next(k)
+		}
+		if !ok || next.IsNil() {
+			return
+		}
+		sk, kok := strengthenValue(next)
+		sv, vok := strengthenValue(val)
+		if kok && vok {
+			return sk, sv, true
+		}
+		k = next
+	}
+}
+
+// Remove all entries whose key or value has been collected, returning the
+// number of entries removed.
+func (t *Table) sweep() (count int) {
+	isDead := func(v Value) bool {
+		_, ok := strengthenValue(v)
+		return !ok
+	}
+	if a := t.array; a != nil {
+		// Keys in the array part are integers so only values can be dead.
+		for i := int64(a.len); i >= 1; i-- {
+			if isDead(a.values[i-1]) {
+				a.remove(i)
+				count++
+			}
+		}
+	}
+	if h := t.hashTable; h != nil {
+		for i := range h.slots {
+			it := &h.slots[i]
+			if !it.value.IsNil() && (isDead(it.key) || isDead(it.value)) {
+				// As in removeKey, the key is left in place.
+				it.value = NilValue
+				count++
+			}
+		}
+	}
+	return
+}
+
+//
+// Insertion order
+//
+// Tables created by a deterministic runtime (see WithDeterministic) iterate
+// over their keys in the order they were first inserted, which does not depend
+// on the hashes of the keys (these are not stable from one process to the
+// next).
+//
+
+type tableOrder struct {
+	keys  []Value       // keys in insertion order, some of them may be removed
+	index map[Value]int // position of each key in keys
+}
+
+func newTableOrder() *tableOrder {
+	return &tableOrder{index: map[Value]int{}}
+}
+
+// Keys are normalised as in mixedTable (e.g. 1.0 is the same key as 1).
+func orderKey(k Value) Value {
+	if i, ok := ToIntNoString(k); ok {
+		return IntValue(i)
+	}
+	return k
+}
+
+// Record that k is inserted in t if it is a new key.  As adding keys is not
+// allowed while traversing a table, this is a good time to forget removed
+// keys.
+func (o *tableOrder) add(t *mixedTable, k Value) {
+	k = orderKey(k)
+	if _, ok := o.index[k]; ok {
+		return
+	}
+	if len(o.keys) == cap(o.keys) && len(o.keys) >= 8 {
+		o.compact(t)
+	}
+	o.index[k] = len(o.keys)
+	o.keys = append(o.keys, k)
+}
+
+// Remove the keys which are no longer in t.
+func (o *tableOrder) compact(t *mixedTable) {
+	keys := o.keys[:0]
+	for _, k := range o.keys {
+		if t.get(k).IsNil() {
+			delete(o.index, k)
+		} else {
+			o.index[k] = len(keys)
+			keys = append(keys, k)
+		}
+	}
+	for i := len(keys); i < len(o.keys); i++ {
+		o.keys[i] = NilValue
+	}
+	o.keys = keys
+}
+
+// Like mixedTable.next, but in insertion order.
+func (o *tableOrder) next(t *mixedTable, k Value) (next Value, val Value, ok bool) {
+	i := 0
+	if !k.IsNil() {
+		j, found := o.index[orderKey(k)]
+		if !found {
+			return
+		}
+		i = j + 1
+	}
+	for ; i < len(o.keys); i++ {
+		next = o.keys[i]
+		if val = t.get(next); !val.IsNil() {
+			return next, val, true
+		}
+	}
+	return NilValue, NilValue, true
+}
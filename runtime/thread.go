@@ -55,6 +55,8 @@ type Thread struct {
 	DebugHooks
 
 	closeStack // Stack of pending to-be-closed values
+
+	eph ephemerons // Values this thread is the key of in weak tables
 }
 
 // NewThread creates a new thread out of a Runtime.  Its initial
@@ -91,6 +93,9 @@ func (t *Thread) RunContinuation(c Cont) (err error) {
 	var errContCount = 0
 	_ = t.triggerCall(t, c)
 	for c != nil {
+		if t.finalizerQueue.hasPending() {
+			t.RunPendingFinalizers()
+		}
 		t.currentCont = c
 		next, err = c.RunInThread(t)
 		if err != nil {
@@ -128,6 +133,17 @@ type threadClose struct{}
 // Start starts the thread in a goroutine, giving it the callable c to run.  the
 // t.Resume() method needs to be called to provide arguments to the callable.
 func (t *Thread) Start(c Callable) {
+	t.start(func(args []Value) ([]Value, error) {
+		next := NewTerminationWith(t.CurrentCont(), 0, true)
+		err := t.call(c, args, next)
+		return next.Etc(), err
+	})
+}
+
+// Start the thread in a goroutine which runs run() with the values the thread
+// is first resumed with.  The values returned by run() are sent back to the
+// caller when the thread ends.
+func (t *Thread) start(run func(args []Value) ([]Value, error)) {
 	t.RequireBytes(2 << 10) // A goroutine starts off with 2k stack
 	go func() {
 		var (
@@ -152,9 +168,7 @@ func (t *Thread) Start(c Callable) {
 		}()
 		args, err = t.getResumeValues()
 		if err == nil {
-			next := NewTerminationWith(t.CurrentCont(), 0, true)
-			err = t.call(c, args, next)
-			args = next.Etc()
+			args, err = run(args)
 		}
 	}()
 }
@@ -299,7 +313,14 @@ func (t *Thread) sendResumeValues(args []Value, err error, exception interface{}
 //
 // See quotas.md for details about this API.
 func (t *Thread) CallContext(def RuntimeContextDef, f func() error) (ctx RuntimeContext, err error) {
+	return t.callContext(def, nil, f)
+}
+
+func (t *Thread) callContext(def RuntimeContextDef, interrupt *int32, f func() error) (ctx RuntimeContext, err error) {
 	t.PushContext(def)
+	if interrupt != nil {
+		t.setInterrupt(interrupt)
+	}
 	c, h := t.CurrentCont(), t.closeStack.size()
 	defer func() {
 		ctx = t.PopContext()
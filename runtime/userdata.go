@@ -3,8 +3,10 @@ package runtime
 // A UserData is a Go value of any type wrapped to be used as a Lua value.  It
 // has a metatable which may allow Lua code to interact with it.
 type UserData struct {
-	value interface{}
-	meta  *Table
+	value  interface{}
+	meta   *Table
+	marked bool       // true if the userdata is marked for finalization
+	eph    ephemerons // values this userdata is the key of in weak tables
 }
 
 // NewUserData returns a new UserData pointer for the value v, giving it meta as
@@ -0,0 +1,146 @@
+//go:build go1.24
+// +build go1.24
+
+package runtime
+
+import (
+	"weak"
+)
+
+// WeakRefsAvailable is true if weak tables are supported by the runtime.  It
+// requires Go 1.24 or later.
+const WeakRefsAvailable = true
+
+// A weakRef holds a weak reference to a collectable Lua value.  Values of this
+// type can be stored in tables in place of the original value.  Two weakRefs
+// made from the same pointer are equal, so they can be used as table keys.
+type weakRef[T any] struct {
+	p weak.Pointer[T]
+}
+
+// Returns a value holding a weak reference to v if v is collectable, otherwise
+// return v.
+func weakenValue(v Value) Value {
+	switch x := v.iface.(type) {
+	case *Table:
+		return Value{iface: weakRef[Table]{weak.Make(x)}}
+	case *UserData:
+		return Value{iface: weakRef[UserData]{weak.Make(x)}}
+	case *Closure:
+		return Value{iface: weakRef[Closure]{weak.Make(x)}}
+	case *GoFunction:
+		return Value{iface: weakRef[GoFunction]{weak.Make(x)}}
+	case *Thread:
+		return Value{iface: weakRef[Thread]{weak.Make(x)}}
+	default:
+		return v
+	}
+}
+
+// Returns the value referenced by v if it is a weak reference, otherwise v
+// itself.  The returned bool is false if the value has been collected.
+func strengthenValue(v Value) (Value, bool) {
+	switch x := v.iface.(type) {
+	case weakRef[Table]:
+		if p := x.p.Value(); p != nil {
+			return TableValue(p), true
+		}
+	case weakRef[UserData]:
+		if p := x.p.Value(); p != nil {
+			return UserDataValue(p), true
+		}
+	case weakRef[Closure]:
+		if p := x.p.Value(); p != nil {
+			return FunctionValue(p), true
+		}
+	case weakRef[GoFunction]:
+		if p := x.p.Value(); p != nil {
+			return FunctionValue(p), true
+		}
+	case weakRef[Thread]:
+		if p := x.p.Value(); p != nil {
+			return ThreadValue(p), true
+		}
+	default:
+		return v, true
+	}
+	return NilValue, false
+}
+
+// The values a collectable Lua value is associated with as a key in ephemeron
+// tables (i.e. tables with weak keys and strong values), by table.  These
+// tables only hold weak references to the values, so that they are kept alive
+// by their key only.
+type ephemerons struct {
+	values map[weak.Pointer[Table]]Value
+}
+
+// Returns the ephemerons of v, or nil if v is not collectable.
+func ephemeronsOf(v Value) *ephemerons {
+	switch x := v.iface.(type) {
+	case *Table:
+		return &x.eph
+	case *UserData:
+		return &x.eph
+	case *Closure:
+		return &x.eph
+	case *GoFunction:
+		return &x.eph
+	case *Thread:
+		return &x.eph
+	default:
+		return nil
+	}
+}
+
+// Set the value associated with t.  As the tables are not kept alive, this is a
+// good time to forget about the values of tables that have been collected.
+func (e *ephemerons) set(t *Table, v Value) {
+	if e.values == nil {
+		e.values = map[weak.Pointer[Table]]Value{}
+	}
+	p := weak.Make(t)
+	if _, ok := e.values[p]; !ok {
+		for q := range e.values {
+			if q.Value() == nil {
+				delete(e.values, q)
+			}
+		}
+	}
+	e.values[p] = v
+}
+
+func (e *ephemerons) remove(t *Table) {
+	if e.values != nil {
+		delete(e.values, weak.Make(t))
+	}
+}
+
+// weakTableSet keeps track of the weak tables in a runtime, without preventing
+// them from being collected.
+type weakTableSet struct {
+	tables []weak.Pointer[Table]
+}
+
+func (s *weakTableSet) add(t *Table) {
+	s.tables = append(s.tables, weak.Make(t))
+}
+
+// Remove collected entries from all weak tables that are still alive, and
+// return the number of entries removed.
+func (s *weakTableSet) sweep() (count uint64) {
+	live := s.tables[:0]
+	for _, p := range s.tables {
+		t := p.Value()
+		if t == nil {
+			continue
+		}
+		live = append(live, p)
+		count += uint64(t.sweep())
+	}
+	for i := len(live); i < len(s.tables); i++ {
+		s.tables[i] = weak.Pointer[Table]{}
+	}
+	s.tables = live
+	return
+}
@@ -0,0 +1,35 @@
+//go:build !go1.24
+// +build !go1.24
+
+package runtime
+
+// WeakRefsAvailable is true if weak tables are supported by the runtime.  It
+// requires Go 1.24 or later, so before that the __mode metamethod is ignored
+// and all tables keep strong references.
+const WeakRefsAvailable = false
+
+func weakenValue(v Value) Value {
+	return v
+}
+
+func strengthenValue(v Value) (Value, bool) {
+	return v, true
+}
+
+type ephemerons struct{}
+
+func ephemeronsOf(v Value) *ephemerons {
+	return nil
+}
+
+func (e *ephemerons) set(t *Table, v Value) {}
+
+func (e *ephemerons) remove(t *Table) {}
+
+type weakTableSet struct{}
+
+func (s *weakTableSet) add(t *Table) {}
+
+func (s *weakTableSet) sweep() uint64 {
+	return 0
+}
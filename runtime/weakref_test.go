@@ -0,0 +1,35 @@
+//go:build go1.24 && !noquotas
+// +build go1.24,!noquotas
+
+package runtime
+
+import "testing"
+
+func TestWeakTable_ReleaseMem(t *testing.T) {
+	r := New(nil)
+	th := r.MainThread()
+	r.PushContext(RuntimeContextDef{HardLimits: RuntimeResources{Memory: 100000}})
+	defer r.PopContext()
+
+	meta := NewTable()
+	r.SetEnv(meta, "__mode", StringValue("k"))
+	tbl := NewTable()
+	r.SetRawMetatable(TableValue(tbl), meta)
+
+	before := r.UsedResources().Memory
+	func() {
+		for i := 0; i < 100; i++ {
+			r.SetTable(tbl, TableValue(NewTable()), IntValue(int64(i)))
+		}
+	}()
+	if used := r.UsedResources().Memory; used != before+100*tableEntrySize {
+		t.Fatalf("expected %d bytes used, got %d", before+100*tableEntrySize, used)
+	}
+	th.CollectGarbage()
+	if k, _, _ := tbl.Next(NilValue); !k.IsNil() {
+		t.Fatalf("expected weak table to be empty, got key %v", k)
+	}
+	if used := r.UsedResources().Memory; used != before {
+		t.Fatalf("expected %d bytes used after collection, got %d", before, used)
+	}
+}
@@ -3,38 +3,53 @@ package safeio
 import (
 	"errors"
 	"io/fs"
-	"io/ioutil"
-	"os"
 
 	rt "github.com/arnodel/golua/runtime"
+	"github.com/arnodel/golua/vfs"
 )
 
-func OpenFile(r *rt.Runtime, name string, flag int, perm fs.FileMode) (*os.File, error) {
-	if r.RequiredFlags()&rt.ComplyIoSafe != 0 {
+// Files are accessed through the file system of the runtime.  If it is the
+// host file system, this is not allowed when the runtime requires io safety.
+// Other file systems only give access to their own files (e.g. vfs.Dir does not
+// follow symbolic links out of its root).
+func fileSystem(r *rt.Runtime) (vfs.FS, error) {
+	fsys := r.FS()
+	if fsys == vfs.OS && r.RequiredFlags()&rt.ComplyIoSafe != 0 {
 		return nil, ErrNotAllowed
 	}
-	return os.OpenFile(name, flag, perm)
+	return fsys, nil
 }
 
-func TempFile(r *rt.Runtime, dir string, pattern string) (*os.File, error) {
-	if r.RequiredFlags()&rt.ComplyIoSafe != 0 {
-		return nil, ErrNotAllowed
+func OpenFile(r *rt.Runtime, name string, flag int, perm fs.FileMode) (vfs.File, error) {
+	fsys, err := fileSystem(r)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.OpenFile(name, flag, perm)
+}
+
+func TempFile(r *rt.Runtime, dir string, pattern string) (vfs.File, error) {
+	fsys, err := fileSystem(r)
+	if err != nil {
+		return nil, err
 	}
-	return ioutil.TempFile(dir, pattern)
+	return fsys.CreateTemp(dir, pattern)
 }
 
 func RemoveFile(r *rt.Runtime, name string) error {
-	if r.RequiredFlags()&rt.ComplyIoSafe != 0 {
-		return ErrNotAllowed
+	fsys, err := fileSystem(r)
+	if err != nil {
+		return err
 	}
-	return os.Remove(name)
+	return fsys.Remove(name)
 }
 
 func RenameFile(r *rt.Runtime, oldName, newName string) error {
-	if r.RequiredFlags()&rt.ComplyIoSafe != 0 {
-		return ErrNotAllowed
+	fsys, err := fileSystem(r)
+	if err != nil {
+		return err
 	}
-	return os.Rename(oldName, newName)
+	return fsys.Rename(oldName, newName)
 }
 
 var ErrNotAllowed = errors.New("safeio: operation not allowed")
@@ -0,0 +1,62 @@
+package safeio
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// StartCommand starts cmd, which is not allowed when the runtime requires io
+// safety.
+func StartCommand(r *rt.Runtime, cmd *exec.Cmd) error {
+	if r.RequiredFlags()&rt.ComplyIoSafe != 0 {
+		return ErrNotAllowed
+	}
+	return cmd.Start()
+}
+
+// ShellCommand returns a command that runs cmdline in the system shell, as
+// os.execute and io.popen do.
+func ShellCommand(cmdline string) *exec.Cmd {
+	cmd, args := cmdArgs(cmdline)
+	return exec.Command(cmd, args...)
+}
+
+func cmdArgs(arg string) (string, []string) {
+	cmd := "/bin/sh"
+	args := []string{"-c"}
+	if runtime.GOOS == "windows" {
+		cmd = "C:\\Windows\\system32\\cmd.exe"
+		args = []string{"/c"}
+	}
+	args = append(args, arg)
+	return cmd, args
+}
+
+// ExitStatus returns the values describing how a process terminated, as
+// returned by os.execute: true or false depending on whether the process was
+// successful, then "exit" and the exit code or "signal" and the signal number
+// that terminated it.
+func ExitStatus(ps *os.ProcessState) []rt.Value {
+	success := rt.BoolValue(true)
+	if !ps.Success() {
+		success = rt.BoolValue(false)
+	}
+
+	exit := rt.StringValue("exit")
+	code := rt.IntValue(int64(ps.ExitCode()))
+	if !ps.Exited() {
+		// terminated by signal
+		exit = rt.StringValue("signal")
+		if runtime.GOOS != "windows" {
+			// i am not sure how this is on windows...
+			ws := ps.Sys().(syscall.WaitStatus)
+			sig := ws.Signal()
+			code = rt.IntValue(int64(sig)) // syscall signal, which is an int
+		}
+	}
+	return []rt.Value{success, exit, code}
+}
@@ -0,0 +1,95 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Dir returns a file system made of the files under the directory root of the
+// host file system.  Names are interpreted as slash separated paths relative to
+// root ("/" and ".." cannot go above root) and temporary files are created in
+// root.  Symbolic links inside root are followed, but not if they lead outside
+// of it.
+func Dir(root string) FS {
+	return dirFS{root: root}
+}
+
+type dirFS struct {
+	root string
+}
+
+var errPatternHasSeparator = errors.New("pattern contains path separator")
+
+// Returns name as a host path relative to root, which cannot go above root.
+func relPath(name string) string {
+	rel := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if rel == "" {
+		return "."
+	}
+	return filepath.FromSlash(rel)
+}
+
+// Errors are about the names given by the caller, not the host paths.
+func (d dirFS) fixError(err error, name string) error {
+	if pathErr, ok := err.(*fs.PathError); ok {
+		pathErr.Path = name
+	} else if linkErr, ok := err.(*os.LinkError); ok {
+		return &fs.PathError{Op: linkErr.Op, Path: name, Err: linkErr.Err}
+	}
+	return err
+}
+
+func (d dirFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := d.openFile(relPath(name), flag, perm)
+	if err != nil {
+		return nil, d.fixError(err, name)
+	}
+	return dirFile{File: f, name: name}, nil
+}
+
+// CreateTemp creates a new file in dir whose name is made from pattern, as
+// os.CreateTemp does.
+func (d dirFS) CreateTemp(dir, pattern string) (File, error) {
+	if strings.ContainsAny(pattern, `/\`) {
+		return nil, &fs.PathError{Op: "createtemp", Path: pattern, Err: errPatternHasSeparator}
+	}
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+	for try := 0; ; try++ {
+		name := path.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10)+suffix)
+		f, err := d.openFile(relPath(name), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, fs.ErrExist) && try < 10000 {
+			continue
+		}
+		if err != nil {
+			return nil, d.fixError(err, dir)
+		}
+		return dirFile{File: f, name: name}, nil
+	}
+}
+
+func (d dirFS) Remove(name string) error {
+	return d.fixError(d.remove(relPath(name)), name)
+}
+
+func (d dirFS) Rename(oldName, newName string) error {
+	return d.fixError(d.rename(relPath(oldName), relPath(newName)), oldName)
+}
+
+// A file of a dirFS, which does not give away its host path.
+type dirFile struct {
+	*os.File
+	name string
+}
+
+func (f dirFile) Name() string {
+	return f.name
+}
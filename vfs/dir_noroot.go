@@ -0,0 +1,95 @@
+//go:build !go1.25
+// +build !go1.25
+
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Without os.Root, the symbolic links a name goes through are resolved before
+// using it, to check that they do not lead out of the root directory.  This
+// does not protect against links changed at the same time by another process.
+// Names are relative to the root.
+
+var errPathEscapes = errors.New("path escapes from parent")
+
+func (d dirFS) openFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	p, err := d.hostPath("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(p, flag, perm)
+}
+
+// The last element of the name is not followed as it is the link itself which
+// is removed.
+func (d dirFS) remove(name string) error {
+	p, err := d.hostPath("remove", name, false)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+func (d dirFS) rename(oldName, newName string) error {
+	oldPath, err := d.hostPath("rename", oldName, false)
+	if err != nil {
+		return err
+	}
+	newPath, err := d.hostPath("rename", newName, false)
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// Returns the host path of name, checking that the links it goes through
+// (including its last element if followLast is true) stay under the root.
+func (d dirFS) hostPath(op, name string, followLast bool) (string, error) {
+	root, err := filepath.EvalSymlinks(d.root)
+	if err != nil {
+		return "", err
+	}
+	p := filepath.Join(root, name)
+	checked := p
+	if !followLast {
+		checked = filepath.Dir(p)
+	}
+	resolved, err := resolveLinks(checked)
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &fs.PathError{Op: op, Path: name, Err: errPathEscapes}
+	}
+	return p, nil
+}
+
+// Resolves the symbolic links in the part of p which exists.  A dangling link
+// is an error as the file it points to could be created anywhere.
+func resolveLinks(p string) (string, error) {
+	rest := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		if _, lerr := os.Lstat(p); lerr == nil {
+			return "", errPathEscapes
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+		p = parent
+	}
+}
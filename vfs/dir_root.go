@@ -0,0 +1,39 @@
+//go:build go1.25
+// +build go1.25
+
+package vfs
+
+import (
+	"io/fs"
+	"os"
+)
+
+// Files are accessed through an os.Root, which does not follow symbolic links
+// out of the root directory.  Names are relative to the root.
+
+func (d dirFS) openFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	root, err := os.OpenRoot(d.root)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	return root.OpenFile(name, flag, perm)
+}
+
+func (d dirFS) remove(name string) error {
+	root, err := os.OpenRoot(d.root)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	return root.Remove(name)
+}
+
+func (d dirFS) rename(oldName, newName string) error {
+	root, err := os.OpenRoot(d.root)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	return root.Rename(oldName, newName)
+}
@@ -0,0 +1,77 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// FromFS returns a read-only file system made of the files in fsys (e.g. an
+// embed.FS).  Names are interpreted as slash separated paths from the root of
+// fsys.  Opening a file for writing, creating, removing or renaming files fail
+// with fs.ErrPermission.
+func FromFS(fsys fs.FS) FS {
+	return ioFS{fsys: fsys}
+}
+
+type ioFS struct {
+	fsys fs.FS
+}
+
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_TRUNC | os.O_APPEND
+
+func (f ioFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if flag&writeFlags != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	file, err := f.fsys.Open(strings.TrimPrefix(path.Clean("/"+name), "/"))
+	if err != nil {
+		if pathErr, ok := err.(*fs.PathError); ok {
+			pathErr.Path = name
+		}
+		return nil, err
+	}
+	return ioFile{File: file, name: name}, nil
+}
+
+func (f ioFS) CreateTemp(dir, pattern string) (File, error) {
+	return nil, &fs.PathError{Op: "createtemp", Path: dir, Err: fs.ErrPermission}
+}
+
+func (f ioFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+func (f ioFS) Rename(oldName, newName string) error {
+	return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrPermission}
+}
+
+var errSeekNotSupported = errors.New("seek not supported")
+
+// A file of an ioFS.
+type ioFile struct {
+	fs.File
+	name string
+}
+
+func (f ioFile) Name() string {
+	return f.name
+}
+
+func (f ioFile) Write(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrPermission}
+}
+
+func (f ioFile) Seek(offset int64, whence int) (int64, error) {
+	if s, ok := f.File.(io.Seeker); ok {
+		return s.Seek(offset, whence)
+	}
+	return 0, &fs.PathError{Op: "seek", Path: f.name, Err: errSeekNotSupported}
+}
+
+func (f ioFile) Sync() error {
+	return nil
+}
@@ -0,0 +1,217 @@
+package vfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MemFS is a file system which keeps its files in memory.  It has no
+// directories: a file name is any slash separated path, which is cleaned (so
+// "a/../b" is the same file as "/b").  It is safe for concurrent use.
+type MemFS struct {
+	mux     sync.Mutex
+	files   map[string]*memData
+	tempSeq int
+}
+
+var _ FS = (*MemFS)(nil)
+
+// The content of a file, which survives the file being removed while it is
+// open.
+type memData struct {
+	data []byte
+}
+
+// NewMemFS returns a new empty in-memory file system.
+func NewMemFS() *MemFS {
+	return &MemFS{files: map[string]*memData{}}
+}
+
+func memKey(name string) string {
+	return path.Clean("/" + name)
+}
+
+// WriteFile creates or replaces the named file with the given content.
+func (m *MemFS) WriteFile(name string, data []byte) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.files[memKey(name)] = &memData{data: append([]byte(nil), data...)}
+}
+
+// OpenFile opens the named file, as os.OpenFile does.  The perm argument is
+// ignored.
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	key := memKey(name)
+	d, ok := m.files[key]
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !ok:
+		d = &memData{}
+		m.files[key] = d
+	case flag&os.O_TRUNC != 0 && flag&(os.O_WRONLY|os.O_RDWR) != 0:
+		d.data = nil
+	}
+	return &memFile{fs: m, name: name, data: d, flag: flag}, nil
+}
+
+// CreateTemp creates a new file in dir whose name is made from pattern, as
+// os.CreateTemp does.  Names are not random but they are unique in m.
+func (m *MemFS) CreateTemp(dir, pattern string) (File, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if dir == "" {
+		dir = "/tmp"
+	}
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+	for {
+		m.tempSeq++
+		name := path.Join(dir, prefix+strconv.Itoa(m.tempSeq)+suffix)
+		key := memKey(name)
+		if _, ok := m.files[key]; !ok {
+			d := &memData{}
+			m.files[key] = d
+			return &memFile{fs: m, name: name, data: d, flag: os.O_RDWR}, nil
+		}
+	}
+}
+
+// Remove removes the named file.
+func (m *MemFS) Remove(name string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	key := memKey(name)
+	if _, ok := m.files[key]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, key)
+	return nil
+}
+
+// Rename renames a file, replacing newName if it exists.
+func (m *MemFS) Rename(oldName, newName string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	oldKey := memKey(oldName)
+	d, ok := m.files[oldKey]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+	delete(m.files, oldKey)
+	m.files[memKey(newName)] = d
+	return nil
+}
+
+// A file open in a MemFS.
+type memFile struct {
+	fs     *MemFS
+	name   string
+	data   *memData
+	flag   int
+	offset int64
+	closed bool
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+// Returns an error if the file is closed or cannot be used for op.
+func (f *memFile) check(op string, write bool) error {
+	var err error
+	switch {
+	case f.closed:
+		err = fs.ErrClosed
+	case write && f.flag&(os.O_WRONLY|os.O_RDWR) == 0:
+		err = fs.ErrPermission
+	case !write && f.flag&os.O_WRONLY != 0:
+		err = fs.ErrPermission
+	default:
+		return nil
+	}
+	return &fs.PathError{Op: op, Path: f.name, Err: err}
+}
+
+func (f *memFile) Read(b []byte) (int, error) {
+	f.fs.mux.Lock()
+	defer f.fs.mux.Unlock()
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if f.offset >= int64(len(f.data.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.data.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	f.fs.mux.Lock()
+	defer f.fs.mux.Unlock()
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	data := f.data.data
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(data))
+	}
+	end := f.offset + int64(len(b))
+	if end > int64(len(data)) {
+		if end > int64(cap(data)) {
+			newData := make([]byte, len(data), 2*end)
+			copy(newData, data)
+			data = newData
+		}
+		data = data[:end]
+	}
+	copy(data[f.offset:], b)
+	f.data.data = data
+	f.offset = end
+	return len(b), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mux.Lock()
+	defer f.fs.mux.Unlock()
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.data.data))
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Sync() error {
+	return nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mux.Lock()
+	defer f.fs.mux.Unlock()
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
@@ -0,0 +1,92 @@
+// Package vfs defines the file system seen by Lua code.
+//
+// Each Runtime has a file system (see runtime.WithFS), which the io and os
+// libraries, loadfile, dofile and require go through.  By default it is the
+// host file system (OS), but it can be replaced with e.g. a directory of the
+// host file system (Dir), a read-only fs.FS such as an embed.FS (FromFS) or an
+// in-memory file system (MemFS).
+package vfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+)
+
+// A File is an open file in a file system.  *os.File implements File.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+
+	// Name returns the name of the file as given to OpenFile (or chosen by
+	// CreateTemp).
+	Name() string
+
+	// Sync commits the content of the file to storage.
+	Sync() error
+}
+
+// An FS is a file system which can be written to.  File names use the same
+// conventions as the os package, the flags given to OpenFile are those of
+// os.OpenFile and errors should be *fs.PathError values where it makes sense.
+type FS interface {
+	// OpenFile opens the named file, as os.OpenFile does.
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+
+	// CreateTemp creates a new temporary file, as os.CreateTemp does.
+	CreateTemp(dir, pattern string) (File, error)
+
+	// Remove removes the named file, as os.Remove does.
+	Remove(name string) error
+
+	// Rename renames a file, as os.Rename does.
+	Rename(oldName, newName string) error
+}
+
+// OS is the file system of the host.
+var OS FS = osFS{}
+
+type osFS struct{}
+
+var _ File = (*os.File)(nil)
+
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) CreateTemp(dir, pattern string) (File, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+// Open opens the named file for reading.
+func Open(fsys FS, name string) (File, error) {
+	return fsys.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// ReadFile reads the whole content of the named file.
+func ReadFile(fsys FS, name string) ([]byte, error) {
+	f, err := Open(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
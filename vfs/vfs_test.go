@@ -0,0 +1,203 @@
+package vfs_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+	"github.com/arnodel/golua/vfs"
+)
+
+func TestMemFS(t *testing.T) {
+	m := vfs.NewMemFS()
+	f, err := m.OpenFile("a/b.txt", os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(f, "hello")
+	f.Seek(1, io.SeekStart)
+	io.WriteString(f, "ELLO, world")
+	f.Close()
+	if _, err := f.Write([]byte("x")); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("expected closed error, got %v", err)
+	}
+
+	if b, err := vfs.ReadFile(m, "/a/./b.txt"); err != nil || string(b) != "hELLO, world" {
+		t.Errorf("unexpected content %q, %v", b, err)
+	}
+	if _, err := m.OpenFile("a/b.txt", os.O_CREATE|os.O_EXCL, 0666); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("expected exist error, got %v", err)
+	}
+	if err := m.Rename("a/b.txt", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vfs.Open(m, "a/b.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+	f, _ = m.OpenFile("c", os.O_WRONLY|os.O_APPEND, 0)
+	io.WriteString(f, "!")
+	if _, err := f.Read(make([]byte, 1)); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected permission error, got %v", err)
+	}
+	if b, _ := vfs.ReadFile(m, "c"); string(b) != "hELLO, world!" {
+		t.Errorf("unexpected content %q", b)
+	}
+
+	tmp1, _ := m.CreateTemp("", "x*.txt")
+	tmp2, _ := m.CreateTemp("", "x*.txt")
+	if tmp1.Name() == tmp2.Name() || filepath.Ext(tmp1.Name()) != ".txt" {
+		t.Errorf("unexpected temp names %q, %q", tmp1.Name(), tmp2.Name())
+	}
+	if err := m.Remove(tmp1.Name()); err != nil {
+		t.Error(err)
+	}
+	if err := m.Remove(tmp1.Name()); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
+
+func TestDir(t *testing.T) {
+	root := t.TempDir()
+	d := vfs.Dir(root)
+	f, err := d.OpenFile("/../../x.txt", os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(f, "in root")
+	f.Close()
+	if f.Name() != "/../../x.txt" {
+		t.Errorf("unexpected name %q", f.Name())
+	}
+	if b, err := os.ReadFile(filepath.Join(root, "x.txt")); err != nil || string(b) != "in root" {
+		t.Errorf("unexpected content %q, %v", b, err)
+	}
+	_, err = vfs.Open(d, "missing")
+	if pathErr, ok := err.(*fs.PathError); !ok || pathErr.Path != "missing" {
+		t.Errorf("unexpected error %v", err)
+	}
+	tmp, err := d.CreateTemp("", "golua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp.Close()
+	if err := d.Remove(tmp.Name()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDirSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0666)
+	os.Mkdir(filepath.Join(root, "sub"), 0777)
+	os.WriteFile(filepath.Join(root, "sub", "a.txt"), []byte("a"), 0666)
+	for name, target := range map[string]string{
+		"out":      outside,
+		"secret":   filepath.Join(outside, "secret.txt"),
+		"dangling": filepath.Join(outside, "new.txt"),
+		"in":       "sub",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skipf("cannot create symbolic links: %s", err)
+		}
+	}
+	d := vfs.Dir(root)
+	if b, err := vfs.ReadFile(d, "in/a.txt"); err != nil || string(b) != "a" {
+		t.Errorf("unexpected content %q, %v", b, err)
+	}
+	for _, name := range []string{"out/secret.txt", "secret", "/in/../out/secret.txt"} {
+		if b, err := vfs.ReadFile(d, name); err == nil {
+			t.Errorf("%s: read outside of root: %q", name, b)
+		}
+	}
+	for _, name := range []string{"out/new.txt", "dangling"} {
+		if f, err := d.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0666); err == nil {
+			f.Close()
+			t.Errorf("%s: created file outside of root", name)
+		}
+	}
+	if err := d.Rename("in/a.txt", "out/a.txt"); err == nil {
+		t.Error("renamed file outside of root")
+	}
+	if _, err := d.CreateTemp("out", "golua"); err == nil {
+		t.Error("created temporary file outside of root")
+	}
+	// Removing a link does not touch its target.
+	if err := d.Remove("secret"); err != nil {
+		t.Error(err)
+	}
+	entries, _ := os.ReadDir(outside)
+	if len(entries) != 1 || entries[0].Name() != "secret.txt" {
+		t.Errorf("unexpected files outside of root: %v", entries)
+	}
+}
+
+func TestFromFS(t *testing.T) {
+	f := vfs.FromFS(fstest.MapFS{"dir/file": {Data: []byte("data")}})
+	if b, err := vfs.ReadFile(f, "/dir/file"); err != nil || string(b) != "data" {
+		t.Errorf("unexpected content %q, %v", b, err)
+	}
+	if _, err := f.OpenFile("dir/file", os.O_RDWR, 0); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected permission error, got %v", err)
+	}
+	if err := f.Remove("dir/file"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected permission error, got %v", err)
+	}
+}
+
+func runChunk(t *testing.T, r *rt.Runtime, src string) rt.Value {
+	t.Helper()
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte(src), rt.TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := rt.NewTerminationWith(nil, 1, false)
+	if err := r.SafeCall(rt.FunctionValue(clos), nil, res); err != nil {
+		t.Fatal(err)
+	}
+	return res.Get(0)
+}
+
+func TestRuntimeFS(t *testing.T) {
+	m := vfs.NewMemFS()
+	m.WriteFile("lib/greet.lua", []byte(`return function(x) return "hello " .. x end`))
+	m.WriteFile("script.lua", []byte(`return "from script"`))
+	r := rt.New(nil, rt.WithFS(m))
+	lib.LoadAll(r)
+
+	res := runChunk(t, r, `
+package.path = "/lib/?.lua"
+local greet = require "greet"
+return greet(dofile("script.lua")) .. " " .. tostring(loadfile("/script.lua") ~= nil)
+`)
+	if res != rt.StringValue("hello from script true") {
+		t.Errorf("unexpected result %v", res)
+	}
+
+	// The host file system cannot be accessed in an io safe context, but a
+	// virtual one can.
+	r.PushContext(rt.RuntimeContextDef{RequiredFlags: rt.ComplyIoSafe})
+	res = runChunk(t, r, `
+local f = assert(io.open("out.txt", "w"))
+f:write("hello")
+f:close()
+assert(os.rename("out.txt", "new.txt"))
+assert(not io.open("out.txt"))
+local s = io.open("new.txt"):read("a")
+assert(os.remove("new.txt"))
+return s
+`)
+	r.PopContext()
+	if res != rt.StringValue("hello") {
+		t.Errorf("unexpected result %v", res)
+	}
+	if _, err := vfs.Open(m, "new.txt"); err == nil {
+		t.Error("new.txt not removed")
+	}
+}